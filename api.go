@@ -0,0 +1,706 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	// External modules
+)
+
+//
+//// DAEMON API
+//
+
+// The daemon serves a local HTTP API (JSON) on a Unix socket in the user data
+// directory, only accessible by the user:
+//
+//	GET  /v1/crates                                   crates and targets, with their state and last run
+//	GET  /v1/crates/<crate>/targets/<target>          status and last run of a target
+//	POST /v1/crates/<crate>/targets/<target>/enable   enable a target
+//	POST /v1/crates/<crate>/targets/<target>/disable  disable a target
+//	POST /v1/sync                                     queue a sync: {"crate": "...", "targets": ["..."]}
+//	GET  /v1/runs                                     runs of the daemon (queued, running and finished)
+//	GET  /v1/runs/<id>                                a single run
+//	GET  /v1/runs/<id>/events                         output of a run (server-sent events)
+//
+// Syncs requested through the API are queued and run by the daemon one at a time,
+// along with the scheduled ones.
+
+// Number of finished runs (and lines of output per run) kept in memory
+const (
+	daemonAPIMaxRuns        = 50
+	daemonAPIMaxOutputLines = 10000
+)
+
+// Written (on its own line) to the output once a run finished, so that the whole
+// output of the run is attributed to it before it is marked as finished
+const daemonRunEndMarker = "\x00synctropy-run-end\x00"
+
+// States of a run
+const (
+	daemonRunQueued   = "queued"
+	daemonRunRunning  = "running"
+	daemonRunFinished = "finished"
+)
+
+type daemonRun struct {
+	ID         string    `json:"id"`
+	Crate      string    `json:"crate"`
+	Targets    []string  `json:"targets"`
+	Trigger    string    `json:"trigger"`
+	State      string    `json:"state"`
+	ExitCode   int       `json:"exit_code"`
+	QueuedAt   time.Time `json:"queued_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+
+	crate       Crate
+	targets     []Target
+	output      []string
+	subscribers map[chan string]bool
+	outputEnded chan struct{}
+}
+
+type daemonAPI struct {
+	program  Program
+	listener net.Listener
+	queue    chan *daemonRun
+
+	restoreOutput func()
+
+	mutex   sync.Mutex
+	runs    []*daemonRun
+	nextID  int
+	current *daemonRun
+}
+
+//
+//// RUNS
+//
+
+func (api *daemonAPI) newRun(crate Crate, targets []Target, trigger string) *daemonRun {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	api.nextID++
+	run := &daemonRun{
+		ID:          strconv.Itoa(api.nextID),
+		Crate:       crate.name,
+		Targets:     []string{},
+		Trigger:     trigger,
+		State:       daemonRunQueued,
+		QueuedAt:    time.Now(),
+		crate:       crate,
+		targets:     targets,
+		subscribers: make(map[chan string]bool),
+		outputEnded: make(chan struct{}),
+	}
+	for _, target := range targets {
+		run.Targets = append(run.Targets, target.name)
+	}
+
+	api.runs = append(api.runs, run)
+
+	// Forget the oldest finished runs
+	for len(api.runs) > daemonAPIMaxRuns && api.runs[0].State == daemonRunFinished {
+		api.runs = api.runs[1:]
+	}
+
+	return run
+}
+
+func (api *daemonAPI) getRun(id string) *daemonRun {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	for _, run := range api.runs {
+		if run.ID == id {
+			return run
+		}
+	}
+
+	return nil
+}
+
+// Runs a sync (in the goroutine of the daemon), attributing its output (from the
+// title) to the run
+func (api *daemonAPI) execute(run *daemonRun, title string, program Program) functionResponse {
+	api.mutex.Lock()
+	run.State = daemonRunRunning
+	run.StartedAt = time.Now()
+	api.current = run
+	api.mutex.Unlock()
+
+	space()
+	showInfoSectionTitle(title, program.indentLevel)
+
+	response := targetsSync(run.crate, run.targets, program)
+	handleFunctionResponse(response, false)
+
+	// Wait until the whole output went through the pipe
+	fmt.Println(daemonRunEndMarker)
+	<-run.outputEnded
+
+	api.mutex.Lock()
+	run.State = daemonRunFinished
+	run.FinishedAt = time.Now()
+	run.ExitCode = response.exitCode
+	for subscriber := range run.subscribers {
+		close(subscriber)
+	}
+	run.subscribers = nil
+	api.mutex.Unlock()
+
+	return response
+}
+
+// Attributes a line of the output of the daemon to the current run (if any).
+// Returns what is written to the standard output of the daemon.
+func (api *daemonAPI) handleOutputLine(line string) string {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	run := api.current
+	ended := strings.HasSuffix(strings.TrimRight(line, "\r\n"), daemonRunEndMarker)
+	if ended == true {
+		line = strings.TrimSuffix(strings.TrimRight(line, "\r\n"), daemonRunEndMarker)
+		if line == "" {
+			if run != nil {
+				api.current = nil
+				close(run.outputEnded)
+			}
+			return ""
+		}
+		line += "\n"
+	}
+
+	if run != nil {
+		text := strings.ReplaceAll(strings.TrimRight(line, "\n"), "\r", "")
+
+		run.output = append(run.output, text)
+		if len(run.output) > daemonAPIMaxOutputLines {
+			run.output = run.output[1:]
+		}
+
+		for subscriber := range run.subscribers {
+			select {
+			case subscriber <- text:
+			default:
+				// Too slow: disconnect it
+				close(subscriber)
+				delete(run.subscribers, subscriber)
+			}
+		}
+
+		if ended == true {
+			api.current = nil
+			close(run.outputEnded)
+		}
+	}
+
+	return line
+}
+
+//
+//// SERVER
+//
+
+// Starts the API on the socket of the user data directory. Fails if another daemon
+// is listening on it.
+func startDaemonAPI(program Program) (*daemonAPI, error) {
+	socketPath := program.userDaemonSocket
+
+	if _, err := os.Stat(socketPath); err == nil {
+		connection, err := net.Dial("unix", socketPath)
+		if err == nil {
+			connection.Close()
+			return nil, fmt.Errorf("another daemon is listening on %v", socketPath)
+		}
+
+		// Left behind by a daemon that did not stop cleanly
+		os.Remove(socketPath)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(socketPath, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	api := &daemonAPI{
+		program:  program,
+		listener: listener,
+		queue:    make(chan *daemonRun, 64),
+	}
+
+	api.restoreOutput, err = teeDisplayOutput(api.handleOutputLine)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	go http.Serve(listener, http.HandlerFunc(api.serveHTTP))
+
+	return api, nil
+}
+
+func (api *daemonAPI) close() {
+	api.listener.Close()
+	os.Remove(api.program.userDaemonSocket)
+
+	api.restoreOutput()
+}
+
+func writeAPIResponse(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "\t")
+	encoder.Encode(value)
+}
+
+func writeAPIError(writer http.ResponseWriter, status int, message string) {
+	writeAPIResponse(writer, status, map[string]string{"error": message})
+}
+
+func (api *daemonAPI) serveHTTP(writer http.ResponseWriter, request *http.Request) {
+	parts := strings.Split(strings.Trim(request.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "v1" {
+		writeAPIError(writer, http.StatusNotFound, "Not found")
+		return
+	}
+	parts = parts[1:]
+
+	route := func(method string, pattern ...string) bool {
+		if request.Method != method || len(parts) != len(pattern) {
+			return false
+		}
+		for index, part := range pattern {
+			if part != "*" && parts[index] != part {
+				return false
+			}
+		}
+		return true
+	}
+
+	switch {
+	case route("GET", "crates"):
+		api.serveCrates(writer)
+	case route("GET", "crates", "*", "targets", "*"):
+		api.serveTargetStatus(writer, parts[1], parts[3])
+	case route("POST", "crates", "*", "targets", "*", "enable"), route("POST", "crates", "*", "targets", "*", "disable"):
+		api.serveTargetState(writer, parts[1], parts[3], parts[4] == "enable")
+	case route("POST", "sync"):
+		api.serveSync(writer, request)
+	case route("GET", "runs"):
+		api.mutex.Lock()
+		runs := append([]*daemonRun{}, api.runs...)
+		writeAPIResponse(writer, http.StatusOK, runs)
+		api.mutex.Unlock()
+	case route("GET", "runs", "*"):
+		run := api.getRun(parts[1])
+		if run == nil {
+			writeAPIError(writer, http.StatusNotFound, fmt.Sprintf("Run '%v' not found", parts[1]))
+			return
+		}
+		api.mutex.Lock()
+		writeAPIResponse(writer, http.StatusOK, run)
+		api.mutex.Unlock()
+	case route("GET", "runs", "*", "events"):
+		api.serveRunEvents(writer, request, parts[1])
+	default:
+		writeAPIError(writer, http.StatusNotFound, "Not found")
+	}
+}
+
+type apiTarget struct {
+	Crate     string               `json:"crate"`
+	Name      string               `json:"name"`
+	Disabled  bool                 `json:"disabled"`
+	Method    string               `json:"method"`
+	Roots     string               `json:"roots,omitempty"`
+	LastRun   *journalTargetRecord `json:"last_run,omitempty"`
+	LastRunAt time.Time            `json:"last_run_at,omitempty"`
+}
+
+type apiCrate struct {
+	Name     string      `json:"name"`
+	Disabled bool        `json:"disabled"`
+	Targets  []apiTarget `json:"targets"`
+}
+
+func (api *daemonAPI) getAPITarget(target Target, runs []journalRun) apiTarget {
+	method, roots := getTargetSyncRoots(target, api.program)
+	disabled, _ := isTargetDisabled(target, api.program)
+
+	status := apiTarget{
+		Crate:    target.crate.name,
+		Name:     target.name,
+		Disabled: disabled,
+		Method:   method,
+		Roots:    roots,
+	}
+
+	if run, record, found := getTargetLastRun(runs, target); found == true {
+		status.LastRun = &record
+		status.LastRunAt = run.StartedAt
+	}
+
+	return status
+}
+
+// Names coming from requests are joined into paths, so they must not leave the
+// directory of their crate or target
+func isValidAPIName(name string) bool {
+	return name != "" && strings.HasPrefix(name, ".") == false && strings.Contains(name, "/") == false
+}
+
+// Returns the crate with the given name, if it exists
+func (api *daemonAPI) findCrate(crateName string) (Crate, error) {
+	crate := generateCrateObj(crateName, api.program)
+	if isValidAPIName(crateName) == false {
+		return crate, fmt.Errorf("Crate '%v' not found", crateName)
+	}
+	if _, err := os.Stat(crate.path); err != nil {
+		return crate, fmt.Errorf("Crate '%v' not found", crateName)
+	}
+
+	return crate, nil
+}
+
+// Returns the target with the given name, if the crate and the target exist
+func (api *daemonAPI) findTarget(crateName string, targetName string) (Crate, Target, error) {
+	crate, err := api.findCrate(crateName)
+	if err != nil {
+		return crate, Target{}, err
+	}
+
+	target := generateTargetObj(crate.name, targetName, api.program)
+	if isValidAPIName(targetName) == false {
+		return crate, target, fmt.Errorf("Target '%v' not found", targetName)
+	}
+	if _, err := os.Stat(target.path); err != nil {
+		return crate, target, fmt.Errorf("Target '%v' not found", targetName)
+	}
+
+	return crate, target, nil
+}
+
+func (api *daemonAPI) serveCrates(writer http.ResponseWriter) {
+	runs, response := readJournal(api.program)
+	if response.exitCode != 0 {
+		writeAPIError(writer, http.StatusInternalServerError, response.message)
+		return
+	}
+
+	result := []apiCrate{}
+
+	crates, _ := getUserCrates(api.program)
+	for _, crate := range crates {
+		disabled, _ := isCrateDisabled(crate, api.program)
+		entry := apiCrate{Name: crate.name, Disabled: disabled, Targets: []apiTarget{}}
+
+		targets, _ := getCrateTargets(crate, api.program)
+		for _, target := range targets {
+			entry.Targets = append(entry.Targets, api.getAPITarget(target, runs))
+		}
+
+		result = append(result, entry)
+	}
+
+	writeAPIResponse(writer, http.StatusOK, result)
+}
+
+func (api *daemonAPI) serveTargetStatus(writer http.ResponseWriter, crateName string, targetName string) {
+	_, target, err := api.findTarget(crateName, targetName)
+	if err != nil {
+		writeAPIError(writer, http.StatusNotFound, err.Error())
+		return
+	}
+
+	runs, response := readJournal(api.program)
+	if response.exitCode != 0 {
+		writeAPIError(writer, http.StatusInternalServerError, response.message)
+		return
+	}
+
+	writeAPIResponse(writer, http.StatusOK, api.getAPITarget(target, runs))
+}
+
+func (api *daemonAPI) serveTargetState(writer http.ResponseWriter, crateName string, targetName string, enable bool) {
+	_, target, err := api.findTarget(crateName, targetName)
+	if err != nil {
+		writeAPIError(writer, http.StatusNotFound, err.Error())
+		return
+	}
+
+	var response functionResponse
+	if enable == true {
+		response = enableTarget(target, api.program)
+	} else {
+		response = disableTarget(target, api.program)
+	}
+
+	if response.exitCode != 0 {
+		writeAPIError(writer, http.StatusInternalServerError, response.message)
+		return
+	}
+
+	writeAPIResponse(writer, http.StatusOK, map[string]string{"message": response.message})
+}
+
+type apiSyncRequest struct {
+	Crate   string   `json:"crate"`
+	Targets []string `json:"targets"`
+}
+
+// Queues a sync of the given targets (all the targets of the crate if none)
+func (api *daemonAPI) serveSync(writer http.ResponseWriter, request *http.Request) {
+	var syncRequest apiSyncRequest
+	err := json.NewDecoder(request.Body).Decode(&syncRequest)
+	if err != nil {
+		writeAPIError(writer, http.StatusBadRequest, "Invalid request -> "+err.Error())
+		return
+	}
+
+	crate, err := api.findCrate(syncRequest.Crate)
+	if err != nil {
+		writeAPIError(writer, http.StatusNotFound, err.Error())
+		return
+	}
+
+	var targets []Target
+	if len(syncRequest.Targets) == 0 {
+		var response functionResponse
+		targets, response = getCrateTargets(crate, api.program)
+		if response.exitCode != 0 {
+			writeAPIError(writer, http.StatusNotFound, response.message)
+			return
+		}
+	}
+	for _, name := range syncRequest.Targets {
+		_, target, err := api.findTarget(crate.name, name)
+		if err != nil {
+			writeAPIError(writer, http.StatusNotFound, err.Error())
+			return
+		}
+		targets = append(targets, target)
+	}
+
+	run := api.newRun(crate, targets, "api")
+	select {
+	case api.queue <- run:
+	default:
+		api.mutex.Lock()
+		run.State = daemonRunFinished
+		run.ExitCode = 1
+		api.mutex.Unlock()
+
+		writeAPIError(writer, http.StatusServiceUnavailable, "Too many queued runs")
+		return
+	}
+
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	writeAPIResponse(writer, http.StatusAccepted, run)
+}
+
+// Streams the output of a run (from its beginning) as server-sent events: an
+// 'output' event per line, then an 'end' event with the run
+func (api *daemonAPI) serveRunEvents(writer http.ResponseWriter, request *http.Request, id string) {
+	run := api.getRun(id)
+	if run == nil {
+		writeAPIError(writer, http.StatusNotFound, fmt.Sprintf("Run '%v' not found", id))
+		return
+	}
+
+	flusher, ok := writer.(http.Flusher)
+	if ok == false {
+		writeAPIError(writer, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+
+	writeEvent := func(event string, data string) {
+		fmt.Fprintf(writer, "event: %v\ndata: %v\n\n", event, data)
+		flusher.Flush()
+	}
+
+	// Send the output so far, then follow the run
+	api.mutex.Lock()
+	backlog := append([]string{}, run.output...)
+	var lines chan string
+	if run.State != daemonRunFinished {
+		lines = make(chan string, 4096)
+		run.subscribers[lines] = true
+	}
+	api.mutex.Unlock()
+
+	for _, line := range backlog {
+		writeEvent("output", line)
+	}
+
+	if lines != nil {
+		for {
+			select {
+			case line, open := <-lines:
+				if open == false {
+					lines = nil
+				} else {
+					writeEvent("output", line)
+				}
+			case <-request.Context().Done():
+				api.mutex.Lock()
+				if run.subscribers != nil && run.subscribers[lines] == true {
+					delete(run.subscribers, lines)
+				}
+				api.mutex.Unlock()
+				return
+			}
+
+			if lines == nil {
+				break
+			}
+		}
+	}
+
+	api.mutex.Lock()
+	content, _ := json.Marshal(run)
+	finished := run.State == daemonRunFinished
+	api.mutex.Unlock()
+
+	// Disconnected for being too slow
+	if finished == false {
+		return
+	}
+
+	writeEvent("end", string(content))
+}
+
+//
+//// CLIENT
+//
+
+func newDaemonAPIClient(program Program) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network string, address string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", program.userDaemonSocket)
+			},
+		},
+	}
+}
+
+// Reads the error returned by the API
+func readAPIError(response *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil || body.Error == "" {
+		return fmt.Errorf("unexpected response from the daemon (%v)", response.Status)
+	}
+
+	return errors.New(body.Error)
+}
+
+// Asks the daemon to sync the targets and prints the output of the run as it
+// happens. Returns the exit code of the run.
+func syncViaDaemon(crate Crate, targets []Target, program Program) functionResponse {
+	client := newDaemonAPIClient(program)
+
+	failed := func(err error) functionResponse {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to sync through the daemon -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	syncRequest := apiSyncRequest{Crate: crate.name, Targets: []string{}}
+	for _, target := range targets {
+		syncRequest.Targets = append(syncRequest.Targets, target.name)
+	}
+	content, _ := json.Marshal(syncRequest)
+
+	response, err := client.Post("http://daemon/v1/sync", "application/json", bytes.NewReader(content))
+	if err != nil {
+		return failed(fmt.Errorf("could not connect to the daemon on %v (is 'synctropy daemon' running?)", program.userDaemonSocket))
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusAccepted {
+		return failed(readAPIError(response))
+	}
+
+	var run daemonRun
+	err = json.NewDecoder(response.Body).Decode(&run)
+	if err != nil {
+		return failed(err)
+	}
+
+	space()
+	showText(gray.Sprintf(fmt.Sprintf("Run %v queued by the daemon", run.ID)), program.indentLevel)
+
+	events, err := client.Get("http://daemon/v1/runs/" + run.ID + "/events")
+	if err != nil {
+		return failed(err)
+	}
+	defer events.Body.Close()
+
+	if events.StatusCode != http.StatusOK {
+		return failed(readAPIError(events))
+	}
+
+	scanner := bufio.NewScanner(events.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	event := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && event == "output":
+			fmt.Println(strings.TrimPrefix(line, "data: "))
+		case strings.HasPrefix(line, "data: ") && event == "end":
+			err = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &run)
+			if err != nil {
+				return failed(err)
+			}
+
+			return functionResponse{
+				exitCode: run.ExitCode,
+			}
+		}
+	}
+
+	return failed(fmt.Errorf("the output stream of run %v was interrupted", run.ID))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFindTarget(t *testing.T) {
+	program := newSelectionTestProgram(t, []string{"home/nvim", "home/.hidden", "work/git"}, nil)
+	api := &daemonAPI{program: program}
+
+	tests := []struct {
+		crate   string
+		target  string
+		wantErr bool
+	}{
+		{"home", "nvim", false},
+		{"work", "git", false},
+		{"home", "missing", true},
+		{"missing", "nvim", true},
+		{"", "nvim", true},
+		{"home", "", true},
+		{"home", ".hidden", true},
+		// Names must not reach outside of their directory, even to existing paths
+		{".", "nvim", true},
+		{"..", "nvim", true},
+		{"home/targets/../../work", "git", true},
+		{"home", "..", true},
+		{"home", "../../work/targets/git", true},
+		{"home", "nvim/", true},
+	}
+
+	for _, test := range tests {
+		_, target, err := api.findTarget(test.crate, test.target)
+		if (err != nil) != test.wantErr {
+			t.Errorf("findTarget(%q, %q) = %v, want an error: %v", test.crate, test.target, err, test.wantErr)
+		}
+		if err == nil && target.name != test.target {
+			t.Errorf("findTarget(%q, %q) returned the target %q", test.crate, test.target, target.name)
+		}
+	}
+}
+
+func TestServeSyncNames(t *testing.T) {
+	program := newSelectionTestProgram(t, []string{"home/nvim", "work/git"}, nil)
+
+	tests := []struct {
+		body       string
+		wantStatus int
+	}{
+		{`{"crate": "home", "targets": ["nvim"]}`, http.StatusAccepted},
+		{`{"crate": "home"}`, http.StatusAccepted},
+		{`{"crate": ""}`, http.StatusNotFound},
+		{`{"crate": "missing"}`, http.StatusNotFound},
+		{`{"crate": "."}`, http.StatusNotFound},
+		{`{"crate": ".."}`, http.StatusNotFound},
+		{`{"crate": "home/targets/../../work"}`, http.StatusNotFound},
+		{`{"crate": "home", "targets": ["../../work/targets/git"]}`, http.StatusNotFound},
+		{`{"crate": "home", "targets": ["nvim", ".."]}`, http.StatusNotFound},
+		{`{"crate": `, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		api := &daemonAPI{program: program, queue: make(chan *daemonRun, 1)}
+
+		recorder := httptest.NewRecorder()
+		api.serveHTTP(recorder, httptest.NewRequest("POST", "/v1/sync", strings.NewReader(test.body)))
+
+		if recorder.Code != test.wantStatus {
+			t.Errorf("POST /v1/sync %v = %v (%v), want %v", test.body, recorder.Code, strings.TrimSpace(recorder.Body.String()), test.wantStatus)
+		}
+		if queued := len(api.queue); (queued == 1) != (test.wantStatus == http.StatusAccepted) {
+			t.Errorf("POST /v1/sync %v queued %v runs", test.body, queued)
+		}
+	}
+}
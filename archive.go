@@ -0,0 +1,659 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	// External modules
+	age "filippo.io/age"
+	terminal "golang.org/x/crypto/ssh/terminal"
+)
+
+//
+//// ARCHIVE CONFIGURATION
+//
+
+// Encrypted archives of the primary directory of targets ('targets archive') are
+// configured in the 'archive' section of the crate configuration:
+//
+//	{
+//		"archive": {
+//			"recipients": ["age1..."],
+//			"recipientsFile": "~/.config/synctropy/recipients.txt",
+//			"identityFile": "~/.config/synctropy/key.txt",
+//			"passphrase": false,
+//			"dir": "/mnt/offsite"
+//		}
+//	}
+//
+// Archives are encrypted with age, to the X25519 recipients (public keys), or with
+// a passphrase (scrypt) if 'passphrase' is true. The identity file (private keys)
+// is used to decrypt them.
+type crateArchiveConfig struct {
+	Recipients     []string   `json:"recipients"`
+	RecipientsFile string     `json:"recipientsFile"`
+	IdentityFile   string     `json:"identityFile"`
+	Passphrase     configBool `json:"passphrase"`
+	Dir            string     `json:"dir"`
+}
+
+// Environment variable used (if set) instead of asking for the archive passphrase
+const archivePassphraseEnvVar = "SYNCTROPY_ARCHIVE_PASSPHRASE"
+
+// Name of the manifest stored at the end of every archive
+const archiveManifestName = ".synctropy-archive.json"
+
+// Extension of the archive files
+const archiveFileExtension = ".tar.gz.age"
+
+type archiveManifest struct {
+	Crate     string            `json:"crate"`
+	Target    string            `json:"target"`
+	Source    string            `json:"source"`
+	CreatedAt time.Time         `json:"created_at"`
+	Files     map[string]string `json:"files"`
+}
+
+// Asks for the archive passphrase (twice when encrypting)
+func readArchivePassphrase(confirm bool, program Program) (string, error) {
+	if passphrase := os.Getenv(archivePassphraseEnvVar); passphrase != "" {
+		return passphrase, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) == false {
+		return "", fmt.Errorf("standard input is not a terminal to ask for the passphrase (it can be set with $%v)", archivePassphraseEnvVar)
+	}
+
+	fmt.Print(returnText("Enter archive passphrase: ", program.indentLevel))
+	passphrase, err := terminal.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	if len(passphrase) == 0 {
+		return "", errors.New("empty passphrase")
+	}
+
+	if confirm == true {
+		fmt.Print(returnText("Confirm archive passphrase: ", program.indentLevel))
+		confirmation, err := terminal.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", err
+		}
+		if string(confirmation) != string(passphrase) {
+			return "", errors.New("the passphrases do not match")
+		}
+	}
+
+	return string(passphrase), nil
+}
+
+func getArchiveRecipients(config crateArchiveConfig, crate Crate, program Program) ([]age.Recipient, error) {
+	if config.Passphrase == true {
+		passphrase, err := readArchivePassphrase(true, program)
+		if err != nil {
+			return nil, err
+		}
+
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		return []age.Recipient{recipient}, nil
+	}
+
+	var recipients []age.Recipient
+	for _, publicKey := range config.Recipients {
+		recipient, err := age.ParseX25519Recipient(publicKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient '%v' -> %v", publicKey, err.Error())
+		}
+		recipients = append(recipients, recipient)
+	}
+
+	if config.RecipientsFile != "" {
+		file, err := os.Open(expandConfigPath(config.RecipientsFile, crate.environment))
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		fileRecipients, err := age.ParseRecipients(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse recipients file -> %v", err.Error())
+		}
+		recipients = append(recipients, fileRecipients...)
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients set and passphrase disabled in the 'archive' section of %v", crate.configPath)
+	}
+
+	return recipients, nil
+}
+
+// Returns the identities used to decrypt an archive: the given identity file, the
+// one of the crate configuration, or a passphrase
+func getArchiveIdentities(config crateArchiveConfig, identityFile string, crate Crate, program Program) ([]age.Identity, error) {
+	if identityFile == "" && config.Passphrase == false && config.IdentityFile != "" {
+		identityFile = expandConfigPath(config.IdentityFile, crate.environment)
+	}
+
+	if identityFile == "" {
+		passphrase, err := readArchivePassphrase(false, program)
+		if err != nil {
+			return nil, err
+		}
+
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		return []age.Identity{identity}, nil
+	}
+
+	file, err := os.Open(identityFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse identity file -> %v", err.Error())
+	}
+
+	return identities, nil
+}
+
+//
+//// ARCHIVES
+//
+
+// Writes an encrypted archive (tar, gzip and age) of a directory, ending with a
+// manifest holding the SHA-256 of every file
+func writeArchive(writer io.Writer, sourceDir string, manifest archiveManifest, recipients []age.Recipient) (int, error) {
+	tree, _, err := scanSyncTree(localSyncFS{root: sourceDir}, []string{"/" + archiveManifestName})
+	if err != nil {
+		return 0, err
+	}
+
+	encrypted, err := age.Encrypt(writer, recipients...)
+	if err != nil {
+		return 0, err
+	}
+	compressed := gzip.NewWriter(encrypted)
+	archive := tar.NewWriter(compressed)
+
+	manifest.Files = make(map[string]string)
+
+	for _, name := range sortedSyncPaths(tree) {
+		entry := tree[name]
+
+		header := &tar.Header{
+			Name:    name,
+			Mode:    int64(entry.mode),
+			ModTime: entry.modTime,
+			Format:  tar.FormatPAX,
+		}
+
+		switch {
+		case entry.isDir == true:
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+		case entry.isSymlink == true:
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = entry.linkTarget
+		default:
+			header.Typeflag = tar.TypeReg
+			header.Size = entry.size
+		}
+
+		err := archive.WriteHeader(header)
+		if err != nil {
+			return 0, err
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			file, err := os.Open(filepath.Join(sourceDir, filepath.FromSlash(name)))
+			if err != nil {
+				return 0, err
+			}
+
+			hash := sha256.New()
+			_, err = io.CopyN(io.MultiWriter(archive, hash), file, entry.size)
+			file.Close()
+			if err != nil {
+				return 0, fmt.Errorf("failed to archive %v -> %v", name, err.Error())
+			}
+
+			manifest.Files[name] = hex.EncodeToString(hash.Sum(nil))
+		}
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return 0, err
+	}
+
+	err = archive.WriteHeader(&tar.Header{
+		Name:     archiveManifestName,
+		Typeflag: tar.TypeReg,
+		Mode:     0600,
+		Size:     int64(len(content)),
+		ModTime:  manifest.CreatedAt,
+	})
+	if err == nil {
+		_, err = archive.Write(content)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err == nil {
+		err = compressed.Close()
+	}
+	if err == nil {
+		err = encrypted.Close()
+	}
+
+	return len(tree), err
+}
+
+// Extracts an encrypted archive into a directory (which must be empty or not
+// exist), verifying the contents of every file against the manifest
+func extractArchive(reader io.Reader, destDir string, identities []age.Identity) (archiveManifest, error) {
+	var manifest archiveManifest
+	var found bool
+
+	decrypted, err := age.Decrypt(reader, identities...)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to decrypt archive -> %v", err.Error())
+	}
+	decompressed, err := gzip.NewReader(decrypted)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to decompress archive -> %v", err.Error())
+	}
+	archive := tar.NewReader(decompressed)
+
+	hashes := make(map[string]string)
+	symlinks := make(map[string]bool)
+	var dirs []*tar.Header
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return manifest, fmt.Errorf("failed to read archive -> %v", err.Error())
+		}
+
+		name := path.Clean(strings.TrimSuffix(header.Name, "/"))
+		if name == "." || path.IsAbs(name) == true || name == ".." || strings.HasPrefix(name, "../") == true {
+			return manifest, fmt.Errorf("invalid path '%v' in archive", header.Name)
+		}
+
+		if name == archiveManifestName {
+			content, err := io.ReadAll(archive)
+			if err == nil {
+				err = json.Unmarshal(content, &manifest)
+			}
+			if err != nil {
+				return manifest, fmt.Errorf("failed to read archive manifest -> %v", err.Error())
+			}
+			found = true
+			continue
+		}
+
+		// Never write through a symlink extracted before
+		for parent := path.Dir(name); parent != "."; parent = path.Dir(parent) {
+			if symlinks[parent] == true {
+				return manifest, fmt.Errorf("invalid path '%v' in archive (inside a symlink)", header.Name)
+			}
+		}
+
+		target := filepath.Join(destDir, filepath.FromSlash(name))
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0700)
+			dirs = append(dirs, header)
+		case tar.TypeSymlink:
+			err = os.Symlink(header.Linkname, target)
+			symlinks[name] = true
+		case tar.TypeReg:
+			var file *os.File
+			file, err = os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+			if err != nil {
+				break
+			}
+
+			hash := sha256.New()
+			_, err = io.Copy(io.MultiWriter(file, hash), archive)
+			closeErr := file.Close()
+			if err == nil {
+				err = closeErr
+			}
+			if err == nil {
+				err = os.Chmod(target, mode)
+			}
+			if err == nil {
+				err = os.Chtimes(target, header.ModTime, header.ModTime)
+			}
+			hashes[name] = hex.EncodeToString(hash.Sum(nil))
+		default:
+			err = fmt.Errorf("unsupported entry type")
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("failed to extract %v -> %v", name, err.Error())
+		}
+	}
+
+	// Integrity verification
+	if found == false {
+		return manifest, errors.New("archive manifest not found (the archive is truncated or was not created by synctropy)")
+	}
+	for name, hash := range manifest.Files {
+		if extractedHash, exists := hashes[name]; exists == false {
+			return manifest, fmt.Errorf("%v is missing from the archive", name)
+		} else if extractedHash != hash {
+			return manifest, fmt.Errorf("%v does not match its checksum", name)
+		}
+	}
+	if len(hashes) != len(manifest.Files) {
+		return manifest, errors.New("the archive contains files not listed in its manifest")
+	}
+
+	// Directory metadata is applied last, as extracting their contents changes it
+	for index := len(dirs) - 1; index >= 0; index-- {
+		target := filepath.Join(destDir, filepath.FromSlash(path.Clean(dirs[index].Name)))
+		os.Chmod(target, os.FileMode(dirs[index].Mode).Perm())
+		os.Chtimes(target, dirs[index].ModTime, dirs[index].ModTime)
+	}
+
+	return manifest, nil
+}
+
+//
+//// TARGET COMMANDS
+//
+
+func targetsArchive(crate Crate, targets []Target, outputPath string, program Program) functionResponse {
+	config, response := readCrateConfig(crate, program)
+	if response.exitCode != 0 {
+		return response
+	}
+
+	if outputPath != "" && len(targets) > 1 {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Flag '--output/-o' can only be used with a single target",
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	var recipients []age.Recipient
+
+	for _, target := range targets {
+		space()
+		showInfoSectionTitle(displayTargetTag("Archiving", target), program.indentLevel)
+
+		targetConfig, response := readTargetConfig(target, program)
+		if response.exitCode != 0 {
+			response.indentLevel = program.indentLevel + 1
+			return response
+		}
+
+		primaryDir, err := getTargetPrimaryDir(target, targetConfig)
+		if err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to find primary directory -> " + err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+
+		// The recipients (and passphrase) are the same for every target
+		if recipients == nil {
+			recipients, err = getArchiveRecipients(config.Archive, crate, incrementProgramIndentLevel(program, 1))
+			if err != nil {
+				return functionResponse{
+					exitCode:    1,
+					message:     fmt.Sprintf("Failed to get archive recipients -> " + err.Error()),
+					logLevel:    "error",
+					indentLevel: program.indentLevel + 1,
+				}
+			}
+		}
+
+		manifest := archiveManifest{
+			Crate:     crate.name,
+			Target:    target.name,
+			Source:    primaryDir,
+			CreatedAt: time.Now(),
+		}
+
+		archivePath := outputPath
+		if archivePath == "" {
+			archiveDir := "."
+			if config.Archive.Dir != "" {
+				archiveDir = expandConfigPath(config.Archive.Dir, crate.environment)
+			}
+			archivePath = filepath.Join(archiveDir, fmt.Sprintf("%v-%v-%v%v", crate.name, target.name, manifest.CreatedAt.Format(snapshotIDFormat), archiveFileExtension))
+		}
+
+		showText(fmt.Sprintf("Source: %s", blue.Sprintf(primaryDir)), program.indentLevel+1)
+
+		// Written through a temporary file, so that a failed archive never
+		// replaces a good one
+		tempPath := archivePath + syncTempFileSuffix
+		file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to create archive -> " + err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+
+		entries, err := writeArchive(file, primaryDir, manifest, recipients)
+		closeErr := file.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tempPath, archivePath)
+		}
+		if err != nil {
+			os.Remove(tempPath)
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to write archive -> " + err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+
+		info, _ := os.Stat(archivePath)
+		showText(gray.Sprintf(fmt.Sprintf("%v entries, %v", entries, formatByteSize(info.Size()))), program.indentLevel+1)
+		showSuccess(fmt.Sprintf("> Created %v", archivePath), program.indentLevel+1)
+	}
+
+	return functionResponse{
+		exitCode: 0,
+	}
+}
+
+func targetsUnarchive(crate Crate, target Target, archivePath string, identityFile string, destDir string, program Program) functionResponse {
+	space()
+	showInfoSectionTitle(displayTargetTag("Extracting archive", target), program.indentLevel)
+
+	config, response := readCrateConfig(crate, program)
+	if response.exitCode != 0 {
+		response.indentLevel = program.indentLevel + 1
+		return response
+	}
+
+	if destDir == "" {
+		targetConfig, response := readTargetConfig(target, program)
+		if response.exitCode != 0 {
+			response.indentLevel = program.indentLevel + 1
+			return response
+		}
+
+		primaryDir, err := getTargetPrimaryDir(target, targetConfig)
+		if err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to find primary directory -> " + err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+		destDir = primaryDir
+
+		// Extracting in place overwrites the current files
+		showAttention(fmt.Sprintf("> The files in %v will be replaced by the ones in the archive", destDir), program.indentLevel+1)
+		if askConfirmation("Enter 'yes/y' to confirm or 'no/n' to cancel the operation", incrementProgramIndentLevel(program, 1)) == false {
+			return functionResponse{
+				exitCode:    1,
+				message:     "Operation cancelled",
+				logLevel:    "attention",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+	}
+
+	showText(fmt.Sprintf("Destination: %s", blue.Sprintf(destDir)), program.indentLevel+1)
+
+	identities, err := getArchiveIdentities(config.Archive, identityFile, crate, incrementProgramIndentLevel(program, 1))
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to get archive identities -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to open archive -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+	defer file.Close()
+
+	// The archive is extracted (and verified) in a temporary directory next to the
+	// destination, which is only modified if the whole archive is valid
+	destDir = filepath.Clean(destDir)
+	err = os.MkdirAll(filepath.Dir(destDir), 0755)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to create destination directory -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	tempDir, err := os.MkdirTemp(filepath.Dir(destDir), ".synctropy-unarchive-")
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to create temporary directory -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+	defer os.RemoveAll(tempDir)
+
+	manifest, err := extractArchive(file, tempDir, identities)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to extract archive (the destination was not modified) -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	if manifest.Crate != crate.name || manifest.Target != target.name {
+		showAttention(fmt.Sprintf("> The archive was created for target %v/%v", manifest.Crate, manifest.Target), program.indentLevel+1)
+	}
+	showText(gray.Sprintf(fmt.Sprintf("Verified %v files (archive created on %v)", len(manifest.Files), manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"))), program.indentLevel+1)
+
+	err = moveExtractedArchive(tempDir, destDir)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to move extracted files -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	return functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
+	}
+}
+
+// Moves the top-level entries of the extracted archive into the destination,
+// replacing the existing ones
+func moveExtractedArchive(tempDir string, destDir string) error {
+	if _, err := os.Lstat(destDir); os.IsNotExist(err) {
+		err := os.Rename(tempDir, destDir)
+		if err == nil {
+			return os.Chmod(destDir, 0755)
+		}
+		return err
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		target := filepath.Join(destDir, entry.Name())
+
+		err := os.RemoveAll(target)
+		if err == nil {
+			err = os.Rename(filepath.Join(tempDir, entry.Name()), target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -33,6 +33,13 @@ type Program struct {
 	userTemplatesDir        string
 	userTargetsTemplatesDir string
 	userCratesTemplatesDir  string
+	userHooksDir            string
+	userTargetsHooksDir     string
+	userJournalFile         string
+	userSnapshotsDir        string
+	userDaemonStateFile     string
+	userDaemonSocket        string
+	userConfigFile          string
 	indentLevel             int
 }
 
@@ -93,6 +100,13 @@ func initializeDefaultProgram(customUserDataDir string) Program {
 	userTemplatesDir := userDataDir + "/templates"
 	userTargetsTemplatesDir := userTemplatesDir + "/targets"
 	userCratesTemplatesDir := userTemplatesDir + "/crates"
+	userHooksDir := userDataDir + "/hooks"
+	userTargetsHooksDir := userHooksDir + "/targets"
+	userJournalFile := userDataDir + "/journal.jsonl"
+	userSnapshotsDir := userDataDir + "/snapshots"
+	userDaemonStateFile := userDataDir + "/daemon.json"
+	userDaemonSocket := userDataDir + "/daemon.sock"
+	userConfigFile := userDataDir + "/config.json"
 
 	// INDENT LEVEL
 	indentLevel := 0
@@ -110,6 +124,13 @@ func initializeDefaultProgram(customUserDataDir string) Program {
 		userTemplatesDir:        userTemplatesDir,
 		userTargetsTemplatesDir: userTargetsTemplatesDir,
 		userCratesTemplatesDir:  userCratesTemplatesDir,
+		userHooksDir:            userHooksDir,
+		userTargetsHooksDir:     userTargetsHooksDir,
+		userJournalFile:         userJournalFile,
+		userSnapshotsDir:        userSnapshotsDir,
+		userDaemonStateFile:     userDaemonStateFile,
+		userDaemonSocket:        userDaemonSocket,
+		userConfigFile:          userConfigFile,
 		indentLevel:             indentLevel,
 	}
 }
@@ -40,6 +40,10 @@ func decrementProgramIndentLevel(program Program, count int) Program {
 
 func handleFunctionResponse(response functionResponse, finishProgramAfter bool) {
 	if response.exitCode != 0 {
+		if response.logLevel == "error" {
+			emitEvent(runEvent{Event: "error", Message: response.message})
+		}
+
 		if response.logLevel == "attention" {
 			showAttention(fmt.Sprintf("> "+response.message), response.indentLevel)
 
@@ -6,10 +6,13 @@ package main
 
 import (
 	// Modules in GOROOT
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"strings"
+	"syscall"
 
 	// External modules
 	survey "github.com/AlecAivazis/survey/v2"
@@ -21,24 +24,27 @@ import (
 //
 
 type Crate struct {
-	name         string
-	path         string
-	hooksDir     string
-	targetsDir   string
-	tempDir      string
-	disabledPath string
-	environment  map[string]string
+	name            string
+	path            string
+	hooksDir        string
+	targetsHooksDir string
+	targetsDir      string
+	tempDir         string
+	disabledPath    string
+	lockPath        string
+	configPath      string
+	environment     map[string]string
 }
 
-func getSelectedCratesFromCLI(crateNames []string, allCrates bool, interactiveSelection bool, multiple bool, program Program) ([]Crate, functionResponse) {
+func getSelectedCratesFromCLI(crateNames []string, matches []string, allCrates bool, interactiveSelection bool, tags []string, groups []string, multiple bool, program Program) ([]Crate, functionResponse) {
 	var selectedCrates []Crate
 
 	if interactiveSelection == true {
-		if allCrates != false || crateNames != nil {
+		if allCrates != false || crateNames != nil || matches != nil || tags != nil || groups != nil {
 			return []Crate{}, functionResponse{
 				exitCode:    1,
 				logLevel:    "error",
-				message:     fmt.Sprintf("Flag '--interactive/-i' cannot be used together with flags '--crate/-c' or '--all/-a'"),
+				message:     fmt.Sprintf("Flag '--interactive/-i' cannot be used together with flags '--crate/-c', '--match', '--all/-a', '--tag' or '--group/-g'"),
 				indentLevel: program.indentLevel,
 			}
 		}
@@ -84,43 +90,131 @@ func getSelectedCratesFromCLI(crateNames []string, allCrates bool, interactiveSe
 		}
 
 		return selectedCrates, functionResponse{exitCode: 0}
-	} else {
-		if allCrates != false && crateNames != nil {
+	}
+
+	response := validateTagSelectors(tags, program)
+	if response.exitCode != 0 {
+		return []Crate{}, response
+	}
+
+	if allCrates != false && (crateNames != nil || matches != nil) {
+		return []Crate{}, functionResponse{
+			exitCode:    1,
+			logLevel:    "error",
+			message:     fmt.Sprintf("Conflicting flags: flag '--all/-a' cannot be specified with flags '--crate/-c' or '--match'"),
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	response = validateNamePatterns(crateNames, program)
+	if response.exitCode != 0 {
+		return []Crate{}, response
+	}
+
+	expressions, response := compileMatchSelectors(matches, program)
+	if response.exitCode != 0 {
+		return []Crate{}, response
+	}
+
+	var patternMatches []patternMatch
+
+	// Selects the crates matched by a pattern (failing if there are none)
+	selectMatchedCrates := func(flag string, pattern string, matchedCrates []Crate) functionResponse {
+		if len(matchedCrates) == 0 {
+			return functionResponse{
+				exitCode:    1,
+				logLevel:    "error",
+				message:     fmt.Sprintf("No crates match the pattern '%v'", pattern),
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		names := make([]string, len(matchedCrates))
+		for i, crate := range matchedCrates {
+			names[i] = crate.name
+		}
+		patternMatches = append(patternMatches, patternMatch{flag: flag, pattern: pattern, names: names})
+		selectedCrates = append(selectedCrates, matchedCrates...)
+
+		return functionResponse{exitCode: 0}
+	}
+
+	if groups != nil {
+		if allCrates != false || crateNames != nil || matches != nil {
 			return []Crate{}, functionResponse{
 				exitCode:    1,
 				logLevel:    "error",
-				message:     fmt.Sprintf("Conflicting flags: both '--crate/-c' and '--all/-a' flags cannot be specified at the same time"),
+				message:     fmt.Sprintf("Flag '--group/-g' cannot be used with flags '--crate/-c', '--match' or '--all/-a'"),
 				indentLevel: program.indentLevel,
 			}
 		}
 
-		if allCrates == false && crateNames == nil {
+		selectedCrates, response = getGroupsCrates(groups, program)
+		if response.exitCode != 0 {
+			return []Crate{}, response
+		}
+	} else if crateNames == nil && matches == nil {
+		if allCrates == false && tags == nil {
 			return []Crate{}, functionResponse{
 				exitCode:    1,
 				logLevel:    "error",
-				message:     fmt.Sprintf("Missing required flag: '--interactive/--i' or '--crate/-c' or '--all/-a' flag must be specified"),
+				message:     fmt.Sprintf("Missing required flag: '--interactive/--i' or '--crate/-c' or '--match' or '--all/-a' or '--tag' or '--group/-g' flag must be specified"),
 				indentLevel: program.indentLevel,
 			}
 		}
 
-		if allCrates == true {
-			selectedCrates, response := getUserCrates(program)
-			handleFunctionResponse(response, true)
+		// All the crates (possibly filtered by their tags)
+		selectedCrates, response = getUserCrates(program)
+		handleFunctionResponse(response, true)
+	} else {
+		availableCrates, _ := getUserCrates(program)
+
+		var namedCrates []Crate
+		for _, element := range crateNames {
+			if isNamePattern(element) == true {
+				response := selectMatchedCrates("-c", element, matchCrateNames(availableCrates, globMatcher(element)))
+				if response.exitCode != 0 {
+					return []Crate{}, response
+				}
+			} else {
+				crate := generateCrateObj(element, program)
+				namedCrates = append(namedCrates, crate)
+				selectedCrates = append(selectedCrates, crate)
+			}
+		}
 
-			return selectedCrates, functionResponse{exitCode: 0}
-		} else {
-			selectedCrates = make([]Crate, len(crateNames))
-			for i, element := range crateNames {
-				selectedCrates[i] = generateCrateObj(element, program)
+		// Verify crates
+		response := verifyCratesDirectories(namedCrates, program)
+		handleFunctionResponse(response, true)
+
+		for i, expression := range expressions {
+			response := selectMatchedCrates("--match", matches[i], matchCrateNames(availableCrates, expression.MatchString))
+			if response.exitCode != 0 {
+				return []Crate{}, response
 			}
+		}
+	}
 
-			// Verify crates
-			response := verifyCratesDirectories(selectedCrates, program)
-			handleFunctionResponse(response, true)
+	showPatternMatches(patternMatches, program)
+	selectedCrates = uniqueCrates(selectedCrates)
+
+	if tags != nil {
+		selectedCrates, response = filterCratesByTags(selectedCrates, tags, program)
+		if response.exitCode != 0 {
+			return []Crate{}, response
+		}
 
-			return selectedCrates, functionResponse{exitCode: 0}
+		if len(selectedCrates) == 0 {
+			return []Crate{}, functionResponse{
+				exitCode:    1,
+				logLevel:    "attention",
+				message:     fmt.Sprintf("No crates match the tags %v", strings.Join(tags, ", ")),
+				indentLevel: program.indentLevel,
+			}
 		}
 	}
+
+	return selectedCrates, functionResponse{exitCode: 0}
 }
 
 func displayCrateTag(msg string, crate Crate) string {
@@ -170,21 +264,27 @@ func generateCrateObj(crate string, program Program) Crate {
 		"USER_TEMPLATES_DIR":         program.userTemplatesDir,
 		"USER_CRATES_TEMPLATES_DIR":  program.userCratesTemplatesDir,
 		"USER_TARGETS_TEMPLATES_DIR": program.userTargetsTemplatesDir,
+		"USER_HOOKS_DIR":             program.userHooksDir,
+		"USER_TARGETS_HOOKS_DIR":     program.userTargetsHooksDir,
 		"CRATE_NAME":                 crate,
 		"CRATE_DIR":                  program.userCratesDir + "/" + crate,
 		"CRATE_HOOKS_DIR":            program.userCratesDir + "/" + crate + "/hooks",
+		"CRATE_TARGETS_HOOKS_DIR":    program.userCratesDir + "/" + crate + "/hooks/targets",
 		"CRATE_TARGETS_DIR":          program.userCratesDir + "/" + crate + "/targets",
 		"CRATE_TEMP_DIR":             program.userCratesDir + "/" + crate + "/.tmp",
 	}
 
 	return Crate{
-		name:         crate,
-		path:         program.userCratesDir + "/" + crate,
-		hooksDir:     program.userCratesDir + "/" + crate + "/hooks",
-		targetsDir:   program.userCratesDir + "/" + crate + "/targets",
-		tempDir:      program.userCratesDir + "/" + crate + "/.tmp",
-		disabledPath: program.userCratesDir + "/" + crate + "/disabled",
-		environment:  defaultCrateEnv,
+		name:            crate,
+		path:            program.userCratesDir + "/" + crate,
+		hooksDir:        program.userCratesDir + "/" + crate + "/hooks",
+		targetsHooksDir: program.userCratesDir + "/" + crate + "/hooks/targets",
+		targetsDir:      program.userCratesDir + "/" + crate + "/targets",
+		tempDir:         program.userCratesDir + "/" + crate + "/.tmp",
+		disabledPath:    program.userCratesDir + "/" + crate + "/disabled",
+		lockPath:        program.userCratesDir + "/" + crate + "/.lock",
+		configPath:      program.userCratesDir + "/" + crate + "/config.json",
+		environment:     defaultCrateEnv,
 	}
 }
 
@@ -208,6 +308,54 @@ func isCrateDisabled(crate Crate, program Program) (bool, functionResponse) {
 	}
 }
 
+//
+//// CRATE LOCK
+//
+
+// A crate is locked while it is being synced, so that two syncs of the same crate
+// (e.g. a manual one and the one started by 'targets watch') never run at the same
+// time. The lock is an advisory lock on the '.lock' file of the crate, released
+// when the file is closed or the process exits; the file holds the PID of the
+// process owning the lock.
+
+var errCrateLocked = errors.New("crate is locked")
+
+func lockCrate(crate Crate) (*os.File, error) {
+	file, err := os.OpenFile(crate.lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		file.Close()
+		return nil, errCrateLocked
+	} else if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	file.Truncate(0)
+	file.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+
+	return file, nil
+}
+
+func unlockCrate(file *os.File) {
+	file.Truncate(0)
+	file.Close()
+}
+
+// Returns the PID written by the process holding the lock of the crate
+func getCrateLockOwner(crate Crate) string {
+	content, err := ioutil.ReadFile(crate.lockPath)
+	if err != nil || strings.TrimSpace(string(content)) == "" {
+		return "unknown"
+	}
+
+	return strings.TrimSpace(string(content))
+}
+
 func enableCrate(crate Crate, program Program) functionResponse {
 	if _, err := os.Stat(crate.disabledPath); os.IsNotExist(err) {
 		return functionResponse{
@@ -355,7 +503,7 @@ func verifyCrateDirectory(crate Crate, program Program) functionResponse {
 	}
 }
 
-func removeCrateTempDirectory(crate Crate, showCrateName bool, notRemoveTempDir bool, program Program) {
+func removeCrateTempDirectory(crate Crate, showCrateName bool, notRemoveTempDir bool, program Program) functionResponse {
 	var response functionResponse
 
 	if showCrateName == true {
@@ -365,14 +513,12 @@ func removeCrateTempDirectory(crate Crate, showCrateName bool, notRemoveTempDir
 	}
 
 	if notRemoveTempDir == true {
-		response = functionResponse{
+		return functionResponse{
 			exitCode:    0,
 			message:     "Skipping",
 			logLevel:    "attention",
 			indentLevel: program.indentLevel + 1,
 		}
-		handleFunctionResponse(response, false)
-		return
 	}
 
 	if _, err := os.Stat(crate.tempDir); os.IsNotExist(err) {
@@ -383,6 +529,16 @@ func removeCrateTempDirectory(crate Crate, showCrateName bool, notRemoveTempDir
 			indentLevel: program.indentLevel + 1,
 		}
 	} else {
+		// Stop the SSH agent left running by the crate hooks (if any)
+		if sshAgentRunning(crate.tempDir) == true {
+			response = stopSSHAgent(crate.tempDir, incrementProgramIndentLevel(program, 1))
+			if response.exitCode != 0 {
+				handleFunctionResponse(response, false)
+			} else {
+				showAttention("> Stopped SSH agent", program.indentLevel+1)
+			}
+		}
+
 		err = os.RemoveAll(crate.tempDir)
 		if err != nil {
 			response = functionResponse{
@@ -401,10 +557,10 @@ func removeCrateTempDirectory(crate Crate, showCrateName bool, notRemoveTempDir
 		}
 	}
 
-	handleFunctionResponse(response, true)
+	return response
 }
 
-func setupCrateTempDirectory(crate Crate, showCrateName bool, notCreateTempDir bool, program Program) {
+func setupCrateTempDirectory(crate Crate, showCrateName bool, notCreateTempDir bool, program Program) functionResponse {
 	var response functionResponse
 
 	if showCrateName == true {
@@ -414,14 +570,12 @@ func setupCrateTempDirectory(crate Crate, showCrateName bool, notCreateTempDir b
 	}
 
 	if notCreateTempDir == true {
-		response = functionResponse{
+		return functionResponse{
 			exitCode:    0,
 			message:     "Skipping",
 			logLevel:    "attention",
 			indentLevel: program.indentLevel + 1,
 		}
-		handleFunctionResponse(response, false)
-		return
 	}
 
 	if _, err := os.Stat(crate.tempDir); err == nil {
@@ -435,14 +589,12 @@ func setupCrateTempDirectory(crate Crate, showCrateName bool, notCreateTempDir b
 
 		err = os.RemoveAll(crate.tempDir)
 		if err != nil {
-			response = functionResponse{
+			return functionResponse{
 				exitCode:    1,
 				logLevel:    "error",
 				message:     fmt.Sprintf("Failed to recreate temporary directory -> '%v'", err.Error()),
 				indentLevel: program.indentLevel + 3,
 			}
-
-			handleFunctionResponse(response, true)
 		}
 	}
 
@@ -456,6 +608,8 @@ func setupCrateTempDirectory(crate Crate, showCrateName bool, notCreateTempDir b
 			indentLevel: program.indentLevel + 1,
 		}
 	} else {
+		emitEvent(runEvent{Event: "crate_tempdir_created", Crate: crate.name, Path: crate.tempDir})
+
 		response = functionResponse{
 			exitCode:    0,
 			logLevel:    "success",
@@ -464,7 +618,7 @@ func setupCrateTempDirectory(crate Crate, showCrateName bool, notCreateTempDir b
 		}
 	}
 
-	handleFunctionResponse(response, true)
+	return response
 }
 
 func cratesCreate(program Program) functionResponse {
@@ -613,8 +767,8 @@ func cratesCreate(program Program) functionResponse {
 	// Run post_create hook (if any)
 	space()
 	showInfoSectionTitle(lightGray.Sprintf("Running ")+orange.Sprintf("post_create")+lightGray.Sprintf(" hook"), program.indentLevel+1)
-	if _, err := os.Stat(crate.hooksDir + "/post_create"); err == nil {
-		_, response := runHook(crate.hooksDir+"/post_create", crate.environment, true, true, true, true, true, incrementProgramIndentLevel(program, 1))
+	if hookExists(crate.hooksDir+"/post_create") == true {
+		_, response := runHook(crate.hooksDir+"/post_create", crate.environment, nil, true, true, true, true, true, incrementProgramIndentLevel(program, 1))
 
 		if response.exitCode != 0 {
 			handleFunctionResponse(response, false)
@@ -703,7 +857,7 @@ func cratesRm(crates []Crate, program Program) functionResponse {
 			for _, target := range targets {
 				showInfoSectionTitle(displayTargetTag(lightGray.Sprintf("Running ")+gray.Sprintf("pre_rm")+lightGray.Sprintf(" hook"), target), program.indentLevel+1)
 
-				if _, err := os.Stat(target.hooksDir + "/pre_rm"); os.IsNotExist(err) {
+				if hookExists(resolveTargetHook(target, "pre_rm", program).path) == false {
 					response = functionResponse{
 						exitCode:    0,
 						message:     "Hook not found",
@@ -714,7 +868,7 @@ func cratesRm(crates []Crate, program Program) functionResponse {
 				} else {
 					program := incrementProgramIndentLevel(program, 1)
 
-					_, response := runHook(target.hooksDir+"/pre_rm", target.environment, true, true, true, true, true, program)
+					_, response := runHook(resolveTargetHook(target, "pre_rm", program).path, target.environment, nil, true, true, true, true, true, program)
 					response.indentLevel = program.indentLevel + 2
 					handleFunctionResponse(response, true)
 				}
@@ -736,7 +890,7 @@ func cratesRm(crates []Crate, program Program) functionResponse {
 
 		showInfoSectionTitle(displayCrateTag(lightGray.Sprintf("Running ")+gray.Sprintf("pre_rm")+lightGray.Sprintf(" hook"), crate), program.indentLevel+1)
 
-		if _, err := os.Stat(crate.hooksDir + "/pre_rm"); os.IsNotExist(err) {
+		if hookExists(crate.hooksDir+"/pre_rm") == false {
 			response = functionResponse{
 				exitCode:    0,
 				message:     "Hook not found",
@@ -747,7 +901,7 @@ func cratesRm(crates []Crate, program Program) functionResponse {
 		} else {
 			program = incrementProgramIndentLevel(program, 1)
 
-			_, response := runHook(crate.hooksDir+"/pre_rm", crate.environment, true, true, true, true, true, program)
+			_, response := runHook(crate.hooksDir+"/pre_rm", crate.environment, nil, true, true, true, true, true, program)
 			response.indentLevel = program.indentLevel + 2
 			handleFunctionResponse(response, true)
 		}
@@ -793,8 +947,12 @@ func cratesLs(program Program) functionResponse {
 
 	for _, crate := range crates {
 		// Get optional description (if hook exists)
-		crateDescription, response := runHook(crate.hooksDir+"/ls", crate.environment, false, false, false, false, false, program)
+		crateDescription, response := runHook(crate.hooksDir+"/ls", crate.environment, nil, false, false, false, false, false, program)
 		crateDescriptionString := crateDescription.Output
+		if crateDescription.status.Description != "" {
+			// Prefer the description reported through the status file
+			crateDescriptionString = crateDescription.status.Description
+		}
 
 		var description string
 
@@ -823,19 +981,19 @@ func cratesLs(program Program) functionResponse {
 
 func cratesEdit(crates []Crate, program Program) functionResponse {
 	hook := "edit"
-	response := cratesRunHooks(crates, []string{hook}, false, false, false, false, false, program)
+	response := cratesRunHooks(crates, []string{hook}, nil, false, false, false, false, false, program)
 
 	return response
 }
 
 func cratesView(crates []Crate, program Program) functionResponse {
 	hook := "view"
-	response := cratesRunHooks(crates, []string{hook}, false, false, false, false, false, program)
+	response := cratesRunHooks(crates, []string{hook}, nil, false, false, false, false, false, program)
 
 	return response
 }
 
-func cratesRunHooks(crates []Crate, hooks []string, notCreateTempDir bool, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, notPrintAlerts bool, program Program) functionResponse {
+func cratesRunHooks(crates []Crate, hooks []string, hookArgs []string, notCreateTempDir bool, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, notPrintAlerts bool, program Program) functionResponse {
 	for index, crate := range crates {
 		space()
 		space()
@@ -865,9 +1023,9 @@ func cratesRunHooks(crates []Crate, hooks []string, notCreateTempDir bool, notRe
 
 		program = incrementProgramIndentLevel(program, 1)
 
-		setupCrateTempDirectory(crate, false, notCreateTempDir, program)
+		handleFunctionResponse(setupCrateTempDirectory(crate, false, notCreateTempDir, program), true)
 
-		response = func(crate Crate, hooks []string, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, program Program) functionResponse {
+		response = func(crate Crate, hooks []string, hookArgs []string, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, program Program) functionResponse {
 			for _, hook := range hooks {
 				space()
 				space()
@@ -875,7 +1033,7 @@ func cratesRunHooks(crates []Crate, hooks []string, notCreateTempDir bool, notRe
 				showInfoSectionTitle(lightGray.Sprintf("Running ")+orange.Sprintf(hook)+lightGray.Sprintf(" hook"), program.indentLevel)
 
 				// Run hook
-				if _, err := os.Stat(crate.hooksDir + "/" + hook); os.IsNotExist(err) {
+				if hookExists(crate.hooksDir+"/"+hook) == false {
 					response = functionResponse{
 						exitCode:    1,
 						message:     fmt.Sprintf("No '%v' hook found", hook),
@@ -884,7 +1042,8 @@ func cratesRunHooks(crates []Crate, hooks []string, notCreateTempDir bool, notRe
 					}
 					handleFunctionResponse(response, false)
 				} else {
-					_, hookResponse := runHook(crate.hooksDir+"/"+hook, crate.environment, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
+					hookResult, hookResponse := runHook(crate.hooksDir+"/"+hook, crate.environment, hookArgs, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
+					showHookStatus(hookResult.status, program)
 
 					if hookResponse.exitCode != 0 {
 						hookResponse.indentLevel = program.indentLevel + 1
@@ -904,7 +1063,7 @@ func cratesRunHooks(crates []Crate, hooks []string, notCreateTempDir bool, notRe
 			return functionResponse{
 				exitCode: 0,
 			}
-		}(crate, hooks, notRemoveTempDir, notPrintOutput, notPrintEntryCmd, program)
+		}(crate, hooks, hookArgs, notRemoveTempDir, notPrintOutput, notPrintEntryCmd, program)
 
 		if response.exitCode != 0 {
 			handleFunctionResponse(response, false)
@@ -912,7 +1071,7 @@ func cratesRunHooks(crates []Crate, hooks []string, notCreateTempDir bool, notRe
 			space()
 			space()
 
-			removeCrateTempDirectory(crate, false, notRemoveTempDir, program)
+			handleFunctionResponse(removeCrateTempDirectory(crate, false, notRemoveTempDir, program), true)
 
 			space()
 			finishProgram(response.exitCode)
@@ -923,7 +1082,7 @@ func cratesRunHooks(crates []Crate, hooks []string, notCreateTempDir bool, notRe
 		space()
 		space()
 
-		removeCrateTempDirectory(crate, false, notRemoveTempDir, program)
+		handleFunctionResponse(removeCrateTempDirectory(crate, false, notRemoveTempDir, program), true)
 
 		program = decrementProgramIndentLevel(program, 1)
 	}
@@ -940,7 +1099,7 @@ func cratesHooksLs(crates []Crate, program Program) functionResponse {
 		orange.Println(fmt.Sprintf("(%v/%v)", index+1, len(crates)))
 		showInfoSectionTitle(displayCrateTag("Listing hooks", crate), program.indentLevel)
 
-		hooks, err := ioutil.ReadDir(crate.hooksDir)
+		hooks, err := listHooksInDir(crate.hooksDir)
 		if err != nil {
 			return functionResponse{
 				exitCode:    1,
@@ -950,40 +1109,15 @@ func cratesHooksLs(crates []Crate, program Program) functionResponse {
 			}
 		}
 
-		// Filter out .entry files
-		filteredHooks := make([]os.FileInfo, 0)
-		for _, element := range hooks {
-			if !strings.HasSuffix(element.Name(), ".entry") {
-				filteredHooks = append(filteredHooks, element)
-			}
-		}
-		filteredHooks = filterHiddenFilesAndDirectories(filteredHooks)
-
-		if len(filteredHooks) == 0 {
+		if len(hooks) == 0 {
 			showAttention("No hooks found", program.indentLevel)
 		}
 
-		for _, element := range filteredHooks {
-			// Verify if hook has custom entry command
-			var entryCommand string
-			customEntryFilePath := crate.hooksDir + "/" + element.Name() + ".entry"
-			if _, err = os.Stat(customEntryFilePath); err == nil {
-				contents, err := ioutil.ReadFile(customEntryFilePath)
-				if err != nil {
-					return functionResponse{
-						exitCode:    1,
-						message:     fmt.Sprintf("Failed to read custom entry configuration file for hook " + element.Name() + " -> " + err.Error()),
-						logLevel:    "error",
-						indentLevel: program.indentLevel,
-					}
-				}
-				entryCommand = string(contents)
-				entryCommand = strings.TrimLeft(entryCommand, "\n")
-				entryCommand = strings.TrimRight(entryCommand, "\n")
-			} else {
-				entryCommand = program.defaultShell
+		for _, hook := range hooks {
+			response := showHook(hook, crate.hooksDir+"/"+hook, crate.environment, "", program)
+			if response.exitCode != 0 {
+				return response
 			}
-			showText(fmt.Sprintf("- %s (%s)", element.Name(), coral.Sprintf(entryCommand)), program.indentLevel+1)
 		}
 	}
 
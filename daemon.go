@@ -0,0 +1,588 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	// External modules
+)
+
+//
+//// SCHEDULES
+//
+
+// Crates and targets can be synced periodically by the daemon, with a 'schedule'
+// section in their configuration: either a cron expression or an interval.
+//
+//	{
+//		"schedule": {
+//			"cron": "0 */2 * * *"
+//		}
+//	}
+//
+//	{
+//		"schedule": {
+//			"every": "30m"
+//		}
+//	}
+//
+// The schedule of a crate syncs all its targets, except the ones with their own
+// schedule (which are synced on their own).
+type scheduleConfig struct {
+	Cron  string `json:"cron"`
+	Every string `json:"every"`
+}
+
+func (config scheduleConfig) isSet() bool {
+	return config.Cron != "" || config.Every != ""
+}
+
+func (config scheduleConfig) String() string {
+	if config.Cron != "" {
+		return fmt.Sprintf("cron '%v'", config.Cron)
+	}
+
+	return fmt.Sprintf("every %v", config.Every)
+}
+
+// Returns the first time after the last run (or after the given time, if the
+// schedule never ran) at which the schedule must run
+func (config scheduleConfig) next(lastRun time.Time) (time.Time, error) {
+	switch {
+	case config.Cron != "" && config.Every != "":
+		return time.Time{}, fmt.Errorf("'cron' and 'every' cannot be used together")
+	case config.Cron != "":
+		schedule, err := parseCronExpression(config.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		next := schedule.next(lastRun)
+		if next.IsZero() == true {
+			return next, fmt.Errorf("the cron expression '%v' never matches", config.Cron)
+		}
+
+		return next, nil
+	default:
+		interval, err := time.ParseDuration(config.Every)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid interval '%v' -> %v", config.Every, err.Error())
+		}
+		if interval < time.Minute {
+			return time.Time{}, fmt.Errorf("the interval must be at least 1m")
+		}
+
+		return lastRun.Add(interval), nil
+	}
+}
+
+//
+//// CRON EXPRESSIONS
+//
+
+// Standard cron expressions: five fields (minute, hour, day of month, month and day
+// of week) with lists, ranges, steps and names (jan-dec, sun-sat), or one of the
+// macros (@hourly, @daily, etc.). As in cron, when both the day of month and the day
+// of week are restricted, a day matching either of them matches.
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronWeekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+type cronSchedule struct {
+	minutes  uint64
+	hours    uint64
+	days     uint64
+	months   uint64
+	weekdays uint64
+	// Whether the day fields are unrestricted ('*')
+	anyDay     bool
+	anyWeekday bool
+}
+
+func parseCronValue(value string, names map[string]int) (int, error) {
+	if number, exists := names[strings.ToLower(value)]; exists == true {
+		return number, nil
+	}
+
+	return strconv.Atoi(value)
+}
+
+// Returns the values matched by a field, as a bit set
+func parseCronField(field string, min int, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if separator := strings.Index(part, "/"); separator >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[separator+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in '%v'", part)
+			}
+			part = part[:separator]
+		}
+
+		low, high := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+			low, err = parseCronValue(bounds[0], names)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value '%v'", bounds[0])
+			}
+
+			high = low
+			if len(bounds) == 2 {
+				high, err = parseCronValue(bounds[1], names)
+				if err != nil {
+					return 0, fmt.Errorf("invalid value '%v'", bounds[1])
+				}
+			} else if step > 1 {
+				// 'a/n' means 'a-max/n'
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("'%v' is out of range (%v-%v)", part, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronExpression(expression string) (cronSchedule, error) {
+	var schedule cronSchedule
+
+	if macro, exists := cronMacros[strings.TrimSpace(expression)]; exists == true {
+		expression = macro
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return schedule, fmt.Errorf("invalid cron expression '%v' (expected 5 fields)", expression)
+	}
+
+	var err error
+	fieldsBits := []*uint64{&schedule.minutes, &schedule.hours, &schedule.days, &schedule.months, &schedule.weekdays}
+	ranges := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	names := []map[string]int{nil, nil, nil, cronMonthNames, cronWeekdayNames}
+
+	for index, field := range fields {
+		*fieldsBits[index], err = parseCronField(field, ranges[index][0], ranges[index][1], names[index])
+		if err != nil {
+			return schedule, fmt.Errorf("invalid cron expression '%v' -> %v", expression, err.Error())
+		}
+	}
+
+	// Sunday is both 0 and 7
+	if schedule.weekdays&(1<<7) != 0 {
+		schedule.weekdays |= 1
+	}
+
+	schedule.anyDay = strings.HasPrefix(fields[2], "*")
+	schedule.anyWeekday = strings.HasPrefix(fields[4], "*")
+
+	return schedule, nil
+}
+
+func (schedule cronSchedule) matchesDay(t time.Time) bool {
+	day := schedule.days&(1<<uint(t.Day())) != 0
+	weekday := schedule.weekdays&(1<<uint(t.Weekday())) != 0
+
+	if schedule.anyDay == true || schedule.anyWeekday == true {
+		return day && weekday
+	}
+
+	return day || weekday
+}
+
+// Returns the first time after the given one matching the schedule (or the zero
+// time if none is found in the next five years)
+func (schedule cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.AddDate(5, 0, 0)
+
+	for t.Before(limit) == true {
+		switch {
+		case schedule.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case schedule.matchesDay(t) == false:
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case schedule.hours&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case schedule.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+//
+//// SCHEDULED JOBS
+//
+
+// A scheduled sync: a crate (all its targets without their own schedule) or a
+// single target
+type scheduledJob struct {
+	key      string
+	crate    Crate
+	targets  []Target
+	schedule scheduleConfig
+}
+
+// Reads the schedules of every crate and target. Returns the jobs and the problems
+// found in the configuration files.
+func getScheduledJobs(program Program) ([]scheduledJob, []string) {
+	var jobs []scheduledJob
+	var problems []string
+
+	crates, response := getUserCrates(program)
+	if response.exitCode != 0 {
+		return jobs, append(problems, response.message)
+	}
+
+	for _, crate := range crates {
+		config, response := readCrateConfig(crate, program)
+		if response.exitCode != 0 {
+			problems = append(problems, response.message)
+			continue
+		}
+
+		targets, response := getCrateTargets(crate, program)
+		if response.exitCode != 0 {
+			problems = append(problems, response.message)
+			continue
+		}
+
+		var crateTargets []Target
+		for _, target := range targets {
+			targetConfig, response := readTargetConfig(target, program)
+			if response.exitCode != 0 {
+				problems = append(problems, response.message)
+				continue
+			}
+
+			if targetConfig.Schedule.isSet() == false {
+				crateTargets = append(crateTargets, target)
+				continue
+			}
+
+			jobs = append(jobs, scheduledJob{
+				key:      crate.name + "/" + target.name,
+				crate:    crate,
+				targets:  []Target{target},
+				schedule: targetConfig.Schedule,
+			})
+		}
+
+		if config.Schedule.isSet() == true && len(crateTargets) > 0 {
+			jobs = append(jobs, scheduledJob{
+				key:      crate.name,
+				crate:    crate,
+				targets:  crateTargets,
+				schedule: config.Schedule,
+			})
+		}
+	}
+
+	return jobs, problems
+}
+
+//
+//// DAEMON STATE
+//
+
+// The time of the last run of every job is kept in the user data directory, so
+// that the runs missed while the daemon was not running (or the computer was
+// asleep) are caught up (once) when it runs again. A job that never ran is
+// scheduled from the time the daemon first saw it, so restarting the daemon does
+// not postpone its first run.
+type daemonState struct {
+	LastRuns  map[string]time.Time `json:"last_runs"`
+	FirstSeen map[string]time.Time `json:"first_seen"`
+}
+
+func readDaemonState(program Program) (daemonState, error) {
+	state := daemonState{LastRuns: make(map[string]time.Time), FirstSeen: make(map[string]time.Time)}
+
+	content, err := ioutil.ReadFile(program.userDaemonStateFile)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(content, &state)
+	if err != nil {
+		return state, fmt.Errorf("invalid daemon state %v -> %v", program.userDaemonStateFile, err.Error())
+	}
+	if state.LastRuns == nil {
+		state.LastRuns = make(map[string]time.Time)
+	}
+	if state.FirstSeen == nil {
+		state.FirstSeen = make(map[string]time.Time)
+	}
+
+	return state, nil
+}
+
+func writeDaemonState(state daemonState, program Program) error {
+	content, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(program.userDaemonStateFile, content, 0644)
+}
+
+// Records the jobs that never ran and are seen for the first time. Returns whether
+// any was recorded.
+func (state daemonState) recordFirstSeen(jobs []scheduledJob, now time.Time) bool {
+	recorded := false
+	for _, job := range jobs {
+		_, ranBefore := state.LastRuns[job.key]
+		_, seenBefore := state.FirstSeen[job.key]
+		if ranBefore == false && seenBefore == false {
+			state.FirstSeen[job.key] = now
+			recorded = true
+		}
+	}
+
+	return recorded
+}
+
+// Returns the time from which the next run of a job is computed: its last run or,
+// if it never ran, the time it was first seen. Returns false if it never ran.
+func (state daemonState) jobReference(key string) (time.Time, bool) {
+	if lastRun, ranBefore := state.LastRuns[key]; ranBefore == true {
+		return lastRun, true
+	}
+
+	return state.FirstSeen[key], false
+}
+
+//
+//// DAEMON
+//
+
+// The clock is checked at least this often. Timers do not advance while the
+// computer is asleep, so the daemon never waits longer than this to notice that
+// runs were missed.
+const daemonPollInterval = 30 * time.Second
+
+func showScheduledJobs(jobs []scheduledJob, state daemonState, program Program) {
+	if len(jobs) == 0 {
+		showAttention("> No schedules found", program.indentLevel)
+		return
+	}
+
+	for _, job := range jobs {
+		lastRun, ranBefore := state.jobReference(job.key)
+
+		next, err := job.schedule.next(lastRun)
+		if err != nil {
+			showText(fmt.Sprintf("- %s: %s", job.key, red.Sprintf(err.Error())), program.indentLevel)
+			continue
+		}
+
+		description := fmt.Sprintf("%v, next run: %v", job.schedule, next.Format("2006-01-02 15:04"))
+		if ranBefore == true {
+			description += fmt.Sprintf(", last run: %v", lastRun.Format("2006-01-02 15:04"))
+		}
+
+		showText(fmt.Sprintf("- %s %s", job.key, gray.Sprintf("(%v)", description)), program.indentLevel)
+	}
+}
+
+// Runs the scheduled syncs (and the ones requested through the API) until
+// interrupted. Jobs run one at a time (and never while another process syncs the
+// same crate); a job whose time passed while the daemon was stopped or the
+// computer was asleep runs once, as soon as possible.
+func daemon(listOnly bool, program Program) functionResponse {
+	state, err := readDaemonState(program)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read daemon state -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	jobs, problems := getScheduledJobs(program)
+	firstSeen := state.recordFirstSeen(jobs, time.Now())
+
+	space()
+	showInfoSectionTitle("Schedules", program.indentLevel)
+	showScheduledJobs(jobs, state, incrementProgramIndentLevel(program, 1))
+	for _, problem := range problems {
+		showError(fmt.Sprintf("> %v", problem), program.indentLevel+1)
+	}
+
+	if listOnly == true {
+		space()
+		return functionResponse{exitCode: 0}
+	}
+
+	if firstSeen == true {
+		err = writeDaemonState(state, program)
+		if err != nil {
+			showError(fmt.Sprintf("> Failed to write daemon state -> %v", err.Error()), program.indentLevel)
+		}
+	}
+
+	api, err := startDaemonAPI(program)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to start daemon API -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	defer api.close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	space()
+	showText(gray.Sprintf(fmt.Sprintf("Listening on %v", program.userDaemonSocket)), program.indentLevel)
+	showText(gray.Sprintf("Waiting for scheduled runs. Press Ctrl+C to stop."), program.indentLevel)
+
+	// Problems are only shown when they change
+	reportedProblems := strings.Join(problems, "\n")
+	expectedWake := time.Now().Round(0)
+
+	for {
+		// Use the wall clock: waking up much later than expected means the computer
+		// was asleep
+		now := time.Now().Round(0)
+		if now.Sub(expectedWake) > daemonPollInterval {
+			space()
+			showAttention(fmt.Sprintf("> Resumed after %v, catching up missed runs", now.Sub(expectedWake).Round(time.Second)), program.indentLevel)
+		}
+
+		// Configuration changes are picked up without restarting the daemon
+		jobs, problems = getScheduledJobs(program)
+		if strings.Join(problems, "\n") != reportedProblems {
+			reportedProblems = strings.Join(problems, "\n")
+			for _, problem := range problems {
+				showError(fmt.Sprintf("> %v", problem), program.indentLevel)
+			}
+		}
+
+		if state.recordFirstSeen(jobs, now) == true {
+			err = writeDaemonState(state, program)
+			if err != nil {
+				showError(fmt.Sprintf("> Failed to write daemon state -> %v", err.Error()), program.indentLevel)
+			}
+		}
+
+		wait := daemonPollInterval
+		for _, job := range jobs {
+			lastRun, _ := state.jobReference(job.key)
+
+			next, err := job.schedule.next(lastRun)
+			if err != nil {
+				continue
+			}
+
+			if now.Before(next) == true {
+				if next.Sub(now) < wait {
+					wait = next.Sub(now)
+				}
+				continue
+			}
+
+			// Try again at the next check if the crate is being synced
+			lock, err := lockCrate(job.crate)
+			if err == errCrateLocked {
+				continue
+			} else if err == nil {
+				unlockCrate(lock)
+			}
+
+			title := fmt.Sprintf("Scheduled run: %s %s", orange.Sprintf(job.key), gray.Sprintf("(%v, %v)", job.schedule, now.Format("2006-01-02 15:04")))
+			api.execute(api.newRun(job.crate, job.targets, "schedule"), title, program)
+
+			state.LastRuns[job.key] = time.Now()
+			err = writeDaemonState(state, program)
+			if err != nil {
+				showError(fmt.Sprintf("> Failed to write daemon state -> %v", err.Error()), program.indentLevel)
+			}
+
+			// Stop between runs when interrupted
+			select {
+			case <-signals:
+				space()
+				return functionResponse{
+					exitCode:    0,
+					message:     "Daemon stopped",
+					logLevel:    "success",
+					indentLevel: program.indentLevel,
+				}
+			default:
+			}
+
+			now = time.Now().Round(0)
+		}
+
+		expectedWake = time.Now().Round(0).Add(wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-signals:
+			timer.Stop()
+
+			space()
+			return functionResponse{
+				exitCode:    0,
+				message:     "Daemon stopped",
+				logLevel:    "success",
+				indentLevel: program.indentLevel,
+			}
+		case run := <-api.queue:
+			timer.Stop()
+
+			title := fmt.Sprintf("Requested run: %s %s", orange.Sprintf(run.Crate), gray.Sprintf("(%v)", strings.Join(run.Targets, ", ")))
+			api.execute(run, title, program)
+		case <-timer.C:
+		}
+	}
+}
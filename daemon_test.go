@@ -0,0 +1,257 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Returns the values of a bit set
+func cronFieldValues(bits uint64) []int {
+	var values []int
+	for value := 0; value < 64; value++ {
+		if bits&(1<<uint(value)) != 0 {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field string
+		min   int
+		max   int
+		names map[string]int
+		want  []int
+	}{
+		{"5", 0, 59, nil, []int{5}},
+		{"*", 0, 6, nil, []int{0, 1, 2, 3, 4, 5, 6}},
+		{"*/15", 0, 59, nil, []int{0, 15, 30, 45}},
+		{"*/10", 1, 31, nil, []int{1, 11, 21, 31}},
+		{"5/15", 0, 59, nil, []int{5, 20, 35, 50}},
+		{"10-20/5", 0, 59, nil, []int{10, 15, 20}},
+		{"10-21/5", 0, 59, nil, []int{10, 15, 20}},
+		{"1-3", 0, 59, nil, []int{1, 2, 3}},
+		{"1,3-4,8/20", 0, 59, nil, []int{1, 3, 4, 8, 28, 48}},
+		{"59", 0, 59, nil, []int{59}},
+		{"*/100", 0, 59, nil, []int{0}},
+		{"jan,DEC", 1, 12, cronMonthNames, []int{1, 12}},
+		{"mon-fri", 0, 7, cronWeekdayNames, []int{1, 2, 3, 4, 5}},
+		{"mon-fri/2", 0, 7, cronWeekdayNames, []int{1, 3, 5}},
+		{"7", 0, 7, cronWeekdayNames, []int{7}},
+	}
+
+	for _, test := range tests {
+		bits, err := parseCronField(test.field, test.min, test.max, test.names)
+		if err != nil {
+			t.Errorf("parseCronField(%q) failed: %v", test.field, err)
+			continue
+		}
+		if got := cronFieldValues(bits); reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("parseCronField(%q) = %v, want %v", test.field, got, test.want)
+		}
+	}
+}
+
+func TestParseCronFieldErrors(t *testing.T) {
+	tests := []struct {
+		field string
+		min   int
+		max   int
+	}{
+		{"", 0, 59},
+		{"60", 0, 59},
+		{"0", 1, 31},
+		{"5-1", 0, 59},
+		{"-5", 0, 59},
+		{"1-2-3", 0, 59},
+		{"1-", 0, 59},
+		{"1,,2", 0, 59},
+		{"*/0", 0, 59},
+		{"*/-1", 0, 59},
+		{"*/x", 0, 59},
+		{"5/", 0, 59},
+		{"mon", 0, 59},
+	}
+
+	for _, test := range tests {
+		if bits, err := parseCronField(test.field, test.min, test.max, nil); err == nil {
+			t.Errorf("parseCronField(%q, %v, %v) = %v, want an error", test.field, test.min, test.max, cronFieldValues(bits))
+		}
+	}
+}
+
+func TestParseCronExpressionErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@reboot",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * foo *",
+		"* 24 * * *",
+	} {
+		if _, err := parseCronExpression(expression); err == nil {
+			t.Errorf("parseCronExpression(%q) succeeded, want an error", expression)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	date := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02 15:04:05", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name       string
+		expression string
+		after      string
+		want       string
+	}{
+		{"next step", "*/15 * * * *", "2025-06-02 10:07:30", "2025-06-02 10:15:00"},
+		{"strictly after", "*/15 * * * *", "2025-06-02 10:15:00", "2025-06-02 10:30:00"},
+		{"macro", "@hourly", "2025-06-02 10:59:59", "2025-06-02 11:00:00"},
+		{"next day", "30 2 * * *", "2025-06-02 03:00:00", "2025-06-03 02:30:00"},
+		{"year wrap", "0 0 1 jan *", "2025-12-31 23:59:00", "2026-01-01 00:00:00"},
+		{"skips short months", "0 0 31 * *", "2025-04-01 00:00:00", "2025-05-31 00:00:00"},
+		{"leap day", "0 0 29 2 *", "2025-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"sunday as 7", "0 0 * * 7", "2025-06-02 00:00:00", "2025-06-08 00:00:00"},
+		{"weekday step", "0 12 * * mon-fri/2", "2025-06-02 12:00:00", "2025-06-04 12:00:00"},
+		{"day of month only", "0 0 13 * *", "2025-06-01 00:00:00", "2025-06-13 00:00:00"},
+		{"day of week only", "0 0 * * fri", "2025-06-01 00:00:00", "2025-06-06 00:00:00"},
+		// Both restricted: either of them matches
+		{"day of week before day of month", "0 0 13 * 5", "2025-06-01 00:00:00", "2025-06-06 00:00:00"},
+		{"day of month before day of week", "0 0 13 * 1", "2025-06-10 00:00:00", "2025-06-13 00:00:00"},
+		{"day of week after day of month", "0 0 13 * 1", "2025-06-13 12:00:00", "2025-06-16 00:00:00"},
+		// A '*' field (even with a step) is unrestricted: both must match
+		{"stepped day of month and day of week", "0 0 */2 * 1", "2025-06-01 00:00:00", "2025-06-09 00:00:00"},
+		{"day of month and stepped day of week", "0 0 1 * */7", "2025-06-02 00:00:00", "2026-02-01 00:00:00"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := parseCronExpression(test.expression)
+			if err != nil {
+				t.Fatalf("parseCronExpression(%q) failed: %v", test.expression, err)
+			}
+
+			got := schedule.next(date(test.after))
+			if got.Equal(date(test.want)) == false {
+				t.Errorf("next(%v) of %q = %v, want %v", test.after, test.expression, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNeverMatches(t *testing.T) {
+	config := scheduleConfig{Cron: "0 0 30 2 *"}
+
+	if next, err := config.next(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Errorf("next() of %q = %v, want an error", config.Cron, next)
+	}
+}
+
+func TestScheduleConfigNext(t *testing.T) {
+	lastRun := time.Date(2025, 6, 2, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		config scheduleConfig
+		want   time.Time
+	}{
+		{scheduleConfig{Every: "90m"}, lastRun.Add(90 * time.Minute)},
+		{scheduleConfig{Cron: "@daily"}, time.Date(2025, 6, 3, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		got, err := test.config.next(lastRun)
+		if err != nil {
+			t.Errorf("next() of %v failed: %v", test.config, err)
+			continue
+		}
+		if got.Equal(test.want) == false {
+			t.Errorf("next() of %v = %v, want %v", test.config, got, test.want)
+		}
+	}
+
+	for _, config := range []scheduleConfig{
+		{Every: "30s"},
+		{Every: "soon"},
+		{Cron: "0 0 * * *", Every: "1h"},
+	} {
+		if got, err := config.next(lastRun); err == nil {
+			t.Errorf("next() of %+v = %v, want an error", config, got)
+		}
+	}
+}
+
+func TestDaemonStateFirstSeen(t *testing.T) {
+	program := Program{userDaemonStateFile: t.TempDir() + "/daemon.json"}
+	daily := scheduledJob{key: "home", schedule: scheduleConfig{Every: "24h"}}
+	weekly := scheduledJob{key: "work/git", schedule: scheduleConfig{Cron: "@weekly"}}
+	ran := scheduledJob{key: "photos", schedule: scheduleConfig{Every: "1h"}}
+
+	firstStart := time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)
+	lastRun := firstStart.Add(-30 * time.Minute)
+
+	state, err := readDaemonState(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.LastRuns[ran.key] = lastRun
+
+	if state.recordFirstSeen([]scheduledJob{daily, weekly, ran}, firstStart) == false {
+		t.Fatalf("recordFirstSeen() = false for new jobs")
+	}
+	if _, exists := state.FirstSeen[ran.key]; exists == true {
+		t.Errorf("recordFirstSeen() recorded a job that already ran")
+	}
+	if err := writeDaemonState(state, program); err != nil {
+		t.Fatal(err)
+	}
+
+	// The daemon restarts every day before the jobs are due
+	for day := 1; day <= 8; day++ {
+		restart := firstStart.AddDate(0, 0, day).Add(-time.Hour)
+
+		state, err = readDaemonState(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.recordFirstSeen([]scheduledJob{daily, weekly, ran}, restart) == true {
+			t.Fatalf("recordFirstSeen() = true after restart %v", day)
+		}
+	}
+
+	tests := []struct {
+		job       scheduledJob
+		ranBefore bool
+		want      time.Time
+	}{
+		{daily, false, firstStart.Add(24 * time.Hour)},
+		{weekly, false, time.Date(2025, 6, 8, 0, 0, 0, 0, time.UTC)},
+		{ran, true, lastRun.Add(time.Hour)},
+	}
+
+	for _, test := range tests {
+		reference, ranBefore := state.jobReference(test.job.key)
+		if ranBefore != test.ranBefore {
+			t.Errorf("jobReference(%q) ran before = %v, want %v", test.job.key, ranBefore, test.ranBefore)
+		}
+
+		next, err := test.job.schedule.next(reference)
+		if err != nil {
+			t.Errorf("next() of %q failed: %v", test.job.key, err)
+			continue
+		}
+		if next.Equal(test.want) == false {
+			t.Errorf("next run of %q = %v, want %v", test.job.key, next, test.want)
+		}
+	}
+}
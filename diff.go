@@ -0,0 +1,634 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+	// External modules
+)
+
+//
+//// TARGET DIFF
+//
+
+// Preview of the changes the next sync of a target would make. Built-in engines
+// compare their roots (without modifying them); other targets can provide a 'diff'
+// hook printing one change per line:
+//
+//	<symbol> <path>[<tab><size>]
+//
+// where the symbol is '+' (added), '~' (modified), '-' (deleted) or '!' (conflict),
+// the same ones shown by the sync engines.
+
+// Kinds of changes
+const (
+	diffChangeAdded    = "added"
+	diffChangeModified = "modified"
+	diffChangeDeleted  = "deleted"
+	diffChangeConflict = "conflict"
+)
+
+var diffChangeSymbols = map[string]string{
+	"+": diffChangeAdded,
+	"~": diffChangeModified,
+	"-": diffChangeDeleted,
+	"!": diffChangeConflict,
+}
+
+// Maximum number of lines compared for the unified diff of a file
+const diffMaxLines = 4000
+
+type diffChange struct {
+	Change    string `json:"change"`
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	Direction string `json:"direction,omitempty"`
+	Diff      string `json:"diff,omitempty"`
+}
+
+type targetDiff struct {
+	Crate   string       `json:"crate"`
+	Target  string       `json:"target"`
+	Method  string       `json:"method"`
+	Changes []diffChange `json:"changes"`
+	Error   string       `json:"error,omitempty"`
+}
+
+type diffOptions struct {
+	patch        bool
+	maxPatchSize int64
+}
+
+//
+//// ENGINE DIFF
+//
+
+// Compares the roots of a mirror target: what the source would add, modify or
+// (with the 'delete' option) delete in the destination
+func diffMirrorEngine(src syncFS, dst syncFS, config syncEngineConfig, options diffOptions) ([]diffChange, error) {
+	var changes []diffChange
+
+	if _, err := src.Lstat(""); err != nil {
+		return changes, fmt.Errorf("failed to read source -> %v", err.Error())
+	}
+
+	srcTree, _, err := scanSyncTree(src, config.Exclude)
+	if err != nil {
+		return changes, fmt.Errorf("failed to scan source -> %v", err.Error())
+	}
+	dstTree, _, err := scanSyncTree(dst, config.Exclude)
+	if err != nil {
+		return changes, fmt.Errorf("failed to scan destination -> %v", err.Error())
+	}
+
+	for _, name := range sortedSyncPaths(srcTree) {
+		srcEntry := srcTree[name]
+		dstEntry, exists := dstTree[name]
+		if srcEntry.isDir == true && (exists == false || dstEntry.isDir == true) {
+			continue
+		}
+
+		change := diffChange{Path: name, Size: srcEntry.size}
+
+		switch {
+		case exists == false:
+			change.Change = diffChangeAdded
+		case srcEntry.sameKind(dstEntry) == false:
+			change.Change = diffChangeModified
+		case srcEntry.isSymlink == true:
+			if srcEntry.linkTarget == dstEntry.linkTarget {
+				continue
+			}
+			change.Change = diffChangeModified
+		default:
+			changed, err := mirrorFileChanged(src, dst, srcEntry, dstEntry, config)
+			if err != nil {
+				return changes, err
+			}
+			if changed == false {
+				continue
+			}
+			change.Change = diffChangeModified
+
+			if options.patch == true {
+				change.Diff = diffSyncFiles(dst, dstEntry, src, srcEntry, options)
+			}
+		}
+
+		changes = append(changes, change)
+	}
+
+	if config.Delete == true {
+		for _, name := range sortedSyncPaths(dstTree) {
+			if _, exists := srcTree[name]; exists == true || dstTree[name].isDir == true {
+				continue
+			}
+
+			changes = append(changes, diffChange{Change: diffChangeDeleted, Path: name, Size: dstTree[name].size})
+		}
+	}
+
+	return changes, nil
+}
+
+// Compares the roots of a twoway target with its archive: the changes made on each
+// root since the last sync, which would be applied to the other one
+func diffTwoWayEngine(target Target, fsA syncFS, fsB syncFS, config syncEngineConfig, options diffOptions) ([]diffChange, error) {
+	var changes []diffChange
+	checksum := config.Compare == syncCompareChecksum
+
+	archive, err := readSyncArchive(target, []string{fsA.String(), fsB.String()})
+	if err != nil {
+		return changes, fmt.Errorf("failed to read archive -> %v", err.Error())
+	}
+
+	sides := []*twoWaySide{{label: "A", fs: fsA}, {label: "B", fs: fsB}}
+	for _, side := range sides {
+		if _, err := side.fs.Lstat(""); err != nil {
+			return changes, fmt.Errorf("failed to read root %v -> %v", side.label, err.Error())
+		}
+
+		tree, _, err := scanSyncTree(side.fs, config.Exclude)
+		if err != nil {
+			return changes, fmt.Errorf("failed to scan root %v -> %v", side.label, err.Error())
+		}
+		side.tree = tree
+	}
+
+	union := make(map[string]syncEntry)
+	for _, side := range sides {
+		for name, entry := range side.tree {
+			union[name] = entry
+		}
+	}
+	for name := range archive.Entries {
+		if _, exists := union[name]; exists == false {
+			union[name] = syncEntry{path: name}
+		}
+	}
+
+	isDir := func(state *syncArchiveState) bool {
+		return state == nil || state.Kind == syncKindDir
+	}
+
+	for _, name := range sortedSyncPaths(union) {
+		archived := archive.Entries[name]
+
+		stateA, changedA, err := sides[0].state(name, archived.A, checksum)
+		if err != nil {
+			return changes, err
+		}
+		stateB, changedB, err := sides[1].state(name, archived.B, checksum)
+		if err != nil {
+			return changes, err
+		}
+
+		// Directories are created and deleted with their contents
+		if isDir(stateA) == true && isDir(stateB) == true {
+			continue
+		}
+
+		from, to := sides[0], sides[1]
+		fromState, toState := stateA, stateB
+
+		switch {
+		case sameSyncContent(stateA, stateB) == true:
+			continue
+		case changedA == true && changedB == true, changedA == false && changedB == false:
+			changes = append(changes, diffChange{Change: diffChangeConflict, Path: name, Size: union[name].size})
+			continue
+		case changedB == true:
+			from, to = sides[1], sides[0]
+			fromState, toState = stateB, stateA
+		}
+
+		change := diffChange{Path: name, Direction: from.label + " -> " + to.label}
+		switch {
+		case fromState == nil:
+			change.Change = diffChangeDeleted
+			change.Size = to.tree[name].size
+		case toState == nil:
+			change.Change = diffChangeAdded
+			change.Size = fromState.Size
+		default:
+			change.Change = diffChangeModified
+			change.Size = fromState.Size
+
+			if options.patch == true && fromState.Kind == syncKindFile && toState.Kind == syncKindFile {
+				change.Diff = diffSyncFiles(to.fs, to.tree[name], from.fs, from.tree[name], options)
+			}
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+//
+//// UNIFIED DIFF
+//
+
+// Returns the unified diff between two versions of a file, or an empty string if
+// they are too large or not text
+func diffSyncFiles(oldFS syncFS, oldEntry syncEntry, newFS syncFS, newEntry syncEntry, options diffOptions) string {
+	if oldEntry.size > options.maxPatchSize || newEntry.size > options.maxPatchSize {
+		return ""
+	}
+
+	readText := func(fs syncFS, name string) (string, bool) {
+		file, err := fs.Open(name)
+		if err != nil {
+			return "", false
+		}
+		defer file.Close()
+
+		content, err := io.ReadAll(io.LimitReader(file, options.maxPatchSize+1))
+		if err != nil || bytes.IndexByte(content, 0) >= 0 || utf8.Valid(content) == false {
+			return "", false
+		}
+
+		return string(content), true
+	}
+
+	oldText, ok := readText(oldFS, oldEntry.path)
+	if ok == false {
+		return ""
+	}
+	newText, ok := readText(newFS, newEntry.path)
+	if ok == false {
+		return ""
+	}
+
+	return unifiedDiff(oldFS.String()+"/"+oldEntry.path, newFS.String()+"/"+newEntry.path, oldText, newText, 3)
+}
+
+func splitDiffLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// Line-based unified diff (longest common subsequence), with the given number of
+// context lines around each change
+func unifiedDiff(oldName string, newName string, oldText string, newText string, context int) string {
+	a := splitDiffLines(oldText)
+	b := splitDiffLines(newText)
+	if len(a) > diffMaxLines || len(b) > diffMaxLines {
+		return ""
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// Edit script: ' ' (both), '-' (only in a) and '+' (only in b)
+	type diffLine struct {
+		op   byte
+		text string
+		i, j int
+	}
+	var script []diffLine
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			script = append(script, diffLine{' ', a[i], i, j})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+			script = append(script, diffLine{'+', b[j], i, j})
+			j++
+		default:
+			script = append(script, diffLine{'-', a[i], i, j})
+			i++
+		}
+	}
+
+	var output strings.Builder
+	output.WriteString("--- " + oldName + "\n")
+	output.WriteString("+++ " + newName + "\n")
+
+	for start := 0; start < len(script); {
+		// Find the next change
+		for start < len(script) && script[start].op == ' ' {
+			start++
+		}
+		if start == len(script) {
+			break
+		}
+
+		// Extend the hunk while changes are close enough
+		end := start
+		for index := start; index < len(script); index++ {
+			if script[index].op != ' ' {
+				end = index + 1
+			} else if index-end >= 2*context {
+				break
+			}
+		}
+
+		hunkStart := start - context
+		if hunkStart < 0 {
+			hunkStart = 0
+		}
+		hunkEnd := end + context
+		if hunkEnd > len(script) {
+			hunkEnd = len(script)
+		}
+
+		oldCount, newCount := 0, 0
+		for _, line := range script[hunkStart:hunkEnd] {
+			if line.op != '+' {
+				oldCount++
+			}
+			if line.op != '-' {
+				newCount++
+			}
+		}
+
+		oldStart, newStart := script[hunkStart].i+1, script[hunkStart].j+1
+		if oldCount == 0 {
+			oldStart--
+		}
+		if newCount == 0 {
+			newStart--
+		}
+		output.WriteString(fmt.Sprintf("@@ -%v,%v +%v,%v @@\n", oldStart, oldCount, newStart, newCount))
+
+		for _, line := range script[hunkStart:hunkEnd] {
+			output.WriteString(string(line.op) + line.text)
+			if strings.HasSuffix(line.text, "\n") == false {
+				output.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		start = hunkEnd
+	}
+
+	return output.String()
+}
+
+//
+//// HOOK DIFF
+//
+
+// Parses the output of a 'diff' hook. Returns the changes and the number of lines
+// that could not be parsed.
+func parseDiffHookOutput(output string) ([]diffChange, int) {
+	var changes []diffChange
+	unrecognized := 0
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		change, exists := diffChangeSymbols[fields[0]]
+		if exists == false || len(fields) < 2 || strings.TrimSpace(fields[1]) == "" {
+			unrecognized++
+			continue
+		}
+
+		entry := diffChange{Change: change, Path: fields[1]}
+		if separator := strings.LastIndex(fields[1], "\t"); separator >= 0 {
+			if size, err := strconv.ParseInt(strings.TrimSpace(fields[1][separator+1:]), 10, 64); err == nil {
+				entry.Path = fields[1][:separator]
+				entry.Size = size
+			}
+		}
+
+		changes = append(changes, entry)
+	}
+
+	return changes, unrecognized
+}
+
+//
+//// TARGET COMMANDS
+//
+
+// Computes the diff of a target, with its engine or its 'diff' hook
+func getTargetDiff(target Target, connections *sshConnectionPool, options diffOptions, program Program) targetDiff {
+	diff := targetDiff{
+		Crate:   target.crate.name,
+		Target:  target.name,
+		Changes: []diffChange{},
+	}
+
+	config, response := readTargetConfig(target, program)
+	if response.exitCode != 0 {
+		diff.Error = response.message
+		return diff
+	}
+
+	if config.Sync.Engine == "" {
+		diff.Method = "diff hook"
+
+		hookPath := resolveTargetHook(target, "diff", program).path
+		if hookExists(hookPath) == false {
+			diff.Error = "No diff hook found"
+			return diff
+		}
+
+		result, response := runHook(hookPath, target.environment, nil, false, false, false, false, false, program)
+		if response.exitCode != 0 {
+			diff.Error = response.message
+			return diff
+		}
+
+		changes, unrecognized := parseDiffHookOutput(result.Output)
+		diff.Changes = append(diff.Changes, changes...)
+		if unrecognized > 0 {
+			diff.Error = fmt.Sprintf("%v line(s) of the diff hook output could not be parsed", unrecognized)
+		}
+
+		return diff
+	}
+
+	diff.Method = config.Sync.Engine + " engine"
+
+	response = verifySyncEngineConfig(config.Sync, program)
+	if response.exitCode != 0 {
+		diff.Error = response.message
+		return diff
+	}
+
+	roots := []string{config.Sync.Source, config.Sync.Destination}
+	if config.Sync.Engine == syncEngineTwoWay {
+		roots = config.Sync.Roots
+	}
+
+	filesystems := make([]syncFS, len(roots))
+	for i, root := range roots {
+		fs, err := openSyncFS(root, target.environment, connections)
+		if err != nil {
+			diff.Error = fmt.Sprintf("Failed to open %v -> %v", root, err.Error())
+			return diff
+		}
+		filesystems[i] = fs
+	}
+
+	var changes []diffChange
+	var err error
+	if config.Sync.Engine == syncEngineTwoWay {
+		changes, err = diffTwoWayEngine(target, filesystems[0], filesystems[1], config.Sync, options)
+	} else {
+		changes, err = diffMirrorEngine(filesystems[0], filesystems[1], config.Sync, options)
+	}
+	diff.Changes = append(diff.Changes, changes...)
+	if err != nil {
+		diff.Error = err.Error()
+	}
+
+	return diff
+}
+
+func displayDiffChange(change string) string {
+	switch change {
+	case diffChangeAdded:
+		return green.Sprintf(change)
+	case diffChangeModified:
+		return orange.Sprintf(change)
+	case diffChangeDeleted, diffChangeConflict:
+		return red.Sprintf(change)
+	default:
+		return change
+	}
+}
+
+func showTargetDiff(diff targetDiff, program Program) {
+	showText(fmt.Sprintf("Method: %s", diff.Method), program.indentLevel)
+
+	if len(diff.Changes) == 0 {
+		if diff.Error == "" {
+			showText(gray.Sprintf("No changes"), program.indentLevel)
+		}
+	} else {
+		space()
+
+		// Table columns are aligned on the plain (uncolored) text
+		pathWidth := len("PATH")
+		for _, change := range diff.Changes {
+			if len(change.Path) > pathWidth {
+				pathWidth = len(change.Path)
+			}
+		}
+
+		showText(lightGray.Sprintf(fmt.Sprintf("%-10v %10v  %-*v  %v", "CHANGE", "SIZE", pathWidth, "PATH", "DIRECTION")), program.indentLevel)
+
+		counts := make(map[string]int)
+		for _, change := range diff.Changes {
+			counts[change.Change]++
+			padding := strings.Repeat(" ", 10-len(change.Change))
+			showText(fmt.Sprintf("%v%v %10v  %-*v  %v", displayDiffChange(change.Change), padding, formatByteSize(change.Size), pathWidth, change.Path, gray.Sprintf(change.Direction)), program.indentLevel)
+		}
+
+		space()
+		showText(gray.Sprintf(fmt.Sprintf("%v added, %v modified, %v deleted, %v conflict(s)", counts[diffChangeAdded], counts[diffChangeModified], counts[diffChangeDeleted], counts[diffChangeConflict])), program.indentLevel)
+
+		for _, change := range diff.Changes {
+			if change.Diff == "" {
+				continue
+			}
+
+			space()
+			for _, line := range strings.Split(strings.TrimSuffix(change.Diff, "\n"), "\n") {
+				switch {
+				case strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---"):
+					showText(lightGray.Sprintf(line), program.indentLevel)
+				case strings.HasPrefix(line, "@@"):
+					showText(blue.Sprintf(line), program.indentLevel)
+				case strings.HasPrefix(line, "+"):
+					showText(green.Sprintf(line), program.indentLevel)
+				case strings.HasPrefix(line, "-"):
+					showText(red.Sprintf(line), program.indentLevel)
+				default:
+					showText(line, program.indentLevel)
+				}
+			}
+		}
+	}
+
+	if diff.Error != "" {
+		showAttention(fmt.Sprintf("> %v", diff.Error), program.indentLevel)
+	}
+}
+
+// Shows what the next sync of each target would change. With the JSON output, the
+// diffs (of the targets of every crate) are written to the given output as a single
+// list (the messages going to the standard error).
+func targetsDiff(selections []targetSelection, options diffOptions, jsonOutput *os.File, program Program) functionResponse {
+	diffs := []targetDiff{}
+
+	for _, selection := range selections {
+		connections := newSSHConnectionPool(selection.crate, program)
+
+		for _, target := range selection.targets {
+			space()
+			showInfoSectionTitle(displayTargetTag("Diff", target), program.indentLevel)
+
+			diff := getTargetDiff(target, connections, options, program)
+			diffs = append(diffs, diff)
+
+			if jsonOutput == nil {
+				showTargetDiff(diff, incrementProgramIndentLevel(program, 1))
+			} else if diff.Error != "" {
+				showAttention(fmt.Sprintf("> %v", diff.Error), program.indentLevel+1)
+			}
+		}
+
+		connections.close()
+	}
+
+	if jsonOutput != nil {
+		encoder := json.NewEncoder(jsonOutput)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "\t")
+
+		if err := encoder.Encode(diffs); err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to encode diff -> " + err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+	}
+
+	return functionResponse{
+		exitCode: 0,
+	}
+}
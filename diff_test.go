@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	const header = "--- old\n+++ new\n"
+
+	tests := []struct {
+		name    string
+		oldText string
+		newText string
+		context int
+		want    string
+	}{
+		{
+			name:    "identical",
+			oldText: "a\nb\n",
+			newText: "a\nb\n",
+			context: 3,
+			want:    header,
+		},
+		{
+			name:    "created",
+			oldText: "",
+			newText: "a\nb\n",
+			context: 3,
+			want:    header + "@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:    "emptied",
+			oldText: "a\nb\n",
+			newText: "",
+			context: 3,
+			want:    header + "@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name:    "changed line with context",
+			oldText: "1\n2\n3\n4\n5\n",
+			newText: "1\n2\nX\n4\n5\n",
+			context: 1,
+			want:    header + "@@ -2,3 +2,3 @@\n 2\n-3\n+X\n 4\n",
+		},
+		{
+			name:    "context clipped at both ends",
+			oldText: "1\n2\n3\n",
+			newText: "1\nX\n3\n",
+			context: 3,
+			want:    header + "@@ -1,3 +1,3 @@\n 1\n-2\n+X\n 3\n",
+		},
+		{
+			name:    "changes 2*context lines apart share a hunk",
+			oldText: "a\nb\nc\nd\ne\nf\n",
+			newText: "A\nb\nc\nD\ne\nf\n",
+			context: 1,
+			want:    header + "@@ -1,5 +1,5 @@\n-a\n+A\n b\n c\n-d\n+D\n e\n",
+		},
+		{
+			name:    "changes further apart get their own hunks",
+			oldText: "a\nb\nc\nd\ne\nf\ng\n",
+			newText: "A\nb\nc\nd\nE\nf\ng\n",
+			context: 1,
+			want:    header + "@@ -1,2 +1,2 @@\n-a\n+A\n b\n@@ -4,3 +4,3 @@\n d\n-e\n+E\n f\n",
+		},
+		{
+			name:    "insertion without context",
+			oldText: "a\nb\n",
+			newText: "a\nX\nb\n",
+			context: 0,
+			want:    header + "@@ -1,0 +2,1 @@\n+X\n",
+		},
+		{
+			name:    "deletion without context",
+			oldText: "a\nX\nb\n",
+			newText: "a\nb\n",
+			context: 0,
+			want:    header + "@@ -2,1 +1,0 @@\n-X\n",
+		},
+		{
+			name:    "missing newline at end of both files",
+			oldText: "a\nb",
+			newText: "a\nc",
+			context: 3,
+			want:    header + "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			name:    "newline added at end",
+			oldText: "a",
+			newText: "a\n",
+			context: 3,
+			want:    header + "@@ -1,1 +1,1 @@\n-a\n\\ No newline at end of file\n+a\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := unifiedDiff("old", "new", test.oldText, test.newText, test.context)
+			if got != test.want {
+				t.Errorf("unifiedDiff() =\n%v\nwant\n%v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiffTooLarge(t *testing.T) {
+	large := strings.Repeat("line\n", diffMaxLines+1)
+
+	if got := unifiedDiff("old", "new", large, "line\n", 3); got != "" {
+		t.Errorf("unifiedDiff() of a file over %v lines = %q, want an empty diff", diffMaxLines, got)
+	}
+}
+
+// Applies a unified diff to a text
+func applyUnifiedDiff(t *testing.T, oldText string, diff string) string {
+	t.Helper()
+
+	oldLines := splitDiffLines(oldText)
+	diffLines := splitDiffLines(diff)[2:]
+
+	var output []string
+	consumed := 0
+
+	for index := 0; index < len(diffLines); index++ {
+		line := diffLines[index]
+		if strings.HasPrefix(line, "@@ ") == false {
+			t.Fatalf("unexpected line %q in\n%v", line, diff)
+		}
+
+		var oldStart, oldCount, newStart, newCount int
+		if _, err := fmt.Sscanf(line, "@@ -%d,%d +%d,%d @@\n", &oldStart, &oldCount, &newStart, &newCount); err != nil {
+			t.Fatalf("invalid hunk header %q: %v", line, err)
+		}
+
+		// Without old lines, the hunk goes after line oldStart
+		firstOld := oldStart - 1
+		if oldCount == 0 {
+			firstOld = oldStart
+		}
+		if firstOld < consumed {
+			t.Fatalf("hunk %q overlaps the previous one in\n%v", line, diff)
+		}
+		output = append(output, oldLines[consumed:firstOld]...)
+		consumed = firstOld
+
+		if len(output)+1 != newStart && newCount > 0 {
+			t.Fatalf("hunk %q starts at new line %v, want %v", line, len(output)+1, newStart)
+		}
+
+		seenOld, seenNew := 0, 0
+		for index+1 < len(diffLines) && strings.HasPrefix(diffLines[index+1], "@@ ") == false {
+			index++
+			hunkLine := diffLines[index]
+
+			if hunkLine == "\\ No newline at end of file\n" {
+				// Applies to the previous line
+				previous := diffLines[index-1]
+				if previous[0] != '-' {
+					output[len(output)-1] = strings.TrimSuffix(output[len(output)-1], "\n")
+				}
+				continue
+			}
+
+			text := hunkLine[1:]
+			if index+1 < len(diffLines) && diffLines[index+1] == "\\ No newline at end of file\n" {
+				text = strings.TrimSuffix(text, "\n")
+			}
+
+			switch hunkLine[0] {
+			case ' ', '-':
+				if consumed >= len(oldLines) || oldLines[consumed] != text {
+					t.Fatalf("hunk line %q does not match the old text in\n%v", hunkLine, diff)
+				}
+				consumed++
+				seenOld++
+				if hunkLine[0] == ' ' {
+					output = append(output, text)
+					seenNew++
+				}
+			case '+':
+				output = append(output, text)
+				seenNew++
+			default:
+				t.Fatalf("unexpected hunk line %q in\n%v", hunkLine, diff)
+			}
+		}
+
+		if seenOld != oldCount || seenNew != newCount {
+			t.Fatalf("hunk %q has %v old and %v new lines in\n%v", line, seenOld, seenNew, diff)
+		}
+	}
+
+	output = append(output, oldLines[consumed:]...)
+
+	return strings.Join(output, "")
+}
+
+func TestUnifiedDiffApplies(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	randomText := func() string {
+		lines := make([]string, random.Intn(12))
+		for index := range lines {
+			lines[index] = strconv.Itoa(random.Intn(4)) + "\n"
+		}
+		text := strings.Join(lines, "")
+		if text != "" && random.Intn(4) == 0 {
+			text = strings.TrimSuffix(text, "\n")
+		}
+		return text
+	}
+
+	for iteration := 0; iteration < 2000; iteration++ {
+		oldText, newText := randomText(), randomText()
+		context := random.Intn(4)
+
+		diff := unifiedDiff("old", "new", oldText, newText, context)
+		if got := applyUnifiedDiff(t, oldText, diff); got != newText {
+			t.Fatalf("applying the diff of %q and %q (context %v) gives %q\n%v", oldText, newText, context, got, diff)
+		}
+
+		if (oldText == newText) != (diff == "--- old\n+++ new\n") {
+			t.Fatalf("diff of %q and %q has hunks = %v", oldText, newText, oldText != newText)
+		}
+	}
+}
+
+func TestParseDiffHookOutput(t *testing.T) {
+	output := strings.Join([]string{
+		"+ new file",
+		"~ dir/changed\t1024",
+		"- removed\tnot a size",
+		"! both\r",
+		"",
+		"? unknown",
+		"+",
+		"nonsense",
+	}, "\n")
+
+	changes, unrecognized := parseDiffHookOutput(output)
+
+	want := []diffChange{
+		{Change: diffChangeAdded, Path: "new file"},
+		{Change: diffChangeModified, Path: "dir/changed", Size: 1024},
+		{Change: diffChangeDeleted, Path: "removed\tnot a size"},
+		{Change: diffChangeConflict, Path: "both"},
+	}
+	if reflect.DeepEqual(changes, want) == false {
+		t.Errorf("parseDiffHookOutput() = %+v, want %+v", changes, want)
+	}
+	if unrecognized != 3 {
+		t.Errorf("parseDiffHookOutput() unrecognized = %v, want 3", unrecognized)
+	}
+}
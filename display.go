@@ -6,7 +6,9 @@ package main
 
 import (
 	// Modules in GOROOT
+	"bufio"
 	"fmt"
+	"os"
 	"strings"
 
 	// External modules
@@ -119,6 +121,61 @@ func showError(msg string, indentLevel int) {
 	printColoredMessage(red, msg, indentLevel)
 }
 
+// Sends the messages of the program to the standard error, keeping the standard
+// output for machine-readable output (e.g. '--output json'). Returns the original
+// standard output.
+func redirectDisplayToStderr() *os.File {
+	stdout := os.Stdout
+
+	os.Stdout = os.Stderr
+	color.SetOutput(os.Stderr)
+
+	return stdout
+}
+
+// Sends the output of the program (its messages and the output of the hooks)
+// through a pipe, line by line: each line is passed to the function (e.g. to stream
+// it through the daemon API), and what it returns is written to the original
+// standard output. The returned function restores the standard output (once the
+// pending lines are written).
+func teeDisplayOutput(handleLine func(line string) string) (func(), error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	color.SetOutput(writer)
+
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
+
+		buffered := bufio.NewReader(reader)
+		for {
+			line, err := buffered.ReadString('\n')
+			if line != "" {
+				stdout.WriteString(handleLine(line))
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	restore := func() {
+		os.Stdout = stdout
+		color.SetOutput(stdout)
+
+		writer.Close()
+		<-ended
+		reader.Close()
+	}
+
+	return restore, nil
+}
+
 func space() {
 	fmt.Println("")
 }
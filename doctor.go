@@ -0,0 +1,196 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"fmt"
+	"os"
+	"time"
+	// External modules
+)
+
+//
+//// DOCTOR
+//
+
+// Checks the configuration of crates and targets without running any hook: config
+// files (including the global one), schedules, notifications, SSH settings, sync
+// engines, local roots and unison profiles.
+
+func checkGlobalConfig(program Program) []string {
+	var problems []string
+
+	config, response := readGlobalConfig(program)
+	if response.exitCode != 0 {
+		return append(problems, response.message)
+	}
+
+	for _, notification := range config.Notifications {
+		if err := notification.validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid notification -> "+err.Error()))
+		}
+	}
+
+	problems = append(problems, checkGroups(config.Groups, program)...)
+
+	return problems
+}
+
+func checkCrate(crate Crate, program Program) []string {
+	var problems []string
+
+	config, response := readCrateConfig(crate, program)
+	if response.exitCode != 0 {
+		return append(problems, response.message)
+	}
+
+	if config.Schedule.isSet() == true {
+		if _, err := config.Schedule.next(time.Now()); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid schedule -> "+err.Error()))
+		}
+	}
+
+	for _, notification := range config.Notifications {
+		if err := notification.validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid notification -> "+err.Error()))
+		}
+	}
+
+	if config.SSH.Enabled == true {
+		if config.SSH.KeyPath != "" {
+			keyPath := expandConfigPath(config.SSH.KeyPath, crate.environment)
+			if _, err := os.Stat(keyPath); err != nil {
+				problems = append(problems, fmt.Sprintf("SSH key %v not found", keyPath))
+			}
+		}
+
+		if config.SSH.KnownHostsFile != "" {
+			knownHostsFile := expandConfigPath(config.SSH.KnownHostsFile, crate.environment)
+			if _, err := os.Stat(knownHostsFile); err != nil {
+				problems = append(problems, fmt.Sprintf("Known hosts file %v not found", knownHostsFile))
+			}
+		}
+	}
+
+	return problems
+}
+
+func checkTarget(target Target, program Program) []string {
+	var problems []string
+
+	config, response := readTargetConfig(target, program)
+	if response.exitCode != 0 {
+		return append(problems, response.message)
+	}
+
+	profilePath := getTargetUnisonProfilePath(target)
+
+	if config.Schedule.isSet() == true {
+		if _, err := config.Schedule.next(time.Now()); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid schedule -> "+err.Error()))
+		}
+	}
+
+	if config.Sync.Engine != "" {
+		response := verifySyncEngineConfig(config.Sync, program)
+		if response.exitCode != 0 {
+			return append(problems, response.message)
+		}
+
+		roots := config.Sync.Roots
+		if config.Sync.Engine == syncEngineMirror {
+			// The destination of a mirror is created when missing
+			roots = []string{config.Sync.Source}
+		}
+
+		for _, root := range roots {
+			root = expandConfigPath(root, target.environment)
+			if _, isRemote := parseSSHRemote(root, 0); isRemote == true {
+				continue
+			}
+			if _, err := os.Stat(root); err != nil {
+				problems = append(problems, fmt.Sprintf("Root %v not found", root))
+			}
+		}
+	} else if hookExists(resolveTargetHook(target, "sync", program).path) == false {
+		problems = append(problems, "No sync hook and no sync engine configured")
+	}
+
+	if _, err := os.Stat(profilePath); err == nil {
+		profile, err := parseUnisonProfile(profilePath)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("Failed to read unison profile -> "+err.Error()))
+		} else {
+			for _, problem := range validateUnisonProfile(profile) {
+				problems = append(problems, "Unison profile: "+problem)
+			}
+		}
+	}
+
+	return problems
+}
+
+func doctor(crates []Crate, program Program) functionResponse {
+	problemsCount := 0
+
+	showProblems := func(problems []string, indentLevel int) {
+		for _, problem := range problems {
+			showError(fmt.Sprintf("> %v", problem), indentLevel)
+		}
+		problemsCount += len(problems)
+	}
+
+	if problems := checkGlobalConfig(program); len(problems) > 0 {
+		space()
+		showInfoSectionTitle("Checking global configuration", program.indentLevel)
+		showProblems(problems, program.indentLevel+1)
+	}
+
+	for index, crate := range crates {
+		space()
+
+		orange.Println(fmt.Sprintf("(%v/%v)", index+1, len(crates)))
+		showInfoSectionTitle(displayCrateTag("Checking", crate), program.indentLevel)
+
+		showProblems(checkCrate(crate, program), program.indentLevel+1)
+
+		targets, response := getCrateTargets(crate, program)
+		if response.exitCode != 0 {
+			response.indentLevel = program.indentLevel + 1
+			handleFunctionResponse(response, false)
+			continue
+		}
+
+		for _, target := range targets {
+			problems := checkTarget(target, program)
+			if len(problems) == 0 {
+				showText(fmt.Sprintf("- %s: %s", target.name, green.Sprintf("ok")), program.indentLevel+1)
+				continue
+			}
+
+			showText(fmt.Sprintf("- %s:", target.name), program.indentLevel+1)
+			showProblems(problems, program.indentLevel+2)
+		}
+	}
+
+	space()
+
+	if problemsCount > 0 {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Found %v problem(s)", problemsCount),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return functionResponse{
+		exitCode:    0,
+		message:     "No problems found",
+		logLevel:    "success",
+		indentLevel: program.indentLevel,
+	}
+}
@@ -0,0 +1,644 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	// External modules
+	ptywrapper "github.com/fearlessdots/ptywrapper"
+)
+
+//
+//// SYNC ENGINE CONFIGURATION
+//
+
+// Built-in sync engines
+const (
+	syncEngineMirror = "mirror"
+	syncEngineTwoWay = "twoway"
+)
+
+// How files are compared to decide if they changed
+const (
+	syncCompareMtime    = "mtime"
+	syncCompareChecksum = "checksum"
+)
+
+type syncEngineConfig struct {
+	Engine              string   `json:"engine"`
+	Source              string   `json:"source"`
+	Destination         string   `json:"destination"`
+	Roots               []string `json:"roots"`
+	Compare             string   `json:"compare"`
+	Delete              bool     `json:"delete"`
+	Exclude             []string `json:"exclude"`
+	PreservePermissions *bool    `json:"preservePermissions"`
+	PreserveTimes       *bool    `json:"preserveTimes"`
+}
+
+func (config syncEngineConfig) preservePermissions() bool {
+	return config.PreservePermissions == nil || *config.PreservePermissions == true
+}
+
+func (config syncEngineConfig) preserveTimes() bool {
+	return config.PreserveTimes == nil || *config.PreserveTimes == true
+}
+
+func verifySyncEngineConfig(config syncEngineConfig, program Program) functionResponse {
+	var message string
+
+	switch {
+	case config.Engine != syncEngineMirror && config.Engine != syncEngineTwoWay:
+		message = fmt.Sprintf("Unknown sync engine '%v' (expected '%v' or '%v')", config.Engine, syncEngineMirror, syncEngineTwoWay)
+	case config.Engine == syncEngineMirror && config.Source == "":
+		message = "No source set for the mirror engine"
+	case config.Engine == syncEngineMirror && config.Destination == "":
+		message = "No destination set for the mirror engine"
+	case config.Engine == syncEngineTwoWay && len(config.Roots) != 2:
+		message = "The twoway engine needs exactly two roots"
+	case config.Compare != "" && config.Compare != syncCompareMtime && config.Compare != syncCompareChecksum:
+		message = fmt.Sprintf("Unknown comparison method '%v' (expected '%v' or '%v')", config.Compare, syncCompareMtime, syncCompareChecksum)
+	default:
+		return functionResponse{exitCode: 0}
+	}
+
+	return functionResponse{
+		exitCode:    1,
+		message:     message,
+		logLevel:    "error",
+		indentLevel: program.indentLevel,
+	}
+}
+
+//
+//// SYNC FILESYSTEMS
+//
+
+// Filesystem used by the sync engines. All names are slash-separated and relative
+// to the root of the filesystem ("" being the root itself).
+type syncFS interface {
+	String() string
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+	Mkdir(name string, perm os.FileMode) error
+	MkdirAll(name string) error
+	Remove(name string) error
+	Rename(oldName string, newName string) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, modTime time.Time) error
+	Readlink(name string) (string, error)
+	Symlink(linkTarget string, name string) error
+}
+
+type localSyncFS struct {
+	root string
+}
+
+func (fs localSyncFS) path(name string) string {
+	return filepath.Join(fs.root, filepath.FromSlash(name))
+}
+
+func (fs localSyncFS) String() string {
+	return fs.root
+}
+
+func (fs localSyncFS) Lstat(name string) (os.FileInfo, error) {
+	return os.Lstat(fs.path(name))
+}
+
+func (fs localSyncFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(fs.path(name))
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func (fs localSyncFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(fs.path(name))
+}
+
+func (fs localSyncFS) Create(name string) (io.WriteCloser, error) {
+	return os.OpenFile(fs.path(name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (fs localSyncFS) Mkdir(name string, perm os.FileMode) error {
+	return os.Mkdir(fs.path(name), perm)
+}
+
+func (fs localSyncFS) MkdirAll(name string) error {
+	return os.MkdirAll(fs.path(name), 0755)
+}
+
+func (fs localSyncFS) Remove(name string) error {
+	return os.Remove(fs.path(name))
+}
+
+func (fs localSyncFS) Rename(oldName string, newName string) error {
+	return os.Rename(fs.path(oldName), fs.path(newName))
+}
+
+func (fs localSyncFS) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(fs.path(name), mode)
+}
+
+func (fs localSyncFS) Chtimes(name string, modTime time.Time) error {
+	return os.Chtimes(fs.path(name), modTime, modTime)
+}
+
+func (fs localSyncFS) Readlink(name string) (string, error) {
+	return os.Readlink(fs.path(name))
+}
+
+func (fs localSyncFS) Symlink(linkTarget string, name string) error {
+	return os.Symlink(linkTarget, fs.path(name))
+}
+
+// Opens the filesystem for a root given in the configuration: a local path or a
+// remote one (accessed through SFTP)
+func openSyncFS(root string, env map[string]string, connections *sshConnectionPool) (syncFS, error) {
+	expandedRoot := expandConfigEnv(root, env)
+	if _, isRemote := parseSSHRemote(expandedRoot, 0); isRemote == false {
+		return localSyncFS{root: expandConfigPath(root, env)}, nil
+	}
+
+	// The SSH settings of the crate (e.g. the default port) are only read for
+	// remote roots
+	sshConfig, err := connections.sshConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	remote, _ := parseSSHRemote(expandedRoot, sshConfig.Port)
+
+	return connections.openSFTP(remote)
+}
+
+//
+//// SYNC TREES
+//
+
+type syncEntry struct {
+	path       string
+	isDir      bool
+	isSymlink  bool
+	size       int64
+	modTime    time.Time
+	mode       os.FileMode
+	linkTarget string
+}
+
+// Two entries are of the same kind if both are directories, symlinks or files
+func (entry syncEntry) sameKind(other syncEntry) bool {
+	return entry.isDir == other.isDir && entry.isSymlink == other.isSymlink
+}
+
+func newSyncEntry(fs syncFS, name string, info os.FileInfo) (syncEntry, error) {
+	entry := syncEntry{
+		path:      name,
+		isDir:     info.IsDir(),
+		isSymlink: info.Mode()&os.ModeSymlink != 0,
+		size:      info.Size(),
+		// Modification times are compared with a precision of one second, which is
+		// what every filesystem (and SFTP) can store
+		modTime: info.ModTime().Truncate(time.Second),
+		mode:    info.Mode().Perm(),
+	}
+
+	if entry.isSymlink == true {
+		linkTarget, err := fs.Readlink(name)
+		if err != nil {
+			return entry, err
+		}
+		entry.linkTarget = linkTarget
+	}
+
+	return entry, nil
+}
+
+// Lists every entry below the root of the filesystem, skipping the excluded ones.
+// If the root does not exist, the tree is empty.
+func scanSyncTree(fs syncFS, excludes []string) (map[string]syncEntry, int, error) {
+	tree := make(map[string]syncEntry)
+	excluded := 0
+
+	info, err := fs.Lstat("")
+	if os.IsNotExist(err) {
+		return tree, excluded, nil
+	} else if err != nil {
+		return tree, excluded, err
+	} else if info.IsDir() == false {
+		return tree, excluded, fmt.Errorf("%v is not a directory", fs)
+	}
+
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		infos, err := fs.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, info := range infos {
+			name := path.Join(dir, info.Name())
+			if strings.HasSuffix(name, syncTempFileSuffix) == true {
+				// Left behind by an interrupted copy
+				continue
+			}
+			if isSyncPathExcluded(name, info.IsDir(), excludes) == true {
+				excluded++
+				continue
+			}
+
+			entry, err := newSyncEntry(fs, name, info)
+			if err != nil {
+				return err
+			}
+			tree[name] = entry
+
+			if entry.isDir == true {
+				err = walk(name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return tree, excluded, walk("")
+}
+
+// Returns the paths of a tree sorted so that directories come before their contents
+func sortedSyncPaths(tree map[string]syncEntry) []string {
+	paths := make([]string, 0, len(tree))
+	for name := range tree {
+		paths = append(paths, name)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// Exclude patterns follow rsync's rules: patterns without a slash match the name of
+// the file at any depth, patterns with a slash (or starting with one) match the
+// path relative to the root, and a trailing slash only matches directories. '**'
+// matches any part of a path, slashes included (so patterns with it also match the
+// path relative to the root).
+func isSyncPathExcluded(name string, isDir bool, excludes []string) bool {
+	for _, pattern := range excludes {
+		dirOnly := strings.HasSuffix(pattern, "/")
+		pattern = strings.TrimSuffix(pattern, "/")
+		if pattern == "" || (dirOnly == true && isDir == false) {
+			continue
+		}
+
+		subject := path.Base(name)
+		if strings.Contains(pattern, "/") == true || strings.Contains(pattern, "**") == true {
+			pattern = strings.TrimPrefix(pattern, "/")
+			subject = name
+		}
+
+		if matchSyncPattern(pattern, subject) == true {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Matches a path against a pattern of path.Match in which '**' also matches any
+// sequence of characters, slashes included
+func matchSyncPattern(pattern string, name string) bool {
+	before, after, found := strings.Cut(pattern, "**")
+	if found == false {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}
+
+	for index := 0; index <= len(name); index++ {
+		if matched, _ := path.Match(before, name[:index]); matched == false {
+			continue
+		}
+
+		for rest := index; rest <= len(name); rest++ {
+			if matchSyncPattern(after, name[rest:]) == true {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func hashSyncFile(fs syncFS, name string) (string, error) {
+	file, err := fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Suffix of the temporary files written while copying
+const syncTempFileSuffix = ".synctropy-tmp"
+
+// Copies a file between filesystems (to the given name in the destination) through
+// a temporary file, so that the destination is never left half-written. Returns the
+// number of bytes copied and the SHA-256 of the contents.
+func copySyncFile(src syncFS, dst syncFS, entry syncEntry, dstName string, preservePermissions bool, preserveTimes bool) (int64, string, error) {
+	reader, err := src.Open(entry.path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer reader.Close()
+
+	tempName := path.Join(path.Dir(dstName), "."+path.Base(dstName)+syncTempFileSuffix)
+	writer, err := dst.Create(tempName)
+	if err != nil {
+		return 0, "", err
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(writer, hash), reader)
+	closeErr := writer.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && preservePermissions == true {
+		err = dst.Chmod(tempName, entry.mode)
+	}
+	if err == nil && preserveTimes == true {
+		err = dst.Chtimes(tempName, entry.modTime)
+	}
+	if err == nil {
+		err = dst.Rename(tempName, dstName)
+	}
+	if err != nil {
+		_ = dst.Remove(tempName)
+		return written, "", err
+	}
+
+	return written, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Reported when a directory cannot be replaced, since it contains excluded paths
+var errSyncPathHasExcluded = errors.New("the directory contains excluded paths")
+
+// Removes an entry (and its contents, if it is a directory). Excluded paths are
+// kept, and so are the directories still containing them. Returns whether the
+// entry was removed.
+func removeSyncPath(fs syncFS, entry syncEntry, excludes []string) (bool, error) {
+	if entry.isDir == true {
+		infos, err := fs.ReadDir(entry.path)
+		if err != nil {
+			return false, err
+		}
+
+		keep := false
+		for _, info := range infos {
+			childPath := path.Join(entry.path, info.Name())
+			if isSyncPathExcluded(childPath, info.IsDir(), excludes) == true {
+				keep = true
+				continue
+			}
+
+			child, err := newSyncEntry(fs, childPath, info)
+			if err != nil {
+				return false, err
+			}
+			removed, err := removeSyncPath(fs, child, excludes)
+			if err != nil {
+				return false, err
+			}
+			if removed == false {
+				keep = true
+			}
+		}
+
+		if keep == true {
+			return false, nil
+		}
+	}
+
+	err := fs.Remove(entry.path)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+//
+//// SYNC STATS
+//
+
+type syncStats struct {
+	engine      string
+	scanned     int
+	created     int
+	updated     int
+	unchanged   int
+	deleted     int
+	excluded    int
+	dirsCreated int
+	conflicts   int
+	unresolved  int
+	bytes       int64
+	errors      []string
+}
+
+func (stats *syncStats) addError(name string, err error) {
+	stats.errors = append(stats.errors, fmt.Sprintf("%v -> %v", name, err.Error()))
+}
+
+// Statistics reported to the run summary and journal
+func (stats syncStats) hookStats() []hookStat {
+	hookStats := []hookStat{
+		{Name: "files_scanned", Value: float64(stats.scanned)},
+		{Name: "files_created", Value: float64(stats.created)},
+		{Name: "files_updated", Value: float64(stats.updated)},
+		{Name: "files_deleted", Value: float64(stats.deleted)},
+		{Name: "bytes_transferred", Value: float64(stats.bytes)},
+	}
+
+	if stats.engine == syncEngineTwoWay {
+		hookStats = append(hookStats, hookStat{Name: "conflicts", Value: float64(stats.conflicts + stats.unresolved)})
+	}
+
+	return hookStats
+}
+
+func showSyncStats(stats syncStats, duration time.Duration, program Program) {
+	rows := [][]string{
+		{"Files scanned", fmt.Sprintf("%v", stats.scanned)},
+		{"Created", fmt.Sprintf("%v", stats.created)},
+		{"Updated", fmt.Sprintf("%v", stats.updated)},
+		{"Unchanged", fmt.Sprintf("%v", stats.unchanged)},
+		{"Deleted", fmt.Sprintf("%v", stats.deleted)},
+		{"Excluded", fmt.Sprintf("%v", stats.excluded)},
+		{"Directories created", fmt.Sprintf("%v", stats.dirsCreated)},
+	}
+	if stats.engine == syncEngineTwoWay {
+		rows = append(rows, []string{"Conflicts", fmt.Sprintf("%v new, %v unresolved", stats.conflicts, stats.unresolved)})
+	}
+	rows = append(rows, [][]string{
+		{"Bytes transferred", formatByteSize(stats.bytes)},
+		{"Errors", fmt.Sprintf("%v", len(stats.errors))},
+		{"Duration", fmt.Sprintf("%.1fs", duration.Seconds())},
+	}...)
+
+	for _, row := range rows {
+		showText(fmt.Sprintf("%s %v", lightGray.Sprintf("%-20s", row[0]+":"), row[1]), program.indentLevel)
+	}
+}
+
+func formatByteSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%v %v", size, units[unit])
+	}
+
+	return fmt.Sprintf("%.1f %v", value, units[unit])
+}
+
+// Shows a change applied by a sync engine
+func showSyncChange(symbol string, name string, program Program) {
+	switch symbol {
+	case "+":
+		showText(green.Sprintf("+ ")+name, program.indentLevel)
+	case "-":
+		showText(red.Sprintf("- ")+name, program.indentLevel)
+	case "!":
+		showText(red.Sprintf("! ")+name, program.indentLevel)
+	default:
+		showText(orange.Sprintf(symbol+" ")+name, program.indentLevel)
+	}
+}
+
+//
+//// SYNC ENGINE EXECUTION
+//
+
+// Runs the built-in sync engine selected by a target (instead of its 'sync' hook).
+// The result is reported like a hook run, with the sync statistics as its status.
+// Remote roots use (and keep open) the connections of the crate transaction.
+func runTargetSyncEngine(target Target, config syncEngineConfig, connections *sshConnectionPool, program Program) (hookResult, functionResponse) {
+	response := verifySyncEngineConfig(config, program)
+	if response.exitCode != 0 {
+		return hookResult{}, response
+	}
+
+	roots := []string{config.Source, config.Destination}
+	if config.Engine == syncEngineTwoWay {
+		roots = config.Roots
+	}
+
+	filesystems := make([]syncFS, len(roots))
+	for i, root := range roots {
+		fs, err := openSyncFS(root, target.environment, connections)
+		if err != nil {
+			return hookResult{}, functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to open %v -> %v", root, err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+		filesystems[i] = fs
+	}
+
+	if config.Engine == syncEngineTwoWay {
+		showText(fmt.Sprintf("%s %v %s %v", lightGray.Sprintf("A:"), filesystems[0], lightGray.Sprintf("B:"), filesystems[1]), program.indentLevel+1)
+	} else {
+		showText(fmt.Sprintf("%s %v %s %v", lightGray.Sprintf("Source:"), filesystems[0], lightGray.Sprintf("Destination:"), filesystems[1]), program.indentLevel+1)
+	}
+	hr("-", 0.5, incrementProgramIndentLevel(program, 1))
+
+	var stats syncStats
+	var err error
+	startTime := time.Now()
+	if config.Engine == syncEngineTwoWay {
+		stats, err = runTwoWayEngine(target, filesystems[0], filesystems[1], config, incrementProgramIndentLevel(program, 1))
+	} else {
+		stats, err = runMirrorEngine(filesystems[0], filesystems[1], config, incrementProgramIndentLevel(program, 1))
+	}
+	stats.engine = config.Engine
+	duration := time.Since(startTime)
+
+	hr("-", 0.5, incrementProgramIndentLevel(program, 1))
+	showSyncStats(stats, duration, incrementProgramIndentLevel(program, 1))
+
+	result := hookResult{
+		status:   hookStatus{Stats: stats.hookStats()},
+		duration: duration,
+	}
+	for _, message := range stats.errors {
+		result.status.Warnings = append(result.status.Warnings, message)
+	}
+	if stats.conflicts+stats.unresolved > 0 {
+		result.status.Warnings = append(result.status.Warnings, fmt.Sprintf("%v conflict(s), see %v", stats.conflicts+stats.unresolved, getSyncConflictReportPath(target)))
+	}
+
+	if err == nil && len(stats.errors) > 0 {
+		err = fmt.Errorf("%v file(s) could not be synced", len(stats.errors))
+	}
+	if err != nil {
+		result.Command = ptywrapper.Command{Completed: true, ExitCode: 1}
+		return result, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to sync -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	result.Command = ptywrapper.Command{Completed: true, ExitCode: 0}
+	return result, functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: program.indentLevel,
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIsSyncPathExcluded(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		isDir   bool
+		want    bool
+	}{
+		// Basename patterns match at any depth
+		{"*.tmp", "x.tmp", false, true},
+		{"*.tmp", "a/b/x.tmp", false, true},
+		{"*.tmp", "x.tmp/file", false, false},
+		{"cache", "a/cache", true, true},
+		{"cache", "a/cache", false, true},
+		{"cache", "a/cache2", false, false},
+		{"?.log", "dir/a.log", false, true},
+		{"[ab].log", "dir/c.log", false, false},
+		// Anchored patterns match the path relative to the root
+		{"/build", "build", true, true},
+		{"/build", "src/build", true, false},
+		{"src/build", "src/build", true, true},
+		{"src/build", "x/src/build", true, false},
+		{"/src/*.o", "src/main.o", false, true},
+		{"/src/*.o", "src/sub/main.o", false, false},
+		// Directory-only patterns
+		{"cache/", "a/cache", true, true},
+		{"cache/", "a/cache", false, false},
+		{"/build/", "build", true, true},
+		{"/build/", "build", false, false},
+		// '**' matches across slashes
+		{"src/**/*.o", "src/a/b/main.o", false, true},
+		{"src/**/*.o", "src/a/main.o", false, true},
+		{"src/**/*.o", "src/main.o", false, false},
+		{"src/**", "src/a/b", false, true},
+		{"src/**", "other/a", false, false},
+		{"**/node_modules/", "a/b/node_modules", true, true},
+		{"**/node_modules/", "a/b/node_modules", false, false},
+		{"**.log", "a/b/x.log", false, true},
+		{"a/**/b/**/c", "a/x/b/y/z/c", false, true},
+		{"a/**/b/**/c", "a/x/y/z/c", false, false},
+		// Empty patterns match nothing
+		{"", "x", false, false},
+		{"/", "x", true, false},
+	}
+
+	for _, test := range tests {
+		if got := isSyncPathExcluded(test.name, test.isDir, []string{test.pattern}); got != test.want {
+			t.Errorf("isSyncPathExcluded(%q, %v, %q) = %v, want %v", test.name, test.isDir, test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestIsSyncPathExcludedAnyPattern(t *testing.T) {
+	excludes := []string{"*.tmp", "cache/", "/build"}
+
+	tests := []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{"notes.txt", false, false},
+		{"notes.tmp", false, true},
+		{"build", true, true},
+		{"src/build", true, false},
+		{"src/cache", true, true},
+		{"src/cache.json", false, false},
+	}
+
+	for _, test := range tests {
+		if got := isSyncPathExcluded(test.name, test.isDir, excludes); got != test.want {
+			t.Errorf("isSyncPathExcluded(%q, %v, %q) = %v, want %v", test.name, test.isDir, excludes, got, test.want)
+		}
+	}
+}
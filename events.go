@@ -0,0 +1,135 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	// External modules
+	color "github.com/gookit/color"
+)
+
+//
+//// EVENTS
+//
+
+// With '--events json', the lifecycle of a sync is reported as JSON objects, one
+// per line (e.g. to drive a dashboard):
+//
+//	run_start                 a sync started ('targets')
+//	crate_tempdir_created     the temporary directory of the crate was created ('path')
+//	hook_start                a hook started ('hook', 'path', 'command')
+//	hook_output               a chunk of the output of a hook ('output')
+//	hook_end                  a hook finished ('exit_code', 'duration_seconds')
+//	target_skipped_disabled   a disabled target was skipped
+//	run_end                   the sync finished ('exit_code', 'duration_seconds', 'run')
+//	error                     an error was reported ('message')
+//
+// Every event has its name ('event'), its time and, when relevant, the crate and
+// the target. Events are written to the standard output, replacing the messages of
+// the program, or to a file (keeping the messages). Hooks run without a
+// pseudo-terminal (but with the standard input), so that their output can be
+// reported.
+
+const eventsFormatJSON = "json"
+
+type runEvent struct {
+	Event           string      `json:"event"`
+	Time            time.Time   `json:"time"`
+	Crate           string      `json:"crate,omitempty"`
+	Target          string      `json:"target,omitempty"`
+	Targets         []string    `json:"targets,omitempty"`
+	Hook            string      `json:"hook,omitempty"`
+	Path            string      `json:"path,omitempty"`
+	Command         string      `json:"command,omitempty"`
+	Output          string      `json:"output,omitempty"`
+	ExitCode        *int        `json:"exit_code,omitempty"`
+	DurationSeconds *float64    `json:"duration_seconds,omitempty"`
+	Message         string      `json:"message,omitempty"`
+	Run             *journalRun `json:"run,omitempty"`
+}
+
+type eventStream struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+	file    *os.File
+}
+
+// Set while events are reported
+var events *eventStream
+
+// Starts reporting events to the file (or to the standard output if empty, in
+// which case the messages of the program are discarded)
+func startEventStream(format string, filePath string) error {
+	if format != eventsFormatJSON {
+		return fmt.Errorf("invalid events format '%v' (expected 'json')", format)
+	}
+
+	stream := &eventStream{}
+
+	if filePath != "" {
+		file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		stream.file = file
+		stream.encoder = json.NewEncoder(file)
+	} else {
+		stream.encoder = json.NewEncoder(os.Stdout)
+
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		os.Stdout = devNull
+		color.SetOutput(io.Discard)
+	}
+	stream.encoder.SetEscapeHTML(false)
+
+	events = stream
+
+	return nil
+}
+
+func emitEvent(event runEvent) {
+	if events == nil {
+		return
+	}
+
+	event.Time = time.Now()
+
+	events.mutex.Lock()
+	events.encoder.Encode(event)
+	events.mutex.Unlock()
+}
+
+// Returns a writer reporting what is written to it as 'hook_output' events
+func newHookOutputEventWriter(crate string, target string, hook string) io.Writer {
+	return eventWriterFunc(func(data []byte) (int, error) {
+		emitEvent(runEvent{Event: "hook_output", Crate: crate, Target: target, Hook: hook, Output: string(data)})
+		return len(data), nil
+	})
+}
+
+type eventWriterFunc func(data []byte) (int, error)
+
+func (write eventWriterFunc) Write(data []byte) (int, error) {
+	return write(data)
+}
+
+func eventInt(value int) *int {
+	return &value
+}
+
+func eventSeconds(duration time.Duration) *float64 {
+	seconds := duration.Seconds()
+	return &seconds
+}
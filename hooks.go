@@ -0,0 +1,587 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	// External modules
+	copy "github.com/otiai10/copy"
+	cobra "github.com/spf13/cobra"
+)
+
+//
+//// HOOK ARGUMENTS
+//
+
+// Returns the arguments given after '--' on the command line, which are forwarded
+// to the hooks as positional arguments.
+func getHookArgsFromCLI(cmd *cobra.Command, args []string, program Program) ([]string, functionResponse) {
+	dashIndex := cmd.ArgsLenAtDash()
+
+	if (dashIndex == -1 && len(args) > 0) || dashIndex > 0 {
+		return nil, functionResponse{
+			exitCode:    1,
+			logLevel:    "error",
+			message:     fmt.Sprintf("Unexpected argument(s) '%v'. Use '--' to pass arguments to the hook(s)", strings.Join(args, " ")),
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	if dashIndex == -1 {
+		return nil, functionResponse{exitCode: 0}
+	}
+
+	return args[dashIndex:], functionResponse{exitCode: 0}
+}
+
+// Joins the hook arguments into a single string (as exposed by $HOOK_ARGS), quoting
+// them so that they can be split again with 'eval set -- $HOOK_ARGS'.
+func joinHookArgs(hookArgs []string) string {
+	words := make([]string, len(hookArgs))
+	for i, arg := range hookArgs {
+		words[i] = quoteHookEntryWord(arg)
+	}
+
+	return strings.Join(words, " ")
+}
+
+//
+//// HOOK ENTRY COMMAND
+//
+
+// Placeholder that can be used inside a '.entry' file to choose where the hook
+// path is inserted. When it is absent, the hook path is appended as the last
+// argument.
+const hookEntryPathPlaceholder = "{hook}"
+
+type hookEntry struct {
+	command string
+	args    []string
+	custom  bool
+}
+
+// Reads the '.entry' file of a hook (if any) and returns the command that should
+// be used to run it. Without a '.entry' file, the hook runs with the default shell.
+func getHookEntry(hookPath string, env map[string]string, program Program) (hookEntry, functionResponse) {
+	customEntryFilePath := hookPath + ".entry"
+
+	if _, err := os.Stat(customEntryFilePath); os.IsNotExist(err) {
+		return hookEntry{
+			command: program.defaultShell,
+			args:    []string{hookPath},
+			custom:  false,
+		}, functionResponse{exitCode: 0}
+	}
+
+	contents, err := ioutil.ReadFile(customEntryFilePath)
+	if err != nil {
+		return hookEntry{}, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read custom entry configuration file -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	words, err := parseHookEntryWords(string(contents), func(key string) string {
+		if value, ok := env[key]; ok {
+			return value
+		}
+		return os.Getenv(key)
+	})
+	if err != nil {
+		return hookEntry{}, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to parse custom entry configuration file '%v' -> %v", customEntryFilePath, err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	if len(words) == 0 {
+		return hookEntry{}, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Custom entry configuration file '%v' is empty", customEntryFilePath),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	// Insert the hook path where the placeholder was used (the command included, to
+	// run the hook itself with extra arguments) or, by default, as the last argument
+	usedPlaceholder := false
+	for index, word := range words {
+		if strings.Contains(word, hookEntryPathPlaceholder) == true {
+			words[index] = strings.ReplaceAll(word, hookEntryPathPlaceholder, hookPath)
+			usedPlaceholder = true
+		}
+	}
+	args := words[1:]
+	if usedPlaceholder == false {
+		args = append(args, hookPath)
+	}
+
+	return hookEntry{
+		command: words[0],
+		args:    args,
+		custom:  true,
+	}, functionResponse{exitCode: 0}
+}
+
+// Returns a printable representation of the entry command, quoting words when
+// needed so that the output can be pasted back into a shell.
+func (entry hookEntry) String() string {
+	words := make([]string, 0, len(entry.args)+1)
+	for _, word := range append([]string{entry.command}, entry.args...) {
+		words = append(words, quoteHookEntryWord(word))
+	}
+
+	return strings.Join(words, " ")
+}
+
+func quoteHookEntryWord(word string) string {
+	if word == "" {
+		return "''"
+	}
+
+	if strings.ContainsAny(word, " \t\n\"'\\$#;&|<>()*?`") == false {
+		return word
+	}
+
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// Splits the contents of a '.entry' file into words following the usual shell
+// rules: single and double quotes, backslash escapes, line continuations and
+// '#' comments. Environment variables ($VAR and ${VAR}) are expanded outside of
+// single quotes using the given lookup function.
+func parseHookEntryWords(contents string, lookupEnv func(string) string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+
+	input := []rune(contents)
+
+	// Expands the variable starting at input[i] (right after the '$') and returns
+	// the index of the last consumed rune
+	expandVariable := func(i int) (int, error) {
+		if i >= len(input) {
+			word.WriteRune('$')
+			return i - 1, nil
+		}
+
+		if input[i] == '{' {
+			end := i + 1
+			for end < len(input) && input[end] != '}' {
+				end++
+			}
+			if end >= len(input) {
+				return 0, fmt.Errorf("unterminated variable reference '${'")
+			}
+			word.WriteString(lookupEnv(string(input[i+1 : end])))
+			return end, nil
+		}
+
+		end := i
+		for end < len(input) && (input[end] == '_' || (input[end] >= 'a' && input[end] <= 'z') || (input[end] >= 'A' && input[end] <= 'Z') || (end > i && input[end] >= '0' && input[end] <= '9')) {
+			end++
+		}
+		if end == i {
+			// Not a variable reference, keep the dollar sign
+			word.WriteRune('$')
+			return i - 1, nil
+		}
+		word.WriteString(lookupEnv(string(input[i:end])))
+		return end - 1, nil
+	}
+
+	for i := 0; i < len(input); i++ {
+		char := input[i]
+
+		switch {
+		case char == ' ' || char == '\t' || char == '\n' || char == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case char == '#' && !inWord:
+			// Comment until the end of the line
+			for i < len(input) && input[i] != '\n' {
+				i++
+			}
+		case char == '\\':
+			if i+1 >= len(input) {
+				return nil, fmt.Errorf("unexpected end of input after '\\'")
+			}
+			i++
+			if input[i] == '\n' {
+				// Line continuation
+				continue
+			}
+			word.WriteRune(input[i])
+			inWord = true
+		case char == '\'':
+			end := i + 1
+			for end < len(input) && input[end] != '\'' {
+				end++
+			}
+			if end >= len(input) {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			word.WriteString(string(input[i+1 : end]))
+			i = end
+			inWord = true
+		case char == '"':
+			i++
+			for ; i < len(input) && input[i] != '"'; i++ {
+				switch input[i] {
+				case '\\':
+					if i+1 < len(input) && strings.ContainsRune("$`\"\\\n", input[i+1]) {
+						i++
+						if input[i] != '\n' {
+							word.WriteRune(input[i])
+						}
+					} else {
+						word.WriteRune('\\')
+					}
+				case '$':
+					end, err := expandVariable(i + 1)
+					if err != nil {
+						return nil, err
+					}
+					i = end
+				default:
+					word.WriteRune(input[i])
+				}
+			}
+			if i >= len(input) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inWord = true
+		case char == '$':
+			end, err := expandVariable(i + 1)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			// An unquoted variable that expands to nothing does not produce a word
+			inWord = inWord || word.Len() > 0
+		default:
+			word.WriteRune(char)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+//
+//// HOOK DIRECTORIES
+//
+
+// A hook can also be a directory called '<hook>.d', whose executable scripts run
+// in lexical order as a single logical hook.
+const hookDirSuffix = ".d"
+
+// Verifies if a hook exists, either as a single file or as a hook directory. A
+// single file takes precedence over a hook directory with the same name.
+func hookExists(hookPath string) bool {
+	if info, err := os.Stat(hookPath); err == nil && info.IsDir() == false {
+		return true
+	}
+	if info, err := os.Stat(hookPath + hookDirSuffix); err == nil && info.IsDir() == true {
+		return true
+	}
+
+	return false
+}
+
+// Returns the scripts that make up a hook, in execution order. A single-file hook
+// has only one step: the hook itself.
+func getHookSteps(hookPath string, program Program) ([]string, functionResponse) {
+	if info, err := os.Stat(hookPath); err == nil && info.IsDir() == false {
+		return []string{hookPath}, functionResponse{exitCode: 0}
+	}
+
+	hookDirPath := hookPath + hookDirSuffix
+	elements, err := ioutil.ReadDir(hookDirPath)
+	if err != nil {
+		return []string{}, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read hook directory -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	elements = filterHiddenFilesAndDirectories(elements)
+
+	// ioutil.ReadDir already returns the entries sorted by name
+	var steps []string
+	for _, element := range elements {
+		if element.IsDir() || strings.HasSuffix(element.Name(), ".entry") {
+			continue
+		}
+
+		// Only executable scripts are run, so a step can be disabled by removing
+		// its executable bit
+		if element.Mode().Perm()&0111 == 0 {
+			continue
+		}
+
+		steps = append(steps, hookDirPath+"/"+element.Name())
+	}
+
+	return steps, functionResponse{exitCode: 0}
+}
+
+// Lists the hooks inside a hooks directory, both single files and hook
+// directories (reported without the '.d' suffix).
+func listHooksInDir(hooksDir string) ([]string, error) {
+	elements, err := ioutil.ReadDir(hooksDir)
+	if err != nil {
+		return []string{}, err
+	}
+	elements = filterHiddenFilesAndDirectories(elements)
+
+	var hookNames []string
+	foundHooks := make(map[string]bool)
+	for _, element := range elements {
+		var name string
+		if element.IsDir() {
+			if strings.HasSuffix(element.Name(), hookDirSuffix) == false {
+				continue
+			}
+			name = strings.TrimSuffix(element.Name(), hookDirSuffix)
+		} else {
+			if strings.HasSuffix(element.Name(), ".entry") {
+				continue
+			}
+			name = element.Name()
+		}
+
+		if foundHooks[name] == false {
+			foundHooks[name] = true
+			hookNames = append(hookNames, name)
+		}
+	}
+
+	sort.Strings(hookNames)
+
+	return hookNames, nil
+}
+
+// Displays a hook in 'hooks ls' with its entry command. Hook directories are
+// expanded into the sequence of scripts they run.
+func showHook(name string, hookPath string, env map[string]string, tag string, program Program) functionResponse {
+	steps, response := getHookSteps(hookPath, program)
+	if response.exitCode != 0 {
+		return response
+	}
+
+	if len(steps) == 1 && steps[0] == hookPath {
+		entry, response := getHookEntry(hookPath, env, program)
+		if response.exitCode != 0 {
+			return response
+		}
+		showText(fmt.Sprintf("- %s (%s)%s", name, coral.Sprintf(entry.String()), tag), program.indentLevel+1)
+
+		return functionResponse{exitCode: 0}
+	}
+
+	showText(fmt.Sprintf("- %s (%s)%s", name, coral.Sprintf("%v step(s)", len(steps)), tag), program.indentLevel+1)
+	for index, step := range steps {
+		entry, response := getHookEntry(step, env, program)
+		if response.exitCode != 0 {
+			return response
+		}
+		showText(fmt.Sprintf("%v. %s (%s)", index+1, filepath.Base(step), coral.Sprintf(entry.String())), program.indentLevel+2)
+	}
+
+	return functionResponse{exitCode: 0}
+}
+
+//
+//// HOOK RESOLUTION
+//
+
+// Sources from which a target hook can be resolved, in order of precedence
+const (
+	hookSourceTarget = "target"
+	hookSourceCrate  = "crate"
+	hookSourceGlobal = "global"
+)
+
+type resolvedHook struct {
+	name      string
+	path      string
+	source    string
+	overrides string // Source of the default hook shadowed by this one (if any)
+}
+
+type hookSearchDir struct {
+	source string
+	path   string
+}
+
+// Returns the directories searched when resolving a target hook: the target's
+// own hooks directory, then the crate's default target hooks and finally the
+// user-global default target hooks.
+func getTargetHookSearchDirs(target Target, program Program) []hookSearchDir {
+	return []hookSearchDir{
+		{source: hookSourceTarget, path: target.hooksDir},
+		{source: hookSourceCrate, path: target.crate.targetsHooksDir},
+		{source: hookSourceGlobal, path: program.userTargetsHooksDir},
+	}
+}
+
+// Resolves a target hook following the fallback chain. When the hook is not found
+// anywhere, the returned path points to the target's own hooks directory and the
+// source is empty.
+func resolveTargetHook(target Target, hook string, program Program) resolvedHook {
+	resolved := resolvedHook{
+		name:   hook,
+		path:   target.hooksDir + "/" + hook,
+		source: "",
+	}
+
+	for _, dir := range getTargetHookSearchDirs(target, program) {
+		hookPath := dir.path + "/" + hook
+		if hookExists(hookPath) == true {
+			if resolved.source == "" {
+				resolved.path = hookPath
+				resolved.source = dir.source
+			} else {
+				resolved.overrides = dir.source
+				break
+			}
+		}
+	}
+
+	return resolved
+}
+
+// Returns all the hooks available to a target, each one resolved following the
+// fallback chain.
+func getTargetHooks(target Target, program Program) ([]resolvedHook, functionResponse) {
+	var hookNames []string
+	foundHooks := make(map[string]bool)
+
+	for _, dir := range getTargetHookSearchDirs(target, program) {
+		hooks, err := listHooksInDir(dir.path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return []resolvedHook{}, functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Error reading the hooks directory '%v' -> %v", dir.path, err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		for _, hook := range hooks {
+			if foundHooks[hook] == false {
+				foundHooks[hook] = true
+				hookNames = append(hookNames, hook)
+			}
+		}
+	}
+
+	sort.Strings(hookNames)
+
+	resolvedHooks := make([]resolvedHook, len(hookNames))
+	for i, hook := range hookNames {
+		resolvedHooks[i] = resolveTargetHook(target, hook, program)
+	}
+
+	return resolvedHooks, functionResponse{exitCode: 0}
+}
+
+func displayHookSource(source string) string {
+	switch source {
+	case hookSourceTarget:
+		return green.Sprintf("target")
+	case hookSourceCrate:
+		return salmonPink.Sprintf("crate default")
+	case hookSourceGlobal:
+		return blue.Sprintf("global default")
+	default:
+		return red.Sprintf("not found")
+	}
+}
+
+// Copies an inherited hook (and its '.entry' file, if any) into the target's own
+// hooks directory, so that it can be customized for this target only.
+func overrideTargetHook(target Target, hook string, program Program) functionResponse {
+	resolved := resolveTargetHook(target, hook, program)
+
+	if resolved.source == "" {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("No '%v' hook found", hook),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	if resolved.source == hookSourceTarget {
+		return functionResponse{
+			exitCode:    0,
+			message:     "Hook already defined by the target",
+			logLevel:    "attention",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	err := os.MkdirAll(target.hooksDir, 0755)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to create the target's hooks directory -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	copyOptions := copy.Options{
+		PreserveTimes: true,
+		PreserveOwner: true,
+	}
+
+	for _, suffix := range []string{"", ".entry", hookDirSuffix} {
+		if _, err := os.Stat(resolved.path + suffix); os.IsNotExist(err) {
+			continue
+		}
+
+		err = copy.Copy(resolved.path+suffix, target.hooksDir+"/"+hook+suffix, copyOptions)
+		if err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to copy hook -> " + err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+	}
+
+	return functionResponse{
+		exitCode:    0,
+		message:     fmt.Sprintf("Copied from %v", resolved.path),
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseHookEntryWords(t *testing.T) {
+	env := map[string]string{
+		"HOME":  "/home/user",
+		"SPACE": "a b",
+		"EMPTY": "",
+		"V1":    "one",
+	}
+	lookupEnv := func(name string) string {
+		return env[name]
+	}
+
+	tests := []struct {
+		name     string
+		contents string
+		want     []string
+	}{
+		{"empty", "", nil},
+		{"blank lines", " \n\t\r\n", nil},
+		{"words", "bash -c run", []string{"bash", "-c", "run"}},
+		{"repeated whitespace", "  bash \t -x\n\nrun  ", []string{"bash", "-x", "run"}},
+		{"single quotes", `echo 'a b' '$HOME' 'x"y'`, []string{"echo", "a b", "$HOME", `x"y`}},
+		{"double quotes", `echo "a b" "$HOME/x" "it's"`, []string{"echo", "a b", "/home/user/x", "it's"}},
+		{"escapes in double quotes", `echo "a\"b" "\$HOME" "c\\d" "e\nf"`, []string{"echo", `a"b`, "$HOME", `c\d`, `e\nf`}},
+		{"escapes outside quotes", `echo a\ b \'x\' \$HOME`, []string{"echo", "a b", "'x'", "$HOME"}},
+		{"adjacent quotes form one word", `echo a'b c'"d e"f`, []string{"echo", "ab cd ef"}},
+		{"empty quotes are a word", `echo '' ""`, []string{"echo", "", ""}},
+		{"line continuation", "bash \\\n  -x", []string{"bash", "-x"}},
+		{"line continuation in double quotes", "echo \"a\\\nb\"", []string{"echo", "ab"}},
+		{"comments", "# comment\nbash # trailing\n-x", []string{"bash", "-x"}},
+		{"hash inside a word", "echo a#b '#c'", []string{"echo", "a#b", "#c"}},
+		{"braced variable", "echo ${HOME}/x pre${V1}post", []string{"echo", "/home/user/x", "preonepost"}},
+		{"variable name ends at non-identifier", "echo $V1.txt $V1-$V1", []string{"echo", "one.txt", "one-one"}},
+		{"unquoted variable is not split", "echo $SPACE", []string{"echo", "a b"}},
+		{"empty unquoted variable is no word", "echo $EMPTY $UNSET x", []string{"echo", "x"}},
+		{"empty quoted variable is a word", `echo "$EMPTY"`, []string{"echo", ""}},
+		{"lone dollar signs", `echo $ "$" a$ $1`, []string{"echo", "$", "$", "a$", "$1"}},
+		{"unicode", "echo 'héllo wörld' ✓", []string{"echo", "héllo wörld", "✓"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseHookEntryWords(test.contents, lookupEnv)
+			if err != nil {
+				t.Fatalf("parseHookEntryWords(%q) failed: %v", test.contents, err)
+			}
+			if reflect.DeepEqual(got, test.want) == false {
+				t.Errorf("parseHookEntryWords(%q) = %q, want %q", test.contents, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseHookEntryWordsErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"unterminated single quote", "echo 'abc"},
+		{"unterminated double quote", `echo "abc`},
+		{"escaped closing double quote", `echo "abc\"`},
+		{"trailing backslash", `echo abc\`},
+		{"unterminated braced variable", "echo ${HOME"},
+		{"unterminated braced variable in double quotes", `echo "${HOME"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseHookEntryWords(test.contents, func(string) string { return "" })
+			if err == nil {
+				t.Errorf("parseHookEntryWords(%q) = %q, want an error", test.contents, got)
+			}
+		})
+	}
+}
+
+func TestQuoteHookEntryWordRoundTrip(t *testing.T) {
+	words := []string{
+		"plain",
+		"",
+		"a b",
+		"it's",
+		`say "hi"`,
+		"$HOME",
+		`back\slash`,
+		"semi;colon",
+		"glob*?",
+		"tab\there",
+		"new\nline",
+		"#hash",
+	}
+
+	for _, word := range words {
+		quoted := quoteHookEntryWord(word)
+
+		got, err := parseHookEntryWords(quoted, func(string) string { return "expanded" })
+		if err != nil {
+			t.Errorf("quoteHookEntryWord(%q) = %q, which fails to parse: %v", word, quoted, err)
+			continue
+		}
+		if len(got) != 1 || got[0] != word {
+			t.Errorf("quoteHookEntryWord(%q) = %q, which parses as %q", word, quoted, got)
+		}
+	}
+}
+
+func TestGetHookEntry(t *testing.T) {
+	program := Program{defaultShell: "/bin/sh"}
+	env := map[string]string{"RUNNER": "python3"}
+	hookPath := filepath.Join(t.TempDir(), "sync")
+
+	tests := []struct {
+		name    string
+		entry   string
+		command string
+		args    []string
+	}{
+		{"appended by default", "bash -x", "bash", []string{"-x", hookPath}},
+		{"placeholder as an argument", `bash -c 'source "$1"' bash {hook} last`, "bash", []string{"-c", `source "$1"`, "bash", hookPath, "last"}},
+		{"placeholder inside an argument", "tool --script={hook}", "tool", []string{"--script=" + hookPath}},
+		{"placeholder as the command", "{hook} --flag", hookPath, []string{"--flag"}},
+		{"placeholder alone", "{hook}", hookPath, []string{}},
+		{"placeholder in the command and arguments", "{hook} {hook}", hookPath, []string{hookPath}},
+		{"environment variables", "$RUNNER -u", "python3", []string{"-u", hookPath}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := os.WriteFile(hookPath+".entry", []byte(test.entry), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			entry, response := getHookEntry(hookPath, env, program)
+			if response.exitCode != 0 {
+				t.Fatalf("getHookEntry() failed: %v", response.message)
+			}
+			if entry.command != test.command || reflect.DeepEqual(entry.args, test.args) == false || entry.custom == false {
+				t.Errorf("getHookEntry() = %q %q, want %q %q", entry.command, entry.args, test.command, test.args)
+			}
+		})
+	}
+
+	t.Run("without an entry file", func(t *testing.T) {
+		os.Remove(hookPath + ".entry")
+
+		entry, response := getHookEntry(hookPath, env, program)
+		if response.exitCode != 0 || entry.command != "/bin/sh" || reflect.DeepEqual(entry.args, []string{hookPath}) == false || entry.custom == true {
+			t.Errorf("getHookEntry() = %q %q (%v), want the default shell", entry.command, entry.args, response.message)
+		}
+	})
+
+	t.Run("empty entry file", func(t *testing.T) {
+		if err := os.WriteFile(hookPath+".entry", []byte("# only a comment\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, response := getHookEntry(hookPath, env, program); response.exitCode == 0 {
+			t.Errorf("getHookEntry() of an empty entry file succeeded, want an error")
+		}
+	})
+}
@@ -0,0 +1,212 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+	// External modules
+)
+
+//
+//// RUN JOURNAL
+//
+
+// Every sync run is appended as a JSON line to the journal file in the user data
+// directory, with the outcome of each target and the status reported by its hooks.
+
+type journalHookRecord struct {
+	Hook            string     `json:"hook"`
+	ExitCode        int        `json:"exit_code"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	Status          hookStatus `json:"status"`
+}
+
+type journalTargetRecord struct {
+	Name            string              `json:"name"`
+	Outcome         string              `json:"outcome"`
+	ExitCode        int                 `json:"exit_code"`
+	DurationSeconds float64             `json:"duration_seconds"`
+	Status          hookStatus          `json:"status"`
+	Snapshot        string              `json:"snapshot,omitempty"`
+	Hooks           []journalHookRecord `json:"hooks,omitempty"`
+}
+
+type journalRun struct {
+	Operation       string                `json:"operation"`
+	Crate           string                `json:"crate"`
+	StartedAt       time.Time             `json:"started_at"`
+	FinishedAt      time.Time             `json:"finished_at"`
+	DurationSeconds float64               `json:"duration_seconds"`
+	ExitCode        int                   `json:"exit_code"`
+	Hooks           []journalHookRecord   `json:"hooks,omitempty"`
+	Targets         []journalTargetRecord `json:"targets"`
+}
+
+// Possible outcomes of a target in a run
+const (
+	targetOutcomeSuccess  = "success"
+	targetOutcomeFailed   = "failed"
+	targetOutcomeSkipped  = "skipped"
+	targetOutcomeDisabled = "disabled"
+)
+
+func newJournalRun(operation string, crate Crate) journalRun {
+	return journalRun{
+		Operation: operation,
+		Crate:     crate.name,
+		StartedAt: time.Now(),
+		Targets:   []journalTargetRecord{},
+	}
+}
+
+func newJournalHookRecord(hook string, result hookResult, response functionResponse) journalHookRecord {
+	return journalHookRecord{
+		Hook:            hook,
+		ExitCode:        response.exitCode,
+		DurationSeconds: result.duration.Seconds(),
+		Status:          result.status,
+	}
+}
+
+// Adds a hook run to the target record, merging the status it reported
+func (record *journalTargetRecord) addHook(hookRecord journalHookRecord) {
+	record.Hooks = append(record.Hooks, hookRecord)
+	record.Status.merge(hookRecord.Status)
+	record.DurationSeconds += hookRecord.DurationSeconds
+}
+
+func (run *journalRun) finish(exitCode int) {
+	run.FinishedAt = time.Now()
+	run.DurationSeconds = run.FinishedAt.Sub(run.StartedAt).Seconds()
+	run.ExitCode = exitCode
+}
+
+func appendRunToJournal(run journalRun, program Program) functionResponse {
+	line, err := json.Marshal(run)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to encode run journal entry -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	file, err := os.OpenFile(program.userJournalFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to open run journal -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to write to run journal -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return functionResponse{exitCode: 0}
+}
+
+// Reads all the runs stored in the journal (oldest first)
+func readJournal(program Program) ([]journalRun, functionResponse) {
+	var runs []journalRun
+
+	file, err := os.Open(program.userJournalFile)
+	if os.IsNotExist(err) {
+		return runs, functionResponse{exitCode: 0}
+	} else if err != nil {
+		return runs, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to open run journal -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var run journalRun
+		if err := json.Unmarshal(scanner.Bytes(), &run); err != nil {
+			// Ignore corrupted entries (e.g. a partially written line)
+			continue
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, functionResponse{exitCode: 0}
+}
+
+// Returns the last run of a target and its record in that run
+func getTargetLastRun(runs []journalRun, target Target) (journalRun, journalTargetRecord, bool) {
+	// The journal is ordered from oldest to newest
+	for index := len(runs) - 1; index >= 0; index-- {
+		if runs[index].Crate != target.crate.name {
+			continue
+		}
+
+		for _, record := range runs[index].Targets {
+			if record.Name == target.name {
+				return runs[index], record, true
+			}
+		}
+	}
+
+	return journalRun{}, journalTargetRecord{}, false
+}
+
+//
+//// RUN SUMMARY
+//
+
+func displayTargetOutcome(outcome string) string {
+	switch outcome {
+	case targetOutcomeSuccess:
+		return green.Sprintf(outcome)
+	case targetOutcomeFailed:
+		return red.Sprintf(outcome)
+	case targetOutcomeSkipped, targetOutcomeDisabled:
+		return orange.Sprintf(outcome)
+	default:
+		return outcome
+	}
+}
+
+func showRunSummary(run journalRun, program Program) {
+	showInfoSectionTitle(lightGray.Sprintf("Summary")+lightGray.Sprintf(" (")+salmonPink.Sprintf(run.Crate)+lightGray.Sprintf(")"), program.indentLevel)
+
+	for _, target := range run.Targets {
+		details := fmt.Sprintf("%.1fs", target.DurationSeconds)
+		if target.Status.Skip != "" {
+			details += ", " + target.Status.Skip
+		}
+		if len(target.Status.Stats) > 0 {
+			details += ", " + formatHookStats(target.Status.Stats)
+		}
+
+		showText(fmt.Sprintf("- %s: %s (%s)", green.Sprintf(target.Name), displayTargetOutcome(target.Outcome), details), program.indentLevel+1)
+
+		for _, warning := range target.Status.Warnings {
+			showAttention(fmt.Sprintf("> Warning: %v", warning), program.indentLevel+2)
+		}
+	}
+
+	showText(gray.Sprintf(fmt.Sprintf("Finished in %.1fs. Run recorded in %v", run.DurationSeconds, program.userJournalFile)), program.indentLevel+1)
+}
@@ -0,0 +1,259 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	// External modules
+)
+
+//
+//// METRICS
+//
+
+// After each sync, the state of every target can be written as Prometheus metrics
+// to a '.prom' file in the directory read by the textfile collector of
+// node_exporter, set in the global configuration:
+//
+//	{
+//		"metrics": {
+//			"textfileDir": "/var/lib/node_exporter/textfile_collector"
+//		}
+//	}
+//
+// The metrics are computed from the journal, so that they cover every target (not
+// only the ones of the last run).
+type metricsConfig struct {
+	TextfileDir string `json:"textfileDir"`
+	// Name of the file (default: synctropy.prom), e.g. to use several user data
+	// directories
+	File string `json:"file"`
+}
+
+const metricsDefaultFile = "synctropy.prom"
+
+type targetMetrics struct {
+	crate        string
+	target       string
+	disabled     bool
+	lastRun      float64
+	lastSuccess  float64
+	lastDuration float64
+	lastExitCode int
+	runs         int
+	failures     int
+	hasRun       bool
+	hasSucceeded bool
+}
+
+// Escapes a label value
+func escapeMetricLabel(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(value)
+}
+
+// Returns the metrics of every target of every crate
+func collectTargetMetrics(program Program) ([]targetMetrics, functionResponse) {
+	runs, response := readJournal(program)
+	if response.exitCode != 0 {
+		return nil, response
+	}
+
+	crates, response := getUserCrates(program)
+	if response.exitCode != 0 {
+		return nil, response
+	}
+
+	var metrics []targetMetrics
+	indices := make(map[string]int)
+
+	for _, crate := range crates {
+		crateIsDisabled, _ := isCrateDisabled(crate, program)
+
+		targets, response := getCrateTargets(crate, program)
+		if response.exitCode != 0 {
+			continue
+		}
+
+		for _, target := range targets {
+			targetIsDisabled, _ := isTargetDisabled(target, program)
+
+			indices[crate.name+"/"+target.name] = len(metrics)
+			metrics = append(metrics, targetMetrics{
+				crate:    crate.name,
+				target:   target.name,
+				disabled: crateIsDisabled == true || targetIsDisabled == true,
+			})
+		}
+	}
+
+	// The journal is ordered from oldest to newest
+	for _, run := range runs {
+		if run.Operation != "sync" {
+			continue
+		}
+
+		for _, record := range run.Targets {
+			index, exists := indices[run.Crate+"/"+record.Name]
+			if exists == false {
+				// Removed since then
+				continue
+			}
+
+			if record.Outcome != targetOutcomeSuccess && record.Outcome != targetOutcomeFailed {
+				continue
+			}
+
+			target := &metrics[index]
+			target.hasRun = true
+			target.runs++
+			target.lastRun = float64(run.FinishedAt.UnixNano()) / 1e9
+			target.lastDuration = record.DurationSeconds
+			target.lastExitCode = record.ExitCode
+
+			if record.Outcome == targetOutcomeSuccess {
+				target.hasSucceeded = true
+				target.lastSuccess = target.lastRun
+			} else {
+				target.failures++
+			}
+		}
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].crate != metrics[j].crate {
+			return metrics[i].crate < metrics[j].crate
+		}
+		return metrics[i].target < metrics[j].target
+	})
+
+	return metrics, functionResponse{exitCode: 0}
+}
+
+// Formats the metrics in the Prometheus text format
+func formatTargetMetrics(metrics []targetMetrics) string {
+	var builder strings.Builder
+
+	family := func(name string, metricType string, help string, value func(target targetMetrics) (string, bool)) {
+		fmt.Fprintf(&builder, "# HELP %v %v\n", name, help)
+		fmt.Fprintf(&builder, "# TYPE %v %v\n", name, metricType)
+
+		for _, target := range metrics {
+			if value, exists := value(target); exists == true {
+				fmt.Fprintf(&builder, "%v{crate=\"%v\",target=\"%v\"} %v\n", name, escapeMetricLabel(target.crate), escapeMetricLabel(target.target), value)
+			}
+		}
+	}
+
+	family("synctropy_target_last_run_timestamp_seconds", "gauge", "Time at which the last sync of the target finished.", func(target targetMetrics) (string, bool) {
+		return fmt.Sprintf("%.3f", target.lastRun), target.hasRun
+	})
+	family("synctropy_target_last_success_timestamp_seconds", "gauge", "Time at which the last successful sync of the target finished.", func(target targetMetrics) (string, bool) {
+		return fmt.Sprintf("%.3f", target.lastSuccess), target.hasSucceeded
+	})
+	family("synctropy_target_last_run_duration_seconds", "gauge", "Duration of the last sync of the target.", func(target targetMetrics) (string, bool) {
+		return fmt.Sprintf("%.3f", target.lastDuration), target.hasRun
+	})
+	family("synctropy_target_last_exit_code", "gauge", "Exit code of the last sync of the target.", func(target targetMetrics) (string, bool) {
+		return fmt.Sprint(target.lastExitCode), target.hasRun
+	})
+	family("synctropy_target_runs_total", "counter", "Syncs of the target (skipped ones excluded).", func(target targetMetrics) (string, bool) {
+		return fmt.Sprint(target.runs), true
+	})
+	family("synctropy_target_failures_total", "counter", "Failed syncs of the target.", func(target targetMetrics) (string, bool) {
+		return fmt.Sprint(target.failures), true
+	})
+	family("synctropy_target_disabled", "gauge", "Whether the target (or its crate) is disabled.", func(target targetMetrics) (string, bool) {
+		if target.disabled == true {
+			return "1", true
+		}
+		return "0", true
+	})
+
+	return builder.String()
+}
+
+// Returns the path of the textfile (empty if the metrics are not enabled)
+func getMetricsTextfilePath(program Program) (string, functionResponse) {
+	config, response := readGlobalConfig(program)
+	if response.exitCode != 0 || config.Metrics.TextfileDir == "" {
+		return "", response
+	}
+
+	file := config.Metrics.File
+	if file == "" {
+		file = metricsDefaultFile
+	}
+
+	return filepath.Join(expandConfigPath(config.Metrics.TextfileDir, nil), file), functionResponse{exitCode: 0}
+}
+
+// Writes the metrics to the textfile, if enabled (after each sync)
+func writeMetricsTextfile(program Program) functionResponse {
+	textfilePath, response := getMetricsTextfilePath(program)
+	if response.exitCode != 0 || textfilePath == "" {
+		return response
+	}
+
+	metrics, response := collectTargetMetrics(program)
+	if response.exitCode != 0 {
+		return response
+	}
+
+	// Written through a temporary file, ignored by the collector
+	err := writeFileAtomically(textfilePath, []byte(formatTargetMetrics(metrics)), 0644)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to write metrics -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return functionResponse{exitCode: 0}
+}
+
+// Prints the metrics (or writes them to the textfile)
+func metricsShow(write bool, program Program) functionResponse {
+	if write == true {
+		textfilePath, response := getMetricsTextfilePath(program)
+		if response.exitCode != 0 {
+			return response
+		}
+		if textfilePath == "" {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Metrics are not enabled ('metrics.textfileDir' is not set in %v)", program.userConfigFile),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		response = writeMetricsTextfile(program)
+		if response.exitCode != 0 {
+			return response
+		}
+
+		return functionResponse{
+			exitCode:    0,
+			message:     fmt.Sprintf("Metrics written to %v", textfilePath),
+			logLevel:    "success",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	metrics, response := collectTargetMetrics(program)
+	if response.exitCode != 0 {
+		return response
+	}
+
+	fmt.Print(formatTargetMetrics(metrics))
+
+	return functionResponse{exitCode: 0}
+}
@@ -0,0 +1,206 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"fmt"
+	"strings"
+	// External modules
+)
+
+//
+//// MIRROR ENGINE
+//
+
+// One-way sync: makes the destination a copy of the source. Files are compared by
+// size and modification time (or by checksum), and files missing from the source
+// are only deleted from the destination when the 'delete' option is set. Excluded
+// paths are neither copied nor deleted.
+func runMirrorEngine(src syncFS, dst syncFS, config syncEngineConfig, program Program) (syncStats, error) {
+	var stats syncStats
+
+	// A missing source must not be mistaken for an empty one
+	if _, err := src.Lstat(""); err != nil {
+		return stats, fmt.Errorf("failed to read source -> %v", err.Error())
+	}
+
+	srcTree, excluded, err := scanSyncTree(src, config.Exclude)
+	if err != nil {
+		return stats, fmt.Errorf("failed to scan source -> %v", err.Error())
+	}
+	stats.excluded = excluded
+
+	dstTree, _, err := scanSyncTree(dst, config.Exclude)
+	if err != nil {
+		return stats, fmt.Errorf("failed to scan destination -> %v", err.Error())
+	}
+
+	err = dst.MkdirAll("")
+	if err != nil {
+		return stats, fmt.Errorf("failed to create destination -> %v", err.Error())
+	}
+
+	srcPaths := sortedSyncPaths(srcTree)
+
+	for _, name := range srcPaths {
+		srcEntry := srcTree[name]
+		dstEntry, exists := dstTree[name]
+
+		// Replace entries that changed kind (e.g. a file that became a directory)
+		if exists == true && srcEntry.sameKind(dstEntry) == false {
+			removed, err := removeSyncPath(dst, dstEntry, config.Exclude)
+			for dstName := range dstTree {
+				if dstName == name || strings.HasPrefix(dstName, name+"/") == true {
+					delete(dstTree, dstName)
+				}
+			}
+			if err == nil && removed == false {
+				err = errSyncPathHasExcluded
+			}
+			if err != nil {
+				stats.addError(name, err)
+				continue
+			}
+			exists = false
+		}
+
+		switch {
+		case srcEntry.isDir == true:
+			if exists == false {
+				err := dst.Mkdir(name, 0755)
+				if err != nil {
+					stats.addError(name, err)
+					continue
+				}
+				stats.dirsCreated++
+				showSyncChange("+", name+"/", program)
+			}
+
+		case srcEntry.isSymlink == true:
+			stats.scanned++
+			if exists == true && dstEntry.linkTarget == srcEntry.linkTarget {
+				stats.unchanged++
+				continue
+			}
+			if exists == true {
+				err := dst.Remove(name)
+				if err != nil {
+					stats.addError(name, err)
+					continue
+				}
+			}
+			err := dst.Symlink(srcEntry.linkTarget, name)
+			if err != nil {
+				stats.addError(name, err)
+				continue
+			}
+			if exists == true {
+				stats.updated++
+				showSyncChange("~", name+" -> "+srcEntry.linkTarget, program)
+			} else {
+				stats.created++
+				showSyncChange("+", name+" -> "+srcEntry.linkTarget, program)
+			}
+
+		default:
+			stats.scanned++
+			if exists == true {
+				changed, err := mirrorFileChanged(src, dst, srcEntry, dstEntry, config)
+				if err != nil {
+					stats.addError(name, err)
+					continue
+				}
+				if changed == false {
+					// Keep metadata in sync even if the contents did not change
+					if config.preservePermissions() == true && srcEntry.mode != dstEntry.mode {
+						_ = dst.Chmod(name, srcEntry.mode)
+					}
+					if config.preserveTimes() == true && srcEntry.modTime.Equal(dstEntry.modTime) == false {
+						_ = dst.Chtimes(name, srcEntry.modTime)
+					}
+					stats.unchanged++
+					continue
+				}
+			}
+
+			written, _, err := copySyncFile(src, dst, srcEntry, name, config.preservePermissions(), config.preserveTimes())
+			stats.bytes += written
+			if err != nil {
+				stats.addError(name, err)
+				continue
+			}
+			if exists == true {
+				stats.updated++
+				showSyncChange("~", name, program)
+			} else {
+				stats.created++
+				showSyncChange("+", name, program)
+			}
+		}
+	}
+
+	// Propagate deletions (contents before their directories)
+	if config.Delete == true {
+		dstPaths := sortedSyncPaths(dstTree)
+		for i := len(dstPaths) - 1; i >= 0; i-- {
+			name := dstPaths[i]
+			if _, exists := srcTree[name]; exists == true {
+				continue
+			}
+
+			removed, err := removeSyncPath(dst, dstTree[name], config.Exclude)
+			if err != nil {
+				stats.addError(name, err)
+				continue
+			}
+			if removed == false {
+				// Still contains excluded paths
+				continue
+			}
+			stats.deleted++
+			showSyncChange("-", name, program)
+		}
+	}
+
+	// Apply directory metadata last, since writing their contents changes it
+	for i := len(srcPaths) - 1; i >= 0; i-- {
+		srcEntry := srcTree[srcPaths[i]]
+		if srcEntry.isDir == false {
+			continue
+		}
+
+		if config.preservePermissions() == true {
+			_ = dst.Chmod(srcEntry.path, srcEntry.mode)
+		}
+		if config.preserveTimes() == true {
+			_ = dst.Chtimes(srcEntry.path, srcEntry.modTime)
+		}
+	}
+
+	return stats, nil
+}
+
+func mirrorFileChanged(src syncFS, dst syncFS, srcEntry syncEntry, dstEntry syncEntry, config syncEngineConfig) (bool, error) {
+	if srcEntry.size != dstEntry.size {
+		return true, nil
+	}
+
+	if config.Compare != syncCompareChecksum {
+		return srcEntry.modTime.Equal(dstEntry.modTime) == false, nil
+	}
+
+	srcHash, err := hashSyncFile(src, srcEntry.path)
+	if err != nil {
+		return false, err
+	}
+
+	dstHash, err := hashSyncFile(dst, dstEntry.path)
+	if err != nil {
+		return false, err
+	}
+
+	return srcHash != dstHash, nil
+}
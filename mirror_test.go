@@ -0,0 +1,207 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Files of a root: names ending with a slash are directories
+type mirrorTestTree map[string]string
+
+func writeMirrorTestTree(t *testing.T, root string, tree mirrorTestTree, modTime time.Time) {
+	t.Helper()
+
+	for name, content := range tree {
+		fullPath := filepath.Join(root, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") == true {
+			if err := os.MkdirAll(fullPath, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fullPath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readMirrorTestTree(t *testing.T, root string) mirrorTestTree {
+	t.Helper()
+
+	tree := make(mirrorTestTree)
+	err := filepath.Walk(root, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil || fullPath == root {
+			return err
+		}
+
+		name, _ := filepath.Rel(root, fullPath)
+		name = filepath.ToSlash(name)
+		if info.IsDir() == true {
+			tree[name+"/"] = ""
+			return nil
+		}
+
+		content, err := os.ReadFile(fullPath)
+		tree[name] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tree
+}
+
+func TestMirrorEngine(t *testing.T) {
+	modTime := time.Date(2024, 3, 5, 14, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		src        mirrorTestTree
+		dst        mirrorTestTree
+		dstModTime time.Time
+		delete     bool
+		compare    string
+		want       mirrorTestTree
+		wantErrors int
+	}{
+		{
+			name: "copies new files and directories",
+			src:  mirrorTestTree{"a": "1", "dir/b": "2", "empty/": ""},
+			want: mirrorTestTree{"a": "1", "dir/": "", "dir/b": "2", "empty/": ""},
+		},
+		{
+			name: "keeps extra files without delete",
+			src:  mirrorTestTree{"a": "1"},
+			dst:  mirrorTestTree{"a": "1", "extra": "x", "old/file": "o"},
+			want: mirrorTestTree{"a": "1", "extra": "x", "old/": "", "old/file": "o"},
+		},
+		{
+			name:   "deletes extra files",
+			src:    mirrorTestTree{"a": "1"},
+			dst:    mirrorTestTree{"a": "1", "extra": "x", "old/sub/file": "o"},
+			delete: true,
+			want:   mirrorTestTree{"a": "1"},
+		},
+		{
+			name:   "keeps excluded files and the deleted directories containing them",
+			src:    mirrorTestTree{"a": "1"},
+			dst:    mirrorTestTree{"a": "1", "gone/file": "f", "gone/cache/big": "b", "gone/x.tmp": "t", "other/file": "f"},
+			delete: true,
+			want:   mirrorTestTree{"a": "1", "gone/": "", "gone/cache/": "", "gone/cache/big": "b", "gone/x.tmp": "t"},
+		},
+		{
+			name: "excluded source files are not copied",
+			src:  mirrorTestTree{"a": "1", "x.tmp": "t", "cache/big": "b"},
+			want: mirrorTestTree{"a": "1"},
+		},
+		{
+			name: "file replaced by a directory",
+			src:  mirrorTestTree{"x/y": "new"},
+			dst:  mirrorTestTree{"x": "old"},
+			want: mirrorTestTree{"x/": "", "x/y": "new"},
+		},
+		{
+			name: "directory replaced by a file",
+			src:  mirrorTestTree{"x": "new"},
+			dst:  mirrorTestTree{"x/y": "old", "x/sub/z": "old"},
+			want: mirrorTestTree{"x": "new"},
+		},
+		{
+			name:       "directory with excluded files is not replaced by a file",
+			src:        mirrorTestTree{"x": "new", "a": "1"},
+			dst:        mirrorTestTree{"x/y": "old", "x/cache/big": "b"},
+			want:       mirrorTestTree{"a": "1", "x/": "", "x/cache/": "", "x/cache/big": "b"},
+			wantErrors: 1,
+		},
+		{
+			name:       "same size and time is unchanged when comparing times",
+			src:        mirrorTestTree{"a": "new"},
+			dst:        mirrorTestTree{"a": "old"},
+			dstModTime: modTime,
+			want:       mirrorTestTree{"a": "old"},
+		},
+		{
+			name:       "other time is copied when comparing times",
+			src:        mirrorTestTree{"a": "new"},
+			dst:        mirrorTestTree{"a": "old"},
+			dstModTime: modTime.Add(-time.Hour),
+			want:       mirrorTestTree{"a": "new"},
+		},
+		{
+			name:       "same size and time is copied when comparing checksums",
+			src:        mirrorTestTree{"a": "new"},
+			dst:        mirrorTestTree{"a": "old"},
+			dstModTime: modTime,
+			compare:    syncCompareChecksum,
+			want:       mirrorTestTree{"a": "new"},
+		},
+		{
+			name:       "other time is unchanged when comparing checksums",
+			src:        mirrorTestTree{"a": "same"},
+			dst:        mirrorTestTree{"a": "same"},
+			dstModTime: modTime.Add(-time.Hour),
+			compare:    syncCompareChecksum,
+			want:       mirrorTestTree{"a": "same"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srcRoot, dstRoot := t.TempDir(), t.TempDir()
+			config := syncEngineConfig{
+				Engine:  syncEngineMirror,
+				Compare: test.compare,
+				Delete:  test.delete,
+				Exclude: []string{"cache/", "*.tmp"},
+			}
+
+			dstModTime := test.dstModTime
+			if dstModTime.IsZero() == true {
+				dstModTime = modTime.Add(-24 * time.Hour)
+			}
+			writeMirrorTestTree(t, srcRoot, test.src, modTime)
+			writeMirrorTestTree(t, dstRoot, test.dst, dstModTime)
+
+			stats, err := runMirrorEngine(localSyncFS{root: srcRoot}, localSyncFS{root: dstRoot}, config, Program{})
+			if err != nil {
+				t.Fatalf("runMirrorEngine() failed: %v", err)
+			}
+			if len(stats.errors) != test.wantErrors {
+				t.Errorf("runMirrorEngine() errors = %q, want %v errors", stats.errors, test.wantErrors)
+			}
+
+			if got := readMirrorTestTree(t, dstRoot); reflect.DeepEqual(got, test.want) == false {
+				t.Errorf("destination = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMirrorEngineMissingSource(t *testing.T) {
+	dstRoot := t.TempDir()
+	writeMirrorTestTree(t, dstRoot, mirrorTestTree{"a": "1"}, time.Now())
+
+	src := localSyncFS{root: filepath.Join(t.TempDir(), "missing")}
+	config := syncEngineConfig{Engine: syncEngineMirror, Delete: true}
+
+	if _, err := runMirrorEngine(src, localSyncFS{root: dstRoot}, config, Program{}); err == nil {
+		t.Errorf("runMirrorEngine() with a missing source succeeded, want an error")
+	}
+
+	want := mirrorTestTree{"a": "1"}
+	if got := readMirrorTestTree(t, dstRoot); reflect.DeepEqual(got, want) == false {
+		t.Errorf("destination = %v, want %v", got, want)
+	}
+}
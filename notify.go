@@ -0,0 +1,339 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+	// External modules
+)
+
+//
+//// NOTIFICATIONS
+//
+
+// Notifications are sent once a sync finished, to the sinks of the global
+// configuration and of the crate configuration:
+//
+//	{
+//		"notifications": [
+//			{
+//				"on": "failure",
+//				"webhook": "https://example.com/hooks/synctropy",
+//				"headers": {"Authorization": "Bearer ${SYNCTROPY_WEBHOOK_TOKEN}"}
+//			},
+//			{
+//				"on": "always",
+//				"command": "mail -s 'synctropy' me@example.com"
+//			}
+//		]
+//	}
+//
+// A webhook receives the run as JSON (see notificationPayload) in a POST request,
+// retried on network errors and server errors. A command runs with the default
+// shell and receives a summary of the run on its standard input (and the run as
+// JSON in SYNCTROPY_NOTIFICATION). 'on' is 'failure' (default), 'success' or
+// 'always'; the URL, the headers and the command can use environment variables
+// (including the ones of the crate).
+type notificationConfig struct {
+	On      string            `json:"on"`
+	Webhook string            `json:"webhook"`
+	Headers map[string]string `json:"headers"`
+	Retries *int              `json:"retries"`
+	Timeout string            `json:"timeout"`
+	Command string            `json:"command"`
+}
+
+const (
+	notificationDefaultRetries = 3
+	notificationDefaultTimeout = 30 * time.Second
+	notificationEnvVar         = "SYNCTROPY_NOTIFICATION"
+)
+
+// Outcomes of a run, as used by 'on'
+const (
+	notifyOnFailure = "failure"
+	notifyOnSuccess = "success"
+	notifyOnAlways  = "always"
+)
+
+func (config notificationConfig) validate() error {
+	switch {
+	case config.Webhook == "" && config.Command == "":
+		return fmt.Errorf("'webhook' or 'command' must be set")
+	case config.Webhook != "" && config.Command != "":
+		return fmt.Errorf("'webhook' and 'command' cannot be used together")
+	}
+
+	switch config.On {
+	case "", notifyOnFailure, notifyOnSuccess, notifyOnAlways:
+	default:
+		return fmt.Errorf("invalid value '%v' for 'on' (expected 'failure', 'success' or 'always')", config.On)
+	}
+
+	if config.Retries != nil && *config.Retries < 0 {
+		return fmt.Errorf("'retries' cannot be negative")
+	}
+
+	if config.Timeout != "" {
+		if _, err := time.ParseDuration(config.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout '%v' -> %v", config.Timeout, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (config notificationConfig) String() string {
+	if config.Webhook != "" {
+		return "webhook " + config.Webhook
+	}
+
+	return "command '" + config.Command + "'"
+}
+
+func (config notificationConfig) matches(outcome string) bool {
+	switch config.On {
+	case notifyOnAlways:
+		return true
+	case notifyOnSuccess:
+		return outcome == notifyOnSuccess
+	default:
+		return outcome == notifyOnFailure
+	}
+}
+
+type notificationTarget struct {
+	Name            string  `json:"name"`
+	Outcome         string  `json:"outcome"`
+	ExitCode        int     `json:"exit_code"`
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+type notificationPayload struct {
+	Program         string               `json:"program"`
+	Host            string               `json:"host"`
+	Operation       string               `json:"operation"`
+	Crate           string               `json:"crate"`
+	Outcome         string               `json:"outcome"`
+	ExitCode        int                  `json:"exit_code"`
+	StartedAt       time.Time            `json:"started_at"`
+	FinishedAt      time.Time            `json:"finished_at"`
+	DurationSeconds float64              `json:"duration_seconds"`
+	Targets         []notificationTarget `json:"targets"`
+	Journal         string               `json:"journal"`
+}
+
+func newNotificationPayload(run journalRun, program Program) notificationPayload {
+	host, _ := os.Hostname()
+
+	outcome := notifyOnSuccess
+	if run.ExitCode != 0 {
+		outcome = notifyOnFailure
+	}
+
+	payload := notificationPayload{
+		Program:         program.name,
+		Host:            host,
+		Operation:       run.Operation,
+		Crate:           run.Crate,
+		Outcome:         outcome,
+		ExitCode:        run.ExitCode,
+		StartedAt:       run.StartedAt,
+		FinishedAt:      run.FinishedAt,
+		DurationSeconds: run.DurationSeconds,
+		Targets:         []notificationTarget{},
+		Journal:         program.userJournalFile,
+	}
+
+	for _, target := range run.Targets {
+		payload.Targets = append(payload.Targets, notificationTarget{
+			Name:            target.Name,
+			Outcome:         target.Outcome,
+			ExitCode:        target.ExitCode,
+			DurationSeconds: target.DurationSeconds,
+		})
+	}
+
+	return payload
+}
+
+// Plain text summary of the run, given to the notify commands
+func (payload notificationPayload) summary() string {
+	var builder strings.Builder
+
+	status := "succeeded"
+	if payload.Outcome == notifyOnFailure {
+		status = fmt.Sprintf("failed (exit code %v)", payload.ExitCode)
+	}
+
+	fmt.Fprintf(&builder, "%v: %v of crate '%v' %v on %v\n", payload.Program, payload.Operation, payload.Crate, status, payload.Host)
+	fmt.Fprintf(&builder, "Started at %v, finished in %.1fs\n", payload.StartedAt.Format("2006-01-02 15:04:05"), payload.DurationSeconds)
+	for _, target := range payload.Targets {
+		details := fmt.Sprintf("%.1fs", target.DurationSeconds)
+		if target.Outcome == targetOutcomeFailed {
+			details += fmt.Sprintf(", exit code %v", target.ExitCode)
+		}
+		fmt.Fprintf(&builder, "- %v: %v (%v)\n", target.Name, target.Outcome, details)
+	}
+	fmt.Fprintf(&builder, "Journal: %v\n", payload.Journal)
+
+	return builder.String()
+}
+
+func getNotificationTimeout(config notificationConfig) time.Duration {
+	timeout, err := time.ParseDuration(config.Timeout)
+	if err != nil || timeout <= 0 {
+		return notificationDefaultTimeout
+	}
+
+	return timeout
+}
+
+// Posts the run to the webhook, retrying (with an increasing delay) on network
+// errors and server errors. Returns the number of attempts.
+func sendWebhookNotification(config notificationConfig, content []byte, env map[string]string) (int, error) {
+	retries := notificationDefaultRetries
+	if config.Retries != nil {
+		retries = *config.Retries
+	}
+
+	client := &http.Client{Timeout: getNotificationTimeout(config)}
+	url := expandConfigEnv(config.Webhook, env)
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var request *http.Request
+		request, err = http.NewRequest("POST", url, bytes.NewReader(content))
+		if err != nil {
+			return attempt, err
+		}
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("User-Agent", "synctropy")
+		for key, value := range config.Headers {
+			request.Header.Set(key, expandConfigEnv(value, env))
+		}
+
+		var response *http.Response
+		response, err = client.Do(request)
+		if err == nil {
+			response.Body.Close()
+
+			switch {
+			case response.StatusCode < 300:
+				return attempt, nil
+			case response.StatusCode < 500 && response.StatusCode != http.StatusTooManyRequests:
+				// Retrying would not help
+				return attempt, fmt.Errorf("the webhook returned %v", response.Status)
+			}
+			err = fmt.Errorf("the webhook returned %v", response.Status)
+		}
+
+		if attempt > retries {
+			return attempt, err
+		}
+		time.Sleep(time.Duration(1<<uint(attempt-1)) * time.Second)
+	}
+}
+
+// Runs the command with the default shell, with the summary on its standard input
+func runNotificationCommand(config notificationConfig, payload notificationPayload, content []byte, env map[string]string, program Program) error {
+	ctx, cancel := context.WithTimeout(context.Background(), getNotificationTimeout(config))
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, program.defaultShell, "-c", config.Command)
+	cmd.Stdin = strings.NewReader(payload.summary())
+	cmd.Env = os.Environ()
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	cmd.Env = append(cmd.Env,
+		notificationEnvVar+"="+string(content),
+		"SYNCTROPY_NOTIFICATION_OUTCOME="+payload.Outcome,
+		"SYNCTROPY_NOTIFICATION_EXIT_CODE="+strconv.Itoa(payload.ExitCode),
+	)
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %v", getNotificationTimeout(config))
+	} else if err != nil {
+		message := strings.TrimSpace(string(output))
+		if message != "" {
+			return fmt.Errorf("%v: %v", err.Error(), message)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Sends the notifications of a finished run (global ones first, then the ones of
+// the crate). Failures are reported but do not change the result of the run.
+func notifyRun(run journalRun, crate Crate, program Program) {
+	var sinks []notificationConfig
+
+	global, response := readGlobalConfig(program)
+	if response.exitCode != 0 {
+		response.indentLevel = program.indentLevel + 1
+		handleFunctionResponse(response, false)
+	}
+	sinks = append(sinks, global.Notifications...)
+
+	config, response := readCrateConfig(crate, program)
+	if response.exitCode != 0 {
+		response.indentLevel = program.indentLevel + 1
+		handleFunctionResponse(response, false)
+	}
+	sinks = append(sinks, config.Notifications...)
+
+	payload := newNotificationPayload(run, program)
+
+	var selectedSinks []notificationConfig
+	for _, sink := range sinks {
+		if sink.validate() != nil || sink.matches(payload.Outcome) == true {
+			selectedSinks = append(selectedSinks, sink)
+		}
+	}
+	if len(selectedSinks) == 0 {
+		return
+	}
+
+	content, _ := json.Marshal(payload)
+
+	space()
+	showInfoSectionTitle(displayCrateTag("Sending notifications", crate), program.indentLevel)
+
+	for _, sink := range selectedSinks {
+		if err := sink.validate(); err != nil {
+			showError(fmt.Sprintf("> Invalid notification -> %v", err.Error()), program.indentLevel+1)
+			continue
+		}
+
+		if sink.Webhook != "" {
+			attempts, err := sendWebhookNotification(sink, content, crate.environment)
+			if err != nil {
+				showError(fmt.Sprintf("> Failed to notify %v after %v attempt(s) -> %v", sink, attempts, err.Error()), program.indentLevel+1)
+				continue
+			}
+		} else {
+			err := runNotificationCommand(sink, payload, content, crate.environment, program)
+			if err != nil {
+				showError(fmt.Sprintf("> Failed to notify %v -> %v", sink, err.Error()), program.indentLevel+1)
+				continue
+			}
+		}
+
+		showText(fmt.Sprintf("- %v %v", sink, gray.Sprintf("(sent)")), program.indentLevel+1)
+	}
+}
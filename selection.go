@@ -0,0 +1,459 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	// External modules
+)
+
+//
+//// SELECTION
+//
+
+// Besides their names, crates and targets can be selected by tag ('--tag') and by
+// group ('--group/-g'). Tags are set in the configuration of crates and targets
+// (targets also have the tags of their crate):
+//
+//	{
+//		"tags": ["work", "laptop-only"]
+//	}
+//
+// A tag prefixed with '!' excludes the crates and targets having it; every tag
+// given must match. Groups are named lists of crates ('crate', for all its
+// targets) and targets ('crate/target'), set in the global configuration (crate
+// commands only accept groups of crates):
+//
+//	{
+//		"groups": {
+//			"dotfiles": ["home/nvim", "home/zsh", "work/git"],
+//			"backups": ["photos", "documents"]
+//		}
+//	}
+//
+// Names can also be patterns: names given to '--crate/-c' and '--target/-t' can
+// be glob patterns ('nvim*'), '--match' selects the names matching a regular
+// expression ('^dot-') and '--target-path/-T' selects targets across crates with
+// 'crate/target' glob patterns ('home/*', 'home' being the same as 'home/*').
+// What each pattern matched is shown before running.
+
+// Targets selected in a crate
+type targetSelection struct {
+	crate   Crate
+	targets []Target
+}
+
+// Verifies the tags given in the command line
+func validateTagSelectors(tags []string, program Program) functionResponse {
+	for _, tag := range tags {
+		if strings.TrimPrefix(tag, "!") == "" {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Invalid tag '%v'", tag),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+	}
+
+	return functionResponse{exitCode: 0}
+}
+
+// Returns whether the tags of a crate or a target match the tags given in the
+// command line
+func matchesTagSelectors(itemTags []string, tags []string) bool {
+	hasTag := make(map[string]bool, len(itemTags))
+	for _, tag := range itemTags {
+		hasTag[tag] = true
+	}
+
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, "!") == true {
+			if hasTag[strings.TrimPrefix(tag, "!")] == true {
+				return false
+			}
+		} else if hasTag[tag] == false {
+			return false
+		}
+	}
+
+	return true
+}
+
+func getCrateTags(crate Crate, program Program) ([]string, functionResponse) {
+	config, response := readCrateConfig(crate, program)
+
+	return config.Tags, response
+}
+
+// Returns the tags of a target, including the ones of its crate
+func getTargetTags(target Target, program Program) ([]string, functionResponse) {
+	crateTags, response := getCrateTags(target.crate, program)
+	if response.exitCode != 0 {
+		return nil, response
+	}
+
+	config, response := readTargetConfig(target, program)
+
+	return append(crateTags, config.Tags...), response
+}
+
+func filterCratesByTags(crates []Crate, tags []string, program Program) ([]Crate, functionResponse) {
+	var filteredCrates []Crate
+	for _, crate := range crates {
+		crateTags, response := getCrateTags(crate, program)
+		if response.exitCode != 0 {
+			return nil, response
+		}
+
+		if matchesTagSelectors(crateTags, tags) == true {
+			filteredCrates = append(filteredCrates, crate)
+		}
+	}
+
+	return filteredCrates, functionResponse{exitCode: 0}
+}
+
+func filterTargetsByTags(targets []Target, tags []string, program Program) ([]Target, functionResponse) {
+	var filteredTargets []Target
+	for _, target := range targets {
+		targetTags, response := getTargetTags(target, program)
+		if response.exitCode != 0 {
+			return nil, response
+		}
+
+		if matchesTagSelectors(targetTags, tags) == true {
+			filteredTargets = append(filteredTargets, target)
+		}
+	}
+
+	return filteredTargets, functionResponse{exitCode: 0}
+}
+
+// Returns the targets of the groups (in their order, without duplicates)
+func getGroupsTargets(groups []string, program Program) ([]Target, functionResponse) {
+	config, response := readGlobalConfig(program)
+	if response.exitCode != 0 {
+		return nil, response
+	}
+
+	var targets []Target
+	selected := make(map[string]bool)
+
+	for _, group := range groups {
+		entries, exists := config.Groups[group]
+		if exists == false {
+			return nil, functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Group '%v' is not defined in %v", group, program.userConfigFile),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		for _, entry := range entries {
+			entryTargets, err := getGroupEntryTargets(entry, program)
+			if err != nil {
+				return nil, functionResponse{
+					exitCode:    1,
+					message:     fmt.Sprintf("Invalid entry '%v' in group '%v' -> %v", entry, group, err.Error()),
+					logLevel:    "error",
+					indentLevel: program.indentLevel,
+				}
+			}
+
+			for _, target := range entryTargets {
+				key := target.crate.name + "/" + target.name
+				if selected[key] == false {
+					selected[key] = true
+					targets = append(targets, target)
+				}
+			}
+		}
+	}
+
+	return targets, functionResponse{exitCode: 0}
+}
+
+// Returns the targets of a group entry: a target ('crate/target') or all the
+// targets of a crate ('crate')
+func getGroupEntryTargets(entry string, program Program) ([]Target, error) {
+	crateName, targetName, isTarget := strings.Cut(entry, "/")
+
+	crate := generateCrateObj(crateName, program)
+	if crateName == "" || verifyCrateDirectory(crate, program).exitCode != 0 {
+		return nil, fmt.Errorf("crate '%v' not found", crateName)
+	}
+
+	if isTarget == false {
+		targets, response := getCrateTargets(crate, program)
+		if response.exitCode != 0 {
+			return nil, fmt.Errorf("%v", response.message)
+		}
+
+		return targets, nil
+	}
+
+	target := generateTargetObj(crate.name, targetName, program)
+	if targetName == "" || verifyTargetDirectory(target, program).exitCode != 0 {
+		return nil, fmt.Errorf("target '%v' not found in crate '%v'", targetName, crateName)
+	}
+
+	return []Target{target}, nil
+}
+
+// Returns the crates of the groups. Their entries must be crates: a 'crate/target'
+// entry would select the whole crate.
+func getGroupsCrates(groups []string, program Program) ([]Crate, functionResponse) {
+	config, response := readGlobalConfig(program)
+	if response.exitCode != 0 {
+		return nil, response
+	}
+
+	var crates []Crate
+	selected := make(map[string]bool)
+
+	for _, group := range groups {
+		entries, exists := config.Groups[group]
+		if exists == false {
+			return nil, functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Group '%v' is not defined in %v", group, program.userConfigFile),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		for _, entry := range entries {
+			crateName, _, isTarget := strings.Cut(entry, "/")
+			if isTarget == true {
+				return nil, functionResponse{
+					exitCode:    1,
+					message:     fmt.Sprintf("Invalid entry '%v' in group '%v' -> crate commands only accept crate entries", entry, group),
+					logLevel:    "error",
+					indentLevel: program.indentLevel,
+				}
+			}
+
+			crate := generateCrateObj(crateName, program)
+			if crateName == "" || verifyCrateDirectory(crate, program).exitCode != 0 {
+				return nil, functionResponse{
+					exitCode:    1,
+					message:     fmt.Sprintf("Invalid entry '%v' in group '%v' -> crate '%v' not found", entry, group, crateName),
+					logLevel:    "error",
+					indentLevel: program.indentLevel,
+				}
+			}
+
+			if selected[crate.name] == false {
+				selected[crate.name] = true
+				crates = append(crates, crate)
+			}
+		}
+	}
+
+	return crates, functionResponse{exitCode: 0}
+}
+
+// Verifies that the entries of the groups exist (see 'doctor')
+func checkGroups(groups map[string][]string, program Program) []string {
+	var problems []string
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, entry := range groups[name] {
+			if _, err := getGroupEntryTargets(entry, program); err != nil {
+				problems = append(problems, fmt.Sprintf("Invalid entry '%v' in group '%v' -> %v", entry, name, err.Error()))
+			}
+		}
+	}
+
+	return problems
+}
+
+// Groups the targets by crate (in the order of their first target)
+func groupTargetsByCrate(targets []Target) []targetSelection {
+	var selections []targetSelection
+	indices := make(map[string]int)
+
+	for _, target := range targets {
+		index, exists := indices[target.crate.name]
+		if exists == false {
+			index = len(selections)
+			indices[target.crate.name] = index
+			selections = append(selections, targetSelection{crate: target.crate})
+		}
+		selections[index].targets = append(selections[index].targets, target)
+	}
+
+	return selections
+}
+
+// Names matched by a pattern given in the command line
+type patternMatch struct {
+	flag    string
+	pattern string
+	names   []string
+}
+
+// Returns whether a name given in the command line is a glob pattern
+func isNamePattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// Verifies the glob patterns given in the command line
+func validateNamePatterns(patterns []string, program Program) functionResponse {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Invalid pattern '%v' -> %v", pattern, err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+	}
+
+	return functionResponse{exitCode: 0}
+}
+
+// Compiles the regular expressions given to '--match'
+func compileMatchSelectors(matches []string, program Program) ([]*regexp.Regexp, functionResponse) {
+	expressions := make([]*regexp.Regexp, len(matches))
+	for i, match := range matches {
+		expression, err := regexp.Compile(match)
+		if err != nil {
+			return nil, functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Invalid pattern '%v' -> %v", match, err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+		expressions[i] = expression
+	}
+
+	return expressions, functionResponse{exitCode: 0}
+}
+
+// Returns a function telling whether a name matches a glob pattern (validated
+// beforehand)
+func globMatcher(pattern string) func(name string) bool {
+	return func(name string) bool {
+		matched, _ := path.Match(pattern, name)
+		return matched
+	}
+}
+
+// Returns the targets matching a 'crate/target' glob pattern (a crate pattern
+// alone matching all its targets)
+func matchTargetPath(targets []Target, targetPath string) []Target {
+	cratePattern, targetPattern, isTarget := strings.Cut(targetPath, "/")
+	if isTarget == false {
+		targetPattern = "*"
+	}
+
+	var matchedTargets []Target
+	for _, target := range targets {
+		if globMatcher(cratePattern)(target.crate.name) == true && globMatcher(targetPattern)(target.name) == true {
+			matchedTargets = append(matchedTargets, target)
+		}
+	}
+
+	return matchedTargets
+}
+
+func matchTargetNames(targets []Target, matches func(name string) bool) []Target {
+	var matchedTargets []Target
+	for _, target := range targets {
+		if matches(target.name) == true {
+			matchedTargets = append(matchedTargets, target)
+		}
+	}
+
+	return matchedTargets
+}
+
+func matchCrateNames(crates []Crate, matches func(name string) bool) []Crate {
+	var matchedCrates []Crate
+	for _, crate := range crates {
+		if matches(crate.name) == true {
+			matchedCrates = append(matchedCrates, crate)
+		}
+	}
+
+	return matchedCrates
+}
+
+// Returns every target of every crate
+func getAllTargets(program Program) []Target {
+	crates, response := getUserCrates(program)
+	handleFunctionResponse(response, true)
+
+	var targets []Target
+	for _, crate := range crates {
+		// Crates without targets are skipped, but not the ones that cannot be read
+		crateTargets, response := getCrateTargets(crate, program)
+		if response.logLevel == "error" {
+			handleFunctionResponse(response, true)
+		}
+
+		targets = append(targets, crateTargets...)
+	}
+
+	return targets
+}
+
+// Returns the targets without duplicates (in the order of their first occurrence)
+func uniqueTargets(targets []Target) []Target {
+	var unique []Target
+	selected := make(map[string]bool)
+
+	for _, target := range targets {
+		key := target.crate.name + "/" + target.name
+		if selected[key] == false {
+			selected[key] = true
+			unique = append(unique, target)
+		}
+	}
+
+	return unique
+}
+
+func uniqueCrates(crates []Crate) []Crate {
+	var unique []Crate
+	selected := make(map[string]bool)
+
+	for _, crate := range crates {
+		if selected[crate.name] == false {
+			selected[crate.name] = true
+			unique = append(unique, crate)
+		}
+	}
+
+	return unique
+}
+
+// Shows what each pattern matched, before running
+func showPatternMatches(matches []patternMatch, program Program) {
+	if len(matches) == 0 {
+		return
+	}
+
+	showInfoSectionTitle("Matching patterns", program.indentLevel)
+	for _, match := range matches {
+		showText(fmt.Sprintf("- %v ", match.flag)+orange.Sprintf("'%v'", match.pattern)+fmt.Sprintf(": %v", strings.Join(match.names, ", ")), program.indentLevel+1)
+	}
+}
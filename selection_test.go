@@ -0,0 +1,399 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Creates crates and their targets ('crate/target', or 'crate' for a crate without
+// targets) in a user data directory, with the given configuration files
+func newSelectionTestProgram(t *testing.T, entries []string, configs map[string]string) Program {
+	t.Helper()
+
+	program := Program{userCratesDir: t.TempDir()}
+	for _, entry := range entries {
+		crate, target, isTarget := strings.Cut(entry, "/")
+		dir := filepath.Join(program.userCratesDir, crate, "targets")
+		if isTarget == true {
+			dir = filepath.Join(dir, target)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for entry, content := range configs {
+		crate, target, isTarget := strings.Cut(entry, "/")
+		configPath := filepath.Join(program.userCratesDir, crate, "config.json")
+		if isTarget == true {
+			configPath = filepath.Join(program.userCratesDir, crate, "targets", target, "config.json")
+		}
+		if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return program
+}
+
+func selectionTestNames(targets []Target) []string {
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.crate.name+"/"+target.name)
+	}
+
+	return names
+}
+
+func TestMatchesTagSelectors(t *testing.T) {
+	tests := []struct {
+		name     string
+		itemTags []string
+		tags     []string
+		want     bool
+	}{
+		{"no selectors", []string{"work"}, nil, true},
+		{"tag present", []string{"work", "laptop"}, []string{"work"}, true},
+		{"tag missing", []string{"home"}, []string{"work"}, false},
+		{"no tags", nil, []string{"work"}, false},
+		{"exclusion only, tag absent", []string{"work"}, []string{"!laptop"}, true},
+		{"exclusion only, tag present", []string{"work", "laptop"}, []string{"!laptop"}, false},
+		{"exclusion only, no tags", nil, []string{"!laptop"}, true},
+		{"all tags present", []string{"work", "laptop", "daily"}, []string{"work", "daily"}, true},
+		{"one of several tags missing", []string{"work", "laptop"}, []string{"work", "daily"}, false},
+		{"tag and exclusion", []string{"work"}, []string{"work", "!laptop"}, true},
+		{"tag and excluded tag", []string{"work", "laptop"}, []string{"work", "!laptop"}, false},
+		{"tags are case sensitive", []string{"Work"}, []string{"work"}, false},
+	}
+
+	for _, test := range tests {
+		if got := matchesTagSelectors(test.itemTags, test.tags); got != test.want {
+			t.Errorf("%v: matchesTagSelectors(%q, %q) = %v, want %v", test.name, test.itemTags, test.tags, got, test.want)
+		}
+	}
+}
+
+func TestFilterTargetsByTags(t *testing.T) {
+	program := newSelectionTestProgram(t,
+		[]string{"home/nvim", "home/zsh", "work/git", "work/notes"},
+		map[string]string{
+			"work":       `{"tags": ["work"]}`,
+			"home/nvim":  `{"tags": ["laptop"]}`,
+			"work/notes": `{"tags": ["laptop"]}`,
+		})
+
+	targets := []Target{
+		generateTargetObj("home", "nvim", program),
+		generateTargetObj("home", "zsh", program),
+		generateTargetObj("work", "git", program),
+		generateTargetObj("work", "notes", program),
+	}
+
+	tests := []struct {
+		tags []string
+		want []string
+	}{
+		// Targets have the tags of their crate
+		{[]string{"work"}, []string{"work/git", "work/notes"}},
+		{[]string{"laptop"}, []string{"home/nvim", "work/notes"}},
+		{[]string{"work", "laptop"}, []string{"work/notes"}},
+		{[]string{"!work"}, []string{"home/nvim", "home/zsh"}},
+		{[]string{"!laptop", "!work"}, []string{"home/zsh"}},
+		{[]string{"daily"}, nil},
+	}
+
+	for _, test := range tests {
+		filtered, response := filterTargetsByTags(targets, test.tags, program)
+		if response.exitCode != 0 {
+			t.Errorf("filterTargetsByTags(%q) failed: %v", test.tags, response.message)
+			continue
+		}
+		if got := selectionTestNames(filtered); reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("filterTargetsByTags(%q) = %q, want %q", test.tags, got, test.want)
+		}
+	}
+}
+
+func TestFilterByTagsInvalidConfig(t *testing.T) {
+	program := newSelectionTestProgram(t,
+		[]string{"home/nvim", "work/git"},
+		map[string]string{
+			"home":      `{"tags": ["home"]}`,
+			"work":      `{"tags": [`,
+			"home/nvim": `{"tags": "laptop"}`,
+		})
+
+	crates := []Crate{generateCrateObj("home", program), generateCrateObj("work", program)}
+	if _, response := filterCratesByTags(crates, []string{"home"}, program); response.exitCode == 0 {
+		t.Errorf("filterCratesByTags() with an invalid crate configuration succeeded")
+	}
+
+	for _, target := range []Target{generateTargetObj("home", "nvim", program), generateTargetObj("work", "git", program)} {
+		if _, response := filterTargetsByTags([]Target{target}, []string{"home"}, program); response.exitCode == 0 {
+			t.Errorf("filterTargetsByTags() of %v/%v with an invalid configuration succeeded", target.crate.name, target.name)
+		}
+	}
+}
+
+func TestGetGroupEntryTargets(t *testing.T) {
+	program := newSelectionTestProgram(t, []string{"home/nvim", "home/zsh", "work/git", "empty"}, nil)
+
+	tests := []struct {
+		entry   string
+		want    []string
+		wantErr bool
+	}{
+		{"home", []string{"home/nvim", "home/zsh"}, false},
+		{"home/zsh", []string{"home/zsh"}, false},
+		{"work/git", []string{"work/git"}, false},
+		{"home/missing", nil, true},
+		{"home/", nil, true},
+		{"missing", nil, true},
+		{"missing/git", nil, true},
+		{"/git", nil, true},
+		{"", nil, true},
+		{"empty", nil, true},
+	}
+
+	for _, test := range tests {
+		targets, err := getGroupEntryTargets(test.entry, program)
+		if (err != nil) != test.wantErr {
+			t.Errorf("getGroupEntryTargets(%q) error = %v, want an error: %v", test.entry, err, test.wantErr)
+			continue
+		}
+		if got := selectionTestNames(targets); reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("getGroupEntryTargets(%q) = %q, want %q", test.entry, got, test.want)
+		}
+	}
+}
+
+func TestGlobMatcher(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"nvim", "nvim", true},
+		{"nvim", "nvim2", false},
+		{"n*", "nvim", true},
+		{"n*", "zsh", false},
+		{"*", "", true},
+		{"z?h", "zsh", true},
+		{"z?h", "zh", false},
+		{"[a-n]*", "git", true},
+		{"[^a-n]*", "git", false},
+		{"*.d", "conf.d", true},
+	}
+
+	for _, test := range tests {
+		if got := globMatcher(test.pattern)(test.name); got != test.want {
+			t.Errorf("globMatcher(%q)(%q) = %v, want %v", test.pattern, test.name, got, test.want)
+		}
+	}
+}
+
+func TestMatchTargetPath(t *testing.T) {
+	program := Program{}
+	targets := []Target{
+		generateTargetObj("home", "nvim", program),
+		generateTargetObj("home", "zsh", program),
+		generateTargetObj("homework", "notes", program),
+		generateTargetObj("work", "git", program),
+		generateTargetObj("work", "notes", program),
+	}
+
+	tests := []struct {
+		targetPath string
+		want       []string
+	}{
+		{"home/nvim", []string{"home/nvim"}},
+		{"home/*", []string{"home/nvim", "home/zsh"}},
+		// A crate pattern alone is the same as 'crate/*'
+		{"home", []string{"home/nvim", "home/zsh"}},
+		{"h*", []string{"home/nvim", "home/zsh", "homework/notes"}},
+		{"*/notes", []string{"homework/notes", "work/notes"}},
+		{"*/n*", []string{"home/nvim", "homework/notes", "work/notes"}},
+		{"*work/[g-n]*", []string{"homework/notes", "work/git", "work/notes"}},
+		{"*", []string{"home/nvim", "home/zsh", "homework/notes", "work/git", "work/notes"}},
+		{"home/missing", nil},
+		{"missing", nil},
+		// Patterns do not match across the slash
+		{"home*", []string{"home/nvim", "home/zsh", "homework/notes"}},
+		{"*/*/*", nil},
+	}
+
+	for _, test := range tests {
+		if got := selectionTestNames(matchTargetPath(targets, test.targetPath)); reflect.DeepEqual(got, test.want) == false {
+			t.Errorf("matchTargetPath(%q) = %q, want %q", test.targetPath, got, test.want)
+		}
+	}
+}
+
+func TestValidateNamePatterns(t *testing.T) {
+	valid := []string{"nvim", "n*", "home/*", "[a-n]*", `z\?h`, "*/*"}
+	if response := validateNamePatterns(valid, Program{}); response.exitCode != 0 {
+		t.Errorf("validateNamePatterns(%q) failed: %v", valid, response.message)
+	}
+
+	for _, pattern := range []string{"[", "n[a-", "home/[", `trailing\`, "[]"} {
+		// An invalid pattern matches nothing, so it must be rejected beforehand
+		if response := validateNamePatterns([]string{"nvim", pattern}, Program{}); response.exitCode == 0 {
+			t.Errorf("validateNamePatterns(%q) succeeded, want an error", pattern)
+		}
+	}
+}
+
+func TestCompileMatchSelectors(t *testing.T) {
+	expressions, response := compileMatchSelectors([]string{"^dot-", "vim$", "(?i)^ZSH"}, Program{})
+	if response.exitCode != 0 {
+		t.Fatalf("compileMatchSelectors() failed: %v", response.message)
+	}
+
+	tests := []struct {
+		name string
+		want []bool
+	}{
+		{"dot-files", []bool{true, false, false}},
+		{"nvim", []bool{false, true, false}},
+		{"zsh", []bool{false, false, true}},
+		{"my-dot-vim", []bool{false, true, false}},
+	}
+
+	for _, test := range tests {
+		for index, expression := range expressions {
+			if got := expression.MatchString(test.name); got != test.want[index] {
+				t.Errorf("expression %q matches %q = %v, want %v", expression, test.name, got, test.want[index])
+			}
+		}
+	}
+
+	for _, match := range []string{"(", "[a-", "*vim", "a{2,1}"} {
+		if _, response := compileMatchSelectors([]string{"ok", match}, Program{}); response.exitCode == 0 {
+			t.Errorf("compileMatchSelectors(%q) succeeded, want an error", match)
+		}
+	}
+}
+
+func TestGetSelectedTargetsFromCLI(t *testing.T) {
+	program := newSelectionTestProgram(t, []string{"home/nvim", "home/zsh", "home/vimrc", "work/git", "work/notes"}, nil)
+
+	tests := []struct {
+		name        string
+		crateName   string
+		targetNames []string
+		targetPaths []string
+		matches     []string
+		want        [][]string
+		wantErr     bool
+	}{
+		{
+			name:        "target names",
+			crateName:   "home",
+			targetNames: []string{"zsh", "nvim"},
+			want:        [][]string{{"home/zsh", "home/nvim"}},
+		},
+		{
+			name:        "target glob",
+			crateName:   "home",
+			targetNames: []string{"*vim*"},
+			want:        [][]string{{"home/nvim", "home/vimrc"}},
+		},
+		{
+			name:      "match",
+			crateName: "home",
+			matches:   []string{"^n"},
+			want:      [][]string{{"home/nvim"}},
+		},
+		{
+			name:        "match combined with target names",
+			crateName:   "home",
+			targetNames: []string{"zsh"},
+			matches:     []string{"^n"},
+			want:        [][]string{{"home/zsh", "home/nvim"}},
+		},
+		{
+			name:        "match combined with a target glob selects each target once",
+			crateName:   "home",
+			targetNames: []string{"n*"},
+			matches:     []string{"vim"},
+			want:        [][]string{{"home/nvim", "home/vimrc"}},
+		},
+		{
+			name:      "match only selects in the crate",
+			crateName: "work",
+			matches:   []string{"^n"},
+			want:      [][]string{{"work/notes"}},
+		},
+		{
+			name:    "match across crates",
+			matches: []string{"^n"},
+			want:    [][]string{{"home/nvim"}, {"work/notes"}},
+		},
+		{
+			name:        "target paths",
+			targetPaths: []string{"work", "h*/z*"},
+			want:        [][]string{{"work/git", "work/notes"}, {"home/zsh"}},
+		},
+		{
+			name:        "target paths combined with match",
+			targetPaths: []string{"work/*"},
+			matches:     []string{"^n"},
+			want:        [][]string{{"work/git", "work/notes"}, {"home/nvim"}},
+		},
+		{
+			name:        "target glob matching nothing",
+			crateName:   "home",
+			targetNames: []string{"x*"},
+			wantErr:     true,
+		},
+		{
+			name:      "match matching nothing",
+			crateName: "home",
+			matches:   []string{"^x"},
+			wantErr:   true,
+		},
+		{
+			name:        "invalid target glob",
+			crateName:   "home",
+			targetNames: []string{"[n"},
+			wantErr:     true,
+		},
+		{
+			name:        "invalid target path",
+			targetPaths: []string{"home/[n"},
+			wantErr:     true,
+		},
+		{
+			name:      "invalid match",
+			crateName: "home",
+			matches:   []string{"("},
+			wantErr:   true,
+		},
+		{
+			name:        "target paths with a crate",
+			crateName:   "home",
+			targetPaths: []string{"work"},
+			wantErr:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selections, response := getSelectedTargetsFromCLI(test.crateName, test.targetNames, test.targetPaths, test.matches, false, false, nil, nil, true, program)
+			if (response.exitCode != 0) != test.wantErr {
+				t.Fatalf("getSelectedTargetsFromCLI() = %v (%v), want an error: %v", response.exitCode, response.message, test.wantErr)
+			}
+
+			var got [][]string
+			for _, selection := range selections {
+				got = append(got, selectionTestNames(selection.targets))
+			}
+			if reflect.DeepEqual(got, test.want) == false {
+				t.Errorf("getSelectedTargetsFromCLI() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
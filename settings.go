@@ -0,0 +1,218 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	// External modules
+)
+
+//
+//// GLOBAL CONFIGURATION
+//
+
+// Settings shared by all crates can be set in an optional 'config.json' file in the
+// user data directory. Its 'notifications' section is used after the syncs of every
+// crate (see notificationConfig), its 'metrics' section sets where the metrics are
+// written (see metricsConfig) and its 'groups' section names lists of crates and
+// targets (see 'SELECTION').
+type globalConfig struct {
+	Notifications []notificationConfig `json:"notifications"`
+	Metrics       metricsConfig        `json:"metrics"`
+	Groups        map[string][]string  `json:"groups"`
+}
+
+func readGlobalConfig(program Program) (globalConfig, functionResponse) {
+	var config globalConfig
+
+	content, err := ioutil.ReadFile(program.userConfigFile)
+	if os.IsNotExist(err) {
+		return config, functionResponse{exitCode: 0}
+	} else if err != nil {
+		return config, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read configuration file -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return config, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to parse configuration file %v -> %v", program.userConfigFile, err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return config, functionResponse{exitCode: 0}
+}
+
+//
+//// CRATE CONFIGURATION
+//
+
+// Crates can be configured through an optional 'config.json' file in the crate
+// directory (the same file used by the crate templates). Its 'ssh' section is used
+// by the SFTP transport of the built-in sync engines, its 'archive' section by the
+// encrypted archives (see crateArchiveConfig), its 'schedule' section by the
+// daemon (see scheduleConfig) and its 'notifications' section by the notifications
+// sent after the syncs of the crate (see notificationConfig):
+//
+//	{
+//		"ssh": {
+//			"enabled": true,
+//			"keyPath": "~/.ssh/id_ed25519",
+//			"knownHostsFile": "~/.ssh/known_hosts",
+//			"port": 22
+//		}
+//	}
+type crateConfig struct {
+	SSH           crateSSHConfig       `json:"ssh"`
+	Archive       crateArchiveConfig   `json:"archive"`
+	Schedule      scheduleConfig       `json:"schedule"`
+	Notifications []notificationConfig `json:"notifications"`
+	Tags          []string             `json:"tags"`
+}
+
+type crateSSHConfig struct {
+	Enabled        configBool `json:"enabled"`
+	KeyPath        string     `json:"keyPath"`
+	KnownHostsFile string     `json:"knownHostsFile"`
+	Port           int        `json:"port"`
+}
+
+// Boolean that can also be written as a string ("true"/"false"), as done by the
+// crate templates
+type configBool bool
+
+func (value *configBool) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*value = configBool(strings.ToLower(strings.TrimSpace(text)) == "true")
+		return nil
+	}
+
+	var boolean bool
+	if err := json.Unmarshal(data, &boolean); err != nil {
+		return fmt.Errorf("expected a boolean, got %s", string(data))
+	}
+	*value = configBool(boolean)
+
+	return nil
+}
+
+func readCrateConfig(crate Crate, program Program) (crateConfig, functionResponse) {
+	var config crateConfig
+
+	content, err := ioutil.ReadFile(crate.configPath)
+	if os.IsNotExist(err) {
+		return config, functionResponse{exitCode: 0}
+	} else if err != nil {
+		return config, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read crate configuration file -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return config, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to parse crate configuration file %v -> %v", crate.configPath, err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return config, functionResponse{exitCode: 0}
+}
+
+//
+//// TARGET CONFIGURATION
+//
+
+// Targets can be configured through an optional 'config.json' file in the target
+// directory. It is used to select a built-in sync engine instead of the 'sync' hook
+// and to configure snapshots (see snapshotConfig), verification (see verifyConfig)
+// and the schedule of the target (see scheduleConfig):
+//
+//	{
+//		"sync": {
+//			"engine": "mirror",
+//			"source": "~/Documents",
+//			"destination": "/mnt/backup/Documents"
+//		}
+//	}
+type targetConfig struct {
+	Sync     syncEngineConfig `json:"sync"`
+	Snapshot snapshotConfig   `json:"snapshot"`
+	Verify   verifyConfig     `json:"verify"`
+	Schedule scheduleConfig   `json:"schedule"`
+	Tags     []string         `json:"tags"`
+}
+
+func readTargetConfig(target Target, program Program) (targetConfig, functionResponse) {
+	var config targetConfig
+
+	content, err := ioutil.ReadFile(target.configPath)
+	if os.IsNotExist(err) {
+		return config, functionResponse{exitCode: 0}
+	} else if err != nil {
+		return config, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read target configuration file -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return config, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to parse target configuration file %v -> %v", target.configPath, err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return config, functionResponse{exitCode: 0}
+}
+
+// Expands environment variables (including the crate/target ones) in a value read
+// from a configuration file
+func expandConfigEnv(value string, env map[string]string) string {
+	return os.Expand(value, func(key string) string {
+		if value, ok := env[key]; ok == true {
+			return value
+		}
+		return os.Getenv(key)
+	})
+}
+
+// Expands '~' and environment variables in a path read from a configuration file
+func expandConfigPath(path string, env map[string]string) string {
+	path = expandConfigEnv(path, env)
+
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		homeDir, err := os.UserHomeDir()
+		if err == nil {
+			path = filepath.Join(homeDir, strings.TrimPrefix(path, "~"))
+		}
+	}
+
+	return path
+}
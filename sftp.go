@@ -0,0 +1,358 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	// External modules
+	sftp "github.com/pkg/sftp"
+	ssh "golang.org/x/crypto/ssh"
+	agent "golang.org/x/crypto/ssh/agent"
+	knownhosts "golang.org/x/crypto/ssh/knownhosts"
+)
+
+//
+//// SSH REMOTES
+//
+
+// Roots of the built-in sync engines can be remote directories, accessed through
+// SFTP. They are written as 'user@host:/path' (scp-like, a path without a leading
+// slash being relative to the home directory) or 'ssh://user@host:port/path'.
+type sshRemote struct {
+	user string
+	host string
+	port int
+	path string
+}
+
+func (remote sshRemote) address() string {
+	return net.JoinHostPort(remote.host, strconv.Itoa(remote.port))
+}
+
+func (remote sshRemote) String() string {
+	return fmt.Sprintf("%v@%v:%v", remote.user, remote.address(), remote.path)
+}
+
+// Parses a remote root. Returns false if the root is a local path.
+func parseSSHRemote(root string, defaultPort int) (sshRemote, bool) {
+	remote := sshRemote{port: defaultPort}
+
+	if strings.HasPrefix(root, "ssh://") == true {
+		parsedURL, err := url.Parse(root)
+		if err != nil || parsedURL.Hostname() == "" {
+			return remote, false
+		}
+
+		remote.host = parsedURL.Hostname()
+		remote.user = parsedURL.User.Username()
+		if port, err := strconv.Atoi(parsedURL.Port()); err == nil {
+			remote.port = port
+		}
+		remote.path = parsedURL.Path
+		if strings.HasPrefix(remote.path, "/~/") == true || remote.path == "/~" {
+			remote.path = strings.TrimPrefix(strings.TrimPrefix(remote.path, "/~"), "/")
+		}
+	} else {
+		separator := strings.Index(root, ":")
+		if separator <= 0 || strings.Contains(root[:separator], "/") == true {
+			return remote, false
+		}
+
+		remote.host = root[:separator]
+		remote.path = root[separator+1:]
+		if at := strings.LastIndex(remote.host, "@"); at >= 0 {
+			remote.user = remote.host[:at]
+			remote.host = remote.host[at+1:]
+		}
+		if remote.path == "~" || strings.HasPrefix(remote.path, "~/") == true {
+			remote.path = strings.TrimPrefix(strings.TrimPrefix(remote.path, "~"), "/")
+		}
+	}
+
+	if remote.path == "" {
+		remote.path = "."
+	}
+	if remote.port == 0 {
+		remote.port = 22
+	}
+	if remote.user == "" {
+		if currentUser, err := user.Current(); err == nil {
+			remote.user = currentUser.Username
+		}
+	}
+
+	return remote, true
+}
+
+//
+//// SSH CONNECTIONS
+//
+
+// SSH connections opened during a crate transaction. Targets syncing with the same
+// host reuse the same connection, and all of them are closed when the transaction
+// ends.
+type sshConnectionPool struct {
+	crate       Crate
+	program     Program
+	config      *crateSSHConfig
+	clientSetup *ssh.ClientConfig
+	agentConn   net.Conn
+	connections map[string]*sshConnection
+}
+
+type sshConnection struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+func newSSHConnectionPool(crate Crate, program Program) *sshConnectionPool {
+	return &sshConnectionPool{
+		crate:       crate,
+		program:     program,
+		connections: make(map[string]*sshConnection),
+	}
+}
+
+// Reads the SSH settings of the crate (once)
+func (pool *sshConnectionPool) sshConfig() (crateSSHConfig, error) {
+	if pool.config != nil {
+		return *pool.config, nil
+	}
+
+	config, response := readCrateConfig(pool.crate, pool.program)
+	if response.exitCode != 0 {
+		return config.SSH, errors.New(response.message)
+	}
+	pool.config = &config.SSH
+
+	return config.SSH, nil
+}
+
+// Builds the client configuration (once): authentication with the SSH agent of the
+// crate (started with 'utils sshagent-start'), or the user's agent, and then with
+// the key set in the crate configuration, checking the host keys against the
+// known_hosts file.
+func (pool *sshConnectionPool) clientConfig(remote sshRemote) (*ssh.ClientConfig, error) {
+	if pool.clientSetup != nil {
+		config := *pool.clientSetup
+		config.User = remote.user
+		return &config, nil
+	}
+
+	sshConfig, err := pool.sshConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	var authMethods []ssh.AuthMethod
+
+	agentSocket := os.Getenv("SSH_AUTH_SOCK")
+	if sshAgentRunning(pool.crate.tempDir) == true {
+		agentSocket = getSSHAgentFiles(pool.crate.tempDir).socketPath
+	}
+	if agentSocket != "" {
+		conn, err := net.Dial("unix", agentSocket)
+		if err == nil {
+			pool.agentConn = conn
+			authMethods = append(authMethods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	if sshConfig.Enabled == true && sshConfig.KeyPath != "" {
+		var signers []ssh.Signer
+		keyPath := expandConfigPath(sshConfig.KeyPath, pool.crate.environment)
+
+		// The key is only loaded (and its passphrase asked) if the agent could not
+		// authenticate
+		authMethods = append(authMethods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if signers != nil {
+				return signers, nil
+			}
+
+			key, response := loadSSHPrivateKey(keyPath, pool.program)
+			if response.exitCode != 0 {
+				return nil, errors.New(response.message)
+			}
+
+			signer, err := ssh.NewSignerFromKey(key)
+			if err != nil {
+				return nil, err
+			}
+			signers = []ssh.Signer{signer}
+
+			return signers, nil
+		}))
+	}
+
+	if len(authMethods) == 0 {
+		return nil, fmt.Errorf("no SSH agent running and no SSH key set in %v", pool.crate.configPath)
+	}
+
+	knownHostsFile := "~/.ssh/known_hosts"
+	if sshConfig.KnownHostsFile != "" {
+		knownHostsFile = sshConfig.KnownHostsFile
+	}
+	knownHostsFile = expandConfigPath(knownHostsFile, pool.crate.environment)
+
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts file -> %v", err.Error())
+	}
+
+	pool.clientSetup = &ssh.ClientConfig{
+		Auth: authMethods,
+		HostKeyCallback: func(hostname string, remoteAddr net.Addr, key ssh.PublicKey) error {
+			err := hostKeyCallback(hostname, remoteAddr, key)
+
+			var keyError *knownhosts.KeyError
+			if errors.As(err, &keyError) == true && len(keyError.Want) == 0 {
+				return fmt.Errorf("host key of %v not found in %v (it can be added with 'ssh-keyscan -p <port> <host> >> %v')", hostname, knownHostsFile, knownHostsFile)
+			} else if errors.As(err, &keyError) == true {
+				return fmt.Errorf("host key of %v does not match the one in %v:%v (possible man-in-the-middle attack)", hostname, keyError.Want[0].Filename, keyError.Want[0].Line)
+			}
+
+			return err
+		},
+		Timeout: 15 * time.Second,
+	}
+
+	return pool.clientConfig(remote)
+}
+
+// Returns the SFTP filesystem for a remote root, connecting to the host if there
+// is no connection to it yet
+func (pool *sshConnectionPool) openSFTP(remote sshRemote) (syncFS, error) {
+	key := remote.user + "@" + remote.address()
+
+	connection, exists := pool.connections[key]
+	if exists == false {
+		config, err := pool.clientConfig(remote)
+		if err != nil {
+			return nil, err
+		}
+
+		sshClient, err := ssh.Dial("tcp", remote.address(), config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %v -> %v", key, err.Error())
+		}
+
+		sftpClient, err := sftp.NewClient(sshClient)
+		if err != nil {
+			sshClient.Close()
+			return nil, fmt.Errorf("failed to start SFTP session on %v -> %v", key, err.Error())
+		}
+
+		connection = &sshConnection{ssh: sshClient, sftp: sftpClient}
+		pool.connections[key] = connection
+	}
+
+	return sftpSyncFS{client: connection.sftp, remote: remote}, nil
+}
+
+func (pool *sshConnectionPool) close() {
+	for key, connection := range pool.connections {
+		connection.sftp.Close()
+		connection.ssh.Close()
+		delete(pool.connections, key)
+	}
+
+	if pool.agentConn != nil {
+		pool.agentConn.Close()
+		pool.agentConn = nil
+	}
+	pool.clientSetup = nil
+}
+
+//
+//// SFTP FILESYSTEM
+//
+
+type sftpSyncFS struct {
+	client *sftp.Client
+	remote sshRemote
+}
+
+func (fs sftpSyncFS) path(name string) string {
+	return path.Join(fs.remote.path, name)
+}
+
+func (fs sftpSyncFS) String() string {
+	return fs.remote.String()
+}
+
+func (fs sftpSyncFS) Lstat(name string) (os.FileInfo, error) {
+	return fs.client.Lstat(fs.path(name))
+}
+
+func (fs sftpSyncFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return fs.client.ReadDir(fs.path(name))
+}
+
+func (fs sftpSyncFS) Open(name string) (io.ReadCloser, error) {
+	return fs.client.Open(fs.path(name))
+}
+
+func (fs sftpSyncFS) Create(name string) (io.WriteCloser, error) {
+	return fs.client.Create(fs.path(name))
+}
+
+func (fs sftpSyncFS) Mkdir(name string, perm os.FileMode) error {
+	err := fs.client.Mkdir(fs.path(name))
+	if err != nil {
+		return err
+	}
+
+	return fs.client.Chmod(fs.path(name), perm)
+}
+
+func (fs sftpSyncFS) MkdirAll(name string) error {
+	return fs.client.MkdirAll(fs.path(name))
+}
+
+func (fs sftpSyncFS) Remove(name string) error {
+	return fs.client.Remove(fs.path(name))
+}
+
+// Renames a file, replacing the destination if it exists
+func (fs sftpSyncFS) Rename(oldName string, newName string) error {
+	if _, ok := fs.client.HasExtension("posix-rename@openssh.com"); ok == true {
+		return fs.client.PosixRename(fs.path(oldName), fs.path(newName))
+	}
+
+	err := fs.client.Remove(fs.path(newName))
+	if err != nil && os.IsNotExist(err) == false {
+		return err
+	}
+
+	return fs.client.Rename(fs.path(oldName), fs.path(newName))
+}
+
+func (fs sftpSyncFS) Chmod(name string, mode os.FileMode) error {
+	return fs.client.Chmod(fs.path(name), mode)
+}
+
+func (fs sftpSyncFS) Chtimes(name string, modTime time.Time) error {
+	return fs.client.Chtimes(fs.path(name), modTime, modTime)
+}
+
+func (fs sftpSyncFS) Readlink(name string) (string, error) {
+	return fs.client.ReadLink(fs.path(name))
+}
+
+func (fs sftpSyncFS) Symlink(linkTarget string, name string) error {
+	return fs.client.Symlink(linkTarget, fs.path(name))
+}
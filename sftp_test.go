@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	sftp "github.com/pkg/sftp"
+	ssh "golang.org/x/crypto/ssh"
+)
+
+func TestParseSSHRemote(t *testing.T) {
+	currentUser := ""
+	if current, err := user.Current(); err == nil {
+		currentUser = current.Username
+	}
+
+	tests := []struct {
+		root        string
+		defaultPort int
+		want        sshRemote
+		wantRemote  bool
+	}{
+		{"/srv/data", 0, sshRemote{}, false},
+		{"relative/dir", 0, sshRemote{}, false},
+		{"./dir:with:colons", 0, sshRemote{}, false},
+		{":data", 0, sshRemote{}, false},
+		{"ssh:///data", 0, sshRemote{}, false},
+		{"me@host:/srv/data", 0, sshRemote{user: "me", host: "host", port: 22, path: "/srv/data"}, true},
+		{"me@host:/srv/data", 2200, sshRemote{user: "me", host: "host", port: 2200, path: "/srv/data"}, true},
+		{"me@host:data", 0, sshRemote{user: "me", host: "host", port: 22, path: "data"}, true},
+		{"me@host:~/data", 0, sshRemote{user: "me", host: "host", port: 22, path: "data"}, true},
+		{"me@host:~", 0, sshRemote{user: "me", host: "host", port: 22, path: "."}, true},
+		{"me@host:", 0, sshRemote{user: "me", host: "host", port: 22, path: "."}, true},
+		{"me@corp@host:/x", 0, sshRemote{user: "me@corp", host: "host", port: 22, path: "/x"}, true},
+		{"host:/x", 0, sshRemote{user: currentUser, host: "host", port: 22, path: "/x"}, true},
+		{"ssh://me@host:2222/srv/data", 2200, sshRemote{user: "me", host: "host", port: 2222, path: "/srv/data"}, true},
+		{"ssh://me@host/srv/data", 2200, sshRemote{user: "me", host: "host", port: 2200, path: "/srv/data"}, true},
+		{"ssh://me@host/~/data", 0, sshRemote{user: "me", host: "host", port: 22, path: "data"}, true},
+		{"ssh://me@host/~", 0, sshRemote{user: "me", host: "host", port: 22, path: "."}, true},
+		{"ssh://me@host", 0, sshRemote{user: "me", host: "host", port: 22, path: "."}, true},
+		{"ssh://[::1]:2022/x", 0, sshRemote{user: currentUser, host: "::1", port: 2022, path: "/x"}, true},
+	}
+
+	for _, test := range tests {
+		got, isRemote := parseSSHRemote(test.root, test.defaultPort)
+		if isRemote != test.wantRemote {
+			t.Errorf("parseSSHRemote(%q) remote = %v, want %v", test.root, isRemote, test.wantRemote)
+			continue
+		}
+		if isRemote == true && got != test.want {
+			t.Errorf("parseSSHRemote(%q, %v) = %+v, want %+v", test.root, test.defaultPort, got, test.want)
+		}
+	}
+}
+
+func TestSSHRemoteAddress(t *testing.T) {
+	remote := sshRemote{user: "me", host: "::1", port: 2022, path: "/x"}
+
+	if got := remote.address(); got != "[::1]:2022" {
+		t.Errorf("address() = %q, want %q", got, "[::1]:2022")
+	}
+	if got := remote.String(); got != "me@[::1]:2022:/x" {
+		t.Errorf("String() = %q, want %q", got, "me@[::1]:2022:/x")
+	}
+}
+
+// Starts an in-process SSH server with the SFTP subsystem, accepting the given
+// user and password. Returns its port and host key.
+func startTestSFTPServer(t *testing.T, username string, password string) (int, ssh.PublicKey) {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, given []byte) (*ssh.Permissions, error) {
+			if conn.User() == username && string(given) == password {
+				return nil, nil
+			}
+			return nil, errors.New("access denied")
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSSHConnection(conn, config)
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port, signer.PublicKey()
+}
+
+func serveTestSSHConnection(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			for request := range requests {
+				// The payload of a subsystem request is its name, as an SSH string
+				isSFTP := request.Type == "subsystem" && len(request.Payload) > 4 && string(request.Payload[4:]) == "sftp"
+				request.Reply(isSFTP, nil)
+				if isSFTP == false {
+					continue
+				}
+
+				server, err := sftp.NewServer(channel)
+				if err != nil {
+					channel.Close()
+					continue
+				}
+				go func() {
+					server.Serve()
+					server.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// Returns a connection pool set up to connect to the test server
+func newTestSSHConnectionPool(t *testing.T, port int, hostKey ssh.PublicKey, password string) *sshConnectionPool {
+	t.Helper()
+
+	pool := newSSHConnectionPool(Crate{configPath: filepath.Join(t.TempDir(), "config.json")}, Program{})
+	pool.config = &crateSSHConfig{Port: port}
+	pool.clientSetup = &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+		Timeout:         5 * time.Second,
+	}
+	t.Cleanup(pool.close)
+
+	return pool
+}
+
+func writeTestFile(t *testing.T, root string, name string, content string) {
+	t.Helper()
+
+	fullPath := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fullPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Returns the contents of the files of a directory (and the targets of its
+// symlinks, prefixed with '-> ')
+func readTestTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+
+	tree := make(map[string]string)
+	err := filepath.Walk(root, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil || fullPath == root {
+			return err
+		}
+
+		name, _ := filepath.Rel(root, fullPath)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			linkTarget, err := os.Readlink(fullPath)
+			tree[name] = "-> " + linkTarget
+			return err
+		case info.IsDir() == true:
+			tree[name+"/"] = ""
+		default:
+			content, err := os.ReadFile(fullPath)
+			tree[name] = string(content)
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tree
+}
+
+func TestSFTPSyncFS(t *testing.T) {
+	port, hostKey := startTestSFTPServer(t, "tester", "secret")
+	pool := newTestSSHConnectionPool(t, port, hostKey, "secret")
+
+	srcDir, remoteDir := t.TempDir(), t.TempDir()
+
+	// The port comes from the SSH settings of the crate
+	fs, err := openSyncFS("tester@127.0.0.1:"+remoteDir, nil, pool)
+	if err != nil {
+		t.Fatalf("openSyncFS() failed: %v", err)
+	}
+	if _, ok := fs.(sftpSyncFS); ok == false {
+		t.Fatalf("openSyncFS() = %T, want sftpSyncFS", fs)
+	}
+	if want := "tester@127.0.0.1:" + strconv.Itoa(port) + ":" + remoteDir; fs.String() != want {
+		t.Errorf("String() = %q, want %q", fs.String(), want)
+	}
+
+	writeTestFile(t, srcDir, "file", "contents")
+	writeTestFile(t, srcDir, "dir/sub/nested", "nested")
+	writeTestFile(t, srcDir, "old", "removed later")
+	if err := os.Symlink("dir/sub/nested", filepath.Join(srcDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	config := syncEngineConfig{Engine: syncEngineMirror, Delete: true}
+
+	stats, err := runMirrorEngine(localSyncFS{root: srcDir}, fs, config, Program{})
+	if err != nil || len(stats.errors) > 0 {
+		t.Fatalf("mirror to SFTP failed: %v %v", err, stats.errors)
+	}
+	if got, want := readTestTree(t, remoteDir), readTestTree(t, srcDir); reflect.DeepEqual(got, want) == false {
+		t.Errorf("remote tree = %v, want %v", got, want)
+	}
+
+	// Times are preserved, so nothing changed
+	stats, err = runMirrorEngine(localSyncFS{root: srcDir}, fs, config, Program{})
+	if err != nil || stats.created+stats.updated+stats.deleted > 0 {
+		t.Errorf("second mirror made changes: %+v (%v)", stats, err)
+	}
+
+	writeTestFile(t, srcDir, "file", "new contents")
+	if err := os.Remove(filepath.Join(srcDir, "old")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = runMirrorEngine(localSyncFS{root: srcDir}, fs, config, Program{})
+	if err != nil || stats.updated != 1 || stats.deleted != 1 {
+		t.Errorf("third mirror = %+v (%v), want 1 update and 1 deletion", stats, err)
+	}
+	if got, want := readTestTree(t, remoteDir), readTestTree(t, srcDir); reflect.DeepEqual(got, want) == false {
+		t.Errorf("remote tree = %v, want %v", got, want)
+	}
+
+	// And back from the remote root
+	backDir := t.TempDir()
+	_, err = runMirrorEngine(fs, localSyncFS{root: backDir}, config, Program{})
+	if err != nil {
+		t.Fatalf("mirror from SFTP failed: %v", err)
+	}
+	if got, want := readTestTree(t, backDir), readTestTree(t, srcDir); reflect.DeepEqual(got, want) == false {
+		t.Errorf("local tree = %v, want %v", got, want)
+	}
+
+	// Renames replace the destination
+	writer, err := fs.Create("renamed")
+	if err == nil {
+		_, err = writer.Write([]byte("renamed"))
+		writer.Close()
+	}
+	if err == nil {
+		err = fs.Rename("renamed", "file")
+	}
+	if content, _ := os.ReadFile(filepath.Join(remoteDir, "file")); err != nil || string(content) != "renamed" {
+		t.Errorf("Rename() over an existing file = %q (%v)", content, err)
+	}
+
+	if linkTarget, err := fs.Readlink("link"); err != nil || linkTarget != "dir/sub/nested" {
+		t.Errorf("Readlink() = %q (%v), want %q", linkTarget, err, "dir/sub/nested")
+	}
+
+	if err := fs.Mkdir("private", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(remoteDir, "private")); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("Mkdir() mode = %v (%v), want %v", info.Mode().Perm(), err, os.FileMode(0700))
+	}
+
+	// Roots on the same host share the connection
+	if _, err := openSyncFS("tester@127.0.0.1:"+srcDir, nil, pool); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.connections) != 1 {
+		t.Errorf("pool has %v connections, want 1", len(pool.connections))
+	}
+}
+
+func TestSFTPAuthenticationFailure(t *testing.T) {
+	port, hostKey := startTestSFTPServer(t, "tester", "secret")
+	pool := newTestSSHConnectionPool(t, port, hostKey, "wrong")
+
+	if _, err := openSyncFS("tester@127.0.0.1:/", nil, pool); err == nil {
+		t.Error("openSyncFS() with a wrong password succeeded, want an error")
+	}
+	if len(pool.connections) != 0 {
+		t.Errorf("pool has %v connections, want 0", len(pool.connections))
+	}
+}
+
+func TestOpenSyncFSLocalRootIgnoresSSHConfig(t *testing.T) {
+	crateDir := t.TempDir()
+	configPath := filepath.Join(crateDir, "config.json")
+	if err := os.WriteFile(configPath, []byte("{ not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := newSSHConnectionPool(Crate{configPath: configPath}, Program{})
+
+	root := t.TempDir()
+	fs, err := openSyncFS(root, nil, pool)
+	if err != nil {
+		t.Fatalf("openSyncFS() of a local root failed: %v", err)
+	}
+	if got, ok := fs.(localSyncFS); ok == false || got.root != root {
+		t.Errorf("openSyncFS() = %#v, want the local root %v", fs, root)
+	}
+
+	if _, err := openSyncFS("me@127.0.0.1:/srv", nil, pool); err == nil {
+		t.Error("openSyncFS() of a remote root with an invalid crate configuration succeeded, want an error")
+	}
+}
@@ -0,0 +1,977 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	// External modules
+)
+
+//
+//// SNAPSHOT CONFIGURATION
+//
+
+// Snapshots capture the primary directory of a target into a local store. Their
+// settings are read from the 'snapshot' section of the target configuration:
+//
+//	{
+//		"snapshot": {
+//			"path": "~/.config/nvim",
+//			"exclude": ["*.log"],
+//			"beforeSync": true,
+//			"keepLast": 5,
+//			"keepDaily": 7,
+//			"keepWeekly": 4
+//		}
+//	}
+//
+// When 'path' is not set, the primary directory is the source of the mirror engine,
+// the first root of the twoway engine or the first root of the unison profile. With
+// 'beforeSync', a snapshot is taken before every sync, so that it can be undone with
+// 'targets rollback'.
+type snapshotConfig struct {
+	Path       string   `json:"path"`
+	Exclude    []string `json:"exclude"`
+	BeforeSync bool     `json:"beforeSync"`
+	KeepLast   int      `json:"keepLast"`
+	KeepDaily  int      `json:"keepDaily"`
+	KeepWeekly int      `json:"keepWeekly"`
+}
+
+// Retention rules are only applied if at least one of them is set
+func (config snapshotConfig) hasRetention() bool {
+	return config.KeepLast > 0 || config.KeepDaily > 0 || config.KeepWeekly > 0
+}
+
+// Returns the (local) directory captured by the snapshots of a target
+func getTargetPrimaryDir(target Target, config targetConfig) (string, error) {
+	primaryDir := config.Snapshot.Path
+
+	if primaryDir == "" {
+		switch config.Sync.Engine {
+		case syncEngineMirror:
+			primaryDir = config.Sync.Source
+		case syncEngineTwoWay:
+			if len(config.Sync.Roots) > 0 {
+				primaryDir = config.Sync.Roots[0]
+			}
+		}
+	}
+
+	if primaryDir == "" {
+		profilePath := getTargetUnisonProfilePath(target)
+		if _, err := os.Stat(profilePath); err == nil {
+			profile, err := parseUnisonProfile(profilePath)
+			if err != nil {
+				return "", fmt.Errorf("failed to read unison profile -> %v", err.Error())
+			}
+			if roots := profile.roots(); len(roots) > 0 {
+				primaryDir = roots[0]
+			}
+		}
+	}
+
+	if primaryDir == "" {
+		return "", fmt.Errorf("no primary directory found (set 'snapshot.path' in %v)", target.configPath)
+	}
+
+	primaryDir = expandConfigPath(primaryDir, target.environment)
+	if _, isRemote := parseSSHRemote(primaryDir, 0); isRemote == true {
+		return "", fmt.Errorf("the primary directory %v is not local (set 'snapshot.path' in %v)", primaryDir, target.configPath)
+	}
+
+	return primaryDir, nil
+}
+
+//
+//// SNAPSHOT STORE
+//
+
+// The store lives in the user data directory. File contents are split into chunks
+// stored once, under the SHA-256 of their contents ('chunks/ab/abcdef...'), so
+// unchanged files (and unchanged parts of large files) are shared by every
+// snapshot. Each snapshot is a JSON manifest ('<crate>/<target>/<id>.json') listing
+// the files and their chunks.
+
+type snapshotFile struct {
+	Path       string      `json:"path"`
+	Type       string      `json:"type"`
+	Mode       os.FileMode `json:"mode"`
+	ModTime    time.Time   `json:"mod_time"`
+	Size       int64       `json:"size,omitempty"`
+	LinkTarget string      `json:"link_target,omitempty"`
+	Chunks     []string    `json:"chunks,omitempty"`
+}
+
+type snapshotManifest struct {
+	ID        string         `json:"id"`
+	Crate     string         `json:"crate"`
+	Target    string         `json:"target"`
+	Source    string         `json:"source"`
+	Reason    string         `json:"reason,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	Size      int64          `json:"size"`
+	Files     []snapshotFile `json:"files"`
+	// Exclude patterns used when the snapshot was taken (excluded paths are left
+	// alone when restoring it)
+	Exclude []string `json:"exclude"`
+}
+
+// Types of the entries of a snapshot
+const (
+	snapshotFileTypeDir     = "dir"
+	snapshotFileTypeFile    = "file"
+	snapshotFileTypeSymlink = "symlink"
+)
+
+// Reasons recorded in the snapshots
+const (
+	snapshotReasonManual  = "manual"
+	snapshotReasonPreSync = "pre-sync"
+)
+
+// Format of the snapshot IDs (the creation time)
+const snapshotIDFormat = "20060102-150405"
+
+func getSnapshotChunksDir(program Program) string {
+	return filepath.Join(program.userSnapshotsDir, "chunks")
+}
+
+func getSnapshotChunkPath(hash string, program Program) string {
+	return filepath.Join(getSnapshotChunksDir(program), hash[:2], hash)
+}
+
+func getTargetSnapshotsDir(target Target, program Program) string {
+	return filepath.Join(program.userSnapshotsDir, target.crate.name, target.name)
+}
+
+// Writes a file through a temporary file, so that it is never left half-written
+func writeFileAtomically(filePath string, data []byte, perm os.FileMode) error {
+	tempPath := filePath + syncTempFileSuffix
+
+	err := ioutil.WriteFile(tempPath, data, perm)
+	if err == nil {
+		err = os.Rename(tempPath, filePath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+
+	return err
+}
+
+//
+//// STORE LOCK
+//
+
+// Chunks are written before the manifest of their snapshot, so removing the unused
+// chunks while a snapshot is being created (for any crate, e.g. by 'targets watch'
+// or the daemon) would remove some of its chunks. Snapshots are created and
+// restored holding a shared lock on the store, and unused chunks are removed
+// holding an exclusive one; each waits for the other.
+
+func lockSnapshotStore(exclusive bool, program Program) (*os.File, error) {
+	err := os.MkdirAll(program.userSnapshotsDir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(program.userSnapshotsDir, ".lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive == true {
+		how = syscall.LOCK_EX
+	}
+
+	err = syscall.Flock(int(file.Fd()), how)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock snapshot store -> %v", err.Error())
+	}
+
+	return file, nil
+}
+
+//
+//// CHUNKING
+//
+
+// Files are split with content-defined chunking (a gear rolling hash), so that an
+// insertion in a large file only changes the chunks around it.
+const (
+	snapshotChunkMinSize = 256 * 1024
+	snapshotChunkMaxSize = 4 * 1024 * 1024
+	// Cut points are found on average every 1 MiB
+	snapshotChunkMask = 1<<20 - 1
+)
+
+var snapshotGearTable = func() [256]uint64 {
+	var table [256]uint64
+	for index := range table {
+		sum := sha256.Sum256([]byte{byte(index)})
+		table[index] = binary.LittleEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// Reads the next chunk from the reader into the buffer (which must be able to hold
+// snapshotChunkMaxSize bytes). Returns io.EOF when there is nothing left.
+func readSnapshotChunk(reader *bufio.Reader, buffer []byte) ([]byte, error) {
+	var hash uint64
+	size := 0
+
+	for size < snapshotChunkMaxSize {
+		b, err := reader.ReadByte()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		buffer[size] = b
+		size++
+
+		hash = (hash << 1) + snapshotGearTable[b]
+		if size >= snapshotChunkMinSize && hash&snapshotChunkMask == 0 {
+			break
+		}
+	}
+
+	if size == 0 {
+		return nil, io.EOF
+	}
+
+	return buffer[:size], nil
+}
+
+// Stores the chunks of a file. Returns their hashes and the number of new chunks
+// (and bytes) written to the store.
+func storeSnapshotFile(filePath string, program Program) ([]string, int, int64, error) {
+	var hashes []string
+	newChunks := 0
+	var newBytes int64
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, 1024*1024)
+	buffer := make([]byte, snapshotChunkMaxSize)
+
+	for {
+		chunk, err := readSnapshotChunk(reader, buffer)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, newChunks, newBytes, err
+		}
+
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+		hashes = append(hashes, hash)
+
+		chunkPath := getSnapshotChunkPath(hash, program)
+		if _, err := os.Stat(chunkPath); err == nil {
+			continue
+		}
+
+		err = os.MkdirAll(filepath.Dir(chunkPath), 0700)
+		if err == nil {
+			err = writeFileAtomically(chunkPath, chunk, 0600)
+		}
+		if err != nil {
+			return nil, newChunks, newBytes, err
+		}
+
+		newChunks++
+		newBytes += int64(len(chunk))
+	}
+
+	return hashes, newChunks, newBytes, nil
+}
+
+// Writes the contents of a stored file, verifying every chunk
+func writeSnapshotFileContents(writer io.Writer, file snapshotFile, program Program) error {
+	for _, hash := range file.Chunks {
+		chunk, err := ioutil.ReadFile(getSnapshotChunkPath(hash, program))
+		if err != nil {
+			return fmt.Errorf("missing chunk %v -> %v", hash, err.Error())
+		}
+
+		sum := sha256.Sum256(chunk)
+		if hex.EncodeToString(sum[:]) != hash {
+			return fmt.Errorf("chunk %v is corrupted", hash)
+		}
+
+		_, err = writer.Write(chunk)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//
+//// SNAPSHOTS
+//
+
+type snapshotStats struct {
+	files     int
+	dirs      int
+	newChunks int
+	newBytes  int64
+}
+
+// Captures a directory into a new snapshot of the target
+func createSnapshot(target Target, sourceDir string, excludes []string, reason string, program Program) (snapshotManifest, snapshotStats, error) {
+	var stats snapshotStats
+
+	if excludes == nil {
+		excludes = []string{}
+	}
+
+	manifest := snapshotManifest{
+		Crate:     target.crate.name,
+		Target:    target.name,
+		Source:    sourceDir,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+		Files:     []snapshotFile{},
+		Exclude:   excludes,
+	}
+
+	info, err := os.Stat(sourceDir)
+	if err != nil {
+		return manifest, stats, err
+	} else if info.IsDir() == false {
+		return manifest, stats, fmt.Errorf("%v is not a directory", sourceDir)
+	}
+
+	lock, err := lockSnapshotStore(false, program)
+	if err != nil {
+		return manifest, stats, err
+	}
+	defer lock.Close()
+
+	tree, _, err := scanSyncTree(localSyncFS{root: sourceDir}, excludes)
+	if err != nil {
+		return manifest, stats, err
+	}
+
+	for _, name := range sortedSyncPaths(tree) {
+		entry := tree[name]
+
+		file := snapshotFile{
+			Path:    name,
+			Mode:    entry.mode,
+			ModTime: entry.modTime,
+		}
+
+		switch {
+		case entry.isDir == true:
+			file.Type = snapshotFileTypeDir
+			stats.dirs++
+		case entry.isSymlink == true:
+			file.Type = snapshotFileTypeSymlink
+			file.LinkTarget = entry.linkTarget
+		default:
+			hashes, newChunks, newBytes, err := storeSnapshotFile(filepath.Join(sourceDir, filepath.FromSlash(name)), program)
+			stats.newChunks += newChunks
+			stats.newBytes += newBytes
+			if err != nil {
+				return manifest, stats, fmt.Errorf("failed to store %v -> %v", name, err.Error())
+			}
+
+			file.Type = snapshotFileTypeFile
+			file.Size = entry.size
+			file.Chunks = hashes
+			manifest.Size += entry.size
+			stats.files++
+		}
+
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	snapshotsDir := getTargetSnapshotsDir(target, program)
+	err = os.MkdirAll(snapshotsDir, 0700)
+	if err != nil {
+		return manifest, stats, err
+	}
+
+	// Use the creation time as ID (adding a suffix if several snapshots are taken
+	// in the same second)
+	manifest.ID = manifest.CreatedAt.Format(snapshotIDFormat)
+	for suffix := 2; ; suffix++ {
+		if _, err := os.Stat(filepath.Join(snapshotsDir, manifest.ID+".json")); os.IsNotExist(err) {
+			break
+		}
+		manifest.ID = fmt.Sprintf("%v-%v", manifest.CreatedAt.Format(snapshotIDFormat), suffix)
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return manifest, stats, err
+	}
+
+	err = writeFileAtomically(filepath.Join(snapshotsDir, manifest.ID+".json"), content, 0600)
+
+	return manifest, stats, err
+}
+
+// Returns the snapshots of a target (oldest first)
+func listSnapshots(target Target, program Program) ([]snapshotManifest, error) {
+	var snapshots []snapshotManifest
+
+	snapshotsDir := getTargetSnapshotsDir(target, program)
+	files, err := ioutil.ReadDir(snapshotsDir)
+	if os.IsNotExist(err) {
+		return snapshots, nil
+	} else if err != nil {
+		return snapshots, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() == true || strings.HasSuffix(file.Name(), ".json") == false {
+			continue
+		}
+
+		manifest, err := readSnapshot(target, strings.TrimSuffix(file.Name(), ".json"), program)
+		if err != nil {
+			return snapshots, err
+		}
+		snapshots = append(snapshots, manifest)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.Before(snapshots[j].CreatedAt)
+	})
+
+	return snapshots, nil
+}
+
+func readSnapshot(target Target, id string, program Program) (snapshotManifest, error) {
+	var manifest snapshotManifest
+
+	if id == "" || strings.ContainsAny(id, "/\\") == true {
+		return manifest, fmt.Errorf("invalid snapshot ID '%v'", id)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(getTargetSnapshotsDir(target, program), id+".json"))
+	if os.IsNotExist(err) {
+		return manifest, fmt.Errorf("snapshot %v not found", id)
+	} else if err != nil {
+		return manifest, err
+	}
+
+	err = json.Unmarshal(content, &manifest)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to parse snapshot %v -> %v", id, err.Error())
+	}
+
+	return manifest, nil
+}
+
+type restoreStats struct {
+	restored  int
+	unchanged int
+	deleted   int
+}
+
+// Restores a snapshot into a directory. Files that did not change since the
+// snapshot are left untouched and, if deleteExtra is true, files that are not in
+// the snapshot are removed (restoring the exact state of the snapshot). Paths
+// excluded from the snapshot are never removed.
+func restoreSnapshot(manifest snapshotManifest, destDir string, deleteExtra bool, program Program) (restoreStats, error) {
+	var stats restoreStats
+
+	lock, err := lockSnapshotStore(false, program)
+	if err != nil {
+		return stats, err
+	}
+	defer lock.Close()
+
+	err = os.MkdirAll(destDir, 0755)
+	if err != nil {
+		return stats, err
+	}
+
+	dst := localSyncFS{root: destDir}
+	current, _, err := scanSyncTree(dst, manifest.Exclude)
+	if err != nil {
+		return stats, err
+	}
+
+	wanted := make(map[string]bool)
+	for _, file := range manifest.Files {
+		wanted[file.Path] = true
+	}
+
+	if deleteExtra == true {
+		paths := sortedSyncPaths(current)
+		for index := len(paths) - 1; index >= 0; index-- {
+			// Contents come after their directory, so they are removed first
+			if wanted[paths[index]] == true {
+				continue
+			}
+
+			removed, err := removeSyncPath(dst, current[paths[index]], manifest.Exclude)
+			if err != nil && os.IsNotExist(err) == false {
+				return stats, err
+			}
+			delete(current, paths[index])
+			if removed == true {
+				stats.deleted++
+			}
+		}
+	}
+
+	var dirs []snapshotFile
+	for _, file := range manifest.Files {
+		name := filepath.Join(destDir, filepath.FromSlash(file.Path))
+		existing, exists := current[file.Path]
+
+		// Replace entries of a different kind
+		if exists == true {
+			sameKind := (file.Type == snapshotFileTypeDir && existing.isDir == true) ||
+				(file.Type == snapshotFileTypeSymlink && existing.isSymlink == true) ||
+				(file.Type == snapshotFileTypeFile && existing.isDir == false && existing.isSymlink == false)
+			if sameKind == false {
+				removed, err := removeSyncPath(dst, existing, manifest.Exclude)
+				if err == nil && removed == false {
+					err = fmt.Errorf("%v -> %v", file.Path, errSyncPathHasExcluded.Error())
+				}
+				if err != nil {
+					return stats, err
+				}
+				exists = false
+			}
+		}
+
+		switch file.Type {
+		case snapshotFileTypeDir:
+			if exists == false {
+				err := os.MkdirAll(name, 0755)
+				if err != nil {
+					return stats, err
+				}
+			}
+			dirs = append(dirs, file)
+		case snapshotFileTypeSymlink:
+			if exists == true && existing.linkTarget == file.LinkTarget {
+				stats.unchanged++
+				continue
+			}
+			if exists == true {
+				os.Remove(name)
+			}
+			err := os.Symlink(file.LinkTarget, name)
+			if err != nil {
+				return stats, err
+			}
+			stats.restored++
+		case snapshotFileTypeFile:
+			if exists == true && existing.size == file.Size && existing.modTime.Equal(file.ModTime) == true {
+				stats.unchanged++
+				continue
+			}
+
+			err := restoreSnapshotFile(file, name, program)
+			if err != nil {
+				return stats, fmt.Errorf("failed to restore %v -> %v", file.Path, err.Error())
+			}
+			stats.restored++
+		}
+	}
+
+	// Directory metadata is applied last, as restoring their contents changes it
+	for index := len(dirs) - 1; index >= 0; index-- {
+		name := filepath.Join(destDir, filepath.FromSlash(dirs[index].Path))
+		os.Chmod(name, dirs[index].Mode)
+		os.Chtimes(name, dirs[index].ModTime, dirs[index].ModTime)
+	}
+
+	return stats, nil
+}
+
+func restoreSnapshotFile(file snapshotFile, filePath string, program Program) error {
+	tempPath := filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+syncTempFileSuffix)
+
+	writer, err := os.OpenFile(tempPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = writeSnapshotFileContents(writer, file, program)
+	closeErr := writer.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tempPath, file.Mode)
+	}
+	if err == nil {
+		err = os.Chtimes(tempPath, file.ModTime, file.ModTime)
+	}
+	if err == nil {
+		err = os.Rename(tempPath, filePath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+	}
+
+	return err
+}
+
+//
+//// RETENTION
+//
+
+// Returns the snapshots kept by the retention rules: the last N snapshots, and the
+// newest snapshot of each of the last N days and weeks that have snapshots.
+func getSnapshotsToKeep(snapshots []snapshotManifest, config snapshotConfig) map[string]bool {
+	keep := make(map[string]bool)
+
+	keepPeriods := func(count int, period func(time.Time) string) {
+		seen := make(map[string]bool)
+		for index := len(snapshots) - 1; index >= 0 && len(seen) < count; index-- {
+			key := period(snapshots[index].CreatedAt.Local())
+			if seen[key] == true {
+				continue
+			}
+			seen[key] = true
+			keep[snapshots[index].ID] = true
+		}
+	}
+
+	keepPeriods(config.KeepLast, func(createdAt time.Time) string {
+		return createdAt.String()
+	})
+	keepPeriods(config.KeepDaily, func(createdAt time.Time) string {
+		return createdAt.Format("2006-01-02")
+	})
+	keepPeriods(config.KeepWeekly, func(createdAt time.Time) string {
+		year, week := createdAt.ISOWeek()
+		return fmt.Sprintf("%v-%v", year, week)
+	})
+
+	return keep
+}
+
+// Removes the snapshots of a target not kept by the retention rules, and then the
+// chunks no longer used by any snapshot. Returns the removed snapshots.
+func pruneSnapshots(target Target, config snapshotConfig, program Program) ([]string, error) {
+	var removed []string
+
+	if config.hasRetention() == false {
+		return removed, nil
+	}
+
+	snapshots, err := listSnapshots(target, program)
+	if err != nil {
+		return removed, err
+	}
+
+	keep := getSnapshotsToKeep(snapshots, config)
+	for _, snapshot := range snapshots {
+		if keep[snapshot.ID] == true {
+			continue
+		}
+
+		err := os.Remove(filepath.Join(getTargetSnapshotsDir(target, program), snapshot.ID+".json"))
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, snapshot.ID)
+	}
+
+	if len(removed) > 0 {
+		err = removeUnusedSnapshotChunks(program)
+	}
+
+	return removed, err
+}
+
+// Removes the chunks not referenced by any snapshot (of any target)
+func removeUnusedSnapshotChunks(program Program) error {
+	lock, err := lockSnapshotStore(true, program)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	used := make(map[string]bool)
+
+	err = filepath.Walk(program.userSnapshotsDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() == true && filePath == getSnapshotChunksDir(program) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() == true || strings.HasSuffix(filePath, ".json") == false {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		var manifest snapshotManifest
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			// Keep every chunk if a snapshot cannot be read
+			return fmt.Errorf("failed to parse snapshot %v -> %v", filePath, err.Error())
+		}
+
+		for _, file := range manifest.Files {
+			for _, hash := range file.Chunks {
+				used[hash] = true
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(getSnapshotChunksDir(program), func(filePath string, info os.FileInfo, err error) error {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if info.IsDir() == false && used[info.Name()] == false {
+			return os.Remove(filePath)
+		}
+
+		return nil
+	})
+}
+
+//
+//// TARGET COMMANDS
+//
+
+func targetsSnapshot(crate Crate, targets []Target, program Program) functionResponse {
+	for _, target := range targets {
+		space()
+		showInfoSectionTitle(displayTargetTag("Creating snapshot", target), program.indentLevel)
+
+		config, response := readTargetConfig(target, program)
+		if response.exitCode != 0 {
+			response.indentLevel = program.indentLevel + 1
+			return response
+		}
+
+		manifest, response := takeTargetSnapshot(target, config, snapshotReasonManual, incrementProgramIndentLevel(program, 1))
+		if response.exitCode != 0 {
+			return response
+		}
+
+		showSuccess(fmt.Sprintf("> Created snapshot %v", blue.Sprintf(manifest.ID)), program.indentLevel+1)
+	}
+
+	return functionResponse{
+		exitCode: 0,
+	}
+}
+
+// Captures the primary directory of a target, showing what was stored
+func takeTargetSnapshot(target Target, config targetConfig, reason string, program Program) (snapshotManifest, functionResponse) {
+	primaryDir, err := getTargetPrimaryDir(target, config)
+	if err != nil {
+		return snapshotManifest{}, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to find primary directory -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	showText(fmt.Sprintf("Source: %s", blue.Sprintf(primaryDir)), program.indentLevel)
+
+	start := time.Now()
+	manifest, stats, err := createSnapshot(target, primaryDir, config.Snapshot.Exclude, reason, program)
+	if err != nil {
+		return manifest, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to create snapshot -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	showText(gray.Sprintf(fmt.Sprintf("%v files and %v directories (%v), %v new chunks (%v) in %.1fs", stats.files, stats.dirs, formatByteSize(manifest.Size), stats.newChunks, formatByteSize(stats.newBytes), time.Since(start).Seconds())), program.indentLevel)
+
+	return manifest, functionResponse{exitCode: 0}
+}
+
+func targetsSnapshotsLs(crate Crate, targets []Target, program Program) functionResponse {
+	for _, target := range targets {
+		space()
+		showInfoSectionTitle(displayTargetTag("Listing snapshots", target), program.indentLevel)
+
+		snapshots, err := listSnapshots(target, program)
+		if err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to list snapshots -> " + err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+
+		if len(snapshots) == 0 {
+			showAttention("> No snapshots found", program.indentLevel+1)
+			continue
+		}
+
+		space()
+
+		for _, snapshot := range snapshots {
+			details := fmt.Sprintf("%v files, %v", len(snapshot.Files), formatByteSize(snapshot.Size))
+			if snapshot.Reason != "" {
+				details += ", " + snapshot.Reason
+			}
+
+			showText(fmt.Sprintf(" - %s %s %s", blue.Sprintf(snapshot.ID), snapshot.CreatedAt.Local().Format("2006-01-02 15:04:05"), gray.Sprintf("("+details+")")), program.indentLevel+1)
+		}
+	}
+
+	return functionResponse{
+		exitCode: 0,
+	}
+}
+
+func targetsRestore(crate Crate, target Target, snapshotID string, destDir string, deleteExtra bool, program Program) functionResponse {
+	space()
+	showInfoSectionTitle(displayTargetTag(fmt.Sprintf("Restoring snapshot %v", snapshotID), target), program.indentLevel)
+
+	manifest, err := readSnapshot(target, snapshotID, program)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read snapshot -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	if destDir == "" {
+		// Restoring in place overwrites the current files
+		destDir = manifest.Source
+
+		showAttention(fmt.Sprintf("> The files in %v will be replaced by the ones in the snapshot", destDir), program.indentLevel+1)
+		if askConfirmation("Enter 'yes/y' to confirm or 'no/n' to cancel the operation", incrementProgramIndentLevel(program, 1)) == false {
+			return functionResponse{
+				exitCode:    1,
+				message:     "Operation cancelled",
+				logLevel:    "attention",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+	}
+
+	showText(fmt.Sprintf("Destination: %s", blue.Sprintf(destDir)), program.indentLevel+1)
+
+	stats, err := restoreSnapshot(manifest, destDir, deleteExtra, program)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to restore snapshot -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	showText(gray.Sprintf(fmt.Sprintf("%v restored, %v unchanged, %v deleted", stats.restored, stats.unchanged, stats.deleted)), program.indentLevel+1)
+
+	return functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
+	}
+}
+
+// Restores the last pre-sync snapshot of each target, undoing its last sync
+func targetsRollback(crate Crate, targets []Target, program Program) functionResponse {
+	for _, target := range targets {
+		snapshots, err := listSnapshots(target, program)
+		if err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to list snapshots -> " + err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		snapshotID := ""
+		for index := len(snapshots) - 1; index >= 0; index-- {
+			if snapshots[index].Reason == snapshotReasonPreSync {
+				snapshotID = snapshots[index].ID
+				break
+			}
+		}
+
+		if snapshotID == "" {
+			space()
+			showInfoSectionTitle(displayTargetTag("Rolling back", target), program.indentLevel)
+			showAttention(fmt.Sprintf("> No pre-sync snapshot found ('snapshot.beforeSync' must be enabled in %v)", target.configPath), program.indentLevel+1)
+			continue
+		}
+
+		response := targetsRestore(crate, target, snapshotID, "", true, program)
+		if response.exitCode != 0 {
+			return response
+		}
+		handleFunctionResponse(response, false)
+	}
+
+	return functionResponse{
+		exitCode: 0,
+	}
+}
+
+// Applies the retention rules of a target after a successful sync
+func pruneTargetSnapshots(target Target, config targetConfig, program Program) {
+	if config.Snapshot.hasRetention() == false {
+		return
+	}
+
+	removed, err := pruneSnapshots(target, config.Snapshot, program)
+	if err != nil {
+		showAttention(fmt.Sprintf("> Failed to prune snapshots -> %v", err.Error()), program.indentLevel)
+		return
+	}
+
+	if len(removed) > 0 {
+		showText(gray.Sprintf(fmt.Sprintf("Pruned %v snapshot(s): %v", len(removed), strings.Join(removed, ", "))), program.indentLevel)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestGetSnapshotsToKeep(t *testing.T) {
+	at := func(id string, year int, month time.Month, day int, hour int) snapshotManifest {
+		return snapshotManifest{ID: id, CreatedAt: time.Date(year, month, day, hour, 0, 0, 0, time.Local)}
+	}
+
+	// Oldest first. June 2 and 9 2025 are Mondays (ISO weeks 23 and 24).
+	snapshots := []snapshotManifest{
+		at("mon-morning", 2025, 6, 2, 8),
+		at("mon-evening", 2025, 6, 2, 20),
+		at("tue", 2025, 6, 3, 9),
+		at("next-mon-morning", 2025, 6, 9, 10),
+		at("next-mon-evening", 2025, 6, 9, 18),
+		at("next-tue", 2025, 6, 10, 7),
+	}
+
+	// Around a year change: December 29 2025 starts week 1 of 2026
+	yearChange := []snapshotManifest{
+		at("sun", 2025, 12, 28, 12),
+		at("mon", 2025, 12, 29, 12),
+		at("fri", 2026, 1, 2, 12),
+	}
+
+	tests := []struct {
+		name      string
+		snapshots []snapshotManifest
+		config    snapshotConfig
+		want      []string
+	}{
+		{"no rules", snapshots, snapshotConfig{}, nil},
+		{"no snapshots", nil, snapshotConfig{KeepLast: 3, KeepDaily: 3, KeepWeekly: 3}, nil},
+		{"last", snapshots, snapshotConfig{KeepLast: 2}, []string{"next-mon-evening", "next-tue"}},
+		{"more than there are", snapshots, snapshotConfig{KeepLast: 10}, []string{"mon-evening", "mon-morning", "next-mon-evening", "next-mon-morning", "next-tue", "tue"}},
+		{"daily keeps the newest of each day", snapshots, snapshotConfig{KeepDaily: 3}, []string{"next-mon-evening", "next-tue", "tue"}},
+		{"daily skips days without snapshots", snapshots, snapshotConfig{KeepDaily: 4}, []string{"mon-evening", "next-mon-evening", "next-tue", "tue"}},
+		{"weekly keeps the newest of each week", snapshots, snapshotConfig{KeepWeekly: 2}, []string{"next-tue", "tue"}},
+		{"last within daily", snapshots, snapshotConfig{KeepLast: 1, KeepDaily: 2}, []string{"next-mon-evening", "next-tue"}},
+		{"daily within weekly", snapshots, snapshotConfig{KeepLast: 1, KeepDaily: 2, KeepWeekly: 2}, []string{"next-mon-evening", "next-tue", "tue"}},
+		{"last beyond daily", snapshots, snapshotConfig{KeepLast: 3, KeepDaily: 1}, []string{"next-mon-evening", "next-mon-morning", "next-tue"}},
+		{"union of all rules", snapshots, snapshotConfig{KeepLast: 3, KeepDaily: 4, KeepWeekly: 1}, []string{"mon-evening", "next-mon-evening", "next-mon-morning", "next-tue", "tue"}},
+		{"ISO weeks across years", yearChange, snapshotConfig{KeepWeekly: 2}, []string{"fri", "sun"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for id := range getSnapshotsToKeep(test.snapshots, test.config) {
+				got = append(got, id)
+			}
+			sort.Strings(got)
+
+			if reflect.DeepEqual(got, test.want) == false {
+				t.Errorf("getSnapshotsToKeep() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadSnapshotChunk(t *testing.T) {
+	random := make([]byte, 3*snapshotChunkMaxSize)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := []struct {
+		name string
+		data []byte
+		// Sizes of the chunks, if they do not depend on the contents
+		want []int
+	}{
+		{"empty", nil, nil},
+		{"smaller than the minimum", random[:snapshotChunkMinSize-1], []int{snapshotChunkMinSize - 1}},
+		{"random", random, nil},
+		{"zeros up to the maximum", make([]byte, snapshotChunkMaxSize), []int{snapshotChunkMaxSize}},
+		{"zeros over the maximum", make([]byte, 2*snapshotChunkMaxSize+1), []int{snapshotChunkMaxSize, snapshotChunkMaxSize, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := bufio.NewReader(bytes.NewReader(test.data))
+			buffer := make([]byte, snapshotChunkMaxSize)
+
+			var joined []byte
+			var sizes []int
+			for {
+				chunk, err := readSnapshotChunk(reader, buffer)
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				joined = append(joined, chunk...)
+				sizes = append(sizes, len(chunk))
+			}
+
+			if bytes.Equal(joined, test.data) == false {
+				t.Fatalf("the chunks do not add up to the data")
+			}
+			for index, size := range sizes {
+				if size > snapshotChunkMaxSize || (size < snapshotChunkMinSize && index < len(sizes)-1) {
+					t.Errorf("chunk %v has %v bytes, want %v-%v", index, size, snapshotChunkMinSize, snapshotChunkMaxSize)
+				}
+			}
+			if test.want != nil && reflect.DeepEqual(sizes, test.want) == false {
+				t.Errorf("chunk sizes = %v, want %v", sizes, test.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotFileRoundTrip(t *testing.T) {
+	program := Program{userSnapshotsDir: t.TempDir()}
+	sourceDir := t.TempDir()
+
+	random := make([]byte, 3*snapshotChunkMaxSize+12345)
+	rand.New(rand.NewSource(2)).Read(random)
+
+	sizes := []int{
+		0,
+		1,
+		snapshotChunkMinSize - 1,
+		snapshotChunkMinSize,
+		snapshotChunkMinSize + 1,
+		snapshotChunkMaxSize - 1,
+		snapshotChunkMaxSize,
+		snapshotChunkMaxSize + 1,
+		len(random),
+	}
+
+	for _, size := range sizes {
+		filePath := filepath.Join(sourceDir, "file")
+		if err := os.WriteFile(filePath, random[:size], 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		hashes, _, _, err := storeSnapshotFile(filePath, program)
+		if err != nil {
+			t.Fatalf("storeSnapshotFile() of %v bytes failed: %v", size, err)
+		}
+
+		// Storing the same contents again adds nothing
+		if _, newChunks, newBytes, err := storeSnapshotFile(filePath, program); err != nil || newChunks != 0 || newBytes != 0 {
+			t.Errorf("storing %v bytes again wrote %v chunks (%v bytes), %v", size, newChunks, newBytes, err)
+		}
+
+		var output bytes.Buffer
+		err = writeSnapshotFileContents(&output, snapshotFile{Path: "file", Chunks: hashes}, program)
+		if err != nil {
+			t.Fatalf("writeSnapshotFileContents() of %v bytes failed: %v", size, err)
+		}
+		if bytes.Equal(output.Bytes(), random[:size]) == false {
+			t.Errorf("round trip of %v bytes gives %v different bytes", size, output.Len())
+		}
+	}
+}
+
+func TestWriteSnapshotFileContentsCorrupted(t *testing.T) {
+	program := Program{userSnapshotsDir: t.TempDir()}
+
+	filePath := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(filePath, []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hashes, _, _, err := storeSnapshotFile(filePath, program)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(getSnapshotChunkPath(hashes[0], program), []byte("altered!"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSnapshotFileContents(io.Discard, snapshotFile{Chunks: hashes}, program); err == nil {
+		t.Errorf("writeSnapshotFileContents() of a corrupted chunk succeeded, want an error")
+	}
+
+	os.Remove(getSnapshotChunkPath(hashes[0], program))
+	if err := writeSnapshotFileContents(io.Discard, snapshotFile{Chunks: hashes}, program); err == nil {
+		t.Errorf("writeSnapshotFileContents() of a missing chunk succeeded, want an error")
+	}
+}
+
+func TestPruneSnapshotsKeepsSharedChunks(t *testing.T) {
+	program := Program{userSnapshotsDir: t.TempDir()}
+	crate := Crate{name: "home"}
+	targetA := Target{name: "a", crate: crate}
+	targetB := Target{name: "b", crate: Crate{name: "work"}}
+
+	chunkOf := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	chunkExists := func(content string) bool {
+		_, err := os.Stat(getSnapshotChunkPath(chunkOf(content), program))
+		return err == nil
+	}
+
+	snapshot := func(target Target, files map[string]string) snapshotManifest {
+		t.Helper()
+
+		sourceDir := t.TempDir()
+		for name, content := range files {
+			if err := os.WriteFile(filepath.Join(sourceDir, name), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		manifest, _, err := createSnapshot(target, sourceDir, nil, snapshotReasonManual, program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return manifest
+	}
+
+	oldA := snapshot(targetA, map[string]string{"shared": "shared contents", "old": "only in the old snapshot of a"})
+	newA := snapshot(targetA, map[string]string{"new": "only in the new snapshot of a"})
+	snapshot(targetB, map[string]string{"shared": "shared contents"})
+
+	removed, err := pruneSnapshots(targetA, snapshotConfig{KeepLast: 1}, program)
+	if err != nil {
+		t.Fatalf("pruneSnapshots() failed: %v", err)
+	}
+	if reflect.DeepEqual(removed, []string{oldA.ID}) == false {
+		t.Errorf("pruneSnapshots() removed %v, want [%v]", removed, oldA.ID)
+	}
+
+	snapshots, err := listSnapshots(targetA, program)
+	if err != nil || len(snapshots) != 1 || snapshots[0].ID != newA.ID {
+		t.Errorf("snapshots of a after pruning = %v (%v), want [%v]", snapshots, err, newA.ID)
+	}
+
+	for content, want := range map[string]bool{
+		"shared contents":               true,
+		"only in the new snapshot of a": true,
+		"only in the old snapshot of a": false,
+	} {
+		if got := chunkExists(content); got != want {
+			t.Errorf("chunk of %q exists = %v, want %v", content, got, want)
+		}
+	}
+
+	// Without retention rules, nothing is removed
+	if removed, err := pruneSnapshots(targetA, snapshotConfig{}, program); err != nil || len(removed) > 0 {
+		t.Errorf("pruneSnapshots() without rules removed %v (%v)", removed, err)
+	}
+}
+
+func TestSnapshotExcludeRoundTrip(t *testing.T) {
+	program := Program{userSnapshotsDir: t.TempDir()}
+	target := Target{name: "a", crate: Crate{name: "home"}}
+
+	// An empty list is kept as such, and not replaced by the current patterns when restoring
+	for _, excludes := range [][]string{nil, {}, {"*.tmp", "cache/"}} {
+		created, _, err := createSnapshot(target, t.TempDir(), excludes, snapshotReasonManual, program)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		manifest, err := readSnapshot(target, created.ID, program)
+		if err != nil {
+			t.Fatalf("readSnapshot() failed: %v", err)
+		}
+
+		want := excludes
+		if want == nil {
+			want = []string{}
+		}
+		if reflect.DeepEqual(manifest.Exclude, want) == false {
+			t.Errorf("exclude patterns of a snapshot taken with %#v = %#v, want %#v", excludes, manifest.Exclude, want)
+		}
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	// External modules
+	ssh "golang.org/x/crypto/ssh"
+	agent "golang.org/x/crypto/ssh/agent"
+	terminal "golang.org/x/crypto/ssh/terminal"
+)
+
+//
+//// SSH AGENT
+//
+
+// The SSH agent is implemented in Go (golang.org/x/crypto/ssh/agent) and served by
+// a background synctropy process on a Unix socket inside the temporary directory
+// (usually $CRATE_TEMP_DIR). The agent shuts itself down when it is stopped with
+// 'utils sshagent-stop', when the temporary directory is removed at the end of the
+// crate transaction, or when the synctropy process that started it exits.
+
+const (
+	sshAgentPIDFileName    = "sshagent.pid"
+	sshAgentSockFileName   = "sshauth.sock"
+	sshAgentSocketFileName = "sshagent.socket"
+
+	sshAgentOwnerPIDEnvVar     = "SYNCTROPY_PID"
+	sshAgentPassphraseAttempts = 3
+	sshAgentStartTimeout       = 5 * time.Second
+	sshAgentStopTimeout        = 5 * time.Second
+	sshAgentWatchInterval      = 1 * time.Second
+)
+
+type sshAgentFiles struct {
+	pidFile    string
+	sockFile   string
+	socketPath string
+}
+
+func getSSHAgentFiles(tempDir string) sshAgentFiles {
+	return sshAgentFiles{
+		pidFile:    filepath.Join(tempDir, sshAgentPIDFileName),
+		sockFile:   filepath.Join(tempDir, sshAgentSockFileName),
+		socketPath: filepath.Join(tempDir, sshAgentSocketFileName),
+	}
+}
+
+func sshAgentRunning(tempDir string) bool {
+	_, err := os.Stat(getSSHAgentFiles(tempDir).pidFile)
+
+	return err == nil
+}
+
+// Reads a private key, asking for its passphrase when it is encrypted
+func loadSSHPrivateKey(privateKeyPath string, program Program) (interface{}, functionResponse) {
+	content, err := ioutil.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read private key -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	key, err := ssh.ParseRawPrivateKey(content)
+	if err == nil {
+		return key, functionResponse{exitCode: 0}
+	}
+
+	var passphraseMissingError *ssh.PassphraseMissingError
+	if errors.As(err, &passphraseMissingError) == false {
+		return nil, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to parse private key -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) == false {
+		return nil, functionResponse{
+			exitCode:    1,
+			message:     "Private key is encrypted, but standard input is not a terminal to ask for its passphrase",
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	for attempt := 1; attempt <= sshAgentPassphraseAttempts; attempt++ {
+		fmt.Print(returnText(fmt.Sprintf("Enter passphrase for %v: ", privateKeyPath), program.indentLevel+1))
+		passphrase, err := terminal.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return nil, functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to read passphrase -> " + err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(content, passphrase)
+		if err == nil {
+			return key, functionResponse{exitCode: 0}
+		}
+
+		if err == x509.IncorrectPasswordError {
+			showAttention(fmt.Sprintf("> Incorrect passphrase (%v/%v)", attempt, sshAgentPassphraseAttempts), program.indentLevel+1)
+			continue
+		}
+
+		return nil, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to decrypt private key -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	return nil, functionResponse{
+		exitCode:    1,
+		message:     "Too many incorrect passphrase attempts",
+		logLevel:    "error",
+		indentLevel: program.indentLevel + 1,
+	}
+}
+
+func utilsSSHAgentStart(privateKeyPath string, tempDir string, program Program) functionResponse {
+	files := getSSHAgentFiles(tempDir)
+
+	for _, file := range []string{files.pidFile, files.sockFile, files.socketPath} {
+		if _, err := os.Stat(file); err == nil {
+			return functionResponse{
+				exitCode:    1,
+				logLevel:    "error",
+				message:     fmt.Sprintf("File '%v' already found at %v. Please, stop the running agent or remove it manually.", filepath.Base(file), file),
+				indentLevel: program.indentLevel,
+			}
+		}
+	}
+
+	//
+	////
+	//
+
+	showInfo("Loading private key (a passphrase may be needed)", program.indentLevel)
+
+	key, response := loadSSHPrivateKey(privateKeyPath, program)
+	if response.exitCode != 0 {
+		return response
+	}
+
+	response = functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
+	}
+	handleFunctionResponse(response, true)
+
+	//
+	////
+	//
+
+	space()
+
+	showInfo("Starting SSH agent", program.indentLevel)
+
+	executable, err := os.Executable()
+	if err != nil {
+		executable = program.exec
+	}
+
+	serveArgs := []string{"utils", "sshagent-serve", tempDir}
+	if ownerPID := os.Getenv(sshAgentOwnerPIDEnvVar); ownerPID != "" {
+		serveArgs = append(serveArgs, "--owner-pid", ownerPID)
+	}
+
+	cmd := exec.Command(executable, serveArgs...)
+	// Detach the agent from the hook terminal, so that it survives the hook
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	err = cmd.Start()
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to start SSH agent -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	// Wait for the agent to be ready
+	deadline := time.Now().Add(sshAgentStartTimeout)
+	for sshAgentRunning(tempDir) == false {
+		select {
+		case err := <-exited:
+			message := "SSH agent exited unexpectedly"
+			if err != nil {
+				message += " -> " + err.Error()
+			}
+			return functionResponse{
+				exitCode:    1,
+				message:     message,
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		if time.Now().After(deadline) {
+			_ = cmd.Process.Signal(syscall.SIGTERM)
+			return functionResponse{
+				exitCode:    1,
+				message:     "Timed out waiting for the SSH agent to start",
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+	}
+
+	response = functionResponse{
+		exitCode:    0,
+		message:     fmt.Sprintf("Listening on %v (PID %v)", files.socketPath, cmd.Process.Pid),
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
+	}
+	handleFunctionResponse(response, true)
+
+	//
+	////
+	//
+
+	space()
+
+	showInfo("Adding private key to the agent", program.indentLevel)
+
+	conn, err := net.Dial("unix", files.socketPath)
+	if err != nil {
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to connect to SSH agent -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+	defer conn.Close()
+
+	err = agent.NewClient(conn).Add(agent.AddedKey{PrivateKey: key, Comment: privateKeyPath})
+	if err != nil {
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to add private key -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	err = ioutil.WriteFile(files.sockFile, []byte(files.socketPath), 0644)
+	if err != nil {
+		_ = cmd.Process.Signal(syscall.SIGTERM)
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to write to file %v -> %v", files.sockFile, err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	return functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
+	}
+}
+
+// Serves the agent until it is stopped, the temporary directory is removed or the
+// owner process exits. Runs in the background process started by 'sshagent-start'.
+func utilsSSHAgentServe(tempDir string, ownerPID int, program Program) functionResponse {
+	files := getSSHAgentFiles(tempDir)
+
+	// Only the current user may connect to the socket
+	syscall.Umask(0077)
+
+	listener, err := net.Listen("unix", files.socketPath)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to listen on socket -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	keyring := agent.NewKeyring()
+
+	shutdown := func() {
+		listener.Close()
+		keyring.RemoveAll()
+		for _, file := range []string{files.socketPath, files.sockFile, files.pidFile} {
+			_ = os.Remove(file)
+		}
+	}
+
+	err = ioutil.WriteFile(files.pidFile, []byte(strconv.Itoa(os.Getpid())), 0644)
+	if err != nil {
+		shutdown()
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to write to file %v -> %v", files.pidFile, err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}(conn)
+		}
+	}()
+
+	signal.Ignore(syscall.SIGHUP)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	ticker := time.NewTicker(sshAgentWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signals:
+			shutdown()
+			return functionResponse{exitCode: 0}
+		case <-ticker.C:
+			if _, err := os.Stat(files.socketPath); err != nil {
+				shutdown()
+				return functionResponse{exitCode: 0}
+			}
+
+			if ownerPID > 0 && syscall.Kill(ownerPID, 0) == syscall.ESRCH {
+				shutdown()
+				return functionResponse{exitCode: 0}
+			}
+		}
+	}
+}
+
+// Stops the agent running in the temporary directory and waits for it to clean up
+func stopSSHAgent(tempDir string, program Program) functionResponse {
+	files := getSSHAgentFiles(tempDir)
+
+	content, err := ioutil.ReadFile(files.pidFile)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read PID file -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	sshAgentPID, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Invalid PID file -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	err = syscall.Kill(sshAgentPID, syscall.SIGTERM)
+	if err != nil && err != syscall.ESRCH {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to stop SSH agent -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	// The agent removes its own files when it exits
+	deadline := time.Now().Add(sshAgentStopTimeout)
+	for sshAgentRunning(tempDir) == true && err != syscall.ESRCH && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	for _, file := range []string{files.socketPath, files.sockFile, files.pidFile} {
+		err := os.Remove(file)
+		if err != nil && os.IsNotExist(err) == false {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to remove file %v -> %v", file, err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+	}
+
+	return functionResponse{exitCode: 0}
+}
+
+func utilsSSHAgentStop(tempDir string, program Program) functionResponse {
+	showInfo("Stopping SSH agent", program.indentLevel)
+
+	response := stopSSHAgent(tempDir, incrementProgramIndentLevel(program, 1))
+	if response.exitCode != 0 {
+		return response
+	}
+
+	return functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
+	}
+}
+
+func utilsSSHAgentGetPID(tempDir string) {
+	content, _ := ioutil.ReadFile(getSSHAgentFiles(tempDir).pidFile)
+	sshAgentPid := string(content)
+
+	fmt.Println(sshAgentPid)
+}
+
+func utilsSSHAgentGetSock(tempDir string) {
+	content, _ := ioutil.ReadFile(getSSHAgentFiles(tempDir).sockFile)
+	sshAgentSock := string(content)
+
+	fmt.Println(sshAgentSock)
+}
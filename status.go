@@ -0,0 +1,182 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	// External modules
+)
+
+//
+//// HOOK STATUS PROTOCOL
+//
+
+// Hooks can report structured information back to synctropy by appending JSON
+// lines to the file pointed to by $SYNCTROPY_STATUS_FILE. Supported keys:
+//
+//	{"progress": 0.4}                            Progress of the hook (0 to 1)
+//	{"stat": "files_transferred", "value": 12}   Named statistic (last value wins)
+//	{"skip": "no changes"}                       The target was skipped (with a reason)
+//	{"warning": "..."}                           Warning to show in the run summary
+//	{"description": "..."}                       Description used by 'ls' hooks
+const hookStatusFileEnvVar = "SYNCTROPY_STATUS_FILE"
+
+type hookStat struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+type hookStatus struct {
+	Progress    *float64   `json:"progress,omitempty"`
+	Stats       []hookStat `json:"stats,omitempty"`
+	Skip        string     `json:"skip,omitempty"`
+	Warnings    []string   `json:"warnings,omitempty"`
+	Description string     `json:"description,omitempty"`
+}
+
+type hookStatusLine struct {
+	Progress    *float64 `json:"progress"`
+	Stat        *string  `json:"stat"`
+	Value       *float64 `json:"value"`
+	Skip        *string  `json:"skip"`
+	Warning     *string  `json:"warning"`
+	Description *string  `json:"description"`
+}
+
+// Creates an empty status file for a hook run
+func createHookStatusFile(program Program) (string, functionResponse) {
+	file, err := ioutil.TempFile("", program.name+"-status-*.jsonl")
+	if err != nil {
+		return "", functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to create hook status file -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	file.Close()
+
+	return file.Name(), functionResponse{exitCode: 0}
+}
+
+// Reads the status written by a hook. Lines that cannot be parsed are reported as
+// warnings instead of failing the hook.
+func readHookStatusFile(statusFilePath string) hookStatus {
+	var status hookStatus
+
+	file, err := os.Open(statusFilePath)
+	if err != nil {
+		return status
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var statusLine hookStatusLine
+		err := json.Unmarshal([]byte(line), &statusLine)
+		if err != nil {
+			status.Warnings = append(status.Warnings, fmt.Sprintf("Invalid status line %v -> %v", lineNumber, err.Error()))
+			continue
+		}
+
+		status.apply(statusLine)
+	}
+
+	return status
+}
+
+func (status *hookStatus) apply(line hookStatusLine) {
+	if line.Progress != nil {
+		progress := *line.Progress
+		status.Progress = &progress
+	}
+
+	if line.Stat != nil {
+		value := 0.0
+		if line.Value != nil {
+			value = *line.Value
+		}
+		status.setStat(*line.Stat, value)
+	}
+
+	if line.Skip != nil {
+		status.Skip = *line.Skip
+		if status.Skip == "" {
+			status.Skip = "no reason given"
+		}
+	}
+
+	if line.Warning != nil {
+		status.Warnings = append(status.Warnings, *line.Warning)
+	}
+
+	if line.Description != nil {
+		status.Description = *line.Description
+	}
+}
+
+func (status *hookStatus) setStat(name string, value float64) {
+	for i, stat := range status.Stats {
+		if stat.Name == name {
+			status.Stats[i].Value = value
+			return
+		}
+	}
+
+	status.Stats = append(status.Stats, hookStat{Name: name, Value: value})
+}
+
+// Merges the status of a later hook (or hook step) into this one
+func (status *hookStatus) merge(other hookStatus) {
+	if other.Progress != nil {
+		progress := *other.Progress
+		status.Progress = &progress
+	}
+
+	for _, stat := range other.Stats {
+		status.setStat(stat.Name, stat.Value)
+	}
+
+	if other.Skip != "" {
+		status.Skip = other.Skip
+	}
+
+	status.Warnings = append(status.Warnings, other.Warnings...)
+
+	if other.Description != "" {
+		status.Description = other.Description
+	}
+}
+
+// Shows the warnings and skip reason reported by a hook right after it finishes
+func showHookStatus(status hookStatus, program Program) {
+	for _, warning := range status.Warnings {
+		showAttention(fmt.Sprintf("> Warning: %v", warning), program.indentLevel+1)
+	}
+
+	if status.Skip != "" {
+		showAttention(fmt.Sprintf("> Skipped: %v", status.Skip), program.indentLevel+1)
+	}
+}
+
+func formatHookStats(stats []hookStat) string {
+	parts := make([]string, len(stats))
+	for i, stat := range stats {
+		parts[i] = fmt.Sprintf("%v=%v", stat.Name, stat.Value)
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	// External modules
 	cobra "github.com/spf13/cobra"
@@ -304,14 +305,20 @@ func main() {
 
 	var utilitySSHAgentStartCmd = &cobra.Command{
 		Use:   "sshagent-start <privateKeyPath> <tempDir>",
-		Short: "Starts an ssh-agent process and adds a private key",
-		Long: `The 'sshagent-start' command starts an ssh-agent process
-		and adds a private key to it.
+		Short: "Starts an SSH agent and adds a private key",
+		Long: `The 'sshagent-start' command starts an SSH agent and adds a
+		private key to it, asking for its passphrase if the key is encrypted.
+
+		The agent is built into synctropy (no 'ssh-agent' or 'ssh-add' executables
+		are needed) and runs in the background, listening on a socket inside the
+		temporary directory. It stops when 'sshagent-stop' is called, when the
+		temporary directory is removed at the end of the crate transaction, or when
+		the synctropy process running the hook exits.
 
 		Arguments:
 		1. privateKeyPath: The path to the private key that will be added to the
-		ssh-agent.
-		2. tempDir: The temporary directory in which the ssh-agent will create its
+		agent.
+		2. tempDir: The temporary directory in which the agent will create its
 		socket and pid files. For example, it can be set with environment variables
 		$CRATE_TEMP_DIR or $TARGET_TEMP_DIR.`,
 		Example: "utils sshagent-start '/path/to/my/key' $TARGET_TEMP_DIR",
@@ -322,13 +329,28 @@ func main() {
 		},
 	}
 
+	var sshAgentOwnerPID int
+
+	var utilitySSHAgentServeCmd = &cobra.Command{
+		Use:    "sshagent-serve <tempDir>",
+		Short:  "Serves the SSH agent (used internally by 'sshagent-start')",
+		Hidden: true,
+		Args:   cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			response := utilsSSHAgentServe(args[0], sshAgentOwnerPID, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+	utilitySSHAgentServeCmd.Flags().IntVarP(&sshAgentOwnerPID, "owner-pid", "", 0, "Stop the agent when this process exits")
+
 	var utilitySSHAgentStopCmd = &cobra.Command{
 		Use:   "sshagent-stop <tempDir>",
-		Short: "Stops the ssh-agent process",
-		Long: `The 'sshagent-stop' command stops the ssh-agent process.
+		Short: "Stops the SSH agent",
+		Long: `The 'sshagent-stop' command stops the SSH agent started by
+		'sshagent-start' and removes its socket and pid files.
 
 		Arguments:
-		1. tempDir: The directory where the ssh-agent created its socket and pid
+		1. tempDir: The directory where the agent created its socket and pid
 		files. For example, it can be set with environment variables $CRATE_TEMP_DIR or
 		$TARGET_TEMP_DIR.`,
 		Example: "utils sshagent-stop $TARGET_TEMP_DIR",
@@ -341,12 +363,12 @@ func main() {
 
 	var utilitySSHAgentGetPIDCmd = &cobra.Command{
 		Use:   "sshagent-getpid <tempDir>",
-		Short: "Get the process ID of the ssh-agent",
+		Short: "Get the process ID of the SSH agent",
 		Long: `The 'sshagent-getpid' command retrieves the process ID of
-		the ssh-agent.
+		the SSH agent.
 
 		Arguments:
-		1. tempDir: The directory where the ssh-agent created its pid file. For
+		1. tempDir: The directory where the agent created its pid file. For
 		example, it can be set with environment variables $CRATE_TEMP_DIR or
 		$TARGET_TEMP_DIR.`,
 		Example: "utils sshagent-getpid $TARGET_TEMP_DIR",
@@ -358,12 +380,12 @@ func main() {
 
 	var utilitySSHAgentGetSockCmd = &cobra.Command{
 		Use:   "sshagent-getsock <tempDir>",
-		Short: "Get the socket path of the ssh-agent",
+		Short: "Get the socket path of the SSH agent",
 		Long: `The 'sshagent-getsock' command retrieves the socket path
-		of the ssh-agent.
+		of the SSH agent.
 
 		Arguments:
-		1. tempDir: The directory where the ssh-agent created its socket file. For
+		1. tempDir: The directory where the agent created its socket file. For
 		example, it can be set with environment variables $CRATE_TEMP_DIR or
 		$TARGET_TEMP_DIR.`,
 		Example: "utils sshagent-getsock $TARGET_TEMP_DIR",
@@ -373,11 +395,67 @@ func main() {
 		},
 	}
 
+	var unisonRootIndex int
+	var unisonRootRsync bool
+
+	var utilityUnisonRootsCmd = &cobra.Command{
+		Use:   "unison-roots [profile]",
+		Short: "Print the roots of a unison profile",
+		Long: `The 'unison-roots' command prints the roots of a unison
+		profile, one per line. Included profiles ('include' and 'source') are
+		followed.
+
+		Arguments:
+		1. profile (optional): The path to the profile. When running in a target
+		hook, it defaults to $TARGET_DIR/unison/$TARGET_NAME.prf.`,
+		Example: "utils unison-roots --index 2 --rsync",
+		Args:    cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			profilePath := ""
+			if len(args) > 0 {
+				profilePath = args[0]
+			}
+
+			response := utilsUnisonRoots(profilePath, unisonRootIndex, unisonRootRsync, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+	utilityUnisonRootsCmd.Flags().IntVarP(&unisonRootIndex, "index", "n", 0, "Only print the root at this position (starting at 1)")
+	utilityUnisonRootsCmd.Flags().BoolVarP(&unisonRootRsync, "rsync", "r", false, "Print remote roots as 'host:path' (rsync/scp syntax)")
+
+	var utilityUnisonGetCmd = &cobra.Command{
+		Use:   "unison-get <key> [profile]",
+		Short: "Print the values of a unison profile preference",
+		Long: `The 'unison-get' command prints every value of a preference
+		of a unison profile (e.g. 'ignore', 'backup' or 'backuplocation'), one per
+		line. It exits with status 1 if the preference is not set.
+
+		Arguments:
+		1. key: The name of the preference.
+		2. profile (optional): The path to the profile. When running in a target
+		hook, it defaults to $TARGET_DIR/unison/$TARGET_NAME.prf.`,
+		Example: "utils unison-get ignore",
+		Args:    cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			profilePath := ""
+			if len(args) > 1 {
+				profilePath = args[1]
+			}
+
+			response := utilsUnisonGet(args[0], profilePath, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
 	//
 	////
 	//
 
 	var interactiveSelection bool
+	var selectionTags []string
+	var selectionGroups []string
+	var selectionMatches []string
+	var targetPaths []string
 	var notCreateTempDir bool
 	var notRemoveTempDir bool
 	var notPrintOutput bool
@@ -418,7 +496,7 @@ func main() {
 		Use:   "edit",
 		Short: "Edit crates",
 		Run: func(cmd *cobra.Command, args []string) {
-			selectedCrates, response := getSelectedCratesFromCLI(crateNames, allCrates, interactiveSelection, true, program)
+			selectedCrates, response := getSelectedCratesFromCLI(crateNames, selectionMatches, allCrates, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
 			response = cratesEdit(selectedCrates, program)
@@ -426,16 +504,19 @@ func main() {
 		},
 	}
 
-	cratesEditCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s)")
+	cratesEditCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s) or glob pattern(s)")
 	cratesEditCmd.Flags().BoolVarP(&allCrates, "all", "a", false, "Include all crates")
 	cratesEditCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	cratesEditCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	cratesEditCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	cratesEditCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
 	cratesEditCmd.Flags().SetInterspersed(false)
 
 	var cratesViewCmd = &cobra.Command{
 		Use:   "view",
 		Short: "View crates",
 		Run: func(cmd *cobra.Command, args []string) {
-			selectedCrates, response := getSelectedCratesFromCLI(crateNames, allCrates, interactiveSelection, true, program)
+			selectedCrates, response := getSelectedCratesFromCLI(crateNames, selectionMatches, allCrates, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
 			response = cratesView(selectedCrates, program)
@@ -443,16 +524,19 @@ func main() {
 		},
 	}
 
-	cratesViewCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s)")
+	cratesViewCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s) or glob pattern(s)")
 	cratesViewCmd.Flags().BoolVarP(&allCrates, "all", "a", false, "Include all crates")
 	cratesViewCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	cratesViewCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	cratesViewCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	cratesViewCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
 	cratesViewCmd.Flags().SetInterspersed(false)
 
 	var cratesEnableCmd = &cobra.Command{
 		Use:   "enable",
 		Short: "Enable crates",
 		Run: func(cmd *cobra.Command, args []string) {
-			selectedCrates, response := getSelectedCratesFromCLI(crateNames, allCrates, interactiveSelection, true, program)
+			selectedCrates, response := getSelectedCratesFromCLI(crateNames, selectionMatches, allCrates, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
 			response = cratesEnable(selectedCrates, program)
@@ -463,13 +547,16 @@ func main() {
 	cratesEnableCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
 	cratesEnableCmd.Flags().BoolVarP(&allCrates, "all", "a", false, "Include all crates")
 	cratesEnableCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	cratesEnableCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	cratesEnableCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	cratesEnableCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
 	cratesEnableCmd.Flags().SetInterspersed(false)
 
 	var cratesDisableCmd = &cobra.Command{
 		Use:   "disable",
 		Short: "Disable crates",
 		Run: func(cmd *cobra.Command, args []string) {
-			selectedCrates, response := getSelectedCratesFromCLI(crateNames, allCrates, interactiveSelection, true, program)
+			selectedCrates, response := getSelectedCratesFromCLI(crateNames, selectionMatches, allCrates, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
 			response = cratesDisable(selectedCrates, program)
@@ -480,6 +567,9 @@ func main() {
 	cratesDisableCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
 	cratesDisableCmd.Flags().BoolVarP(&allCrates, "all", "a", false, "Include all crates")
 	cratesDisableCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	cratesDisableCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	cratesDisableCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	cratesDisableCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
 	cratesDisableCmd.Flags().SetInterspersed(false)
 
 	var cratesCreateCmd = &cobra.Command{
@@ -495,7 +585,7 @@ func main() {
 		Use:   "rm",
 		Short: "Remove crates",
 		Run: func(cmd *cobra.Command, args []string) {
-			selectedCrates, response := getSelectedCratesFromCLI(crateNames, allCrates, interactiveSelection, true, program)
+			selectedCrates, response := getSelectedCratesFromCLI(crateNames, selectionMatches, allCrates, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
 			response = cratesRm(selectedCrates, program)
@@ -503,9 +593,12 @@ func main() {
 		},
 	}
 
-	cratesRmCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s)")
+	cratesRmCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s) or glob pattern(s)")
 	cratesRmCmd.Flags().BoolVarP(&allCrates, "all", "a", false, "Include all crates")
 	cratesRmCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	cratesRmCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	cratesRmCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	cratesRmCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
 	cratesRmCmd.Flags().SetInterspersed(false)
 
 	var cratesLsCmd = &cobra.Command{
@@ -526,7 +619,7 @@ func main() {
 		Use:   "ls",
 		Short: "List crate hooks",
 		Run: func(cmd *cobra.Command, args []string) {
-			selectedCrates, response := getSelectedCratesFromCLI(crateNames, allCrates, interactiveSelection, true, program)
+			selectedCrates, response := getSelectedCratesFromCLI(crateNames, selectionMatches, allCrates, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
 			response = cratesHooksLs(selectedCrates, program)
@@ -534,14 +627,18 @@ func main() {
 		},
 	}
 
-	cratesHooksLsCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s)")
+	cratesHooksLsCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s) or glob pattern(s)")
 	cratesHooksLsCmd.Flags().BoolVarP(&allCrates, "all", "a", false, "Include all crates")
 	cratesHooksLsCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	cratesHooksLsCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	cratesHooksLsCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	cratesHooksLsCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
 	cratesHooksLsCmd.Flags().SetInterspersed(false)
 
 	var cratesHooksRunCmd = &cobra.Command{
-		Use:   "run",
-		Short: "Run crate hook(s)",
+		Use:     "run [-- hook arguments...]",
+		Short:   "Run crate hook(s)",
+		Example: "crates hooks run -c crate -k restore -- 2024-01-01 --verbose",
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(crateHooksNames) == 0 {
 				response := functionResponse{
@@ -553,17 +650,23 @@ func main() {
 				handleFunctionResponse(response, true)
 			}
 
-			selectedCrates, response := getSelectedCratesFromCLI(crateNames, allCrates, interactiveSelection, true, program)
+			hookArgs, response := getHookArgsFromCLI(cmd, args, program)
 			handleFunctionResponse(response, true)
 
-			response = cratesRunHooks(selectedCrates, crateHooksNames, notCreateTempDir, notRemoveTempDir, notPrintOutput, false, true, program)
+			selectedCrates, response := getSelectedCratesFromCLI(crateNames, selectionMatches, allCrates, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			response = cratesRunHooks(selectedCrates, crateHooksNames, hookArgs, notCreateTempDir, notRemoveTempDir, notPrintOutput, false, true, program)
 			handleFunctionResponse(response, true)
 		},
 	}
 
-	cratesHooksRunCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s)")
+	cratesHooksRunCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s) or glob pattern(s)")
 	cratesHooksRunCmd.Flags().BoolVarP(&allCrates, "all", "a", false, "Include all crates")
 	cratesHooksRunCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	cratesHooksRunCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	cratesHooksRunCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	cratesHooksRunCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
 	cratesHooksRunCmd.Flags().StringSliceVarP(&crateHooksNames, "hook", "k", nil, "Hook(s) name(s)")
 	cratesHooksRunCmd.Flags().BoolVarP(&notCreateTempDir, "nocreatetemp", "", false, "Do not create the temporary directory before running the hook(s) (by default, it is created)")
 	cratesHooksRunCmd.Flags().BoolVarP(&notRemoveTempDir, "noremovetemp", "", false, "Do not remove the temporary directory after the hook(s) has/have finished running (by default, it is removed)")
@@ -577,11 +680,47 @@ func main() {
 	var targetHooksNames []string
 	var targetNames []string
 	var allTargets bool
+	var outputFormat string
+	var jsonOutput *os.File
+	var eventsFormat string
+	var eventsFile string
 
 	var targetsCmd = &cobra.Command{
 		Use:   "targets",
 		Short: "Manage targets",
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Keep the standard output for the JSON output
+			switch outputFormat {
+			case "", "text":
+			case "json":
+				jsonOutput = redirectDisplayToStderr()
+			default:
+				handleFunctionResponse(functionResponse{
+					exitCode:    1,
+					message:     fmt.Sprintf("Unknown output format '%v' (expected 'text' or 'json')", outputFormat),
+					logLevel:    "error",
+					indentLevel: program.indentLevel,
+				}, true)
+			}
+
+			// Report the lifecycle of the run as events (replacing the messages when
+			// written to the standard output)
+			if eventsFormat != "" || eventsFile != "" {
+				if eventsFormat == "" {
+					eventsFormat = eventsFormatJSON
+				}
+
+				err := startEventStream(eventsFormat, eventsFile)
+				if err != nil {
+					handleFunctionResponse(functionResponse{
+						exitCode:    1,
+						message:     fmt.Sprintf("Failed to start event stream -> " + err.Error()),
+						logLevel:    "error",
+						indentLevel: program.indentLevel,
+					}, true)
+				}
+			}
+
 			if userDataDir != "" {
 				showAttention(fmt.Sprintf("Running %v using a custom user data directory: %v", program.name, userDataDir), program.indentLevel)
 				space()
@@ -601,7 +740,7 @@ func main() {
 		Use:   "ls",
 		Short: "List targets",
 		Run: func(cmd *cobra.Command, args []string) {
-			selectedCrates, response := getSelectedCratesFromCLI(crateNames, allCrates, interactiveSelection, true, program)
+			selectedCrates, response := getSelectedCratesFromCLI(crateNames, selectionMatches, allCrates, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
 			response = targetsLs(selectedCrates, program)
@@ -609,99 +748,459 @@ func main() {
 		},
 	}
 
-	targetsLsCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s)")
+	targetsLsCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s) or glob pattern(s)")
 	targetsLsCmd.Flags().BoolVarP(&allCrates, "all", "a", false, "Include all crates")
 	targetsLsCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsLsCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsLsCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsLsCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
 	targetsLsCmd.Flags().SetInterspersed(false)
 
+	var targetsStatusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show the status of targets",
+		Run: func(cmd *cobra.Command, args []string) {
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			for _, selection := range selections {
+				response = targetsStatus(selection.crate, selection.targets, program)
+				handleFunctionResponse(response, true)
+			}
+		},
+	}
+
+	targetsStatusCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	targetsStatusCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
+	targetsStatusCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
+	targetsStatusCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsStatusCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsStatusCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsStatusCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsStatusCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	targetsStatusCmd.Flags().SetInterspersed(false)
+
+	var targetsSnapshotCmd = &cobra.Command{
+		Use:   "snapshot",
+		Short: "Create snapshots of targets",
+		Run: func(cmd *cobra.Command, args []string) {
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			for _, selection := range selections {
+				response = targetsSnapshot(selection.crate, selection.targets, program)
+				handleFunctionResponse(response, true)
+			}
+		},
+	}
+
+	targetsSnapshotCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	targetsSnapshotCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
+	targetsSnapshotCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
+	targetsSnapshotCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsSnapshotCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsSnapshotCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsSnapshotCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsSnapshotCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	targetsSnapshotCmd.Flags().SetInterspersed(false)
+
+	var targetsSnapshotsCmd = &cobra.Command{
+		Use:   "snapshots",
+		Short: "Manage target snapshots",
+	}
+
+	var targetsSnapshotsLsCmd = &cobra.Command{
+		Use:   "ls",
+		Short: "List target snapshots",
+		Run: func(cmd *cobra.Command, args []string) {
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			for _, selection := range selections {
+				response = targetsSnapshotsLs(selection.crate, selection.targets, program)
+				handleFunctionResponse(response, true)
+			}
+		},
+	}
+
+	targetsSnapshotsLsCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	targetsSnapshotsLsCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
+	targetsSnapshotsLsCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
+	targetsSnapshotsLsCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsSnapshotsLsCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsSnapshotsLsCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsSnapshotsLsCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsSnapshotsLsCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	targetsSnapshotsLsCmd.Flags().SetInterspersed(false)
+
+	var snapshotID string
+	var restoreDestination string
+	var restoreDelete bool
+
+	var targetsRestoreCmd = &cobra.Command{
+		Use:   "restore",
+		Short: "Restore a target snapshot",
+		Run: func(cmd *cobra.Command, args []string) {
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, false, interactiveSelection, selectionTags, selectionGroups, false, program)
+			handleFunctionResponse(response, true)
+
+			if len(selections) != 1 || len(selections[0].targets) != 1 {
+				handleFunctionResponse(functionResponse{
+					exitCode:    1,
+					message:     "A single target must be selected",
+					logLevel:    "error",
+					indentLevel: program.indentLevel,
+				}, true)
+			}
+
+			response = targetsRestore(selections[0].crate, selections[0].targets[0], snapshotID, restoreDestination, restoreDelete, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	targetsRestoreCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	targetsRestoreCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target name")
+	targetsRestoreCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsRestoreCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsRestoreCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsRestoreCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsRestoreCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	targetsRestoreCmd.Flags().StringVarP(&snapshotID, "snapshot", "s", "", "Snapshot ID (see 'targets snapshots ls')")
+	targetsRestoreCmd.Flags().StringVarP(&restoreDestination, "to", "", "", "Restore into this directory instead of the primary directory")
+	targetsRestoreCmd.Flags().BoolVarP(&restoreDelete, "delete", "", false, "Remove files that are not in the snapshot")
+	targetsRestoreCmd.MarkFlagRequired("snapshot")
+	targetsRestoreCmd.Flags().SetInterspersed(false)
+
+	var targetsRollbackCmd = &cobra.Command{
+		Use:   "rollback",
+		Short: "Undo the last sync of targets (restoring their pre-sync snapshot)",
+		Run: func(cmd *cobra.Command, args []string) {
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			for _, selection := range selections {
+				response = targetsRollback(selection.crate, selection.targets, program)
+				handleFunctionResponse(response, true)
+			}
+		},
+	}
+
+	targetsRollbackCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	targetsRollbackCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
+	targetsRollbackCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
+	targetsRollbackCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsRollbackCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsRollbackCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsRollbackCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsRollbackCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	targetsRollbackCmd.Flags().SetInterspersed(false)
+
+	var diffPatch bool
+	var diffMaxPatchSize int64
+
+	var targetsDiffCmd = &cobra.Command{
+		Use:   "diff",
+		Short: "Show what the next sync of targets would change",
+		Run: func(cmd *cobra.Command, args []string) {
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			options := diffOptions{
+				patch:        diffPatch,
+				maxPatchSize: diffMaxPatchSize,
+			}
+
+			response = targetsDiff(selections, options, jsonOutput, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	targetsDiffCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	targetsDiffCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
+	targetsDiffCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
+	targetsDiffCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsDiffCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsDiffCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsDiffCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsDiffCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	targetsDiffCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format (text or json)")
+	targetsDiffCmd.Flags().BoolVarP(&diffPatch, "patch", "p", false, "Include the unified diff of modified text files (built-in engines only)")
+	targetsDiffCmd.Flags().Int64VarP(&diffMaxPatchSize, "max-diff-size", "", 64*1024, "Maximum size (in bytes) of files shown with --patch")
+	targetsDiffCmd.Flags().SetInterspersed(false)
+
+	var verifyAlgorithm string
+	var verifyJobs int
+	var verifyFull bool
+
+	var targetsVerifyCmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Verify that the roots of targets match",
+		Run: func(cmd *cobra.Command, args []string) {
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			options := verifyOptions{
+				algorithm: verifyAlgorithm,
+				jobs:      verifyJobs,
+				full:      verifyFull,
+			}
+
+			for _, selection := range selections {
+				response = targetsVerify(selection.crate, selection.targets, options, program)
+				handleFunctionResponse(response, true)
+			}
+		},
+	}
+
+	targetsVerifyCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	targetsVerifyCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
+	targetsVerifyCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
+	targetsVerifyCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsVerifyCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsVerifyCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsVerifyCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsVerifyCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	targetsVerifyCmd.Flags().StringVarP(&verifyAlgorithm, "algorithm", "", "", "Hash algorithm: sha1, sha256, sha512 or blake2b (default: sha256, or the one in the target configuration)")
+	targetsVerifyCmd.Flags().IntVarP(&verifyJobs, "jobs", "j", 0, "Number of files hashed in parallel (default: number of CPUs)")
+	targetsVerifyCmd.Flags().BoolVarP(&verifyFull, "full", "", false, "Hash every file again, ignoring the manifest")
+	targetsVerifyCmd.Flags().SetInterspersed(false)
+
+	var archivePath string
+	var archiveIdentityFile string
+
+	var targetsArchiveCmd = &cobra.Command{
+		Use:   "archive",
+		Short: "Create encrypted archives of targets",
+		Run: func(cmd *cobra.Command, args []string) {
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			for _, selection := range selections {
+				response = targetsArchive(selection.crate, selection.targets, archivePath, program)
+				handleFunctionResponse(response, true)
+			}
+		},
+	}
+
+	targetsArchiveCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	targetsArchiveCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
+	targetsArchiveCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
+	targetsArchiveCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsArchiveCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsArchiveCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsArchiveCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsArchiveCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	targetsArchiveCmd.Flags().StringVarP(&archivePath, "output", "o", "", "Archive file (single target only)")
+	targetsArchiveCmd.Flags().SetInterspersed(false)
+
+	var targetsUnarchiveCmd = &cobra.Command{
+		Use:   "unarchive",
+		Short: "Extract an encrypted target archive",
+		Run: func(cmd *cobra.Command, args []string) {
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, false, interactiveSelection, selectionTags, selectionGroups, false, program)
+			handleFunctionResponse(response, true)
+
+			if len(selections) != 1 || len(selections[0].targets) != 1 {
+				handleFunctionResponse(functionResponse{
+					exitCode:    1,
+					message:     "A single target must be selected",
+					logLevel:    "error",
+					indentLevel: program.indentLevel,
+				}, true)
+			}
+
+			response = targetsUnarchive(selections[0].crate, selections[0].targets[0], archivePath, archiveIdentityFile, restoreDestination, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	targetsUnarchiveCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	targetsUnarchiveCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target name")
+	targetsUnarchiveCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsUnarchiveCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsUnarchiveCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsUnarchiveCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsUnarchiveCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	targetsUnarchiveCmd.Flags().StringVarP(&archivePath, "file", "f", "", "Archive file")
+	targetsUnarchiveCmd.Flags().StringVarP(&archiveIdentityFile, "identity", "", "", "Identity file (instead of the one in the crate configuration)")
+	targetsUnarchiveCmd.Flags().StringVarP(&restoreDestination, "to", "", "", "Extract into this directory instead of the primary directory")
+	targetsUnarchiveCmd.MarkFlagRequired("file")
+	targetsUnarchiveCmd.Flags().SetInterspersed(false)
+
 	var targetsEditCmd = &cobra.Command{
 		Use:   "edit",
 		Short: "Edit targets",
 		Run: func(cmd *cobra.Command, args []string) {
-			crate, selectedTargets, response := getSelectedTargetsFromCLI(crateName, targetNames, allTargets, interactiveSelection, true, program)
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
-			response = targetsEdit(crate, selectedTargets, program)
-			handleFunctionResponse(response, true)
+			for _, selection := range selections {
+				response = targetsEdit(selection.crate, selection.targets, program)
+				handleFunctionResponse(response, true)
+			}
 		},
 	}
 
 	targetsEditCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
-	targetsEditCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s)")
+	targetsEditCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
 	targetsEditCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
 	targetsEditCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsEditCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsEditCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsEditCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsEditCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
 	targetsEditCmd.Flags().SetInterspersed(false)
 
 	var targetsViewCmd = &cobra.Command{
 		Use:   "view",
 		Short: "View targets",
 		Run: func(cmd *cobra.Command, args []string) {
-			crate, selectedTargets, response := getSelectedTargetsFromCLI(crateName, targetNames, allTargets, interactiveSelection, true, program)
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
-			response = targetsView(crate, selectedTargets, program)
-			handleFunctionResponse(response, true)
+			for _, selection := range selections {
+				response = targetsView(selection.crate, selection.targets, program)
+				handleFunctionResponse(response, true)
+			}
 		},
 	}
 
 	targetsViewCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
-	targetsViewCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s)")
+	targetsViewCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
 	targetsViewCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
 	targetsViewCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsViewCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsViewCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsViewCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsViewCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
 	targetsViewCmd.Flags().SetInterspersed(false)
 
+	var syncViaDaemonAPI bool
+
 	var targetsSyncCmd = &cobra.Command{
 		Use:   "sync",
 		Short: "Sync targets",
 		Run: func(cmd *cobra.Command, args []string) {
-			crate, selectedTargets, response := getSelectedTargetsFromCLI(crateName, targetNames, allTargets, interactiveSelection, true, program)
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
-			response = targetsSync(crate, selectedTargets, program)
-			handleFunctionResponse(response, true)
+			// The crates are synced one after the other, even if one of them fails
+			exitCode := 0
+			for _, selection := range selections {
+				if syncViaDaemonAPI == true {
+					response = syncViaDaemon(selection.crate, selection.targets, program)
+				} else {
+					response = targetsSync(selection.crate, selection.targets, program)
+				}
+				handleFunctionResponse(response, false)
+
+				// Failed hooks are reported by the sync itself
+				if response.exitCode != 0 {
+					exitCode = response.exitCode
+				}
+			}
+
+			if exitCode != 0 {
+				space()
+
+				finishProgram(exitCode)
+			}
 		},
 	}
 
 	targetsSyncCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
-	targetsSyncCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s)")
+	targetsSyncCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
 	targetsSyncCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
 	targetsSyncCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsSyncCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsSyncCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsSyncCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsSyncCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	targetsSyncCmd.Flags().BoolVarP(&syncViaDaemonAPI, "via-daemon", "", false, "Let the running daemon sync the targets (and show its output)")
+	targetsSyncCmd.Flags().StringVarP(&eventsFormat, "events", "", "", "Report the lifecycle of the run as events (json), instead of the messages")
+	targetsSyncCmd.Flags().StringVarP(&eventsFile, "events-file", "", "", "Write the events to a file (keeping the messages)")
 	targetsSyncCmd.Flags().SetInterspersed(false)
 
+	var watchDebounce time.Duration
+
+	var targetsWatchCmd = &cobra.Command{
+		Use:   "watch",
+		Short: "Sync targets when their files change",
+		Run: func(cmd *cobra.Command, args []string) {
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			if len(selections) != 1 {
+				handleFunctionResponse(functionResponse{
+					exitCode:    1,
+					message:     "The targets of a single crate must be selected",
+					logLevel:    "error",
+					indentLevel: program.indentLevel,
+				}, true)
+			}
+
+			response = targetsWatch(selections[0].crate, selections[0].targets, watchDebounce, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	targetsWatchCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	targetsWatchCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
+	targetsWatchCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
+	targetsWatchCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsWatchCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsWatchCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsWatchCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsWatchCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	targetsWatchCmd.Flags().DurationVarP(&watchDebounce, "debounce", "", 2*time.Second, "Time without changes before syncing a target")
+	targetsWatchCmd.Flags().SetInterspersed(false)
+
 	var targetsEnableCmd = &cobra.Command{
 		Use:   "enable",
 		Short: "Enable targets",
 		Run: func(cmd *cobra.Command, args []string) {
-			crate, selectedTargets, response := getSelectedTargetsFromCLI(crateName, targetNames, allTargets, interactiveSelection, true, program)
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
-			response = targetsEnable(crate, selectedTargets, program)
-			handleFunctionResponse(response, true)
+			for _, selection := range selections {
+				response = targetsEnable(selection.crate, selection.targets, program)
+				handleFunctionResponse(response, true)
+			}
 		},
 	}
 
 	targetsEnableCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
-	targetsEnableCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s)")
+	targetsEnableCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
 	targetsEnableCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
 	targetsEnableCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsEnableCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsEnableCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsEnableCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsEnableCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
 	targetsEnableCmd.Flags().SetInterspersed(false)
 
 	var targetsDisableCmd = &cobra.Command{
 		Use:   "disable",
 		Short: "Disable targets",
 		Run: func(cmd *cobra.Command, args []string) {
-			crate, selectedTargets, response := getSelectedTargetsFromCLI(crateName, targetNames, allTargets, interactiveSelection, true, program)
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
-			response = targetsDisable(crate, selectedTargets, program)
-			handleFunctionResponse(response, true)
+			for _, selection := range selections {
+				response = targetsDisable(selection.crate, selection.targets, program)
+				handleFunctionResponse(response, true)
+			}
 		},
 	}
 
 	targetsDisableCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
-	targetsDisableCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s)")
+	targetsDisableCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
 	targetsDisableCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
 	targetsDisableCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsDisableCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsDisableCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsDisableCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsDisableCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
 	targetsDisableCmd.Flags().SetInterspersed(false)
 
 	var targetsCreateCmd = &cobra.Command{
@@ -717,18 +1216,24 @@ func main() {
 		Use:   "rm",
 		Short: "Remove targets",
 		Run: func(cmd *cobra.Command, args []string) {
-			crate, selectedTargets, response := getSelectedTargetsFromCLI(crateName, targetNames, allTargets, interactiveSelection, true, program)
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
-			response = targetsRm(crate, selectedTargets, program)
-			handleFunctionResponse(response, true)
+			for _, selection := range selections {
+				response = targetsRm(selection.crate, selection.targets, program)
+				handleFunctionResponse(response, true)
+			}
 		},
 	}
 
 	targetsRmCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
-	targetsRmCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s)")
+	targetsRmCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
 	targetsRmCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
 	targetsRmCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsRmCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsRmCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsRmCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsRmCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
 	targetsRmCmd.Flags().SetInterspersed(false)
 
 	var targetsHooksCmd = &cobra.Command{
@@ -740,23 +1245,30 @@ func main() {
 		Use:   "ls",
 		Short: "List target hooks",
 		Run: func(cmd *cobra.Command, args []string) {
-			crate, selectedTargets, response := getSelectedTargetsFromCLI(crateName, targetNames, allTargets, interactiveSelection, true, program)
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
 
-			response = targetsHooksLs(crate, selectedTargets, program)
-			handleFunctionResponse(response, true)
+			for _, selection := range selections {
+				response = targetsHooksLs(selection.crate, selection.targets, program)
+				handleFunctionResponse(response, true)
+			}
 		},
 	}
 
 	targetsHooksLsCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
-	targetsHooksLsCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s)")
+	targetsHooksLsCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
 	targetsHooksLsCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
 	targetsHooksLsCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsHooksLsCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsHooksLsCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsHooksLsCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsHooksLsCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
 	targetsHooksLsCmd.Flags().SetInterspersed(false)
 
 	var targetsHooksRunCmd = &cobra.Command{
-		Use:   "run",
-		Short: "Run target hook(s)",
+		Use:     "run [-- hook arguments...]",
+		Short:   "Run target hook(s)",
+		Example: "targets hooks run -c crate -t target -k restore -- 2024-01-01 --verbose",
 		Run: func(cmd *cobra.Command, args []string) {
 			if len(targetHooksNames) == 0 {
 				response := functionResponse{
@@ -768,18 +1280,27 @@ func main() {
 				handleFunctionResponse(response, true)
 			}
 
-			crate, selectedTargets, response := getSelectedTargetsFromCLI(crateName, targetNames, allTargets, interactiveSelection, true, program)
+			hookArgs, response := getHookArgsFromCLI(cmd, args, program)
 			handleFunctionResponse(response, true)
 
-			response = targetsRunHooks(crate, selectedTargets, targetHooksNames, cratePreHooks, cratePostHooks, notCreateTempDir, notRemoveTempDir, notPrintOutput, false, true, program)
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
 			handleFunctionResponse(response, true)
+
+			for _, selection := range selections {
+				response = targetsRunHooks(selection.crate, selection.targets, targetHooksNames, hookArgs, cratePreHooks, cratePostHooks, notCreateTempDir, notRemoveTempDir, notPrintOutput, false, true, program)
+				handleFunctionResponse(response, true)
+			}
 		},
 	}
 
 	targetsHooksRunCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
-	targetsHooksRunCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s)")
+	targetsHooksRunCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
 	targetsHooksRunCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
 	targetsHooksRunCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsHooksRunCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsHooksRunCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsHooksRunCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsHooksRunCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
 	targetsHooksRunCmd.Flags().StringSliceVarP(&targetHooksNames, "hook", "k", nil, "Hook(s) name(s)")
 	targetsHooksRunCmd.Flags().BoolVarP(&notCreateTempDir, "nocreatetemp", "", false, "Do not create the temporary directory before running the hook(s) (by default, it is created)")
 	targetsHooksRunCmd.Flags().BoolVarP(&notRemoveTempDir, "noremovetemp", "n", false, "Do not remove the temporary directory after the hook(s) has/have finished running (by default, it is removed)")
@@ -788,6 +1309,265 @@ func main() {
 	targetsHooksRunCmd.Flags().BoolVarP(&notPrintOutput, "quiet", "q", false, "Do not print command output (silent)")
 	targetsHooksRunCmd.Flags().SetInterspersed(false)
 
+	var targetsHooksOverrideCmd = &cobra.Command{
+		Use:   "override",
+		Short: "Copy inherited target hook(s) into the target to customize them",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(targetHooksNames) == 0 {
+				response := functionResponse{
+					exitCode:    1,
+					logLevel:    "error",
+					message:     fmt.Sprintf("Flag '--hook/-k' should be specified"),
+					indentLevel: program.indentLevel,
+				}
+				handleFunctionResponse(response, true)
+			}
+
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			for _, selection := range selections {
+				response = targetsHooksOverride(selection.crate, selection.targets, targetHooksNames, program)
+				handleFunctionResponse(response, true)
+			}
+		},
+	}
+
+	targetsHooksOverrideCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	targetsHooksOverrideCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
+	targetsHooksOverrideCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Include all targets")
+	targetsHooksOverrideCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	targetsHooksOverrideCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	targetsHooksOverrideCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	targetsHooksOverrideCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	targetsHooksOverrideCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	targetsHooksOverrideCmd.Flags().StringSliceVarP(&targetHooksNames, "hook", "k", nil, "Hook(s) name(s)")
+	targetsHooksOverrideCmd.Flags().SetInterspersed(false)
+
+	//
+	//// DOCTOR
+	//
+
+	var doctorCmd = &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the configuration of crates and targets",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if userDataDir != "" {
+				showAttention(fmt.Sprintf("Running %v using a custom user data directory: %v", program.name, userDataDir), program.indentLevel)
+				space()
+
+				program = initializeDefaultProgram(userDataDir)
+			}
+
+			// Verify user data directory
+			response := verifyUserDataDirectory(true, program)
+			handleFunctionResponse(response, true)
+
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			selectedCrates, response := getSelectedCratesFromCLI(crateNames, selectionMatches, allCrates, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			response = doctor(selectedCrates, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	doctorCmd.Flags().StringSliceVarP(&crateNames, "crate", "c", nil, "Crate(s) name(s) or glob pattern(s)")
+	doctorCmd.Flags().BoolVarP(&allCrates, "all", "a", false, "Include all crates")
+	doctorCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	doctorCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	doctorCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	doctorCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	doctorCmd.Flags().SetInterspersed(false)
+
+	var daemonListOnly bool
+
+	var daemonCmd = &cobra.Command{
+		Use:   "daemon",
+		Short: "Run the scheduled syncs of crates and targets",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if userDataDir != "" {
+				showAttention(fmt.Sprintf("Running %v using a custom user data directory: %v", program.name, userDataDir), program.indentLevel)
+				space()
+
+				program = initializeDefaultProgram(userDataDir)
+			}
+
+			// Verify user data directory
+			response := verifyUserDataDirectory(true, program)
+			handleFunctionResponse(response, true)
+
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			response := daemon(daemonListOnly, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	daemonCmd.Flags().BoolVarP(&daemonListOnly, "list", "l", false, "Only list the schedules and their next runs")
+
+	var metricsWrite bool
+
+	var metricsCmd = &cobra.Command{
+		Use:   "metrics",
+		Short: "Print the metrics of the targets (Prometheus text format)",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Keep the standard output for the metrics
+			if metricsWrite == false {
+				redirectDisplayToStderr()
+			}
+
+			if userDataDir != "" {
+				showAttention(fmt.Sprintf("Running %v using a custom user data directory: %v", program.name, userDataDir), program.indentLevel)
+				space()
+
+				program = initializeDefaultProgram(userDataDir)
+			}
+
+			// Verify user data directory
+			response := verifyUserDataDirectory(true, program)
+			handleFunctionResponse(response, true)
+
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			response := metricsShow(metricsWrite, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	metricsCmd.Flags().BoolVarP(&metricsWrite, "write", "w", false, "Write the metrics to the textfile of the global configuration")
+
+	var tuiCmd = &cobra.Command{
+		Use:   "tui",
+		Short: "Browse, sync and manage crates and targets in a terminal dashboard",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if userDataDir != "" {
+				showAttention(fmt.Sprintf("Running %v using a custom user data directory: %v", program.name, userDataDir), program.indentLevel)
+				space()
+
+				program = initializeDefaultProgram(userDataDir)
+			}
+
+			// Verify user data directory
+			response := verifyUserDataDirectory(true, program)
+			handleFunctionResponse(response, true)
+
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			response := tui(program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	var scheduleEvery string
+	var scheduleCron string
+	var scheduleNoEnable bool
+	var crontabOutput *os.File
+
+	var scheduleCmd = &cobra.Command{
+		Use:   "schedule",
+		Short: "Manage the systemd timers (or crontab lines) syncing crates and targets",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Keep the standard output for the crontab lines
+			if cmd.Name() == "crontab" {
+				crontabOutput = redirectDisplayToStderr()
+			}
+
+			if userDataDir != "" {
+				showAttention(fmt.Sprintf("Running %v using a custom user data directory: %v", program.name, userDataDir), program.indentLevel)
+				space()
+
+				program = initializeDefaultProgram(userDataDir)
+			}
+
+			// Verify user data directory
+			response := verifyUserDataDirectory(true, program)
+			handleFunctionResponse(response, true)
+
+			return nil
+		},
+	}
+
+	var scheduleInstallCmd = &cobra.Command{
+		Use:   "install",
+		Short: "Install a systemd user timer syncing a crate (--all) or each target",
+		Run: func(cmd *cobra.Command, args []string) {
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			for _, selection := range selections {
+				response = scheduleInstall(selection.crate, selection.targets, allTargets, scheduleEvery, scheduleNoEnable, program)
+				handleFunctionResponse(response, true)
+			}
+		},
+	}
+
+	scheduleInstallCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	scheduleInstallCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
+	scheduleInstallCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Sync the whole crate (a single timer)")
+	scheduleInstallCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	scheduleInstallCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	scheduleInstallCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	scheduleInstallCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	scheduleInstallCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	scheduleInstallCmd.Flags().StringVarP(&scheduleEvery, "every", "", "", "Interval between the syncs (e.g. 30m, 1h, 24h)")
+	scheduleInstallCmd.Flags().BoolVarP(&scheduleNoEnable, "no-enable", "", false, "Only write the units (do not enable the timers)")
+	scheduleInstallCmd.Flags().SetInterspersed(false)
+
+	var scheduleLsCmd = &cobra.Command{
+		Use:   "ls",
+		Short: "List the installed timers",
+		Run: func(cmd *cobra.Command, args []string) {
+			response := scheduleLs(program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	var scheduleRmCmd = &cobra.Command{
+		Use:   "rm",
+		Short: "Remove the timer of a crate (or of its targets)",
+		Run: func(cmd *cobra.Command, args []string) {
+			response := scheduleRm(crateName, targetNames, allTargets, program)
+			handleFunctionResponse(response, true)
+		},
+	}
+
+	scheduleRmCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	scheduleRmCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
+	scheduleRmCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Remove all the timers of the crate")
+	scheduleRmCmd.Flags().SetInterspersed(false)
+
+	var scheduleCrontabCmd = &cobra.Command{
+		Use:   "crontab",
+		Short: "Print crontab lines syncing a crate (--all) or each target",
+		Run: func(cmd *cobra.Command, args []string) {
+			selections, response := getSelectedTargetsFromCLI(crateName, targetNames, targetPaths, selectionMatches, allTargets, interactiveSelection, selectionTags, selectionGroups, true, program)
+			handleFunctionResponse(response, true)
+
+			for _, selection := range selections {
+				response = scheduleCrontab(selection.crate, selection.targets, allTargets, scheduleEvery, scheduleCron, crontabOutput, program)
+				handleFunctionResponse(response, true)
+			}
+		},
+	}
+
+	scheduleCrontabCmd.Flags().StringVarP(&crateName, "crate", "c", "", "Crate name")
+	scheduleCrontabCmd.Flags().StringSliceVarP(&targetNames, "target", "t", nil, "Target(s) name(s) or glob pattern(s)")
+	scheduleCrontabCmd.Flags().BoolVarP(&allTargets, "all", "a", false, "Sync the whole crate (a single line)")
+	scheduleCrontabCmd.Flags().BoolVarP(&interactiveSelection, "interactive", "i", false, "Interactive selection")
+	scheduleCrontabCmd.Flags().StringSliceVarP(&selectionTags, "tag", "", nil, "Select by tag (prefix with '!' to exclude)")
+	scheduleCrontabCmd.Flags().StringSliceVarP(&selectionGroups, "group", "g", nil, "Select the crates or targets of a group (from the global configuration)")
+	scheduleCrontabCmd.Flags().StringArrayVarP(&selectionMatches, "match", "", nil, "Select the names matching a regular expression")
+	scheduleCrontabCmd.Flags().StringSliceVarP(&targetPaths, "target-path", "T", nil, "Select targets across crates by 'crate/target' pattern (e.g. 'home/*')")
+	scheduleCrontabCmd.Flags().StringVarP(&scheduleEvery, "every", "", "", "Interval between the syncs (e.g. 30m, 1h, 24h)")
+	scheduleCrontabCmd.Flags().StringVarP(&scheduleCron, "cron", "", "", "Cron expression (instead of an interval)")
+	scheduleCrontabCmd.Flags().SetInterspersed(false)
+
 	//
 	////
 	//
@@ -799,6 +1579,16 @@ func main() {
 	rootCmd.AddCommand(showVersionCmd)
 	rootCmd.AddCommand(userInitCmd)
 	rootCmd.AddCommand(docsCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(daemonCmd)
+	rootCmd.AddCommand(scheduleCmd)
+	rootCmd.AddCommand(metricsCmd)
+	rootCmd.AddCommand(tuiCmd)
+
+	scheduleCmd.AddCommand(scheduleInstallCmd)
+	scheduleCmd.AddCommand(scheduleLsCmd)
+	scheduleCmd.AddCommand(scheduleRmCmd)
+	scheduleCmd.AddCommand(scheduleCrontabCmd)
 
 	docsCmd.AddCommand(docsGenerateCmd)
 
@@ -810,9 +1600,12 @@ func main() {
 	utilitiesCmd.AddCommand(utilityHrCmd)
 	utilitiesCmd.AddCommand(utilityConfirmCmd)
 	utilitiesCmd.AddCommand(utilitySSHAgentStartCmd)
+	utilitiesCmd.AddCommand(utilitySSHAgentServeCmd)
 	utilitiesCmd.AddCommand(utilitySSHAgentStopCmd)
 	utilitiesCmd.AddCommand(utilitySSHAgentGetPIDCmd)
 	utilitiesCmd.AddCommand(utilitySSHAgentGetSockCmd)
+	utilitiesCmd.AddCommand(utilityUnisonRootsCmd)
+	utilitiesCmd.AddCommand(utilityUnisonGetCmd)
 
 	cratesCmd.AddCommand(cratesEditCmd)
 	cratesCmd.AddCommand(cratesViewCmd)
@@ -829,15 +1622,28 @@ func main() {
 	targetsCmd.AddCommand(targetsEditCmd)
 	targetsCmd.AddCommand(targetsViewCmd)
 	targetsCmd.AddCommand(targetsSyncCmd)
+	targetsCmd.AddCommand(targetsWatchCmd)
 	targetsCmd.AddCommand(targetsEnableCmd)
 	targetsCmd.AddCommand(targetsDisableCmd)
 	targetsCmd.AddCommand(targetsCreateCmd)
 	targetsCmd.AddCommand(targetsRmCmd)
 	targetsCmd.AddCommand(targetsLsCmd)
+	targetsCmd.AddCommand(targetsStatusCmd)
+	targetsCmd.AddCommand(targetsDiffCmd)
+	targetsCmd.AddCommand(targetsVerifyCmd)
+	targetsCmd.AddCommand(targetsSnapshotCmd)
+	targetsCmd.AddCommand(targetsSnapshotsCmd)
+	targetsCmd.AddCommand(targetsRestoreCmd)
+	targetsCmd.AddCommand(targetsRollbackCmd)
+	targetsCmd.AddCommand(targetsArchiveCmd)
+	targetsCmd.AddCommand(targetsUnarchiveCmd)
 	targetsCmd.AddCommand(targetsHooksCmd)
 
+	targetsSnapshotsCmd.AddCommand(targetsSnapshotsLsCmd)
+
 	targetsHooksCmd.AddCommand(targetsHooksRunCmd)
 	targetsHooksCmd.AddCommand(targetsHooksLsCmd)
+	targetsHooksCmd.AddCommand(targetsHooksOverrideCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		showError("Error: "+err.Error(), program.indentLevel)
@@ -27,28 +27,29 @@ type Target struct {
 	hooksDir     string
 	tempDir      string
 	disabledPath string
+	configPath   string
 	environment  map[string]string
 }
 
-func getSelectedTargetsFromCLI(crateName string, targetNames []string, allTargets bool, interactiveSelection bool, multiple bool, program Program) (Crate, []Target, functionResponse) {
+func getSelectedTargetsFromCLI(crateName string, targetNames []string, targetPaths []string, matches []string, allTargets bool, interactiveSelection bool, tags []string, groups []string, multiple bool, program Program) ([]targetSelection, functionResponse) {
 	var selectedCrate Crate
 	var selectedTargets []Target
 
-	if crateName == "" && interactiveSelection == false {
-		return Crate{}, []Target{}, functionResponse{
+	if crateName == "" && interactiveSelection == false && tags == nil && groups == nil && targetPaths == nil && matches == nil {
+		return []targetSelection{}, functionResponse{
 			exitCode:    1,
 			logLevel:    "error",
-			message:     fmt.Sprintf("Flag '--crate/-c' or '--interactive/-i' should be specified"),
+			message:     fmt.Sprintf("Flag '--crate/-c', '--target-path/-T', '--match', '--group/-g', '--tag' or '--interactive/-i' should be specified"),
 			indentLevel: program.indentLevel,
 		}
 	}
 
 	if interactiveSelection == true {
-		if allTargets != false || targetNames != nil || crateName != "" {
-			return Crate{}, []Target{}, functionResponse{
+		if allTargets != false || targetNames != nil || crateName != "" || targetPaths != nil || matches != nil || tags != nil || groups != nil {
+			return []targetSelection{}, functionResponse{
 				exitCode:    1,
 				logLevel:    "error",
-				message:     fmt.Sprintf("Flag '--interactive/-i' cannot be used with flags '--crate/-c' and '--target/-t' or '--all/-a'"),
+				message:     fmt.Sprintf("Flag '--interactive/-i' cannot be used with flags '--crate/-c', '--target/-t', '--target-path/-T', '--match', '--all/-a', '--tag' or '--group/-g'"),
 				indentLevel: program.indentLevel,
 			}
 		}
@@ -70,7 +71,7 @@ func getSelectedTargetsFromCLI(crateName string, targetNames []string, allTarget
 		err := survey.AskOne(promptCrate, &selectedCrateIndex, survey.WithPageSize(10))
 		if err != nil {
 			if err.Error() == "interrupt" {
-				return Crate{}, []Target{}, functionResponse{
+				return []targetSelection{}, functionResponse{
 					exitCode:    1,
 					message:     "Operation cancelled by user",
 					logLevel:    "error",
@@ -98,7 +99,7 @@ func getSelectedTargetsFromCLI(crateName string, targetNames []string, allTarget
 		err = survey.AskOne(promptTarget, &selectedTargetsIndices, survey.WithPageSize(10))
 		if err != nil {
 			if err.Error() == "interrupt" {
-				return Crate{}, []Target{}, functionResponse{
+				return []targetSelection{}, functionResponse{
 					exitCode:    1,
 					message:     "Operation cancelled by user",
 					logLevel:    "error",
@@ -113,7 +114,7 @@ func getSelectedTargetsFromCLI(crateName string, targetNames []string, allTarget
 		}
 
 		if len(selectedTargets) == 0 {
-			return selectedCrate, selectedTargets, functionResponse{
+			return []targetSelection{}, functionResponse{
 				exitCode:    1,
 				logLevel:    "attention",
 				message:     fmt.Sprintf("No targets were selected"),
@@ -121,22 +122,115 @@ func getSelectedTargetsFromCLI(crateName string, targetNames []string, allTarget
 			}
 		}
 
-		return selectedCrate, selectedTargets, functionResponse{exitCode: 0}
+		return []targetSelection{{crate: selectedCrate, targets: selectedTargets}}, functionResponse{exitCode: 0}
+	}
+
+	response := validateTagSelectors(tags, program)
+	if response.exitCode != 0 {
+		return []targetSelection{}, response
+	}
+
+	response = validateNamePatterns(append(append([]string{}, targetNames...), targetPaths...), program)
+	if response.exitCode != 0 {
+		return []targetSelection{}, response
+	}
+
+	expressions, response := compileMatchSelectors(matches, program)
+	if response.exitCode != 0 {
+		return []targetSelection{}, response
+	}
+
+	var patternMatches []patternMatch
+
+	// Selects the targets matched by a pattern (failing if there are none)
+	selectMatchedTargets := func(flag string, pattern string, matchedTargets []Target) functionResponse {
+		if len(matchedTargets) == 0 {
+			return functionResponse{
+				exitCode:    1,
+				logLevel:    "error",
+				message:     fmt.Sprintf("No targets match the pattern '%v'", pattern),
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		names := make([]string, len(matchedTargets))
+		for i, target := range matchedTargets {
+			names[i] = target.crate.name + "/" + target.name
+		}
+		patternMatches = append(patternMatches, patternMatch{flag: flag, pattern: pattern, names: names})
+		selectedTargets = append(selectedTargets, matchedTargets...)
+
+		return functionResponse{exitCode: 0}
+	}
+
+	if groups != nil {
+		if allTargets != false || targetNames != nil || crateName != "" || targetPaths != nil || matches != nil {
+			return []targetSelection{}, functionResponse{
+				exitCode:    1,
+				logLevel:    "error",
+				message:     fmt.Sprintf("Flag '--group/-g' cannot be used with flags '--crate/-c', '--target/-t', '--target-path/-T', '--match' or '--all/-a'"),
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		selectedTargets, response = getGroupsTargets(groups, program)
+		if response.exitCode != 0 {
+			return []targetSelection{}, response
+		}
+	} else if crateName == "" {
+		if allTargets != false || targetNames != nil {
+			return []targetSelection{}, functionResponse{
+				exitCode:    1,
+				logLevel:    "error",
+				message:     fmt.Sprintf("Flags '--target/-t' and '--all/-a' need flag '--crate/-c'"),
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		// The targets of every crate, matched by the patterns (and filtered by their
+		// tags)
+		allCratesTargets := getAllTargets(program)
+
+		for _, targetPath := range targetPaths {
+			response := selectMatchedTargets("-T", targetPath, matchTargetPath(allCratesTargets, targetPath))
+			if response.exitCode != 0 {
+				return []targetSelection{}, response
+			}
+		}
+		for i, expression := range expressions {
+			response := selectMatchedTargets("--match", matches[i], matchTargetNames(allCratesTargets, expression.MatchString))
+			if response.exitCode != 0 {
+				return []targetSelection{}, response
+			}
+		}
+
+		if targetPaths == nil && matches == nil {
+			selectedTargets = allCratesTargets
+		}
 	} else {
-		if allTargets != false && targetNames != nil {
-			return Crate{}, []Target{}, functionResponse{
+		if targetPaths != nil {
+			return []targetSelection{}, functionResponse{
 				exitCode:    1,
 				logLevel:    "error",
-				message:     fmt.Sprintf("Conflicting flags: both '--target/-t' and '--all/-a' flags cannot be specified at the same time"),
+				message:     fmt.Sprintf("Flag '--target-path/-T' cannot be used with flag '--crate/-c'"),
 				indentLevel: program.indentLevel,
 			}
 		}
 
-		if allTargets == false && targetNames == nil {
-			return Crate{}, []Target{}, functionResponse{
+		if allTargets != false && (targetNames != nil || matches != nil) {
+			return []targetSelection{}, functionResponse{
 				exitCode:    1,
 				logLevel:    "error",
-				message:     fmt.Sprintf("Missing required flag: '--interactive/-i' or '--target/-t' or '--all/-a' flag must be specified"),
+				message:     fmt.Sprintf("Conflicting flags: flag '--all/-a' cannot be specified with flags '--target/-t' or '--match'"),
+				indentLevel: program.indentLevel,
+			}
+		}
+
+		if allTargets == false && targetNames == nil && matches == nil && tags == nil {
+			return []targetSelection{}, functionResponse{
+				exitCode:    1,
+				logLevel:    "error",
+				message:     fmt.Sprintf("Missing required flag: '--interactive/-i' or '--target/-t' or '--match' or '--all/-a' or '--tag' flag must be specified"),
 				indentLevel: program.indentLevel,
 			}
 		}
@@ -145,7 +239,7 @@ func getSelectedTargetsFromCLI(crateName string, targetNames []string, allTarget
 
 		response := verifyCrateDirectory(crate, program)
 		if response.exitCode != 0 {
-			return Crate{}, []Target{}, functionResponse{
+			return []targetSelection{}, functionResponse{
 				exitCode:    1,
 				message:     fmt.Sprintf("Crate '%s' not found", crate.name),
 				logLevel:    "error",
@@ -153,24 +247,60 @@ func getSelectedTargetsFromCLI(crateName string, targetNames []string, allTarget
 			}
 		}
 
-		if allTargets == true {
-			selectedTargets, response := getCrateTargets(crate, program)
+		if targetNames == nil && matches == nil {
+			// All the targets (possibly filtered by their tags)
+			selectedTargets, response = getCrateTargets(crate, program)
 			handleFunctionResponse(response, true)
-
-			return crate, selectedTargets, functionResponse{exitCode: 0}
 		} else {
-			selectedTargets = make([]Target, len(targetNames))
-			for i, element := range targetNames {
-				selectedTargets[i] = generateTargetObj(crate.name, element, program)
+			crateTargets, _ := getCrateTargets(crate, program)
+
+			var namedTargets []Target
+			for _, element := range targetNames {
+				if isNamePattern(element) == true {
+					response := selectMatchedTargets("-t", element, matchTargetNames(crateTargets, globMatcher(element)))
+					if response.exitCode != 0 {
+						return []targetSelection{}, response
+					}
+				} else {
+					target := generateTargetObj(crate.name, element, program)
+					namedTargets = append(namedTargets, target)
+					selectedTargets = append(selectedTargets, target)
+				}
 			}
 
 			// Verify targets
-			response := verifyTargetsDirectories(selectedTargets, program)
+			response := verifyTargetsDirectories(namedTargets, program)
 			handleFunctionResponse(response, true)
 
-			return crate, selectedTargets, functionResponse{exitCode: 0}
+			for i, expression := range expressions {
+				response := selectMatchedTargets("--match", matches[i], matchTargetNames(crateTargets, expression.MatchString))
+				if response.exitCode != 0 {
+					return []targetSelection{}, response
+				}
+			}
+		}
+	}
+
+	showPatternMatches(patternMatches, program)
+	selectedTargets = uniqueTargets(selectedTargets)
+
+	if tags != nil {
+		selectedTargets, response = filterTargetsByTags(selectedTargets, tags, program)
+		if response.exitCode != 0 {
+			return []targetSelection{}, response
+		}
+
+		if len(selectedTargets) == 0 {
+			return []targetSelection{}, functionResponse{
+				exitCode:    1,
+				logLevel:    "attention",
+				message:     fmt.Sprintf("No targets match the tags %v", strings.Join(tags, ", ")),
+				indentLevel: program.indentLevel,
+			}
 		}
 	}
+
+	return groupTargetsByCrate(selectedTargets), functionResponse{exitCode: 0}
 }
 
 func displayTargetTag(msg string, target Target) string {
@@ -220,9 +350,12 @@ func generateTargetObj(crate string, target string, program Program) Target {
 		"USER_TEMPLATES_DIR":         program.userTemplatesDir,
 		"USER_CRATES_TEMPLATES_DIR":  program.userCratesTemplatesDir,
 		"USER_TARGETS_TEMPLATES_DIR": program.userTargetsTemplatesDir,
+		"USER_HOOKS_DIR":             program.userHooksDir,
+		"USER_TARGETS_HOOKS_DIR":     program.userTargetsHooksDir,
 		"CRATE_NAME":                 crate,
 		"CRATE_DIR":                  program.userCratesDir + "/" + crate,
 		"CRATE_HOOKS_DIR":            program.userCratesDir + "/" + crate + "/hooks",
+		"CRATE_TARGETS_HOOKS_DIR":    program.userCratesDir + "/" + crate + "/hooks/targets",
 		"CRATE_TARGETS_DIR":          program.userCratesDir + "/" + crate + "/targets",
 		"CRATE_TEMP_DIR":             program.userCratesDir + "/" + crate + "/.tmp",
 		"TARGET_NAME":                target,
@@ -238,6 +371,7 @@ func generateTargetObj(crate string, target string, program Program) Target {
 		hooksDir:     program.userCratesDir + "/" + crate + "/targets" + "/" + target + "/hooks",
 		tempDir:      program.userCratesDir + "/" + crate + "/targets" + "/" + target + "/.tmp",
 		disabledPath: program.userCratesDir + "/" + crate + "/targets" + "/" + target + "/disabled",
+		configPath:   program.userCratesDir + "/" + crate + "/targets" + "/" + target + "/config.json",
 		environment:  defaultTargetEnv,
 	}
 }
@@ -409,20 +543,18 @@ func verifyTargetDirectory(target Target, program Program) functionResponse {
 	}
 }
 
-func removeTargetTempDirectory(target Target, notRemoveTempDir bool, program Program) {
+func removeTargetTempDirectory(target Target, notRemoveTempDir bool, program Program) functionResponse {
 	var response functionResponse
 
 	showInfoSectionTitle(fmt.Sprintf("Removing temporary directory"), program.indentLevel)
 
 	if notRemoveTempDir == true {
-		response = functionResponse{
+		return functionResponse{
 			exitCode:    0,
 			message:     "Skipping",
 			logLevel:    "attention",
 			indentLevel: program.indentLevel + 1,
 		}
-		handleFunctionResponse(response, false)
-		return
 	}
 
 	if _, err := os.Stat(target.tempDir); os.IsNotExist(err) {
@@ -451,23 +583,21 @@ func removeTargetTempDirectory(target Target, notRemoveTempDir bool, program Pro
 		}
 	}
 
-	handleFunctionResponse(response, true)
+	return response
 }
 
-func setupTargetTempDirectory(target Target, notCreateTempDir bool, program Program) {
+func setupTargetTempDirectory(target Target, notCreateTempDir bool, program Program) functionResponse {
 	var response functionResponse
 
 	showInfoSectionTitle(lightGray.Sprintf("Setting up temporary directory"), program.indentLevel)
 
 	if notCreateTempDir == true {
-		response = functionResponse{
+		return functionResponse{
 			exitCode:    0,
 			message:     "Skipping",
 			logLevel:    "attention",
 			indentLevel: program.indentLevel + 1,
 		}
-		handleFunctionResponse(response, false)
-		return
 	}
 
 	if _, err := os.Stat(target.tempDir); err == nil {
@@ -481,13 +611,12 @@ func setupTargetTempDirectory(target Target, notCreateTempDir bool, program Prog
 
 		err = os.RemoveAll(target.tempDir)
 		if err != nil {
-			response = functionResponse{
+			return functionResponse{
 				exitCode:    1,
+				logLevel:    "error",
 				message:     fmt.Sprintf("Failed to recreate temporary directory -> '%v'", err.Error()),
 				indentLevel: program.indentLevel + 3,
 			}
-
-			handleFunctionResponse(response, true)
 		}
 	}
 
@@ -496,6 +625,7 @@ func setupTargetTempDirectory(target Target, notCreateTempDir bool, program Prog
 	if err != nil {
 		response = functionResponse{
 			exitCode:    1,
+			logLevel:    "error",
 			message:     fmt.Sprintf("Failed to create temporary directory -> '%v'", err.Error()),
 			indentLevel: program.indentLevel + 1,
 		}
@@ -508,7 +638,7 @@ func setupTargetTempDirectory(target Target, notCreateTempDir bool, program Prog
 		}
 	}
 
-	handleFunctionResponse(response, true)
+	return response
 }
 
 func targetsCreate(program Program) functionResponse {
@@ -683,8 +813,8 @@ func targetsCreate(program Program) functionResponse {
 	// Run post_create hook (if any)
 	space()
 	showInfoSectionTitle(lightGray.Sprintf("Running ")+orange.Sprintf("post_create")+lightGray.Sprintf(" hook"), program.indentLevel+1)
-	if _, err := os.Stat(target.hooksDir + "/post_create"); err == nil {
-		_, response := runHook(target.hooksDir+"/post_create", target.environment, true, true, true, true, true, incrementProgramIndentLevel(program, 1))
+	if hookExists(resolveTargetHook(target, "post_create", program).path) == true {
+		_, response := runHook(resolveTargetHook(target, "post_create", program).path, target.environment, nil, true, true, true, true, true, incrementProgramIndentLevel(program, 1))
 
 		if response.exitCode != 0 {
 			handleFunctionResponse(response, false)
@@ -752,7 +882,7 @@ func targetsRm(crate Crate, targets []Target, program Program) functionResponse
 
 		showInfoSectionTitle(lightGray.Sprintf("Running ")+orange.Sprintf("pre_rm")+lightGray.Sprintf(" hook"), program.indentLevel+1)
 
-		if _, err := os.Stat(target.hooksDir + "/pre_rm"); os.IsNotExist(err) {
+		if hookExists(resolveTargetHook(target, "pre_rm", program).path) == false {
 			response := functionResponse{
 				exitCode:    0,
 				message:     "Hook not found",
@@ -763,7 +893,7 @@ func targetsRm(crate Crate, targets []Target, program Program) functionResponse
 		} else {
 			program = incrementProgramIndentLevel(program, 1)
 
-			_, response := runHook(target.hooksDir+"/pre_rm", target.environment, true, true, true, true, true, program)
+			_, response := runHook(resolveTargetHook(target, "pre_rm", program).path, target.environment, nil, true, true, true, true, true, program)
 			response.indentLevel = program.indentLevel + 2
 			handleFunctionResponse(response, true)
 		}
@@ -812,8 +942,12 @@ func targetsLs(crates []Crate, program Program) functionResponse {
 
 		for _, target := range targets {
 			// Get optional description (if hook exists)
-			targetDescription, response := runHook(target.hooksDir+"/ls", target.environment, false, false, false, false, false, program)
+			targetDescription, response := runHook(resolveTargetHook(target, "ls", program).path, target.environment, nil, false, false, false, false, false, program)
 			targetDescriptionString := targetDescription.Output
+			if targetDescription.status.Description != "" {
+				// Prefer the description reported through the status file
+				targetDescriptionString = targetDescription.status.Description
+			}
 
 			var description string
 
@@ -829,7 +963,12 @@ func targetsLs(crates []Crate, program Program) functionResponse {
 			handleFunctionResponse(response, true)
 
 			if isTargetDisabled == true {
-				description += fmt.Sprintf("[%s]", red.Sprintf("disabled"))
+				description += fmt.Sprintf("[%s] ", red.Sprintf("disabled"))
+			}
+
+			_, roots := getTargetSyncRoots(target, program)
+			if roots != "" {
+				description += gray.Sprintf(roots)
 			}
 
 			showText(fmt.Sprintf(" - %s %s", target.name, description), program.indentLevel+1)
@@ -841,16 +980,90 @@ func targetsLs(crates []Crate, program Program) functionResponse {
 	}
 }
 
+// Returns how a target is synchronized (built-in engine, unison profile or 'sync'
+// hook) and a description of its roots
+func getTargetSyncRoots(target Target, program Program) (string, string) {
+	config, response := readTargetConfig(target, program)
+	if response.exitCode == 0 && config.Sync.Engine != "" {
+		method := config.Sync.Engine + " engine"
+
+		switch config.Sync.Engine {
+		case syncEngineMirror:
+			return method, fmt.Sprintf("%v -> %v", config.Sync.Source, config.Sync.Destination)
+		default:
+			return method, strings.Join(config.Sync.Roots, " <-> ")
+		}
+	}
+
+	profilePath := getTargetUnisonProfilePath(target)
+	if _, err := os.Stat(profilePath); err == nil {
+		profile, err := parseUnisonProfile(profilePath)
+		if err != nil {
+			return "unison profile", ""
+		}
+
+		return "unison profile", strings.Join(profile.roots(), " <-> ")
+	}
+
+	if hookExists(resolveTargetHook(target, "sync", program).path) == true {
+		return "sync hook", ""
+	}
+
+	return "", ""
+}
+
+func targetsStatus(crate Crate, targets []Target, program Program) functionResponse {
+	runs, response := readJournal(program)
+	if response.exitCode != 0 {
+		return response
+	}
+
+	for _, target := range targets {
+		space()
+		showInfoSectionTitle(displayTargetTag("Status", target), program.indentLevel)
+
+		isTargetDisabled, response := isTargetDisabled(target, program)
+		if response.exitCode != 0 {
+			return response
+		}
+
+		state := green.Sprintf("enabled")
+		if isTargetDisabled == true {
+			state = red.Sprintf("disabled")
+		}
+		showText(fmt.Sprintf("State: %s", state), program.indentLevel+1)
+
+		method, roots := getTargetSyncRoots(target, program)
+		if method == "" {
+			method = red.Sprintf("none (no sync hook, engine or unison profile)")
+		}
+		showText(fmt.Sprintf("Sync: %s", method), program.indentLevel+1)
+		if roots != "" {
+			showText(fmt.Sprintf("Roots: %s", blue.Sprintf(roots)), program.indentLevel+1)
+		}
+
+		lastRun := gray.Sprintf("never")
+		if run, record, found := getTargetLastRun(runs, target); found == true {
+			lastRun = fmt.Sprintf("%s on %v (%.1fs)", displayTargetOutcome(record.Outcome), run.StartedAt.Local().Format("2006-01-02 15:04:05"), record.DurationSeconds)
+		}
+		showText(fmt.Sprintf("Last run: %s", lastRun), program.indentLevel+1)
+	}
+
+	return functionResponse{
+		exitCode: 0,
+	}
+}
+
 func targetsEdit(crate Crate, targets []Target, program Program) functionResponse {
 	hook := "edit"
-	response := targetsRunHooks(crate, targets, []string{hook}, []string{}, []string{}, false, false, false, false, false, program)
+	response := targetsRunHooks(crate, targets, []string{hook}, nil, []string{}, []string{}, false, false, false, false, false, program)
 
 	return response
 }
 
 func targetsView(crate Crate, targets []Target, program Program) functionResponse {
 	hook := "view"
-	response := targetsRunHooks(crate, targets, []string{hook}, []string{}, []string{}, false, false, false, false, false, program)
+	response := targetsRunHooks(crate, targets, []string{hook}, nil, []string{}, []string{}, false, false, false, false, false, program)
 
 	return response
 }
@@ -877,12 +1090,81 @@ func targetsSync(crate Crate, targets []Target, program Program) functionRespons
 		return response
 	}
 
-	setupCrateTempDirectory(crate, true, false, program)
+	// Never sync a crate twice at the same time
+	lock, err := lockCrate(crate)
+	if err == errCrateLocked {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Crate is being synced by another process (PID %v)", getCrateLockOwner(crate)),
+			logLevel:    "attention",
+			indentLevel: program.indentLevel,
+		}
+	} else if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to lock crate -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	defer unlockCrate(lock)
+
+	return syncCrateTargets(crate, targets, program)
+}
+
+// Syncs targets of a crate whose lock is held by the caller (see 'targetsSync')
+func syncCrateTargets(crate Crate, targets []Target, program Program) functionResponse {
+	var response functionResponse
+
+	run := newJournalRun("sync", crate)
+
+	targetNames := make([]string, len(targets))
+	for index, target := range targets {
+		targetNames[index] = target.name
+	}
+	emitEvent(runEvent{Event: "run_start", Crate: crate.name, Targets: targetNames})
+
+	// SSH connections used by the built-in sync engines, shared by all targets
+	connections := newSSHConnectionPool(crate, program)
+
+	// Print the summary, record the run in the journal, update the metrics and send
+	// the notifications
+	finishRun := func(exitCode int, program Program) {
+		connections.close()
+		run.finish(exitCode)
+
+		space()
+		space()
+		showRunSummary(run, program)
+
+		response := appendRunToJournal(run, program)
+		response.indentLevel = program.indentLevel + 1
+		handleFunctionResponse(response, false)
+
+		response = writeMetricsTextfile(program)
+		response.indentLevel = program.indentLevel + 1
+		handleFunctionResponse(response, false)
+
+		notifyRun(run, crate, program)
+
+		emitEvent(runEvent{Event: "run_end", Crate: crate.name, ExitCode: eventInt(run.ExitCode), DurationSeconds: &run.DurationSeconds, Run: &run})
+	}
+
+	response = setupCrateTempDirectory(crate, true, false, program)
+	handleFunctionResponse(response, false)
+	if response.exitCode != 0 {
+		finishRun(response.exitCode, program)
+
+		// Already reported
+		return functionResponse{
+			exitCode: response.exitCode,
+		}
+	}
 
 	// Run pre_transaction hook for crate (if any)
 	space()
 	showInfoSectionTitle(lightGray.Sprintf("Running pre_transaction hook")+lightGray.Sprintf(" (")+salmonPink.Sprintf(crate.name)+lightGray.Sprintf(")"), program.indentLevel)
-	if _, err := os.Stat(crate.hooksDir + "/pre_transaction"); os.IsNotExist(err) {
+	if hookExists(crate.hooksDir+"/pre_transaction") == false {
 		response = functionResponse{
 			exitCode:    0,
 			message:     "Hook not found",
@@ -891,18 +1173,23 @@ func targetsSync(crate Crate, targets []Target, program Program) functionRespons
 		}
 		handleFunctionResponse(response, false)
 	} else {
-		_, response := runHook(crate.hooksDir+"/pre_transaction", crate.environment, true, true, true, true, true, program)
+		result, response := runHook(crate.hooksDir+"/pre_transaction", crate.environment, nil, true, true, true, true, true, program)
 		response.indentLevel = program.indentLevel + 1
+		run.Hooks = append(run.Hooks, newJournalHookRecord("pre_transaction", result, response))
 
 		handleFunctionResponse(response, false)
+		showHookStatus(result.status, program)
 
 		if response.exitCode != 0 {
-			space()
-			removeCrateTempDirectory(crate, true, false, program)
+			finishRun(response.exitCode, program)
 
 			space()
+			handleFunctionResponse(removeCrateTempDirectory(crate, true, false, program), false)
 
-			finishProgram(response.exitCode)
+			// Already reported
+			return functionResponse{
+				exitCode: response.exitCode,
+			}
 		}
 	}
 
@@ -914,6 +1201,11 @@ func targetsSync(crate Crate, targets []Target, program Program) functionRespons
 			orange.Println(fmt.Sprintf("(%v/%v)", index+1, len(targets)))
 			showInfoSectionTitle(lightGray.Sprintf("Syncing")+lightGray.Sprintf(" (")+salmonPink.Sprintf(target.crate.name)+"/"+green.Sprintf(target.name)+lightGray.Sprintf(")"), program.indentLevel)
 
+			record := journalTargetRecord{
+				Name:    target.name,
+				Outcome: targetOutcomeSuccess,
+			}
+
 			isTargetDisabled, response := isTargetDisabled(target, program)
 			if response.exitCode != 0 {
 				response.indentLevel = program.indentLevel + 1
@@ -929,46 +1221,146 @@ func targetsSync(crate Crate, targets []Target, program Program) functionRespons
 					indentLevel: program.indentLevel + 1,
 				}
 				handleFunctionResponse(response, false)
+				emitEvent(runEvent{Event: "target_skipped_disabled", Crate: crate.name, Target: target.name})
+
+				record.Outcome = targetOutcomeDisabled
+				run.Targets = append(run.Targets, record)
 			} else {
 				program = incrementProgramIndentLevel(program, 1)
 
 				space()
-				setupTargetTempDirectory(target, false, program)
-
-				space()
-				space()
-				showInfoSectionTitle(lightGray.Sprintf("Running pre_transaction hook"), program.indentLevel)
-				_, response = runHook(target.hooksDir+"/pre_transaction", target.environment, true, true, true, true, true, program)
-				response.indentLevel = program.indentLevel + 1
+				response = setupTargetTempDirectory(target, false, program)
 				handleFunctionResponse(response, false)
+				if response.exitCode != 0 {
+					record.Outcome = targetOutcomeFailed
+					record.ExitCode = response.exitCode
+					run.Targets = append(run.Targets, record)
 
-				space()
-				space()
-				showInfoSectionTitle(lightGray.Sprintf("Running sync hook"), program.indentLevel)
-				_, response = runHook(target.hooksDir+"/sync", target.environment, true, true, true, true, true, program)
-				response.indentLevel = program.indentLevel + 1
+					return response
+				}
+
+				// Runs one of the target's transaction hooks and records it
+				runTransactionHook := func(hook string) (hookResult, functionResponse) {
+					space()
+					space()
+					showInfoSectionTitle(lightGray.Sprintf(fmt.Sprintf("Running %v hook", hook)), program.indentLevel)
+
+					hookPath := resolveTargetHook(target, hook, program).path
+					result, response := runHook(hookPath, target.environment, nil, true, true, true, true, true, program)
+					response.indentLevel = program.indentLevel + 1
+					if hookExists(hookPath) == true {
+						record.addHook(newJournalHookRecord(hook, result, response))
+					}
+
+					return result, response
+				}
+
+				// Runs the built-in sync engine selected by the target (if any)
+				// instead of its sync hook
+				runSyncEngine := func(config syncEngineConfig) (hookResult, functionResponse) {
+					space()
+					space()
+					showInfoSectionTitle(lightGray.Sprintf(fmt.Sprintf("Running %v engine", config.Engine)), program.indentLevel)
 
+					result, response := runTargetSyncEngine(target, config, connections, program)
+					response.indentLevel = program.indentLevel + 1
+					record.addHook(newJournalHookRecord("sync", result, response))
+
+					return result, response
+				}
+
+				config, response := readTargetConfig(target, program)
 				if response.exitCode != 0 {
 					response.indentLevel = program.indentLevel + 1
 					handleFunctionResponse(response, false)
 
+					record.Outcome = targetOutcomeFailed
+					record.ExitCode = response.exitCode
+					run.Targets = append(run.Targets, record)
+
 					space()
 					space()
-					removeTargetTempDirectory(target, false, program)
+					handleFunctionResponse(removeTargetTempDirectory(target, false, program), false)
 
 					return response
 				}
 
-				space()
-				space()
-				showInfoSectionTitle(lightGray.Sprintf("Running post_transaction hook"), program.indentLevel)
-				_, response = runHook(target.hooksDir+"/post_transaction", target.environment, true, true, true, true, true, program)
-				response.indentLevel = program.indentLevel + 1
+				result, response := runTransactionHook("pre_transaction")
+				handleFunctionResponse(response, false)
+				showHookStatus(result.status, program)
+
+				// Shows how to undo the sync with the pre-sync snapshot (if any)
+				showRollbackHint := func() {
+					if record.Snapshot != "" {
+						showText(gray.Sprintf(fmt.Sprintf("The sync can be undone with '%v targets rollback -c %v -t %v' (snapshot %v)", program.name, target.crate.name, target.name, record.Snapshot)), program.indentLevel+1)
+					}
+				}
+
+				// A pre_transaction hook can ask to skip the sync hook (e.g. when
+				// there are no changes)
+				if result.status.Skip == "" {
+					if config.Snapshot.BeforeSync == true {
+						space()
+						space()
+						showInfoSectionTitle(lightGray.Sprintf("Creating pre-sync snapshot"), program.indentLevel)
+
+						manifest, response := takeTargetSnapshot(target, config, snapshotReasonPreSync, incrementProgramIndentLevel(program, 1))
+						if response.exitCode != 0 {
+							// Do not sync without the safety net
+							handleFunctionResponse(response, false)
+
+							record.Outcome = targetOutcomeFailed
+							record.ExitCode = response.exitCode
+							run.Targets = append(run.Targets, record)
+
+							space()
+							space()
+							handleFunctionResponse(removeTargetTempDirectory(target, false, program), false)
+
+							return response
+						}
+						record.Snapshot = manifest.ID
+					}
+
+					if config.Sync.Engine != "" {
+						result, response = runSyncEngine(config.Sync)
+					} else {
+						result, response = runTransactionHook("sync")
+					}
+
+					if response.exitCode != 0 {
+						handleFunctionResponse(response, false)
+						showHookStatus(result.status, program)
+						showRollbackHint()
+
+						record.Outcome = targetOutcomeFailed
+						record.ExitCode = response.exitCode
+						run.Targets = append(run.Targets, record)
+
+						space()
+						space()
+						handleFunctionResponse(removeTargetTempDirectory(target, false, program), false)
+
+						return response
+					}
+					showHookStatus(result.status, program)
+					showRollbackHint()
+				}
+
+				result, response = runTransactionHook("post_transaction")
 				handleFunctionResponse(response, false)
+				showHookStatus(result.status, program)
+
+				if record.Status.Skip != "" {
+					record.Outcome = targetOutcomeSkipped
+				} else {
+					pruneTargetSnapshots(target, config, program)
+				}
+				run.Targets = append(run.Targets, record)
 
 				space()
 				space()
-				removeTargetTempDirectory(target, false, program)
+				handleFunctionResponse(removeTargetTempDirectory(target, false, program), false)
 
 				program = decrementProgramIndentLevel(program, 1)
 			}
@@ -980,12 +1372,15 @@ func targetsSync(crate Crate, targets []Target, program Program) functionRespons
 	}(targets, program)
 
 	if response.exitCode != 0 {
-		space()
-		removeCrateTempDirectory(crate, true, false, program)
+		finishRun(response.exitCode, program)
 
 		space()
+		handleFunctionResponse(removeCrateTempDirectory(crate, true, false, program), false)
 
-		finishProgram(response.exitCode)
+		// Already reported
+		return functionResponse{
+			exitCode: response.exitCode,
+		}
 	}
 
 	// Run post_transaction hook for crate (if any)
@@ -993,7 +1388,7 @@ func targetsSync(crate Crate, targets []Target, program Program) functionRespons
 	space()
 
 	showText(lightGray.Sprintf("Running post_transaction hook")+lightGray.Sprintf(" (")+salmonPink.Sprintf(crate.name+lightGray.Sprintf(")")), program.indentLevel)
-	if _, err := os.Stat(crate.hooksDir + "/post_transaction"); os.IsNotExist(err) {
+	if hookExists(crate.hooksDir+"/post_transaction") == false {
 		response = functionResponse{
 			exitCode:    0,
 			message:     "Hook not found",
@@ -1002,20 +1397,37 @@ func targetsSync(crate Crate, targets []Target, program Program) functionRespons
 		}
 		handleFunctionResponse(response, false)
 	} else {
-		_, response := runHook(crate.hooksDir+"/post_transaction", crate.environment, true, true, true, true, true, program)
+		result, response := runHook(crate.hooksDir+"/post_transaction", crate.environment, nil, true, true, true, true, true, program)
 		response.indentLevel = program.indentLevel + 1
-		handleFunctionResponse(response, true)
+		run.Hooks = append(run.Hooks, newJournalHookRecord("post_transaction", result, response))
+
+		handleFunctionResponse(response, false)
+		showHookStatus(result.status, program)
+
+		if response.exitCode != 0 {
+			finishRun(response.exitCode, program)
+
+			space()
+			handleFunctionResponse(removeCrateTempDirectory(crate, true, false, program), false)
+
+			// Already reported
+			return functionResponse{
+				exitCode: response.exitCode,
+			}
+		}
 	}
 
+	finishRun(0, program)
+
 	space()
-	removeCrateTempDirectory(crate, true, false, program)
+	handleFunctionResponse(removeCrateTempDirectory(crate, true, false, program), false)
 
 	return functionResponse{
 		exitCode: 0,
 	}
 }
 
-func targetsRunHooks(crate Crate, targets []Target, hooks []string, cratePreHooks []string, cratePostHooks []string, notCreateTempDir bool, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, notPrintAlerts bool, program Program) functionResponse {
+func targetsRunHooks(crate Crate, targets []Target, hooks []string, hookArgs []string, cratePreHooks []string, cratePostHooks []string, notCreateTempDir bool, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, notPrintAlerts bool, program Program) functionResponse {
 	var response functionResponse
 
 	isCrateDisabled, response := isCrateDisabled(crate, program)
@@ -1037,7 +1449,7 @@ func targetsRunHooks(crate Crate, targets []Target, hooks []string, cratePreHook
 		return response
 	}
 
-	setupCrateTempDirectory(crate, true, notCreateTempDir, program)
+	handleFunctionResponse(setupCrateTempDirectory(crate, true, notCreateTempDir, program), true)
 
 	space()
 	space()
@@ -1045,7 +1457,7 @@ func targetsRunHooks(crate Crate, targets []Target, hooks []string, cratePreHook
 	for _, hook := range cratePreHooks {
 		showInfoSectionTitle(displayCrateTag(lightGray.Sprintf("Running ")+orange.Sprintf(hook)+lightGray.Sprintf(" hook"), crate), program.indentLevel)
 
-		if _, err := os.Stat(crate.hooksDir + "/" + hook); os.IsNotExist(err) {
+		if hookExists(crate.hooksDir+"/"+hook) == false {
 			response = functionResponse{
 				exitCode:    1,
 				message:     "Hook not found",
@@ -1054,14 +1466,14 @@ func targetsRunHooks(crate Crate, targets []Target, hooks []string, cratePreHook
 			}
 			handleFunctionResponse(response, true)
 		} else {
-			_, response := runHook(crate.hooksDir+"/"+hook, crate.environment, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
+			_, response := runHook(crate.hooksDir+"/"+hook, crate.environment, nil, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
 			response.indentLevel = program.indentLevel + 1
 
 			handleFunctionResponse(response, false)
 
 			if response.exitCode != 0 {
 				space()
-				removeCrateTempDirectory(crate, true, false, program)
+				handleFunctionResponse(removeCrateTempDirectory(crate, true, false, program), true)
 
 				space()
 
@@ -1104,17 +1516,22 @@ func targetsRunHooks(crate Crate, targets []Target, hooks []string, cratePreHook
 
 		space()
 
-		setupTargetTempDirectory(target, notCreateTempDir, program)
+		handleFunctionResponse(setupTargetTempDirectory(target, notCreateTempDir, program), true)
 
-		response = func(crate Crate, target Target, hooks []string, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, program Program) functionResponse {
+		response = func(crate Crate, target Target, hooks []string, hookArgs []string, notRemoveTempDir bool, notPrintOutput bool, notPrintEntryCmd bool, program Program) functionResponse {
 			for _, hook := range hooks {
 				space()
 				space()
 
-				showInfoSectionTitle(lightGray.Sprintf("Running ")+orange.Sprintf(hook)+lightGray.Sprintf(" hook"), program.indentLevel)
+				resolved := resolveTargetHook(target, hook, program)
+				if resolved.source == hookSourceCrate || resolved.source == hookSourceGlobal {
+					showInfoSectionTitle(lightGray.Sprintf("Running ")+orange.Sprintf(hook)+lightGray.Sprintf(" hook (")+displayHookSource(resolved.source)+lightGray.Sprintf(")"), program.indentLevel)
+				} else {
+					showInfoSectionTitle(lightGray.Sprintf("Running ")+orange.Sprintf(hook)+lightGray.Sprintf(" hook"), program.indentLevel)
+				}
 
 				// Run hook
-				if _, err := os.Stat(target.hooksDir + "/" + hook); os.IsNotExist(err) {
+				if hookExists(resolved.path) == false {
 					response = functionResponse{
 						exitCode:    1,
 						message:     fmt.Sprintf("No '%v' hook found", hook),
@@ -1123,7 +1540,8 @@ func targetsRunHooks(crate Crate, targets []Target, hooks []string, cratePreHook
 					}
 					handleFunctionResponse(response, false)
 				} else {
-					_, hookResponse := runHook(target.hooksDir+"/"+hook, target.environment, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
+					hookResult, hookResponse := runHook(resolved.path, target.environment, hookArgs, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
+					showHookStatus(hookResult.status, program)
 
 					if hookResponse.exitCode != 0 {
 						hookResponse.indentLevel = program.indentLevel + 1
@@ -1143,7 +1561,7 @@ func targetsRunHooks(crate Crate, targets []Target, hooks []string, cratePreHook
 			return functionResponse{
 				exitCode: response.exitCode,
 			}
-		}(crate, target, hooks, notRemoveTempDir, notPrintOutput, notPrintEntryCmd, program)
+		}(crate, target, hooks, hookArgs, notRemoveTempDir, notPrintOutput, notPrintEntryCmd, program)
 
 		if response.exitCode != 0 {
 			handleFunctionResponse(response, false)
@@ -1151,11 +1569,11 @@ func targetsRunHooks(crate Crate, targets []Target, hooks []string, cratePreHook
 			space()
 			space()
 
-			removeTargetTempDirectory(target, notRemoveTempDir, program)
+			handleFunctionResponse(removeTargetTempDirectory(target, notRemoveTempDir, program), true)
 
 			space()
 
-			removeCrateTempDirectory(crate, true, notRemoveTempDir, decrementProgramIndentLevel(program, 1))
+			handleFunctionResponse(removeCrateTempDirectory(crate, true, notRemoveTempDir, decrementProgramIndentLevel(program, 1)), true)
 
 			space()
 			finishProgram(response.exitCode)
@@ -1166,7 +1584,7 @@ func targetsRunHooks(crate Crate, targets []Target, hooks []string, cratePreHook
 		space()
 		space()
 
-		removeTargetTempDirectory(target, notRemoveTempDir, program)
+		handleFunctionResponse(removeTargetTempDirectory(target, notRemoveTempDir, program), true)
 
 		program = decrementProgramIndentLevel(program, 1)
 
@@ -1177,7 +1595,7 @@ func targetsRunHooks(crate Crate, targets []Target, hooks []string, cratePreHook
 	for _, hook := range cratePostHooks {
 		showInfoSectionTitle(displayCrateTag(lightGray.Sprintf("Running ")+orange.Sprintf(hook)+lightGray.Sprintf(" hook"), crate), program.indentLevel)
 
-		if _, err := os.Stat(crate.hooksDir + "/" + hook); os.IsNotExist(err) {
+		if hookExists(crate.hooksDir+"/"+hook) == false {
 			response = functionResponse{
 				exitCode:    1,
 				message:     "Hook not found",
@@ -1186,14 +1604,14 @@ func targetsRunHooks(crate Crate, targets []Target, hooks []string, cratePreHook
 			}
 			handleFunctionResponse(response, true)
 		} else {
-			_, response := runHook(crate.hooksDir+"/"+hook, crate.environment, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
+			_, response := runHook(crate.hooksDir+"/"+hook, crate.environment, nil, !notPrintOutput, true, true, !notPrintEntryCmd, true, program)
 			response.indentLevel = program.indentLevel + 1
 
 			handleFunctionResponse(response, false)
 
 			if response.exitCode != 0 {
 				space()
-				removeCrateTempDirectory(crate, true, false, program)
+				handleFunctionResponse(removeCrateTempDirectory(crate, true, false, program), true)
 
 				space()
 
@@ -1204,7 +1622,7 @@ func targetsRunHooks(crate Crate, targets []Target, hooks []string, cratePreHook
 		space()
 	}
 
-	removeCrateTempDirectory(crate, true, notRemoveTempDir, program)
+	handleFunctionResponse(removeCrateTempDirectory(crate, true, notRemoveTempDir, program), true)
 
 	return functionResponse{
 		exitCode: 0,
@@ -1218,50 +1636,49 @@ func targetsHooksLs(crate Crate, targets []Target, program Program) functionResp
 		orange.Println(fmt.Sprintf("(%v/%v)", index+1, len(targets)))
 		showInfoSectionTitle(displayTargetTag("Listing hooks", target), program.indentLevel)
 
-		hooks, err := ioutil.ReadDir(target.hooksDir)
-		if err != nil {
-			return functionResponse{
-				exitCode:    1,
-				message:     fmt.Sprintf("Error reading the hooks directory -> " + err.Error()),
-				logLevel:    "error",
-				indentLevel: program.indentLevel + 1,
-			}
+		hooks, response := getTargetHooks(target, program)
+		if response.exitCode != 0 {
+			response.indentLevel = program.indentLevel + 1
+			return response
 		}
 
-		// Filter out .entry files
-		filteredHooks := make([]os.FileInfo, 0)
-		for _, element := range hooks {
-			if !strings.HasSuffix(element.Name(), ".entry") {
-				filteredHooks = append(filteredHooks, element)
-			}
+		if len(hooks) == 0 {
+			showAttention("No hooks found", program.indentLevel)
 		}
-		filteredHooks = filterHiddenFilesAndDirectories(filteredHooks)
 
-		if len(filteredHooks) == 0 {
-			showAttention("No hooks found", program.indentLevel)
+		for _, hook := range hooks {
+			source := displayHookSource(hook.source)
+			if hook.overrides != "" {
+				source += fmt.Sprintf(", overrides %s", displayHookSource(hook.overrides))
+			}
+
+			response := showHook(hook.name, hook.path, target.environment, fmt.Sprintf(" [%s]", source), program)
+			if response.exitCode != 0 {
+				return response
+			}
 		}
+	}
 
-		for _, element := range filteredHooks {
-			// Verify if hook has custom entry command
-			var entryCommand string
-			customEntryFilePath := target.hooksDir + "/" + element.Name() + ".entry"
-			if _, err = os.Stat(customEntryFilePath); err == nil {
-				contents, err := ioutil.ReadFile(customEntryFilePath)
-				if err != nil {
-					return functionResponse{
-						exitCode:    1,
-						message:     fmt.Sprintf("Failed to read custom entry configuration file for hook " + element.Name() + " -> " + err.Error()),
-						logLevel:    "error",
-						indentLevel: program.indentLevel,
-					}
-				}
-				entryCommand = string(contents)
-				entryCommand = strings.TrimLeft(entryCommand, "\n")
-				entryCommand = strings.TrimRight(entryCommand, "\n")
-			} else {
-				entryCommand = program.defaultShell
+	return functionResponse{
+		exitCode: 0,
+	}
+}
+
+func targetsHooksOverride(crate Crate, targets []Target, hooks []string, program Program) functionResponse {
+	for index, target := range targets {
+		space()
+
+		orange.Println(fmt.Sprintf("(%v/%v)", index+1, len(targets)))
+		showInfoSectionTitle(displayTargetTag("Overriding hook(s)", target), program.indentLevel)
+
+		for _, hook := range hooks {
+			showInfoSectionTitle(lightGray.Sprintf("Overriding ")+orange.Sprintf(hook)+lightGray.Sprintf(" hook"), program.indentLevel+1)
+
+			response := overrideTargetHook(target, hook, incrementProgramIndentLevel(program, 1))
+			if response.exitCode != 0 {
+				return response
 			}
-			showText(fmt.Sprintf("- %s (%s)", element.Name(), coral.Sprintf(entryCommand)), program.indentLevel+1)
+			handleFunctionResponse(response, false)
 		}
 	}
 
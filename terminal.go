@@ -6,10 +6,15 @@ package main
 
 import (
 	// Modules in GOROOT
+	"bytes"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	// External modules
 	survey "github.com/AlecAivazis/survey/v2"
@@ -74,10 +79,18 @@ func getTerminalDimensions(program Program) (terminalDimensions, functionRespons
 //// COMMAND EXECUTION
 //
 
-func runHook(hookPath string, env map[string]string, printOutput bool, printFinished bool, showRulers bool, printEntryCmd bool, printAlerts bool, program Program) (ptywrapper.Command, functionResponse) {
+// Result of a hook run: the completed command plus the status reported by the
+// hook through $SYNCTROPY_STATUS_FILE
+type hookResult struct {
+	ptywrapper.Command
+	status   hookStatus
+	duration time.Duration
+}
+
+func runHook(hookPath string, env map[string]string, hookArgs []string, printOutput bool, printFinished bool, showRulers bool, printEntryCmd bool, printAlerts bool, program Program) (hookResult, functionResponse) {
 	// Verify if hook exists
-	if _, err := os.Stat(hookPath); os.IsNotExist(err) {
-		return ptywrapper.Command{}, functionResponse{
+	if hookExists(hookPath) == false {
+		return hookResult{}, functionResponse{
 			exitCode:    1,
 			message:     "Hook not found",
 			logLevel:    "attention",
@@ -85,51 +98,96 @@ func runHook(hookPath string, env map[string]string, printOutput bool, printFini
 		}
 	}
 
+	steps, response := getHookSteps(hookPath, program)
+	if response.exitCode != 0 {
+		return hookResult{}, response
+	}
+
+	// Single-file hook
+	if len(steps) == 1 && steps[0] == hookPath {
+		return runHookScript(hookPath, env, hookArgs, printOutput, printFinished, showRulers, printEntryCmd, printAlerts, program)
+	}
+
+	// Hook directory: run every script in order as a single hook, stopping on the
+	// first failure
+	var outputs []string
+	var result hookResult
+	for index, step := range steps {
+		if printEntryCmd == true {
+			showInfoSectionTitle(fmt.Sprintf("Step (%v/%v): %s", index+1, len(steps), orange.Sprintf(filepath.Base(step))), program.indentLevel+1)
+		}
+
+		stepResult, response := runHookScript(step, env, hookArgs, printOutput, false, showRulers, printEntryCmd, printAlerts, program)
+		if len(stepResult.Output) > 0 {
+			outputs = append(outputs, stepResult.Output)
+		}
+		result.status.merge(stepResult.status)
+		result.duration += stepResult.duration
+
+		if response.exitCode != 0 {
+			response.message = fmt.Sprintf("Step '%v' failed -> %v", filepath.Base(step), response.message)
+			result.Command = stepResult.Command
+			result.Output = strings.Join(outputs, "\n")
+			return result, response
+		}
+	}
+
+	var message string
+	if printFinished == true {
+		message = "Finished"
+	}
+
+	result.Command = ptywrapper.Command{
+		Completed: true,
+		Output:    strings.Join(outputs, "\n"),
+		ExitCode:  0,
+	}
+
+	return result, functionResponse{
+		exitCode:    0,
+		message:     message,
+		logLevel:    "success",
+		indentLevel: program.indentLevel,
+	}
+}
+
+// Runs a single hook script, using its entry command
+func runHookScript(hookPath string, env map[string]string, hookArgs []string, printOutput bool, printFinished bool, showRulers bool, printEntryCmd bool, printAlerts bool, program Program) (hookResult, functionResponse) {
+	// Create the file used by the hook to report its status
+	statusFilePath, response := createHookStatusFile(program)
+	if response.exitCode != 0 {
+		return hookResult{}, response
+	}
+	defer os.Remove(statusFilePath)
+
+	// Expose the extra arguments (if any), the status file and the PID of this
+	// process to the hook
+	hookEnv := make(map[string]string, len(env)+3)
+	for key, value := range env {
+		hookEnv[key] = value
+	}
+	hookEnv["HOOK_ARGS"] = joinHookArgs(hookArgs)
+	hookEnv[hookStatusFileEnvVar] = statusFilePath
+	hookEnv[sshAgentOwnerPIDEnvVar] = strconv.Itoa(os.Getpid())
+
 	// Get the current environment
 	currentEnv := os.Environ()
 
 	// Modify the environment variables
-	if env != nil {
-		for key, value := range env {
-			currentEnv = append(currentEnv, key+"="+value)
-		}
+	for key, value := range hookEnv {
+		currentEnv = append(currentEnv, key+"="+value)
 	}
 
 	// Verify if hook has custom entry command
-	var entryCommand string
-	var entryArgs []string
-
-	customEntryFilePath := hookPath + ".entry"
-	if _, err := os.Stat(customEntryFilePath); err == nil {
-		contents, err := ioutil.ReadFile(customEntryFilePath)
-		if err != nil {
-			return ptywrapper.Command{}, functionResponse{
-				exitCode:    1,
-				message:     fmt.Sprintf("Failed to read custom entry configuration file -> " + err.Error()),
-				logLevel:    "error",
-				indentLevel: program.indentLevel,
-			}
-		}
-		entryCommandString := string(contents)
-		entryCommandString = strings.TrimLeft(entryCommandString, "\n")
-		entryCommandString = strings.TrimRight(entryCommandString, "\n")
-		entryCommandSlice := strings.Split(entryCommandString, " ")
-
-		if len(entryCommandSlice) > 1 {
-			entryCommand = entryCommandSlice[0]
-			entryArgs = append(entryCommandSlice[1:], hookPath)
-		} else {
-			entryCommand = entryCommandSlice[0]
-			entryArgs = []string{hookPath}
-		}
-	} else {
-		entryCommand = program.defaultShell
-		entryArgs = []string{hookPath}
+	entry, response := getHookEntry(hookPath, hookEnv, program)
+	if response.exitCode != 0 {
+		return hookResult{}, response
 	}
+	entry.args = append(entry.args, hookArgs...)
 
 	// Run the hook (using the ptywrapper module)
 	if printEntryCmd == true {
-		showInfoSectionTitle(fmt.Sprintf("Entry command: %s", paleLime.Sprintf(entryCommand)), program.indentLevel+1)
+		showInfoSectionTitle(fmt.Sprintf("Entry command: %s", paleLime.Sprintf(entry.String())), program.indentLevel+1)
 	}
 
 	if printOutput == false && printAlerts == true {
@@ -137,8 +195,8 @@ func runHook(hookPath string, env map[string]string, printOutput bool, printFini
 	}
 
 	cmd := &ptywrapper.Command{
-		Entry:   entryCommand,
-		Args:    entryArgs,
+		Entry:   entry.command,
+		Args:    entry.args,
 		Env:     currentEnv,
 		Discard: !printOutput,
 	}
@@ -147,9 +205,31 @@ func runHook(hookPath string, env map[string]string, printOutput bool, printFini
 		hr("-", 0.5, incrementProgramIndentLevel(program, 1))
 	}
 
-	completedCmd, err := cmd.RunInPTY()
+	hookName := filepath.Base(hookPath)
+	emitEvent(runEvent{Event: "hook_start", Crate: env["CRATE_NAME"], Target: env["TARGET_NAME"], Hook: hookName, Path: hookPath, Command: entry.String()})
+
+	startTime := time.Now()
+	var completedCmd ptywrapper.Command
+	var err error
+	if events != nil {
+		// Report the output of the hook as events
+		completedCmd, err = runCommandWithoutTerminal(cmd, io.MultiWriter(os.Stdout, newHookOutputEventWriter(env["CRATE_NAME"], env["TARGET_NAME"], hookName)))
+	} else if terminal.IsTerminal(int(os.Stdin.Fd())) == true {
+		completedCmd, err = cmd.RunInPTY()
+	} else {
+		// E.g. when run by the daemon or a systemd timer
+		completedCmd, err = runCommandWithoutTerminal(cmd, os.Stdout)
+	}
+	duration := time.Since(startTime)
+
+	exitCode := completedCmd.ExitCode
+	if err != nil {
+		exitCode = -1
+	}
+	emitEvent(runEvent{Event: "hook_end", Crate: env["CRATE_NAME"], Target: env["TARGET_NAME"], Hook: hookName, Path: hookPath, ExitCode: eventInt(exitCode), DurationSeconds: eventSeconds(duration)})
+
 	if err != nil {
-		return ptywrapper.Command{}, functionResponse{
+		return hookResult{}, functionResponse{
 			exitCode:    1,
 			message:     fmt.Sprintf("Failed to execute hook -> " + err.Error()),
 			logLevel:    "error",
@@ -177,10 +257,50 @@ func runHook(hookPath string, env map[string]string, printOutput bool, printFini
 		}
 	}
 
-	return completedCmd, functionResponse{
+	return hookResult{
+		Command:  completedCmd,
+		status:   readHookStatusFile(statusFilePath),
+		duration: duration,
+	}, functionResponse{
 		exitCode:    completedCmd.ExitCode,
 		message:     message,
 		indentLevel: program.indentLevel,
 		logLevel:    logLevel,
 	}
 }
+
+// Runs a command without a pseudo-terminal (which needs the standard input to be
+// a terminal), with the same results as ptywrapper: the output is written to the
+// writer (unless discarded) and saved, and the exit code is kept. The command reads
+// the standard input of the program, so interactive hooks (e.g. passphrase prompts)
+// still work when events are reported.
+func runCommandWithoutTerminal(command *ptywrapper.Command, writer io.Writer) (ptywrapper.Command, error) {
+	cmd := exec.Command(command.Entry, command.Args...)
+	cmd.Env = command.Env
+	cmd.Stdin = os.Stdin
+
+	var output bytes.Buffer
+	if command.Discard == true {
+		cmd.Stdout = &output
+		cmd.Stderr = &output
+	} else {
+		// The same writer, so that it is never written concurrently
+		combined := io.MultiWriter(writer, &output)
+		cmd.Stdout = combined
+		cmd.Stderr = combined
+	}
+
+	err := cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); ok == true {
+		command.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return *command, err
+	} else {
+		command.ExitCode = 0
+	}
+
+	command.Completed = true
+	command.Output = strings.ReplaceAll(strings.Trim(output.String(), "\n"), "\r", "")
+
+	return *command, nil
+}
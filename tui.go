@@ -0,0 +1,1083 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	// External modules
+	color "github.com/gookit/color"
+	terminal "golang.org/x/crypto/ssh/terminal"
+)
+
+//
+//// DASHBOARD
+//
+
+// 'tui' shows every crate and target in a full-screen dashboard, with their state,
+// the age of their last sync and their description (from the 'ls' hook). Syncs and
+// hooks run as separate processes of the program, their output being shown live in
+// the output pane; the 'view' and 'edit' hooks take the whole terminal until they
+// finish.
+
+const tuiHelp = "↑↓ select  s sync  v view  e edit  d enable/disable  h hook  l log  r refresh  PgUp/PgDn scroll  q quit"
+
+// Lines kept in the output pane
+const tuiMaxPaneLines = 2000
+
+var tuiEscapeSequence = regexp.MustCompile(`\x1b\[[0-9;?]*[a-zA-Z]`)
+
+// A crate (target is nil) or a target, as shown in the dashboard
+type tuiRow struct {
+	crate       Crate
+	target      *Target
+	disabled    bool
+	description string
+	roots       string
+	lastRun     time.Time
+	lastOutcome string
+	hasRun      bool
+}
+
+func (row tuiRow) key() string {
+	if row.target == nil {
+		return row.crate.name
+	}
+
+	return row.crate.name + "/" + row.target.name
+}
+
+type tuiJobEvent struct {
+	line     string
+	done     bool
+	exitCode int
+}
+
+type tuiSession struct {
+	program Program
+
+	// The terminal (input of the dashboard) and the standard streams of the program,
+	// given to the interactive hooks
+	tty       *os.File
+	ttyState  *terminal.State
+	stdin     *os.File
+	stdout    *os.File
+	input     *tuiInput
+	jobEvents chan tuiJobEvent
+
+	rows         []tuiRow
+	descriptions map[string]string
+	selected     int
+	offset       int
+
+	paneTitle  string
+	paneLines  []string
+	paneScroll int
+
+	job      *exec.Cmd
+	jobTitle string
+
+	prompting      bool
+	promptText     string
+	status         string
+	confirmingQuit bool
+	quit           bool
+
+	width  int
+	height int
+}
+
+// Loads the crates and their targets (running the 'ls' hooks if asked, the
+// descriptions being kept otherwise)
+func (session *tuiSession) load(describe bool) functionResponse {
+	program := session.program
+
+	runs, response := readJournal(program)
+	if response.exitCode != 0 {
+		return response
+	}
+
+	crates, response := getUserCrates(program)
+	if response.exitCode != 0 {
+		return response
+	}
+
+	if describe == true {
+		session.descriptions = make(map[string]string)
+	}
+
+	selectedKey := ""
+	if session.selected < len(session.rows) {
+		selectedKey = session.rows[session.selected].key()
+	}
+
+	var rows []tuiRow
+	for _, crate := range crates {
+		row := tuiRow{crate: crate}
+		row.disabled, _ = isCrateDisabled(crate, program)
+
+		// The last sync of the crate
+		for index := len(runs) - 1; index >= 0; index-- {
+			if runs[index].Crate == crate.name && runs[index].Operation == "sync" {
+				row.hasRun = true
+				row.lastRun = runs[index].FinishedAt
+				row.lastOutcome = targetOutcomeSuccess
+				if runs[index].ExitCode != 0 {
+					row.lastOutcome = targetOutcomeFailed
+				}
+				break
+			}
+		}
+
+		if describe == true {
+			session.descriptions[row.key()] = getTUIDescription(crate.hooksDir+"/ls", crate.environment, program)
+		}
+		row.description = session.descriptions[row.key()]
+
+		rows = append(rows, row)
+
+		targets, response := getCrateTargets(crate, program)
+		if response.exitCode != 0 {
+			continue
+		}
+
+		for index := range targets {
+			target := targets[index]
+
+			row := tuiRow{crate: crate, target: &target}
+			row.disabled, _ = isTargetDisabled(target, program)
+			_, row.roots = getTargetSyncRoots(target, program)
+
+			if run, record, found := getTargetLastRun(runs, target); found == true {
+				row.hasRun = true
+				row.lastRun = run.FinishedAt
+				row.lastOutcome = record.Outcome
+			}
+
+			if describe == true {
+				session.descriptions[row.key()] = getTUIDescription(resolveTargetHook(target, "ls", program).path, target.environment, program)
+			}
+			row.description = session.descriptions[row.key()]
+
+			rows = append(rows, row)
+		}
+	}
+
+	session.rows = rows
+
+	session.selected = 0
+	for index, row := range rows {
+		if row.key() == selectedKey {
+			session.selected = index
+		}
+	}
+
+	return functionResponse{exitCode: 0}
+}
+
+// Returns the description reported by the 'ls' hook (empty if there is none)
+func getTUIDescription(hookPath string, env map[string]string, program Program) string {
+	result, response := runHook(hookPath, env, nil, false, false, false, false, false, program)
+	if response.exitCode != 0 {
+		return ""
+	}
+
+	if result.status.Description != "" {
+		// Prefer the description reported through the status file
+		return result.status.Description
+	}
+
+	return strings.TrimSpace(strings.Split(result.Output, "\n")[0])
+}
+
+func formatTUIAge(since time.Duration) string {
+	switch {
+	case since < time.Minute:
+		return "just now"
+	case since < time.Hour:
+		return fmt.Sprintf("%vm ago", int(since.Minutes()))
+	case since < 48*time.Hour:
+		return fmt.Sprintf("%vh ago", int(since.Hours()))
+	default:
+		return fmt.Sprintf("%vd ago", int(since.Hours()/24))
+	}
+}
+
+//
+//// DASHBOARD - DISPLAY
+//
+
+type tuiSegment struct {
+	text  string
+	style func(text string) string
+}
+
+// Returns a line of exactly the given width, made of the segments (cut when they do
+// not fit). The selected line is shown in reverse video, without the colors.
+func formatTUILine(width int, selected bool, segments ...tuiSegment) string {
+	var builder strings.Builder
+
+	remaining := width
+	for _, segment := range segments {
+		if remaining <= 0 {
+			break
+		}
+
+		text := segment.text
+		if utf8.RuneCountInString(text) > remaining {
+			text = string([]rune(text)[:remaining])
+		}
+		remaining -= utf8.RuneCountInString(text)
+
+		if selected == false && segment.style != nil && text != "" {
+			text = segment.style(text)
+		}
+		builder.WriteString(text)
+	}
+	builder.WriteString(strings.Repeat(" ", remaining))
+
+	if selected == true {
+		return "\x1b[7m" + builder.String() + "\x1b[0m"
+	}
+
+	return builder.String()
+}
+
+func tuiStyle(c color.RGBColor) func(text string) string {
+	return func(text string) string {
+		return c.Sprint(text)
+	}
+}
+
+func padTUIText(text string, width int) string {
+	if count := utf8.RuneCountInString(text); count < width {
+		return text + strings.Repeat(" ", width-count)
+	}
+
+	return text
+}
+
+func (session *tuiSession) paneHeight() int {
+	height := (session.height - 3) * 2 / 5
+	if height < 3 {
+		height = 3
+	}
+
+	return height
+}
+
+func (session *tuiSession) listHeight() int {
+	height := session.height - 3 - session.paneHeight()
+	if height < 1 {
+		height = 1
+	}
+
+	return height
+}
+
+func (session *tuiSession) render() {
+	width, height, err := terminal.GetSize(int(session.tty.Fd()))
+	if err == nil {
+		session.width = width
+		session.height = height
+	}
+
+	var lines []string
+
+	// Title
+	title := fmt.Sprintf(" %v — %v", session.program.name, session.program.userDataDir)
+	lines = append(lines, formatTUILine(session.width, true, tuiSegment{text: title}))
+
+	// Crates and targets
+	nameWidth := 0
+	lastWidth := 0
+	for _, row := range session.rows {
+		if width := utf8.RuneCountInString(session.formatRowName(row)); width > nameWidth {
+			nameWidth = width
+		}
+		if width := utf8.RuneCountInString(formatTUILastRun(row)); width > lastWidth {
+			lastWidth = width
+		}
+	}
+	if nameWidth > 40 {
+		nameWidth = 40
+	}
+
+	listHeight := session.listHeight()
+	if session.selected < session.offset {
+		session.offset = session.selected
+	} else if session.selected >= session.offset+listHeight {
+		session.offset = session.selected - listHeight + 1
+	}
+
+	for index := session.offset; index < session.offset+listHeight; index++ {
+		if index >= len(session.rows) {
+			lines = append(lines, "")
+			continue
+		}
+		lines = append(lines, session.formatRow(session.rows[index], index == session.selected, nameWidth, lastWidth))
+	}
+
+	// Output pane
+	paneTitle := "─ " + session.paneTitle + " "
+	lines = append(lines, formatTUILine(session.width, false, tuiSegment{text: paneTitle + strings.Repeat("─", session.width), style: tuiStyle(gray)}))
+
+	paneHeight := session.paneHeight()
+	end := len(session.paneLines) - session.paneScroll
+	start := end - paneHeight
+	if start < 0 {
+		start = 0
+	}
+	for index := start; index < start+paneHeight; index++ {
+		if index >= end {
+			lines = append(lines, "")
+			continue
+		}
+		lines = append(lines, formatTUILine(session.width, false, tuiSegment{text: session.paneLines[index]}))
+	}
+
+	// Status line
+	switch {
+	case session.prompting == true:
+		lines = append(lines, formatTUILine(session.width, false, tuiSegment{text: "Hook to run: ", style: tuiStyle(orange)}, tuiSegment{text: session.promptText + "█"}))
+	case session.status != "":
+		lines = append(lines, formatTUILine(session.width, false, tuiSegment{text: session.status, style: tuiStyle(orange)}))
+	default:
+		lines = append(lines, formatTUILine(session.width, false, tuiSegment{text: tuiHelp, style: tuiStyle(gray)}))
+	}
+
+	if len(lines) > session.height {
+		lines = lines[:session.height]
+	}
+
+	var frame strings.Builder
+	frame.WriteString("\x1b[H")
+	for index, line := range lines {
+		frame.WriteString(line)
+		frame.WriteString("\x1b[K")
+		if index < len(lines)-1 {
+			frame.WriteString("\r\n")
+		}
+	}
+	frame.WriteString("\x1b[J")
+
+	session.tty.WriteString(frame.String())
+}
+
+func (session *tuiSession) formatRowName(row tuiRow) string {
+	if row.target == nil {
+		return " " + row.crate.name
+	}
+
+	return "   " + row.target.name
+}
+
+func formatTUILastRun(row tuiRow) string {
+	if row.hasRun == false {
+		return "never"
+	}
+
+	return formatTUIAge(time.Since(row.lastRun)) + " " + row.lastOutcome
+}
+
+func (session *tuiSession) formatRow(row tuiRow, selected bool, nameWidth int, lastWidth int) string {
+	name := tuiSegment{text: padTUIText(session.formatRowName(row), nameWidth) + "  "}
+	if row.target == nil {
+		name.style = tuiStyle(salmonPink)
+	}
+
+	state := tuiSegment{text: "enabled   ", style: tuiStyle(green)}
+	if row.disabled == true {
+		state = tuiSegment{text: "disabled  ", style: tuiStyle(red)}
+	}
+
+	last := tuiSegment{text: padTUIText(formatTUILastRun(row), lastWidth) + "  ", style: tuiStyle(gray)}
+	switch row.lastOutcome {
+	case targetOutcomeSuccess:
+		last.style = tuiStyle(green)
+	case targetOutcomeFailed:
+		last.style = tuiStyle(red)
+	case targetOutcomeSkipped, targetOutcomeDisabled:
+		last.style = tuiStyle(orange)
+	}
+
+	description := tuiSegment{text: row.description, style: tuiStyle(blue)}
+	if row.description != "" {
+		description.text += " "
+	}
+
+	roots := tuiSegment{text: row.roots, style: tuiStyle(gray)}
+
+	return formatTUILine(session.width, selected, name, state, last, description, roots)
+}
+
+func (session *tuiSession) enterScreen() {
+	// Alternate screen, hidden cursor
+	session.tty.WriteString("\x1b[?1049h\x1b[?25l")
+}
+
+func (session *tuiSession) leaveScreen() {
+	session.tty.WriteString("\x1b[?25h\x1b[?1049l")
+}
+
+func (session *tuiSession) setPane(title string, lines []string) {
+	session.paneTitle = title
+	session.paneLines = lines
+	session.paneScroll = 0
+}
+
+func (session *tuiSession) appendPaneLine(line string) {
+	line = tuiEscapeSequence.ReplaceAllString(line, "")
+	line = strings.TrimRight(line, "\r\n")
+	// Keep what a carriage return would have left on screen (e.g. progress bars)
+	if index := strings.LastIndex(line, "\r"); index != -1 {
+		line = line[index+1:]
+	}
+	line = strings.ReplaceAll(line, "\t", "    ")
+
+	session.paneLines = append(session.paneLines, line)
+	if len(session.paneLines) > tuiMaxPaneLines {
+		session.paneLines = session.paneLines[len(session.paneLines)-tuiMaxPaneLines:]
+	}
+}
+
+//
+//// DASHBOARD - INPUT
+//
+
+// Reads the keys pressed in the terminal. The reads time out regularly, so that
+// the reader can be paused while an interactive hook uses the terminal.
+type tuiInput struct {
+	tty    *os.File
+	keys   chan string
+	pauses chan chan struct{}
+	resume chan struct{}
+}
+
+var tuiKeySequences = map[string]string{
+	"\x1b[A":  "up",
+	"\x1bOA":  "up",
+	"\x1b[B":  "down",
+	"\x1bOB":  "down",
+	"\x1b[5~": "pgup",
+	"\x1b[6~": "pgdown",
+	"\x1b[H":  "home",
+	"\x1b[1~": "home",
+	"\x1b[F":  "end",
+	"\x1b[4~": "end",
+}
+
+func parseTUIKeys(data []byte) []string {
+	var keys []string
+
+	for len(data) > 0 {
+		if data[0] == '\x1b' {
+			matched := false
+			for sequence, key := range tuiKeySequences {
+				if strings.HasPrefix(string(data), sequence) {
+					keys = append(keys, key)
+					data = data[len(sequence):]
+					matched = true
+					break
+				}
+			}
+			if matched == true {
+				continue
+			}
+
+			if len(data) == 1 {
+				keys = append(keys, "esc")
+				break
+			}
+
+			// Ignore other sequences (up to their final byte)
+			index := 1
+			if data[1] == '[' || data[1] == 'O' {
+				index = 2
+				for index < len(data) && (data[index] < 0x40 || data[index] > 0x7e) {
+					index++
+				}
+				index++
+			}
+			if index > len(data) {
+				index = len(data)
+			}
+			data = data[index:]
+			continue
+		}
+
+		switch data[0] {
+		case '\r', '\n':
+			keys = append(keys, "enter")
+			data = data[1:]
+		case 0x7f, 0x08:
+			keys = append(keys, "backspace")
+			data = data[1:]
+		case 0x03:
+			keys = append(keys, "ctrl-c")
+			data = data[1:]
+		default:
+			r, size := utf8.DecodeRune(data)
+			keys = append(keys, string(r))
+			data = data[size:]
+		}
+	}
+
+	return keys
+}
+
+func (input *tuiInput) run() {
+	buffer := make([]byte, 256)
+
+	for {
+		select {
+		case acknowledge := <-input.pauses:
+			close(acknowledge)
+			<-input.resume
+			continue
+		default:
+		}
+
+		input.tty.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		count, err := input.tty.Read(buffer)
+		if errors.Is(err, os.ErrDeadlineExceeded) == true {
+			continue
+		} else if err != nil {
+			close(input.keys)
+			return
+		}
+
+		for _, key := range parseTUIKeys(buffer[:count]) {
+			select {
+			case input.keys <- key:
+			default:
+				// Drop the keys pressed too fast
+			}
+		}
+	}
+}
+
+// Stops reading the terminal (once the current read timed out)
+func (input *tuiInput) pause() {
+	acknowledge := make(chan struct{})
+	input.pauses <- acknowledge
+	<-acknowledge
+}
+
+func (input *tuiInput) unpause() {
+	input.resume <- struct{}{}
+}
+
+//
+//// DASHBOARD - ACTIONS
+//
+
+func (session *tuiSession) selectedRow() (tuiRow, bool) {
+	if session.selected < 0 || session.selected >= len(session.rows) {
+		return tuiRow{}, false
+	}
+
+	return session.rows[session.selected], true
+}
+
+// Returns the arguments selecting the crate or the target of the row
+func (row tuiRow) selectionArgs() []string {
+	if row.target == nil {
+		return []string{"-c", row.crate.name}
+	}
+
+	return []string{"-c", row.crate.name, "-t", row.target.name}
+}
+
+// Runs the program in the background, its output being shown in the output pane
+func (session *tuiSession) startJob(title string, args []string) {
+	if session.job != nil {
+		session.status = fmt.Sprintf("Already running: %v", session.jobTitle)
+		return
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		session.status = "Failed to start -> " + err.Error()
+		return
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	// Not interrupted by the keys pressed in interactive hooks
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err = cmd.Start()
+	writer.Close()
+	if err != nil {
+		reader.Close()
+		session.status = "Failed to start -> " + err.Error()
+		return
+	}
+
+	session.job = cmd
+	session.jobTitle = title
+	session.setPane(title+" (running)", nil)
+
+	go func() {
+		buffered := bufio.NewReader(reader)
+		for {
+			line, err := buffered.ReadString('\n')
+			if line != "" {
+				session.jobEvents <- tuiJobEvent{line: line}
+			}
+			if err != nil {
+				break
+			}
+		}
+		reader.Close()
+
+		exitCode := 0
+		if err := cmd.Wait(); err != nil {
+			exitCode = -1
+			if exitErr, ok := err.(*exec.ExitError); ok == true {
+				exitCode = exitErr.ExitCode()
+			}
+		}
+
+		session.jobEvents <- tuiJobEvent{done: true, exitCode: exitCode}
+	}()
+}
+
+func (session *tuiSession) handleJobEvent(event tuiJobEvent) {
+	if event.done == false {
+		session.appendPaneLine(event.line)
+		return
+	}
+
+	if event.exitCode == 0 {
+		session.paneTitle = session.jobTitle + " (finished)"
+	} else {
+		session.paneTitle = fmt.Sprintf("%v (failed: exit code %v)", session.jobTitle, event.exitCode)
+	}
+	session.job = nil
+
+	// Show the new state and last run
+	if response := session.load(false); response.exitCode != 0 {
+		session.status = response.message
+	}
+}
+
+// Runs the program with the whole terminal (e.g. the 'view' and 'edit' hooks), then
+// comes back to the dashboard
+func (session *tuiSession) runInteractive(args []string) {
+	session.input.pause()
+	session.leaveScreen()
+	terminal.Restore(int(session.tty.Fd()), session.ttyState)
+
+	// Ctrl+C is for the hook
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, syscall.SIGINT)
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = session.stdin
+	cmd.Stdout = session.stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok == false {
+			fmt.Fprintf(session.stdout, "Failed to run -> %v\n", err.Error())
+		}
+	}
+
+	// The hooks run in a pseudo-terminal leave the standard input non-blocking
+	syscall.SetNonblock(int(session.stdin.Fd()), false)
+
+	fmt.Fprint(session.stdout, "\nPress Enter to return to the dashboard")
+	bufio.NewReader(session.stdin).ReadString('\n')
+
+	signal.Stop(interrupts)
+
+	terminal.MakeRaw(int(session.tty.Fd()))
+	session.enterScreen()
+	session.input.unpause()
+
+	if response := session.load(false); response.exitCode != 0 {
+		session.status = response.message
+	}
+}
+
+func (session *tuiSession) toggleSelected() {
+	row, exists := session.selectedRow()
+	if exists == false {
+		return
+	}
+
+	var response functionResponse
+	switch {
+	case row.target == nil && row.disabled == true:
+		response = enableCrate(row.crate, session.program)
+	case row.target == nil:
+		response = disableCrate(row.crate, session.program)
+	case row.disabled == true:
+		response = enableTarget(*row.target, session.program)
+	default:
+		response = disableTarget(*row.target, session.program)
+	}
+
+	if response.exitCode != 0 {
+		session.status = response.message
+		return
+	}
+
+	if row.disabled == true {
+		session.status = fmt.Sprintf("Enabled %v", row.key())
+	} else {
+		session.status = fmt.Sprintf("Disabled %v", row.key())
+	}
+
+	if response := session.load(false); response.exitCode != 0 {
+		session.status = response.message
+	}
+}
+
+// Shows the runs of the journal involving the selected crate or target
+func (session *tuiSession) showLog() {
+	row, exists := session.selectedRow()
+	if exists == false {
+		return
+	}
+
+	runs, response := readJournal(session.program)
+	if response.exitCode != 0 {
+		session.status = response.message
+		return
+	}
+
+	var lines []string
+	for _, run := range runs {
+		if run.Crate != row.crate.name {
+			continue
+		}
+
+		startedAt := run.StartedAt.Local().Format("2006-01-02 15:04:05")
+
+		if row.target == nil {
+			outcome := targetOutcomeSuccess
+			if run.ExitCode != 0 {
+				outcome = fmt.Sprintf("%v (exit code %v)", targetOutcomeFailed, run.ExitCode)
+			}
+			lines = append(lines, fmt.Sprintf("%v  %v  %v  %.1fs  %v target(s)", startedAt, run.Operation, outcome, run.DurationSeconds, len(run.Targets)))
+			continue
+		}
+
+		for _, record := range run.Targets {
+			if record.Name != row.target.name {
+				continue
+			}
+
+			details := fmt.Sprintf("%.1fs", record.DurationSeconds)
+			if record.Outcome == targetOutcomeFailed {
+				details += fmt.Sprintf(", exit code %v", record.ExitCode)
+			}
+			if record.Status.Skip != "" {
+				details += ", " + record.Status.Skip
+			}
+			if record.Snapshot != "" {
+				details += ", snapshot " + record.Snapshot
+			}
+			lines = append(lines, fmt.Sprintf("%v  %v  %v  %v", startedAt, run.Operation, record.Outcome, details))
+
+			for _, hook := range record.Hooks {
+				lines = append(lines, fmt.Sprintf("    %v: exit code %v (%.1fs)", hook.Hook, hook.ExitCode, hook.DurationSeconds))
+			}
+		}
+	}
+
+	if len(lines) == 0 {
+		lines = append(lines, "No runs in the journal")
+	}
+
+	session.setPane(fmt.Sprintf("Journal: %v", row.key()), lines)
+}
+
+func (session *tuiSession) handleKey(key string) {
+	if session.prompting == true {
+		switch key {
+		case "enter":
+			session.prompting = false
+			hook := strings.TrimSpace(session.promptText)
+			row, exists := session.selectedRow()
+			if hook == "" || exists == false {
+				return
+			}
+
+			args := getProgramArgs(session.program)
+			if row.target == nil {
+				args = append(args, "crates", "hooks", "run")
+			} else {
+				args = append(args, "targets", "hooks", "run")
+			}
+			args = append(append(args, row.selectionArgs()...), "-k", hook)
+			session.startJob(fmt.Sprintf("Hook '%v': %v", hook, row.key()), args)
+		case "esc", "ctrl-c":
+			session.prompting = false
+		case "backspace":
+			if text := []rune(session.promptText); len(text) > 0 {
+				session.promptText = string(text[:len(text)-1])
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				session.promptText += key
+			}
+		}
+		return
+	}
+
+	session.status = ""
+	if key != "q" && key != "ctrl-c" {
+		session.confirmingQuit = false
+	}
+
+	switch key {
+	case "up", "k":
+		if session.selected > 0 {
+			session.selected--
+		}
+	case "down", "j":
+		if session.selected < len(session.rows)-1 {
+			session.selected++
+		}
+	case "home", "g":
+		session.selected = 0
+	case "end", "G":
+		session.selected = len(session.rows) - 1
+	case "pgup":
+		session.paneScroll += session.paneHeight()
+		if maximum := len(session.paneLines) - session.paneHeight(); session.paneScroll > maximum {
+			session.paneScroll = maximum
+		}
+		if session.paneScroll < 0 {
+			session.paneScroll = 0
+		}
+	case "pgdown":
+		session.paneScroll -= session.paneHeight()
+		if session.paneScroll < 0 {
+			session.paneScroll = 0
+		}
+	case "s":
+		if row, exists := session.selectedRow(); exists == true {
+			target := ""
+			if row.target != nil {
+				target = row.target.name
+			}
+			session.startJob(fmt.Sprintf("Sync: %v", row.key()), getScheduleSyncArgs(row.crate.name, target, session.program))
+		}
+	case "v", "e":
+		if row, exists := session.selectedRow(); exists == true {
+			hook := "view"
+			if key == "e" {
+				hook = "edit"
+			}
+
+			args := getProgramArgs(session.program)
+			if row.target == nil {
+				args = append(args, "crates", hook)
+			} else {
+				args = append(args, "targets", hook)
+			}
+			session.runInteractive(append(args, row.selectionArgs()...))
+		}
+	case "d":
+		session.toggleSelected()
+	case "h":
+		if _, exists := session.selectedRow(); exists == true {
+			session.prompting = true
+			session.promptText = ""
+		}
+	case "l":
+		session.showLog()
+	case "r":
+		session.status = "Refreshing..."
+		session.render()
+		session.status = ""
+		if response := session.load(true); response.exitCode != 0 {
+			session.status = response.message
+		}
+	case "q", "ctrl-c":
+		if session.job != nil && session.confirmingQuit == false {
+			session.confirmingQuit = true
+			session.status = fmt.Sprintf("Still running: %v. Press q again to interrupt it and quit.", session.jobTitle)
+		} else {
+			session.quit = true
+		}
+	}
+}
+
+// Interrupts the running job (if any) and waits for it to finish
+func (session *tuiSession) stopJob() {
+	if session.job == nil {
+		return
+	}
+
+	// The whole process group, as Ctrl+C would
+	syscall.Kill(-session.job.Process.Pid, syscall.SIGINT)
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case event := <-session.jobEvents:
+			if event.done == true {
+				session.job = nil
+				return
+			}
+		case <-timeout:
+			syscall.Kill(-session.job.Process.Pid, syscall.SIGKILL)
+			timeout = nil
+		}
+	}
+}
+
+func tui(program Program) functionResponse {
+	if terminal.IsTerminal(int(os.Stdin.Fd())) == false || terminal.IsTerminal(int(os.Stdout.Fd())) == false {
+		return functionResponse{
+			exitCode:    1,
+			message:     "The dashboard needs a terminal",
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	// Separate descriptors of the terminal: one to draw the dashboard and one to read
+	// the keys, with reads that time out (which Fd() would prevent)
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to open the terminal -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	defer tty.Close()
+
+	ttyInput, err := os.Open("/dev/tty")
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to open the terminal -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	defer ttyInput.Close()
+
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to open %v -> %v", os.DevNull, err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	defer devNull.Close()
+
+	showInfoSectionTitle("Loading crates and targets", program.indentLevel)
+
+	session := &tuiSession{
+		program:   program,
+		tty:       tty,
+		stdin:     os.Stdin,
+		stdout:    os.Stdout,
+		jobEvents: make(chan tuiJobEvent, 256),
+		input: &tuiInput{
+			tty:    ttyInput,
+			keys:   make(chan string, 64),
+			pauses: make(chan chan struct{}),
+			resume: make(chan struct{}),
+		},
+		paneTitle: "Output",
+	}
+
+	// The messages of the program would break the dashboard, and the hooks it runs
+	// (e.g. 'ls') must not use the terminal
+	os.Stdin = devNull
+	os.Stdout = devNull
+	color.SetOutput(io.Discard)
+	defer func() {
+		os.Stdin = session.stdin
+		os.Stdout = session.stdout
+		color.SetOutput(session.stdout)
+	}()
+
+	response := session.load(true)
+	if response.exitCode != 0 {
+		return response
+	}
+
+	session.ttyState, err = terminal.MakeRaw(int(tty.Fd()))
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to set up the terminal -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	session.enterScreen()
+	defer func() {
+		session.leaveScreen()
+		terminal.Restore(int(tty.Fd()), session.ttyState)
+	}()
+
+	resizes := make(chan os.Signal, 1)
+	signal.Notify(resizes, syscall.SIGWINCH)
+	defer signal.Stop(resizes)
+
+	// Keep the ages up to date
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	go session.input.run()
+
+	for session.quit == false {
+		session.render()
+
+		select {
+		case key, open := <-session.input.keys:
+			if open == false {
+				session.quit = true
+				break
+			}
+			session.handleKey(key)
+		case event := <-session.jobEvents:
+			session.handleJobEvent(event)
+
+			// Show the pending output at once
+			for pending := true; pending == true; {
+				select {
+				case event := <-session.jobEvents:
+					session.handleJobEvent(event)
+				default:
+					pending = false
+				}
+			}
+		case <-resizes:
+		case <-ticker.C:
+		}
+	}
+
+	session.stopJob()
+
+	return functionResponse{exitCode: 0}
+}
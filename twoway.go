@@ -0,0 +1,518 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+	// External modules
+)
+
+//
+//// SYNC ARCHIVE
+//
+
+// The two-way engine keeps, for each target, an archive with the state of both
+// roots after the last sync. Comparing the current state of each root with the
+// archive tells which side changed a path since then.
+
+const (
+	syncKindFile    = "file"
+	syncKindDir     = "dir"
+	syncKindSymlink = "symlink"
+)
+
+type syncArchiveState struct {
+	Kind       string    `json:"kind"`
+	Size       int64     `json:"size,omitempty"`
+	ModTime    time.Time `json:"mtime,omitempty"`
+	Hash       string    `json:"hash,omitempty"`
+	LinkTarget string    `json:"link,omitempty"`
+}
+
+type syncArchiveEntry struct {
+	A *syncArchiveState `json:"a,omitempty"`
+	B *syncArchiveState `json:"b,omitempty"`
+}
+
+type syncArchive struct {
+	Roots     []string                    `json:"roots"`
+	UpdatedAt time.Time                   `json:"updated_at"`
+	Entries   map[string]syncArchiveEntry `json:"entries"`
+}
+
+func getSyncArchivePath(target Target) string {
+	return target.path + "/archive.json"
+}
+
+func getSyncConflictReportPath(target Target) string {
+	return target.path + "/conflicts.json"
+}
+
+// Reads the archive of a target. An archive made for other roots is ignored (the
+// next sync behaves like the first one).
+func readSyncArchive(target Target, roots []string) (syncArchive, error) {
+	archive := syncArchive{Roots: roots, Entries: make(map[string]syncArchiveEntry)}
+
+	content, err := ioutil.ReadFile(getSyncArchivePath(target))
+	if os.IsNotExist(err) {
+		return archive, nil
+	} else if err != nil {
+		return archive, err
+	}
+
+	var stored syncArchive
+	err = json.Unmarshal(content, &stored)
+	if err != nil {
+		return archive, fmt.Errorf("invalid archive %v -> %v", getSyncArchivePath(target), err.Error())
+	}
+
+	if len(stored.Roots) != len(roots) || stored.Roots[0] != roots[0] || stored.Roots[1] != roots[1] || stored.Entries == nil {
+		return archive, nil
+	}
+
+	return stored, nil
+}
+
+func writeSyncArchive(target Target, archive syncArchive) error {
+	archive.UpdatedAt = time.Now()
+
+	content, err := json.Marshal(archive)
+	if err != nil {
+		return err
+	}
+
+	// Write the new archive next to the old one, then replace it
+	tempPath := getSyncArchivePath(target) + ".tmp"
+	err = ioutil.WriteFile(tempPath, content, 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, getSyncArchivePath(target))
+}
+
+func sameSyncContent(a *syncArchiveState, b *syncArchiveState) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	if a.Kind != b.Kind {
+		return false
+	}
+
+	switch a.Kind {
+	case syncKindFile:
+		return a.Size == b.Size && a.Hash == b.Hash
+	case syncKindSymlink:
+		return a.LinkTarget == b.LinkTarget
+	default:
+		return true
+	}
+}
+
+func describeSyncState(state *syncArchiveState) string {
+	if state == nil {
+		return "deleted"
+	}
+
+	return state.Kind
+}
+
+//
+//// CONFLICT REPORT
+//
+
+type syncConflict struct {
+	Path       string    `json:"path"`
+	A          string    `json:"a"`
+	B          string    `json:"b"`
+	Copies     []string  `json:"copies,omitempty"`
+	Unresolved bool      `json:"unresolved"`
+	DetectedAt time.Time `json:"detected_at"`
+}
+
+// Writes the conflicts found in the last sync (or removes the report if there were
+// none)
+func writeSyncConflictReport(target Target, conflicts []syncConflict) error {
+	if len(conflicts) == 0 {
+		err := os.Remove(getSyncConflictReportPath(target))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	content, err := json.MarshalIndent(conflicts, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(getSyncConflictReportPath(target), append(content, '\n'), 0644)
+}
+
+// Name of the copy of a conflicting file, keeping its extension (e.g.
+// 'notes.conflict-b-20060102-150405.txt')
+func getSyncConflictCopyName(name string, side string, timestamp time.Time) string {
+	extension := path.Ext(name)
+	if strings.HasPrefix(path.Base(name), ".") == true && path.Base(name) == extension {
+		extension = ""
+	}
+
+	return fmt.Sprintf("%v.conflict-%v-%v%v", strings.TrimSuffix(name, extension), side, timestamp.Format("20060102-150405"), extension)
+}
+
+//
+//// TWO-WAY ENGINE
+//
+
+type twoWaySide struct {
+	label string
+	fs    syncFS
+	tree  map[string]syncEntry
+}
+
+// Current state of a path on one side, and whether it changed since the last sync
+func (side twoWaySide) state(name string, archived *syncArchiveState, checksum bool) (*syncArchiveState, bool, error) {
+	entry, exists := side.tree[name]
+	if exists == false {
+		return nil, archived != nil, nil
+	}
+
+	state := &syncArchiveState{Kind: syncKindFile, Size: entry.size, ModTime: entry.modTime}
+	switch {
+	case entry.isDir == true:
+		state = &syncArchiveState{Kind: syncKindDir}
+	case entry.isSymlink == true:
+		state = &syncArchiveState{Kind: syncKindSymlink, LinkTarget: entry.linkTarget}
+	default:
+		// Only hash files whose size or modification time changed (unless checksums
+		// are always requested)
+		if checksum == false && archived != nil && archived.Kind == syncKindFile && archived.Size == state.Size && archived.ModTime.Equal(state.ModTime) == true {
+			state.Hash = archived.Hash
+			return state, false, nil
+		}
+
+		hash, err := hashSyncFile(side.fs, name)
+		if err != nil {
+			return nil, false, err
+		}
+		state.Hash = hash
+	}
+
+	return state, sameSyncContent(state, archived) == false, nil
+}
+
+func (entry syncArchiveEntry) side(label string) *syncArchiveState {
+	if label == "A" {
+		return entry.A
+	}
+
+	return entry.B
+}
+
+func (entry *syncArchiveEntry) setSide(label string, state *syncArchiveState) {
+	if label == "A" {
+		entry.A = state
+	} else {
+		entry.B = state
+	}
+}
+
+// Two-way sync: changes made on either root since the last sync are applied to the
+// other one. Paths changed on both sides in different ways are conflicts: each root
+// keeps its own version, copies of both versions are written to both roots, and the
+// conflict is written to the target's conflict report.
+func runTwoWayEngine(target Target, fsA syncFS, fsB syncFS, config syncEngineConfig, program Program) (syncStats, error) {
+	var stats syncStats
+	checksum := config.Compare == syncCompareChecksum
+
+	archive, err := readSyncArchive(target, []string{fsA.String(), fsB.String()})
+	if err != nil {
+		return stats, fmt.Errorf("failed to read archive -> %v", err.Error())
+	}
+
+	sides := []*twoWaySide{{label: "A", fs: fsA}, {label: "B", fs: fsB}}
+	for _, side := range sides {
+		// Both roots must exist, otherwise an unmounted disk would look like every
+		// file was deleted
+		info, err := side.fs.Lstat("")
+		if err != nil {
+			return stats, fmt.Errorf("failed to read root %v -> %v", side.label, err.Error())
+		} else if info.IsDir() == false {
+			return stats, fmt.Errorf("root %v is not a directory", side.label)
+		}
+
+		tree, excluded, err := scanSyncTree(side.fs, config.Exclude)
+		if err != nil {
+			return stats, fmt.Errorf("failed to scan root %v -> %v", side.label, err.Error())
+		}
+		side.tree = tree
+		stats.excluded += excluded
+
+		archived := 0
+		for _, entry := range archive.Entries {
+			if entry.side(side.label) != nil {
+				archived++
+			}
+		}
+		if len(tree) == 0 && archived > 0 {
+			return stats, fmt.Errorf("root %v is empty but had %v entries in the last sync, refusing to delete them from the other root (remove %v to start over)", side.label, archived, getSyncArchivePath(target))
+		}
+	}
+
+	// Union of the paths known by both roots and the archive
+	union := make(map[string]syncEntry)
+	for _, side := range sides {
+		for name, entry := range side.tree {
+			union[name] = entry
+		}
+	}
+	for name := range archive.Entries {
+		if _, exists := union[name]; exists == false {
+			union[name] = syncEntry{path: name}
+		}
+	}
+	paths := sortedSyncPaths(union)
+
+	type syncDeletion struct {
+		side *twoWaySide
+		name string
+	}
+	var deletions []syncDeletion
+	var conflicts []syncConflict
+	now := time.Now()
+
+	for _, name := range paths {
+		archived := archive.Entries[name]
+
+		stateA, changedA, err := sides[0].state(name, archived.A, checksum)
+		if err != nil {
+			stats.addError(name, err)
+			continue
+		}
+		stateB, changedB, err := sides[1].state(name, archived.B, checksum)
+		if err != nil {
+			stats.addError(name, err)
+			continue
+		}
+
+		if entry := union[name]; entry.isDir == false && (stateA != nil || stateB != nil) {
+			stats.scanned++
+		}
+
+		switch {
+		case changedA == false && changedB == false:
+			archive.Entries[name] = syncArchiveEntry{A: stateA, B: stateB}
+			if sameSyncContent(stateA, stateB) == false {
+				stats.unresolved++
+				conflicts = append(conflicts, syncConflict{Path: name, A: describeSyncState(stateA), B: describeSyncState(stateB), Unresolved: true, DetectedAt: now})
+			} else if stateA != nil && stateA.Kind != syncKindDir {
+				stats.unchanged++
+			}
+
+		case changedA == true && changedB == true && sameSyncContent(stateA, stateB) == true:
+			// Same change on both sides
+			if stateA == nil {
+				delete(archive.Entries, name)
+			} else {
+				archive.Entries[name] = syncArchiveEntry{A: stateA, B: stateB}
+			}
+
+		case changedA == true && changedB == true:
+			conflict := syncConflict{Path: name, A: describeSyncState(stateA), B: describeSyncState(stateB), DetectedAt: now}
+
+			// Keep both versions of conflicting files on both roots
+			if stateA != nil && stateB != nil && stateA.Kind == syncKindFile && stateB.Kind == syncKindFile {
+				for _, source := range sides {
+					copyName := getSyncConflictCopyName(name, strings.ToLower(source.label), now)
+					copyEntry := syncArchiveEntry{}
+
+					for _, destination := range sides {
+						copyState, err := twoWayCopy(source, destination, source.tree[name], copyName, config, &stats)
+						if err != nil {
+							stats.addError(copyName, err)
+							continue
+						}
+						copyEntry.setSide(destination.label, copyState)
+					}
+
+					archive.Entries[copyName] = copyEntry
+					conflict.Copies = append(conflict.Copies, copyName)
+				}
+			}
+
+			archive.Entries[name] = syncArchiveEntry{A: stateA, B: stateB}
+			stats.conflicts++
+			conflicts = append(conflicts, conflict)
+			showSyncChange("!", fmt.Sprintf("%v (conflict: %v on A, %v on B)", name, describeSyncState(stateA), describeSyncState(stateB)), program)
+
+		default:
+			from, to := sides[0], sides[1]
+			state := stateA
+			if changedB == true {
+				from, to = sides[1], sides[0]
+				state = stateB
+			}
+
+			if state == nil {
+				if _, exists := to.tree[name]; exists == true {
+					deletions = append(deletions, syncDeletion{side: to, name: name})
+				} else {
+					delete(archive.Entries, name)
+				}
+				continue
+			}
+
+			toEntry, exists := to.tree[name]
+			if exists == true && toEntry.sameKind(from.tree[name]) == false {
+				removed, err := removeSyncPath(to.fs, toEntry, config.Exclude)
+				for toName := range to.tree {
+					if toName == name || strings.HasPrefix(toName, name+"/") == true {
+						delete(to.tree, toName)
+					}
+				}
+				if err == nil && removed == false {
+					err = errSyncPathHasExcluded
+				}
+				if err != nil {
+					stats.addError(name, err)
+					continue
+				}
+				exists = false
+			}
+
+			copyState, err := twoWayCopy(from, to, from.tree[name], name, config, &stats)
+			if err != nil {
+				stats.addError(name, err)
+				continue
+			}
+
+			entry := syncArchiveEntry{}
+			entry.setSide(from.label, state)
+			entry.setSide(to.label, copyState)
+			archive.Entries[name] = entry
+
+			if state.Kind == syncKindDir {
+				if exists == false {
+					stats.dirsCreated++
+					showSyncChange("+", fmt.Sprintf("%v/ (%v → %v)", name, from.label, to.label), program)
+				}
+			} else if exists == true {
+				stats.updated++
+				showSyncChange("~", fmt.Sprintf("%v (%v → %v)", name, from.label, to.label), program)
+			} else {
+				stats.created++
+				showSyncChange("+", fmt.Sprintf("%v (%v → %v)", name, from.label, to.label), program)
+			}
+		}
+	}
+
+	// Propagate deletions (contents before their directories). Directories that
+	// still have contents (e.g. new files from the other root) are kept.
+	for i := len(deletions) - 1; i >= 0; i-- {
+		deletion := deletions[i]
+		entry := deletion.side.tree[deletion.name]
+
+		err := deletion.side.fs.Remove(deletion.name)
+		if err != nil && entry.isDir == true {
+			other := sides[0]
+			if deletion.side == sides[0] {
+				other = sides[1]
+			}
+
+			err = other.fs.MkdirAll(deletion.name)
+			if err != nil {
+				stats.addError(deletion.name, err)
+				continue
+			}
+
+			archived := archive.Entries[deletion.name]
+			archived.setSide(other.label, &syncArchiveState{Kind: syncKindDir})
+			archive.Entries[deletion.name] = archived
+			showSyncChange("+", fmt.Sprintf("%v/ (kept, not empty on %v)", deletion.name, deletion.side.label), program)
+			continue
+		} else if err != nil {
+			stats.addError(deletion.name, err)
+			continue
+		}
+
+		delete(archive.Entries, deletion.name)
+		stats.deleted++
+		showSyncChange("-", fmt.Sprintf("%v (%v)", deletion.name, deletion.side.label), program)
+	}
+
+	err = writeSyncArchive(target, archive)
+	if err != nil {
+		return stats, fmt.Errorf("failed to write archive -> %v", err.Error())
+	}
+
+	err = writeSyncConflictReport(target, conflicts)
+	if err != nil {
+		return stats, fmt.Errorf("failed to write conflict report -> %v", err.Error())
+	}
+
+	if len(conflicts) > 0 {
+		showAttention(fmt.Sprintf("> %v conflict(s) written to %v", len(conflicts), getSyncConflictReportPath(target)), program.indentLevel)
+	}
+
+	return stats, nil
+}
+
+// Creates an entry of one side on the other side (under the given name) and returns
+// its new state
+func twoWayCopy(from *twoWaySide, to *twoWaySide, entry syncEntry, name string, config syncEngineConfig, stats *syncStats) (*syncArchiveState, error) {
+	err := to.fs.MkdirAll(path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case entry.isDir == true:
+		if _, exists := to.tree[name]; exists == false {
+			err := to.fs.Mkdir(name, 0755)
+			if err != nil && os.IsExist(err) == false {
+				return nil, err
+			}
+		}
+		if config.preservePermissions() == true {
+			_ = to.fs.Chmod(name, entry.mode)
+		}
+		return &syncArchiveState{Kind: syncKindDir}, nil
+
+	case entry.isSymlink == true:
+		if _, exists := to.tree[name]; exists == true {
+			err := to.fs.Remove(name)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err := to.fs.Symlink(entry.linkTarget, name)
+		if err != nil {
+			return nil, err
+		}
+		return &syncArchiveState{Kind: syncKindSymlink, LinkTarget: entry.linkTarget}, nil
+
+	default:
+		written, hash, err := copySyncFile(from.fs, to.fs, entry, name, config.preservePermissions(), config.preserveTimes())
+		stats.bytes += written
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := to.fs.Lstat(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return &syncArchiveState{Kind: syncKindFile, Size: info.Size(), ModTime: info.ModTime().Truncate(time.Second), Hash: hash}, nil
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSameSyncContent(t *testing.T) {
+	file := &syncArchiveState{Kind: syncKindFile, Size: 3, Hash: "abc", ModTime: time.Unix(100, 0)}
+
+	tests := []struct {
+		name string
+		a    *syncArchiveState
+		b    *syncArchiveState
+		want bool
+	}{
+		{"both deleted", nil, nil, true},
+		{"deleted on one side", file, nil, false},
+		{"deleted on the other side", nil, file, false},
+		{"same file with another mtime", file, &syncArchiveState{Kind: syncKindFile, Size: 3, Hash: "abc", ModTime: time.Unix(200, 0)}, true},
+		{"other hash", file, &syncArchiveState{Kind: syncKindFile, Size: 3, Hash: "abd"}, false},
+		{"other size", file, &syncArchiveState{Kind: syncKindFile, Size: 4, Hash: "abc"}, false},
+		{"other kind", file, &syncArchiveState{Kind: syncKindDir}, false},
+		{"directories", &syncArchiveState{Kind: syncKindDir}, &syncArchiveState{Kind: syncKindDir}, true},
+		{"same symlink", &syncArchiveState{Kind: syncKindSymlink, LinkTarget: "x"}, &syncArchiveState{Kind: syncKindSymlink, LinkTarget: "x"}, true},
+		{"other symlink", &syncArchiveState{Kind: syncKindSymlink, LinkTarget: "x"}, &syncArchiveState{Kind: syncKindSymlink, LinkTarget: "y"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := sameSyncContent(test.a, test.b); got != test.want {
+				t.Errorf("sameSyncContent() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGetSyncConflictCopyName(t *testing.T) {
+	timestamp := time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		side string
+		want string
+	}{
+		{"notes.txt", "a", "notes.conflict-a-20240305-140709.txt"},
+		{"dir/notes.txt", "b", "dir/notes.conflict-b-20240305-140709.txt"},
+		{"backup.tar.gz", "a", "backup.tar.conflict-a-20240305-140709.gz"},
+		{"Makefile", "b", "Makefile.conflict-b-20240305-140709"},
+		{".bashrc", "a", ".bashrc.conflict-a-20240305-140709"},
+		{"dir.d/.env.local", "b", "dir.d/.env.conflict-b-20240305-140709.local"},
+	}
+
+	for _, test := range tests {
+		if got := getSyncConflictCopyName(test.name, test.side, timestamp); got != test.want {
+			t.Errorf("getSyncConflictCopyName(%q, %q) = %q, want %q", test.name, test.side, got, test.want)
+		}
+	}
+}
+
+// Changes made to a root between syncs (a nil content removes the path)
+type twoWayTestChanges map[string]*string
+
+func twoWayTestContent(content string) *string {
+	return &content
+}
+
+func applyTwoWayTestChanges(t *testing.T, root string, changes twoWayTestChanges) {
+	t.Helper()
+
+	// Changed files get a later mtime, so that they are always seen as changed
+	later := time.Now().Add(time.Hour)
+
+	for name, content := range changes {
+		fullPath := filepath.Join(root, filepath.FromSlash(name))
+		if content == nil {
+			if err := os.RemoveAll(fullPath); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(*content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fullPath, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns the contents of the files of a root, and the number of conflict copies
+func readTwoWayTestRoot(t *testing.T, root string) (map[string]string, int) {
+	t.Helper()
+
+	files := make(map[string]string)
+	copies := 0
+
+	err := filepath.Walk(root, func(fullPath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() == true {
+			return err
+		}
+
+		name, _ := filepath.Rel(root, fullPath)
+		if strings.Contains(name, ".conflict-") == true {
+			copies++
+			return nil
+		}
+
+		content, err := os.ReadFile(fullPath)
+		files[filepath.ToSlash(name)] = string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files, copies
+}
+
+func TestTwoWayEngine(t *testing.T) {
+	tests := []struct {
+		name          string
+		initial       twoWayTestChanges
+		changesA      twoWayTestChanges
+		changesB      twoWayTestChanges
+		wantA         map[string]string
+		wantB         map[string]string
+		wantConflicts int
+		wantCopies    int
+	}{
+		{
+			name:     "created on A",
+			initial:  twoWayTestChanges{"keep": twoWayTestContent("k")},
+			changesA: twoWayTestChanges{"new": twoWayTestContent("n")},
+			wantA:    map[string]string{"keep": "k", "new": "n"},
+			wantB:    map[string]string{"keep": "k", "new": "n"},
+		},
+		{
+			name:     "created on B in a new directory",
+			initial:  twoWayTestChanges{"keep": twoWayTestContent("k")},
+			changesB: twoWayTestChanges{"dir/sub/new": twoWayTestContent("n")},
+			wantA:    map[string]string{"keep": "k", "dir/sub/new": "n"},
+			wantB:    map[string]string{"keep": "k", "dir/sub/new": "n"},
+		},
+		{
+			name:     "modified on A",
+			initial:  twoWayTestChanges{"keep": twoWayTestContent("k"), "file": twoWayTestContent("v1")},
+			changesA: twoWayTestChanges{"file": twoWayTestContent("version 2")},
+			wantA:    map[string]string{"keep": "k", "file": "version 2"},
+			wantB:    map[string]string{"keep": "k", "file": "version 2"},
+		},
+		{
+			name:     "deleted on B",
+			initial:  twoWayTestChanges{"keep": twoWayTestContent("k"), "file": twoWayTestContent("v1")},
+			changesB: twoWayTestChanges{"file": nil},
+			wantA:    map[string]string{"keep": "k"},
+			wantB:    map[string]string{"keep": "k"},
+		},
+		{
+			name:     "same change on both sides",
+			initial:  twoWayTestChanges{"keep": twoWayTestContent("k"), "file": twoWayTestContent("v1")},
+			changesA: twoWayTestChanges{"file": twoWayTestContent("same")},
+			changesB: twoWayTestChanges{"file": twoWayTestContent("same")},
+			wantA:    map[string]string{"keep": "k", "file": "same"},
+			wantB:    map[string]string{"keep": "k", "file": "same"},
+		},
+		{
+			name:          "modified differently on both sides",
+			initial:       twoWayTestChanges{"keep": twoWayTestContent("k"), "file.txt": twoWayTestContent("v1")},
+			changesA:      twoWayTestChanges{"file.txt": twoWayTestContent("from A")},
+			changesB:      twoWayTestChanges{"file.txt": twoWayTestContent("from B!")},
+			wantA:         map[string]string{"keep": "k", "file.txt": "from A"},
+			wantB:         map[string]string{"keep": "k", "file.txt": "from B!"},
+			wantConflicts: 1,
+			wantCopies:    2,
+		},
+		{
+			name:          "modified on A and deleted on B",
+			initial:       twoWayTestChanges{"keep": twoWayTestContent("k"), "file": twoWayTestContent("v1")},
+			changesA:      twoWayTestChanges{"file": twoWayTestContent("version 2")},
+			changesB:      twoWayTestChanges{"file": nil},
+			wantA:         map[string]string{"keep": "k", "file": "version 2"},
+			wantB:         map[string]string{"keep": "k"},
+			wantConflicts: 1,
+		},
+		{
+			name:     "directory deleted on A while a file was added to it on B",
+			initial:  twoWayTestChanges{"keep": twoWayTestContent("k"), "dir/old": twoWayTestContent("o")},
+			changesA: twoWayTestChanges{"dir": nil},
+			changesB: twoWayTestChanges{"dir/new": twoWayTestContent("n")},
+			wantA:    map[string]string{"keep": "k", "dir/new": "n"},
+			wantB:    map[string]string{"keep": "k", "dir/new": "n"},
+		},
+		{
+			name:     "excluded paths are left alone",
+			initial:  twoWayTestChanges{"keep": twoWayTestContent("k")},
+			changesA: twoWayTestChanges{"cache/big": twoWayTestContent("a"), "x.tmp": twoWayTestContent("t")},
+			changesB: twoWayTestChanges{"cache/big": twoWayTestContent("b")},
+			wantA:    map[string]string{"keep": "k", "cache/big": "a", "x.tmp": "t"},
+			wantB:    map[string]string{"keep": "k", "cache/big": "b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := Target{path: t.TempDir()}
+			rootA, rootB := t.TempDir(), t.TempDir()
+			fsA, fsB := localSyncFS{root: rootA}, localSyncFS{root: rootB}
+			config := syncEngineConfig{Engine: syncEngineTwoWay, Exclude: []string{"cache/", "*.tmp"}}
+
+			applyTwoWayTestChanges(t, rootA, test.initial)
+			if _, err := runTwoWayEngine(target, fsA, fsB, config, Program{}); err != nil {
+				t.Fatalf("first sync failed: %v", err)
+			}
+
+			applyTwoWayTestChanges(t, rootA, test.changesA)
+			applyTwoWayTestChanges(t, rootB, test.changesB)
+			stats, err := runTwoWayEngine(target, fsA, fsB, config, Program{})
+			if err != nil {
+				t.Fatalf("second sync failed: %v", err)
+			}
+			if len(stats.errors) > 0 {
+				t.Fatalf("second sync reported errors: %v", stats.errors)
+			}
+			if stats.conflicts != test.wantConflicts {
+				t.Errorf("conflicts = %v, want %v", stats.conflicts, test.wantConflicts)
+			}
+
+			for _, root := range []struct {
+				label string
+				path  string
+				want  map[string]string
+			}{{"A", rootA, test.wantA}, {"B", rootB, test.wantB}} {
+				files, copies := readTwoWayTestRoot(t, root.path)
+				if reflect.DeepEqual(files, root.want) == false {
+					t.Errorf("root %v = %v, want %v", root.label, files, root.want)
+				}
+				if copies != test.wantCopies {
+					t.Errorf("root %v has %v conflict copies, want %v", root.label, copies, test.wantCopies)
+				}
+			}
+
+			// The conflict report lists the conflicts of the last sync
+			var conflicts []syncConflict
+			content, err := os.ReadFile(getSyncConflictReportPath(target))
+			if err == nil {
+				err = json.Unmarshal(content, &conflicts)
+			}
+			if test.wantConflicts == 0 && os.IsNotExist(err) == false {
+				t.Errorf("conflict report written without conflicts (%v)", err)
+			} else if test.wantConflicts > 0 && (err != nil || len(conflicts) != test.wantConflicts) {
+				t.Errorf("conflict report has %v conflicts (%v), want %v", len(conflicts), err, test.wantConflicts)
+			}
+
+			// The archive is up to date: syncing again changes nothing
+			stats, err = runTwoWayEngine(target, fsA, fsB, config, Program{})
+			if err != nil {
+				t.Fatalf("third sync failed: %v", err)
+			}
+			if stats.created+stats.updated+stats.deleted+stats.conflicts > 0 || len(stats.errors) > 0 {
+				t.Errorf("third sync made changes: %+v", stats)
+			}
+		})
+	}
+}
+
+func TestTwoWayEngineRefusesEmptyRoot(t *testing.T) {
+	target := Target{path: t.TempDir()}
+	rootA, rootB := t.TempDir(), t.TempDir()
+	fsA, fsB := localSyncFS{root: rootA}, localSyncFS{root: rootB}
+	config := syncEngineConfig{Engine: syncEngineTwoWay}
+
+	applyTwoWayTestChanges(t, rootA, twoWayTestChanges{"file": twoWayTestContent("v1")})
+	if _, err := runTwoWayEngine(target, fsA, fsB, config, Program{}); err != nil {
+		t.Fatalf("first sync failed: %v", err)
+	}
+
+	// e.g. an unmounted disk
+	applyTwoWayTestChanges(t, rootB, twoWayTestChanges{"file": nil})
+	if _, err := runTwoWayEngine(target, fsA, fsB, config, Program{}); err == nil {
+		t.Fatal("sync of an empty root succeeded, want an error")
+	}
+
+	files, _ := readTwoWayTestRoot(t, rootA)
+	if files["file"] != "v1" {
+		t.Errorf("root A = %v, want the file to be kept", files)
+	}
+}
+
+func TestReadSyncArchiveIgnoresOtherRoots(t *testing.T) {
+	target := Target{path: t.TempDir()}
+
+	archive := syncArchive{
+		Roots:   []string{"/a", "/b"},
+		Entries: map[string]syncArchiveEntry{"file": {A: &syncArchiveState{Kind: syncKindFile}}},
+	}
+	if err := writeSyncArchive(target, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := readSyncArchive(target, []string{"/a", "/b"})
+	if err != nil || len(stored.Entries) != 1 {
+		t.Errorf("readSyncArchive() = %v entries (%v), want 1", len(stored.Entries), err)
+	}
+
+	other, err := readSyncArchive(target, []string{"/a", "/c"})
+	if err != nil || len(other.Entries) != 0 {
+		t.Errorf("readSyncArchive() for other roots = %v entries (%v), want 0", len(other.Entries), err)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	// External modules
+)
+
+//
+//// UNISON PROFILES
+//
+
+// Parser for unison profiles (.prf files), as used by the unison templates. A
+// profile is a list of 'key = value' preferences (a key can appear several times,
+// e.g. 'root' or 'ignore'), comments starting with '#', and 'include name',
+// 'include? name' and 'source name' directives that read other files.
+
+type unisonPreference struct {
+	key   string
+	value string
+	file  string
+	line  int
+}
+
+type unisonProfile struct {
+	path        string
+	preferences []unisonPreference
+	// Lines that could not be parsed ("file:line: reason")
+	problems []string
+}
+
+// Preferences whose values are checked by the profile validation
+var unisonPathSpecification = regexp.MustCompile(`^(Name|Path|BelowPath|Regex)\s+\S`)
+
+// Returns the path of the unison profile of a target (following the layout of the
+// unison templates)
+func getTargetUnisonProfilePath(target Target) string {
+	return filepath.Join(target.path, "unison", target.name+".prf")
+}
+
+func parseUnisonProfile(profilePath string) (unisonProfile, error) {
+	profile := unisonProfile{path: profilePath}
+
+	err := profile.read(profilePath, true, map[string]bool{})
+
+	return profile, err
+}
+
+func (profile *unisonProfile) read(filePath string, required bool, visited map[string]bool) error {
+	absolutePath, err := filepath.Abs(filePath)
+	if err == nil {
+		filePath = absolutePath
+	}
+
+	if visited[filePath] == true {
+		return fmt.Errorf("profile %v is included recursively", filePath)
+	}
+	visited[filePath] = true
+	defer delete(visited, filePath)
+
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) && required == false {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") == true {
+			continue
+		}
+
+		// Directives
+		fields := strings.Fields(line)
+		if strings.Contains(line, "=") == false && len(fields) == 2 {
+			var includedPath string
+			var includedRequired bool
+
+			switch fields[0] {
+			case "include", "include?":
+				includedPath = filepath.Join(filepath.Dir(filePath), fields[1])
+				// Like unison, fall back to 'name.prf' if 'name' does not exist
+				if _, err := os.Stat(includedPath); os.IsNotExist(err) {
+					includedPath += ".prf"
+				}
+				includedRequired = fields[0] == "include"
+			case "source":
+				includedPath = fields[1]
+				if filepath.IsAbs(includedPath) == false {
+					includedPath = filepath.Join(filepath.Dir(filePath), includedPath)
+				}
+				includedRequired = true
+			}
+
+			if includedPath != "" {
+				err := profile.read(includedPath, includedRequired, visited)
+				if err != nil {
+					profile.problems = append(profile.problems, fmt.Sprintf("%v:%v: %v", filePath, lineNumber, err.Error()))
+				}
+				continue
+			}
+		}
+
+		separator := strings.Index(line, "=")
+		if separator <= 0 {
+			profile.problems = append(profile.problems, fmt.Sprintf("%v:%v: expected 'key = value'", filePath, lineNumber))
+			continue
+		}
+
+		profile.preferences = append(profile.preferences, unisonPreference{
+			key:   strings.TrimSpace(line[:separator]),
+			value: strings.TrimSpace(line[separator+1:]),
+			file:  filePath,
+			line:  lineNumber,
+		})
+	}
+
+	return scanner.Err()
+}
+
+// Returns every value of a preference (in order)
+func (profile unisonProfile) get(key string) []string {
+	var values []string
+	for _, preference := range profile.preferences {
+		if preference.key == key {
+			values = append(values, preference.value)
+		}
+	}
+
+	return values
+}
+
+func (profile unisonProfile) roots() []string {
+	return profile.get("root")
+}
+
+// Converts a unison root into the syntax used by rsync/scp (e.g.
+// 'ssh://user@host//path' becomes 'user@host:/path')
+func unisonRootToRsync(root string) string {
+	if strings.HasPrefix(root, "ssh://") == false {
+		return root
+	}
+
+	remote := strings.TrimPrefix(root, "ssh://")
+	separator := strings.Index(remote, "/")
+	if separator < 0 {
+		return remote + ":"
+	}
+
+	host := remote[:separator]
+	path := remote[separator+1:]
+
+	return host + ":" + path
+}
+
+// Checks that a profile can be used by unison: it must parse, have two roots (the
+// local one existing), and valid path specifications in ignore/follow preferences
+func validateUnisonProfile(profile unisonProfile) []string {
+	problems := append([]string{}, profile.problems...)
+
+	roots := profile.roots()
+	if len(roots) != 2 {
+		problems = append(problems, fmt.Sprintf("expected 2 roots, found %v", len(roots)))
+	}
+
+	for _, root := range roots {
+		if strings.Contains(root, "://") == true || strings.Contains(root, "$") == true {
+			continue
+		}
+
+		if info, err := os.Stat(root); err != nil {
+			problems = append(problems, fmt.Sprintf("local root %v not found", root))
+		} else if info.IsDir() == false {
+			problems = append(problems, fmt.Sprintf("local root %v is not a directory", root))
+		}
+	}
+
+	for _, preference := range profile.preferences {
+		switch preference.key {
+		case "ignore", "ignorenot", "follow", "backup", "backupnot", "path":
+			if preference.key == "path" {
+				if strings.HasPrefix(preference.value, "/") == true {
+					problems = append(problems, fmt.Sprintf("%v:%v: 'path' must be relative to the roots", preference.file, preference.line))
+				}
+				continue
+			}
+
+			if unisonPathSpecification.MatchString(preference.value) == false {
+				problems = append(problems, fmt.Sprintf("%v:%v: invalid path specification '%v' for '%v' (expected Name, Path, BelowPath or Regex)", preference.file, preference.line, preference.value, preference.key))
+			}
+		case "backuplocation":
+			if preference.value != "local" && preference.value != "central" {
+				problems = append(problems, fmt.Sprintf("%v:%v: 'backuplocation' must be 'local' or 'central'", preference.file, preference.line))
+			}
+		}
+	}
+
+	return problems
+}
+
+//
+//// UTILITIES
+//
+
+// Returns the profile given to a utility, or the one of the target running the hook
+func getUtilsUnisonProfilePath(profilePath string, program Program) (string, functionResponse) {
+	if profilePath != "" {
+		return profilePath, functionResponse{exitCode: 0}
+	}
+
+	targetDir := os.Getenv("TARGET_DIR")
+	targetName := os.Getenv("TARGET_NAME")
+	if targetDir == "" || targetName == "" {
+		return "", functionResponse{
+			exitCode:    1,
+			message:     "No profile given and not running in a target hook (TARGET_DIR and TARGET_NAME are not set)",
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return filepath.Join(targetDir, "unison", targetName+".prf"), functionResponse{exitCode: 0}
+}
+
+func utilsUnisonRoots(profilePath string, index int, rsync bool, program Program) functionResponse {
+	profilePath, response := getUtilsUnisonProfilePath(profilePath, program)
+	if response.exitCode != 0 {
+		return response
+	}
+
+	profile, err := parseUnisonProfile(profilePath)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read unison profile -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	roots := profile.roots()
+	if index < 0 || index > len(roots) {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Root %v not found (the profile has %v roots)", index, len(roots)),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	if index > 0 {
+		roots = roots[index-1 : index]
+	}
+
+	for _, root := range roots {
+		if rsync == true {
+			root = unisonRootToRsync(root)
+		}
+		fmt.Println(root)
+	}
+
+	return functionResponse{exitCode: 0}
+}
+
+func utilsUnisonGet(key string, profilePath string, program Program) functionResponse {
+	profilePath, response := getUtilsUnisonProfilePath(profilePath, program)
+	if response.exitCode != 0 {
+		return response
+	}
+
+	profile, err := parseUnisonProfile(profilePath)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read unison profile -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	values := profile.get(key)
+	if len(values) == 0 {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Preference '%v' not set in %v", key, profilePath),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	for _, value := range values {
+		fmt.Println(value)
+	}
+
+	return functionResponse{exitCode: 0}
+}
@@ -0,0 +1,651 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	// External modules
+)
+
+//
+//// SCHEDULE UNITS
+//
+
+// Instead of running the daemon, periodic syncs can be handed to systemd: 'schedule
+// install' writes a user service (running 'targets sync') and a timer for a crate
+// (all its targets) or for each selected target. The timers carry the crate, the
+// target, the interval and the user data directory as 'X-Synctropy-*' keys, read by
+// 'schedule ls' and 'schedule rm'. For systems without systemd, 'schedule crontab'
+// prints the equivalent crontab lines.
+
+const scheduleUnitPrefix = "synctropy-"
+
+type scheduleUnit struct {
+	name      string
+	crate     string
+	target    string
+	every     string
+	directory string
+	enabled   bool
+}
+
+func (unit scheduleUnit) key() string {
+	if unit.target == "" {
+		return unit.crate
+	}
+
+	return unit.crate + "/" + unit.target
+}
+
+// Directory of the user units ($XDG_CONFIG_HOME/systemd/user)
+func getUserUnitsDir(program Program) string {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		configDir = getCurrentUserHomeDir(program) + "/.config"
+	}
+
+	return filepath.Join(configDir, "systemd", "user")
+}
+
+// Escapes a crate or target name for a unit name (as 'systemd-escape' does)
+func escapeUnitName(name string) string {
+	var builder strings.Builder
+	for index := 0; index < len(name); index++ {
+		char := name[index]
+		switch {
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char >= '0' && char <= '9', char == '_', char == '-':
+			builder.WriteByte(char)
+		default:
+			fmt.Fprintf(&builder, "\\x%02x", char)
+		}
+	}
+
+	return builder.String()
+}
+
+func getScheduleUnitName(crate string, target string) string {
+	name := scheduleUnitPrefix + escapeUnitName(crate)
+	if target != "" {
+		name += "." + escapeUnitName(target)
+	}
+
+	return name
+}
+
+// Quotes an argument of 'ExecStart' (specifiers and variables are not expanded)
+func quoteSystemdArg(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+
+	if arg != "" && strings.ContainsAny(arg, " \t\"'\\;") == false {
+		return arg
+	}
+
+	arg = strings.ReplaceAll(arg, "\\", "\\\\")
+	arg = strings.ReplaceAll(arg, "\"", "\\\"")
+
+	return "\"" + arg + "\""
+}
+
+// Quotes an argument for the shell running a crontab line ('%' is special in
+// crontabs). Backslashes are escaped outside of the single quotes, since cron would
+// otherwise pair a backslash with the one escaping a following '%'.
+func quoteCrontabArg(arg string) string {
+	if arg != "" && strings.ContainsAny(arg, " \t\"'\\;&|<>()$`*?[]#~%!{}") == false {
+		return arg
+	}
+
+	var builder strings.Builder
+	builder.WriteString("'")
+	for _, char := range arg {
+		switch char {
+		case '\'':
+			builder.WriteString("'\\''")
+		case '\\':
+			builder.WriteString("'\\\\'")
+		case '%':
+			builder.WriteString("\\%")
+		default:
+			builder.WriteRune(char)
+		}
+	}
+	builder.WriteString("'")
+
+	return builder.String()
+}
+
+// Escapes the specifiers in a unit setting (e.g. 'Description')
+func escapeSystemdSpecifiers(value string) string {
+	return strings.ReplaceAll(value, "%", "%%")
+}
+
+// Returns the arguments running the program (its resolved executable) on the user
+// data directory
+func getProgramArgs(program Program) []string {
+	executable, err := os.Executable()
+	if err != nil {
+		executable = program.exec
+	} else if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+
+	directory, err := filepath.Abs(program.userDataDir)
+	if err != nil {
+		directory = program.userDataDir
+	}
+
+	return []string{executable, "--directory", directory}
+}
+
+// Returns the arguments of the program syncing a crate (all its targets) or a target
+func getScheduleSyncArgs(crate string, target string, program Program) []string {
+	args := append(getProgramArgs(program), "targets", "sync", "-c", crate)
+	if target == "" {
+		return append(args, "-a")
+	}
+
+	return append(args, "-t", target)
+}
+
+func validateScheduleInterval(every string) (time.Duration, error) {
+	if every == "" {
+		return 0, fmt.Errorf("flag '--every' must be specified")
+	}
+
+	interval, err := time.ParseDuration(every)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval '%v' -> %v", every, err.Error())
+	}
+	if interval < time.Minute {
+		return 0, fmt.Errorf("the interval must be at least 1m")
+	}
+
+	return interval, nil
+}
+
+// Converts a duration to the systemd time span syntax (e.g. '1h 30min')
+func formatSystemdTimeSpan(interval time.Duration) string {
+	var parts []string
+	for _, unit := range []struct {
+		name     string
+		duration time.Duration
+	}{{"d", 24 * time.Hour}, {"h", time.Hour}, {"min", time.Minute}, {"s", time.Second}} {
+		if interval >= unit.duration {
+			parts = append(parts, fmt.Sprintf("%d%v", interval/unit.duration, unit.name))
+			interval %= unit.duration
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func generateScheduleService(unit scheduleUnit, program Program) string {
+	var args []string
+	for _, arg := range getScheduleSyncArgs(unit.crate, unit.target, program) {
+		args = append(args, quoteSystemdArg(arg))
+	}
+
+	return fmt.Sprintf(`# Generated by %[1]v (remove with '%[1]v schedule rm')
+[Unit]
+Description=Sync %[2]v (%[1]v)
+
+[Service]
+Type=oneshot
+ExecStart=%[3]v
+`, program.name, escapeSystemdSpecifiers(unit.key()), strings.Join(args, " "))
+}
+
+func generateScheduleTimer(unit scheduleUnit, interval time.Duration, program Program) string {
+	timeSpan := formatSystemdTimeSpan(interval)
+
+	return fmt.Sprintf(`# Generated by %[1]v (remove with '%[1]v schedule rm')
+[Unit]
+Description=Sync %[9]v every %[3]v (%[1]v)
+X-Synctropy-Crate=%[4]v
+X-Synctropy-Target=%[5]v
+X-Synctropy-Every=%[3]v
+X-Synctropy-Directory=%[6]v
+
+[Timer]
+OnActiveSec=%[7]v
+OnUnitActiveSec=%[7]v
+Unit=%[8]v.service
+
+[Install]
+WantedBy=timers.target
+`, program.name, unit.key(), unit.every, unit.crate, unit.target, unit.directory, timeSpan, unit.name, escapeSystemdSpecifiers(unit.key()))
+}
+
+// Reads the keys of a timer written by 'schedule install'. Returns false if the
+// timer was not written by it.
+func readScheduleUnit(unitsDir string, timerName string) (scheduleUnit, bool) {
+	file, err := os.Open(filepath.Join(unitsDir, timerName))
+	if err != nil {
+		return scheduleUnit{}, false
+	}
+	defer file.Close()
+
+	unit := scheduleUnit{name: strings.TrimSuffix(timerName, ".timer")}
+	found := false
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, isKey := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if isKey == false || strings.HasPrefix(key, "X-Synctropy-") == false {
+			continue
+		}
+
+		found = true
+		switch strings.TrimPrefix(key, "X-Synctropy-") {
+		case "Crate":
+			unit.crate = value
+		case "Target":
+			unit.target = value
+		case "Every":
+			unit.every = value
+		case "Directory":
+			unit.directory = value
+		}
+	}
+
+	if _, err := os.Lstat(filepath.Join(unitsDir, "timers.target.wants", timerName)); err == nil {
+		unit.enabled = true
+	}
+
+	return unit, found
+}
+
+// Returns the units written by 'schedule install' for the user data directory
+func getScheduleUnits(program Program) ([]scheduleUnit, error) {
+	unitsDir := getUserUnitsDir(program)
+	directory, _ := filepath.Abs(program.userDataDir)
+
+	entries, err := ioutil.ReadDir(unitsDir)
+	if os.IsNotExist(err) == true {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var units []scheduleUnit
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), scheduleUnitPrefix) == false || strings.HasSuffix(entry.Name(), ".timer") == false {
+			continue
+		}
+
+		unit, found := readScheduleUnit(unitsDir, entry.Name())
+		if found == true && unit.directory == directory {
+			units = append(units, unit)
+		}
+	}
+
+	sort.Slice(units, func(i, j int) bool {
+		return units[i].key() < units[j].key()
+	})
+
+	return units, nil
+}
+
+// Runs 'systemctl --user'. Returns false (without running it) if systemctl is not
+// available.
+func runUserSystemctl(args ...string) (bool, error) {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return false, nil
+	}
+
+	output, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	if err != nil {
+		// The last line holds the error (the previous ones report what was done)
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		message := lines[len(lines)-1]
+		if message == "" {
+			message = err.Error()
+		}
+		return true, fmt.Errorf("%v", message)
+	}
+
+	return true, nil
+}
+
+func scheduleInstall(crate Crate, targets []Target, allTargets bool, every string, noEnable bool, program Program) functionResponse {
+	interval, err := validateScheduleInterval(every)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to install schedule -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	unitsDir := getUserUnitsDir(program)
+	directory, _ := filepath.Abs(program.userDataDir)
+
+	// A single unit syncing the whole crate (including the targets created later),
+	// or a unit per target
+	var units []scheduleUnit
+	if allTargets == true {
+		units = append(units, scheduleUnit{name: getScheduleUnitName(crate.name, ""), crate: crate.name, every: every, directory: directory})
+	} else {
+		for _, target := range targets {
+			units = append(units, scheduleUnit{name: getScheduleUnitName(crate.name, target.name), crate: crate.name, target: target.name, every: every, directory: directory})
+		}
+	}
+
+	err = os.MkdirAll(unitsDir, 0755)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to create units directory -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	for _, unit := range units {
+		space()
+		showInfoSectionTitle(fmt.Sprintf("Installing schedule: %s %s", orange.Sprintf(unit.key()), gray.Sprintf("(every %v)", every)), program.indentLevel)
+
+		// Do not replace the unit of another user data directory
+		existing, found := readScheduleUnit(unitsDir, unit.name+".timer")
+		if found == true && existing.directory != directory {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Unit %v.timer is already installed for the user data directory %v", unit.name, existing.directory),
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+
+		files := map[string]string{
+			unit.name + ".service": generateScheduleService(unit, program),
+			unit.name + ".timer":   generateScheduleTimer(unit, interval, program),
+		}
+		for _, fileName := range []string{unit.name + ".service", unit.name + ".timer"} {
+			err := writeFileAtomically(filepath.Join(unitsDir, fileName), []byte(files[fileName]), 0644)
+			if err != nil {
+				return functionResponse{
+					exitCode:    1,
+					message:     fmt.Sprintf("Failed to write unit -> " + err.Error()),
+					logLevel:    "error",
+					indentLevel: program.indentLevel + 1,
+				}
+			}
+			showText(gray.Sprintf(filepath.Join(unitsDir, fileName)), program.indentLevel+1)
+		}
+	}
+
+	space()
+
+	if noEnable == true {
+		showText(gray.Sprintf("Enable the timers with 'systemctl --user daemon-reload && systemctl --user enable --now <unit>.timer'"), program.indentLevel)
+		space()
+
+		return functionResponse{exitCode: 0}
+	}
+
+	showInfoSectionTitle("Enabling timers", program.indentLevel)
+
+	available, err := runUserSystemctl("daemon-reload")
+	if available == false {
+		return functionResponse{
+			exitCode:    0,
+			message:     "systemctl not found: the units were written but not enabled",
+			logLevel:    "attention",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to reload systemd (the units were written) -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	for _, unit := range units {
+		_, err := runUserSystemctl("enable", "--now", unit.name+".timer")
+		if err != nil {
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to enable %v.timer -> %v", unit.name, err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+		showText(fmt.Sprintf("- %v.timer", unit.name), program.indentLevel+1)
+	}
+
+	return functionResponse{
+		exitCode:    0,
+		message:     "Finished",
+		logLevel:    "success",
+		indentLevel: program.indentLevel + 1,
+	}
+}
+
+func scheduleLs(program Program) functionResponse {
+	units, err := getScheduleUnits(program)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read units directory -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	space()
+	showInfoSectionTitle("Installed schedules", program.indentLevel)
+
+	if len(units) == 0 {
+		return functionResponse{
+			exitCode:    0,
+			message:     "No schedules installed",
+			logLevel:    "attention",
+			indentLevel: program.indentLevel + 1,
+		}
+	}
+
+	for _, unit := range units {
+		description := fmt.Sprintf("every %v", unit.every)
+		if unit.enabled == false {
+			description += ", " + red.Sprintf("not enabled")
+		}
+
+		showText(fmt.Sprintf("- %s %s %s", unit.key(), gray.Sprintf("(%v)", description), gray.Sprintf(unit.name+".timer")), program.indentLevel+1)
+	}
+
+	space()
+
+	return functionResponse{exitCode: 0}
+}
+
+// Removes the units of a crate: the unit syncing the whole crate (if no targets are
+// given), the units of the given targets, or all of them
+func scheduleRm(crateName string, targetNames []string, allUnits bool, program Program) functionResponse {
+	if crateName == "" {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Flag '--crate/-c' should be specified",
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+	if allUnits == true && targetNames != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     "Conflicting flags: both '--target/-t' and '--all/-a' flags cannot be specified at the same time",
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	units, err := getScheduleUnits(program)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to read units directory -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	selectedTargets := make(map[string]bool)
+	for _, targetName := range targetNames {
+		selectedTargets[targetName] = true
+	}
+
+	var selectedUnits []scheduleUnit
+	for _, unit := range units {
+		if unit.crate != crateName {
+			continue
+		}
+
+		switch {
+		case allUnits == true:
+		case targetNames == nil && unit.target != "":
+			continue
+		case targetNames != nil && selectedTargets[unit.target] == false:
+			continue
+		}
+
+		selectedUnits = append(selectedUnits, unit)
+	}
+
+	if len(selectedUnits) == 0 {
+		return functionResponse{
+			exitCode:    1,
+			message:     "No matching schedules installed",
+			logLevel:    "attention",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	unitsDir := getUserUnitsDir(program)
+
+	for _, unit := range selectedUnits {
+		space()
+		showInfoSectionTitle(fmt.Sprintf("Removing schedule: %s", orange.Sprintf(unit.key())), program.indentLevel)
+
+		if unit.enabled == true {
+			_, err := runUserSystemctl("disable", "--now", unit.name+".timer")
+			if err != nil {
+				showAttention(fmt.Sprintf("> Failed to disable %v.timer -> %v", unit.name, err.Error()), program.indentLevel+1)
+
+				// Remove the link left behind, if any
+				os.Remove(filepath.Join(unitsDir, "timers.target.wants", unit.name+".timer"))
+			}
+		}
+
+		for _, fileName := range []string{unit.name + ".timer", unit.name + ".service"} {
+			err := os.Remove(filepath.Join(unitsDir, fileName))
+			if err != nil && os.IsNotExist(err) == false {
+				return functionResponse{
+					exitCode:    1,
+					message:     fmt.Sprintf("Failed to remove unit -> " + err.Error()),
+					logLevel:    "error",
+					indentLevel: program.indentLevel + 1,
+				}
+			}
+		}
+
+		showSuccess("> Finished", program.indentLevel+1)
+	}
+
+	runUserSystemctl("daemon-reload")
+	space()
+
+	return functionResponse{exitCode: 0}
+}
+
+// Converts an interval to a cron expression. Only the intervals that divide an
+// hour (in minutes) or a day (in hours) can be expressed exactly.
+func intervalToCron(interval time.Duration) (string, error) {
+	switch {
+	case interval%time.Minute != 0:
+		return "", fmt.Errorf("the interval must be a whole number of minutes")
+	case interval < time.Hour && time.Hour%interval == 0:
+		return fmt.Sprintf("*/%d * * * *", interval/time.Minute), nil
+	case interval == time.Hour:
+		return "0 * * * *", nil
+	case interval%time.Hour == 0 && interval < 24*time.Hour && (24*time.Hour)%interval == 0:
+		return fmt.Sprintf("0 */%d * * *", interval/time.Hour), nil
+	case interval == 24*time.Hour:
+		return "0 0 * * *", nil
+	case interval == 7*24*time.Hour:
+		return "0 0 * * 0", nil
+	}
+
+	return "", fmt.Errorf("the interval %v cannot be expressed as a cron expression (use '--cron' instead)", interval)
+}
+
+// Prints the crontab lines syncing the crate (all its targets) or each target (the
+// messages of the program go to the standard error)
+func scheduleCrontab(crate Crate, targets []Target, allTargets bool, every string, cronExpression string, output *os.File, program Program) functionResponse {
+	failed := func(err error) functionResponse {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to generate crontab lines -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	switch {
+	case every != "" && cronExpression != "":
+		return failed(fmt.Errorf("flags '--every' and '--cron' cannot be used together"))
+	case cronExpression != "":
+		if _, err := parseCronExpression(cronExpression); err != nil {
+			return failed(err)
+		}
+	default:
+		interval, err := validateScheduleInterval(every)
+		if err != nil {
+			return failed(err)
+		}
+
+		cronExpression, err = intervalToCron(interval)
+		if err != nil {
+			return failed(err)
+		}
+	}
+
+	targetNames := []string{""}
+	if allTargets == false {
+		targetNames = nil
+		for _, target := range targets {
+			targetNames = append(targetNames, target.name)
+		}
+	}
+
+	for _, targetName := range targetNames {
+		var args []string
+		for _, arg := range getScheduleSyncArgs(crate.name, targetName, program) {
+			args = append(args, quoteCrontabArg(arg))
+		}
+
+		key := crate.name
+		if targetName != "" {
+			key += "/" + targetName
+		}
+
+		// Printed as is, so that it can be appended to the crontab
+		fmt.Fprintf(output, "# %v: sync %v\n", program.name, key)
+		fmt.Fprintf(output, "%v %v\n", cronExpression, strings.Join(args, " "))
+	}
+
+	return functionResponse{exitCode: 0}
+}
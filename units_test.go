@@ -0,0 +1,278 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQuoteSystemdArg(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"plain", "plain"},
+		{"", `""`},
+		{"a b", `"a b"`},
+		{"tab\there", "\"tab\there\""},
+		{"50%", "50%%"},
+		{"%h/dir", "%%h/dir"},
+		{"$HOME", "$$HOME"},
+		{"${HOME}", "$${HOME}"},
+		{"it's", `"it's"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`back\slash`, `"back\\slash"`},
+		{"a;b", `"a;b"`},
+		{"50% of $HOME's", `"50%% of $$HOME's"`},
+	}
+
+	for _, test := range tests {
+		if got := quoteSystemdArg(test.arg); got != test.want {
+			t.Errorf("quoteSystemdArg(%q) = %q, want %q", test.arg, got, test.want)
+		}
+	}
+}
+
+func TestQuoteCrontabArg(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"plain", "plain"},
+		{"/usr/bin/synctropy", "/usr/bin/synctropy"},
+		{"", "''"},
+		{"a b", "'a b'"},
+		{"it's", `'it'\''s'`},
+		{"50%", `'50\%'`},
+		{"$HOME", "'$HOME'"},
+		{"*", "'*'"},
+		{"~user", "'~user'"},
+		{`back\slash`, `'back'\\'slash'`},
+		{`a\%b`, `'a'\\'\%b'`},
+	}
+
+	for _, test := range tests {
+		if got := quoteCrontabArg(test.arg); got != test.want {
+			t.Errorf("quoteCrontabArg(%q) = %q, want %q", test.arg, got, test.want)
+		}
+	}
+}
+
+// Removes the escapes of '%' from a crontab command as cron does. Returns false if
+// an unescaped '%' ends the command.
+func unescapeCrontabCommand(command string) (string, bool) {
+	var builder strings.Builder
+
+	escaped := false
+	for _, char := range command {
+		switch {
+		case escaped == true:
+			if char != '%' {
+				builder.WriteRune('\\')
+			}
+			builder.WriteRune(char)
+			escaped = false
+		case char == '\\':
+			escaped = true
+		case char == '%':
+			return builder.String(), false
+		default:
+			builder.WriteRune(char)
+		}
+	}
+	if escaped == true {
+		builder.WriteRune('\\')
+	}
+
+	return builder.String(), true
+}
+
+func TestQuoteCrontabArgRoundTrip(t *testing.T) {
+	words := []string{
+		"plain",
+		"",
+		"my crate",
+		"it's",
+		"50%",
+		"%%",
+		"$HOME",
+		"`date`",
+		`back\slash`,
+		`a\%b`,
+		`trailing\`,
+		`'\''`,
+		"semi;colon & pipe|",
+		"#hash",
+	}
+
+	for _, word := range words {
+		quoted := quoteCrontabArg(word)
+
+		command, complete := unescapeCrontabCommand(quoted)
+		if complete == false {
+			t.Errorf("quoteCrontabArg(%q) = %q, which cron ends at a '%%'", word, quoted)
+			continue
+		}
+
+		got, err := parseHookEntryWords(command, func(string) string { return "expanded" })
+		if err != nil {
+			t.Errorf("quoteCrontabArg(%q) = %q, which fails to parse: %v", word, quoted, err)
+			continue
+		}
+		if len(got) != 1 || got[0] != word {
+			t.Errorf("quoteCrontabArg(%q) = %q, which the shell reads as %q", word, quoted, got)
+		}
+	}
+}
+
+func TestGetScheduleUnitName(t *testing.T) {
+	tests := []struct {
+		crate  string
+		target string
+		want   string
+	}{
+		{"dotfiles", "", "synctropy-dotfiles"},
+		{"dotfiles", "laptop", "synctropy-dotfiles.laptop"},
+		{"my crate", "50%", `synctropy-my\x20crate.50\x25`},
+		{"a.b", "it's", `synctropy-a\x2eb.it\x27s`},
+	}
+
+	for _, test := range tests {
+		if got := getScheduleUnitName(test.crate, test.target); got != test.want {
+			t.Errorf("getScheduleUnitName(%q, %q) = %q, want %q", test.crate, test.target, got, test.want)
+		}
+	}
+}
+
+func TestFormatSystemdTimeSpan(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		want     string
+	}{
+		{time.Minute, "1min"},
+		{90 * time.Minute, "1h 30min"},
+		{61 * time.Second, "1min 1s"},
+		{24 * time.Hour, "1d"},
+		{26*time.Hour + 90*time.Second, "1d 2h 1min 30s"},
+		{14 * 24 * time.Hour, "14d"},
+	}
+
+	for _, test := range tests {
+		if got := formatSystemdTimeSpan(test.interval); got != test.want {
+			t.Errorf("formatSystemdTimeSpan(%v) = %q, want %q", test.interval, got, test.want)
+		}
+	}
+}
+
+func TestIntervalToCron(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		want     string
+	}{
+		{time.Minute, "*/1 * * * *"},
+		{15 * time.Minute, "*/15 * * * *"},
+		{30 * time.Minute, "*/30 * * * *"},
+		{time.Hour, "0 * * * *"},
+		{2 * time.Hour, "0 */2 * * *"},
+		{12 * time.Hour, "0 */12 * * *"},
+		{24 * time.Hour, "0 0 * * *"},
+		{7 * 24 * time.Hour, "0 0 * * 0"},
+	}
+
+	for _, test := range tests {
+		got, err := intervalToCron(test.interval)
+		if err != nil {
+			t.Errorf("intervalToCron(%v) failed: %v", test.interval, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("intervalToCron(%v) = %q, want %q", test.interval, got, test.want)
+		}
+	}
+
+	for _, interval := range []time.Duration{
+		90 * time.Second,
+		45 * time.Minute,
+		90 * time.Minute,
+		5 * time.Hour,
+		48 * time.Hour,
+		14 * 24 * time.Hour,
+	} {
+		if got, err := intervalToCron(interval); err == nil {
+			t.Errorf("intervalToCron(%v) = %q, want an error", interval, got)
+		}
+	}
+}
+
+func TestGenerateScheduleService(t *testing.T) {
+	program := Program{name: "synctropy", userDataDir: "/data/my dir"}
+	executable := quoteSystemdArg(getProgramArgs(program)[0])
+
+	tests := []struct {
+		name        string
+		unit        scheduleUnit
+		description string
+		execStart   string
+	}{
+		{
+			name:        "crate",
+			unit:        scheduleUnit{crate: "dotfiles"},
+			description: "Sync dotfiles (synctropy)",
+			execStart:   executable + ` --directory "/data/my dir" targets sync -c dotfiles -a`,
+		},
+		{
+			name:        "target with special characters",
+			unit:        scheduleUnit{crate: "my crate", target: "50% of $HOME's"},
+			description: "Sync my crate/50%% of $HOME's (synctropy)",
+			execStart:   executable + ` --directory "/data/my dir" targets sync -c "my crate" -t "50%% of $$HOME's"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			want := "# Generated by synctropy (remove with 'synctropy schedule rm')\n" +
+				"[Unit]\n" +
+				"Description=" + test.description + "\n" +
+				"\n" +
+				"[Service]\n" +
+				"Type=oneshot\n" +
+				"ExecStart=" + test.execStart + "\n"
+
+			if got := generateScheduleService(test.unit, program); got != want {
+				t.Errorf("generateScheduleService() =\n%v\nwant\n%v", got, want)
+			}
+		})
+	}
+}
+
+func TestGenerateScheduleTimer(t *testing.T) {
+	program := Program{name: "synctropy"}
+	unit := scheduleUnit{
+		name:      getScheduleUnitName("my crate", "50%"),
+		crate:     "my crate",
+		target:    "50%",
+		every:     "1h30m",
+		directory: "/data/100%",
+	}
+
+	want := `# Generated by synctropy (remove with 'synctropy schedule rm')
+[Unit]
+Description=Sync my crate/50%% every 1h30m (synctropy)
+X-Synctropy-Crate=my crate
+X-Synctropy-Target=50%
+X-Synctropy-Every=1h30m
+X-Synctropy-Directory=/data/100%
+
+[Timer]
+OnActiveSec=1h 30min
+OnUnitActiveSec=1h 30min
+Unit=synctropy-my\x20crate.50\x25.service
+
+[Install]
+WantedBy=timers.target
+`
+
+	if got := generateScheduleTimer(unit, 90*time.Minute, program); got != want {
+		t.Errorf("generateScheduleTimer() =\n%v\nwant\n%v", got, want)
+	}
+}
@@ -0,0 +1,550 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// External modules
+	blake2b "golang.org/x/crypto/blake2b"
+)
+
+//
+//// VERIFY CONFIGURATION
+//
+
+// Integrity verification of the roots of a target, configured in the 'verify'
+// section of the target configuration (every setting is optional):
+//
+//	{
+//		"verify": {
+//			"algorithm": "blake2b",
+//			"jobs": 4,
+//			"exclude": ["*.log"]
+//		}
+//	}
+//
+// The roots are the ones of the sync engine or, for hook-based targets, the ones of
+// the unison profile. The exclude patterns of the sync engine are always applied.
+type verifyConfig struct {
+	Algorithm string   `json:"algorithm"`
+	Jobs      int      `json:"jobs"`
+	Exclude   []string `json:"exclude"`
+}
+
+const verifyDefaultAlgorithm = "sha256"
+
+var verifyAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake2b": func() hash.Hash {
+		// Only fails with an invalid key
+		digest, _ := blake2b.New256(nil)
+		return digest
+	},
+}
+
+type verifyOptions struct {
+	algorithm string
+	jobs      int
+	full      bool
+}
+
+//
+//// VERIFY MANIFEST
+//
+
+// The manifest keeps the hash of every file of both roots, with the size and
+// modification time it was computed for. Later runs only hash the files whose size
+// or modification time changed (unless '--full' is used).
+
+type verifyFileState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Hash    string    `json:"hash"`
+}
+
+type verifyManifestEntry struct {
+	A *verifyFileState `json:"a,omitempty"`
+	B *verifyFileState `json:"b,omitempty"`
+}
+
+type verifyManifest struct {
+	Algorithm string                         `json:"algorithm"`
+	Roots     []string                       `json:"roots"`
+	UpdatedAt time.Time                      `json:"updated_at"`
+	Entries   map[string]verifyManifestEntry `json:"entries"`
+}
+
+func getVerifyManifestPath(target Target) string {
+	return target.path + "/verify.json"
+}
+
+// Reads the manifest of a target. A manifest made for other roots or with another
+// algorithm is ignored (every file is hashed again).
+func readVerifyManifest(target Target, roots []string, algorithm string) (verifyManifest, error) {
+	manifest := verifyManifest{Algorithm: algorithm, Roots: roots, Entries: make(map[string]verifyManifestEntry)}
+
+	content, err := ioutil.ReadFile(getVerifyManifestPath(target))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return manifest, err
+	}
+
+	var stored verifyManifest
+	err = json.Unmarshal(content, &stored)
+	if err != nil {
+		return manifest, fmt.Errorf("invalid manifest %v -> %v", getVerifyManifestPath(target), err.Error())
+	}
+
+	if stored.Algorithm != algorithm || len(stored.Roots) != 2 || stored.Roots[0] != roots[0] || stored.Roots[1] != roots[1] || stored.Entries == nil {
+		return manifest, nil
+	}
+
+	return stored, nil
+}
+
+func writeVerifyManifest(target Target, manifest verifyManifest) error {
+	manifest.UpdatedAt = time.Now()
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomically(getVerifyManifestPath(target), content, 0644)
+}
+
+//
+//// VERIFICATION
+//
+
+// Kinds of problems found while verifying
+const (
+	verifyProblemMissing     = "missing"
+	verifyProblemMismatch    = "mismatch"
+	verifyProblemType        = "type"
+	verifyProblemPermissions = "permissions"
+	verifyProblemError       = "error"
+)
+
+type verifyProblem struct {
+	kind    string
+	path    string
+	details string
+}
+
+type verifyStats struct {
+	files    int
+	hashed   int
+	cached   int
+	bytes    int64
+	problems []verifyProblem
+}
+
+type verifyHashJob struct {
+	side  int
+	entry syncEntry
+}
+
+type verifyHashResult struct {
+	job  verifyHashJob
+	hash string
+	err  error
+}
+
+func hashVerifyFile(fs syncFS, name string, newHash func() hash.Hash) (string, error) {
+	file, err := fs.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	digest := newHash()
+	_, err = io.Copy(digest, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// Hashes the files of the jobs with a pool of workers, streaming their contents
+func runVerifyHashJobs(filesystems []syncFS, jobs []verifyHashJob, newHash func() hash.Hash, workers int) []verifyHashResult {
+	jobsChannel := make(chan verifyHashJob)
+	resultsChannel := make(chan verifyHashResult)
+
+	var group sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for job := range jobsChannel {
+				digest, err := hashVerifyFile(filesystems[job.side], job.entry.path, newHash)
+				resultsChannel <- verifyHashResult{job: job, hash: digest, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, job := range jobs {
+			jobsChannel <- job
+		}
+		close(jobsChannel)
+		group.Wait()
+		close(resultsChannel)
+	}()
+
+	var results []verifyHashResult
+	for result := range resultsChannel {
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// Compares the roots of a target file by file. With 'oneWay' (a mirror without the
+// 'delete' option), files that only exist in the second root are not reported.
+func verifyRoots(target Target, filesystems []syncFS, excludes []string, comparePermissions bool, oneWay bool, options verifyOptions) (verifyStats, error) {
+	var stats verifyStats
+	labels := []string{"A", "B"}
+
+	roots := []string{filesystems[0].String(), filesystems[1].String()}
+	manifest, err := readVerifyManifest(target, roots, options.algorithm)
+	if err != nil {
+		return stats, err
+	}
+
+	trees := make([]map[string]syncEntry, len(filesystems))
+	for side, fs := range filesystems {
+		if _, err := fs.Lstat(""); err != nil {
+			return stats, fmt.Errorf("failed to read root %v -> %v", labels[side], err.Error())
+		}
+
+		tree, _, err := scanSyncTree(fs, excludes)
+		if err != nil {
+			return stats, fmt.Errorf("failed to scan root %v -> %v", labels[side], err.Error())
+		}
+		trees[side] = tree
+	}
+
+	union := make(map[string]syncEntry)
+	for _, tree := range trees {
+		for name, entry := range tree {
+			union[name] = entry
+		}
+	}
+
+	addProblem := func(kind string, name string, details string) {
+		stats.problems = append(stats.problems, verifyProblem{kind: kind, path: name, details: details})
+	}
+
+	// Files whose contents must be compared, hashing those that are not up to date
+	// in the manifest
+	var compared []string
+	var jobs []verifyHashJob
+	hashes := make([]map[string]string, len(filesystems))
+	states := make(map[string]verifyManifestEntry)
+	for side := range hashes {
+		hashes[side] = make(map[string]string)
+	}
+
+	// The contents of a missing directory are not reported one by one
+	missingDir := ""
+
+	for _, name := range sortedSyncPaths(union) {
+		entryA, existsA := trees[0][name]
+		entryB, existsB := trees[1][name]
+
+		if missingDir != "" && strings.HasPrefix(name, missingDir+"/") == true {
+			continue
+		}
+
+		switch {
+		case existsA == false && oneWay == true:
+			continue
+		case existsA == false || existsB == false:
+			missing := "B"
+			if existsA == false {
+				missing = "A"
+			}
+			if union[name].isDir == true {
+				missingDir = name
+			}
+			addProblem(verifyProblemMissing, name, "missing in root "+missing)
+			continue
+		case entryA.sameKind(entryB) == false:
+			addProblem(verifyProblemType, name, "different kinds of file")
+			continue
+		}
+
+		if comparePermissions == true && entryA.isSymlink == false && entryA.mode != entryB.mode {
+			addProblem(verifyProblemPermissions, name, fmt.Sprintf("%v (A) != %v (B)", entryA.mode, entryB.mode))
+		}
+
+		switch {
+		case entryA.isDir == true:
+			continue
+		case entryA.isSymlink == true:
+			stats.files++
+			if entryA.linkTarget != entryB.linkTarget {
+				addProblem(verifyProblemMismatch, name, fmt.Sprintf("links to %v (A) and %v (B)", entryA.linkTarget, entryB.linkTarget))
+			}
+			continue
+		case entryA.size != entryB.size:
+			stats.files++
+			addProblem(verifyProblemMismatch, name, fmt.Sprintf("%v (A) != %v (B)", formatByteSize(entryA.size), formatByteSize(entryB.size)))
+			continue
+		}
+
+		stats.files++
+		compared = append(compared, name)
+
+		stored := manifest.Entries[name]
+		for side, entry := range []syncEntry{entryA, entryB} {
+			state := stored.A
+			if side == 1 {
+				state = stored.B
+			}
+
+			if options.full == false && state != nil && state.Size == entry.size && state.ModTime.Equal(entry.modTime) == true {
+				hashes[side][name] = state.Hash
+				stats.cached++
+				continue
+			}
+
+			jobs = append(jobs, verifyHashJob{side: side, entry: entry})
+			stats.bytes += entry.size
+		}
+	}
+
+	for _, result := range runVerifyHashJobs(filesystems, jobs, verifyAlgorithms[options.algorithm], options.jobs) {
+		if result.err != nil {
+			addProblem(verifyProblemError, result.job.entry.path, fmt.Sprintf("failed to hash root %v -> %v", labels[result.job.side], result.err.Error()))
+			continue
+		}
+
+		hashes[result.job.side][result.job.entry.path] = result.hash
+		stats.hashed++
+	}
+
+	for _, name := range compared {
+		hashA, okA := hashes[0][name]
+		hashB, okB := hashes[1][name]
+
+		entry := verifyManifestEntry{}
+		if okA == true {
+			entry.A = &verifyFileState{Size: trees[0][name].size, ModTime: trees[0][name].modTime, Hash: hashA}
+		}
+		if okB == true {
+			entry.B = &verifyFileState{Size: trees[1][name].size, ModTime: trees[1][name].modTime, Hash: hashB}
+		}
+		states[name] = entry
+
+		if okA == true && okB == true && hashA != hashB {
+			addProblem(verifyProblemMismatch, name, "different contents")
+		}
+	}
+
+	sort.SliceStable(stats.problems, func(i, j int) bool {
+		return stats.problems[i].path < stats.problems[j].path
+	})
+
+	manifest.Entries = states
+	err = writeVerifyManifest(target, manifest)
+	if err != nil {
+		return stats, fmt.Errorf("failed to write manifest -> %v", err.Error())
+	}
+
+	return stats, nil
+}
+
+// Returns the roots compared by 'targets verify': the ones of the sync engine or of
+// the unison profile
+func getTargetVerifyRoots(target Target, config targetConfig) ([]string, error) {
+	switch config.Sync.Engine {
+	case syncEngineMirror:
+		return []string{config.Sync.Source, config.Sync.Destination}, nil
+	case syncEngineTwoWay:
+		return config.Sync.Roots, nil
+	}
+
+	profilePath := getTargetUnisonProfilePath(target)
+	if _, err := os.Stat(profilePath); err == nil {
+		profile, err := parseUnisonProfile(profilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read unison profile -> %v", err.Error())
+		}
+
+		roots := profile.roots()
+		if len(roots) != 2 {
+			return nil, fmt.Errorf("expected 2 roots in the unison profile, found %v", len(roots))
+		}
+
+		return []string{unisonRootToRsync(roots[0]), unisonRootToRsync(roots[1])}, nil
+	}
+
+	return nil, fmt.Errorf("no roots to verify (the target has no sync engine and no unison profile)")
+}
+
+//
+//// TARGET COMMANDS
+//
+
+func verifyTarget(target Target, connections *sshConnectionPool, options verifyOptions, program Program) (verifyStats, functionResponse) {
+	var stats verifyStats
+
+	config, response := readTargetConfig(target, program)
+	if response.exitCode != 0 {
+		return stats, response
+	}
+
+	if config.Sync.Engine != "" {
+		response = verifySyncEngineConfig(config.Sync, program)
+		if response.exitCode != 0 {
+			return stats, response
+		}
+	}
+
+	// Options given in the command line take precedence over the configuration
+	if options.algorithm == "" {
+		options.algorithm = config.Verify.Algorithm
+	}
+	if options.algorithm == "" {
+		options.algorithm = verifyDefaultAlgorithm
+	}
+	if options.jobs <= 0 {
+		options.jobs = config.Verify.Jobs
+	}
+	if options.jobs <= 0 {
+		options.jobs = runtime.NumCPU()
+	}
+
+	if _, exists := verifyAlgorithms[options.algorithm]; exists == false {
+		return stats, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Unknown hash algorithm '%v' (expected 'sha1', 'sha256', 'sha512' or 'blake2b')", options.algorithm),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	roots, err := getTargetVerifyRoots(target, config)
+	if err != nil {
+		return stats, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to verify target -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	filesystems := make([]syncFS, len(roots))
+	for index, root := range roots {
+		fs, err := openSyncFS(root, target.environment, connections)
+		if err != nil {
+			return stats, functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to open %v -> %v", root, err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		}
+		filesystems[index] = fs
+	}
+
+	showText(gray.Sprintf(fmt.Sprintf("A: %v", filesystems[0])), program.indentLevel)
+	showText(gray.Sprintf(fmt.Sprintf("B: %v", filesystems[1])), program.indentLevel)
+
+	excludes := append(append([]string{}, config.Sync.Exclude...), config.Verify.Exclude...)
+	comparePermissions := config.Sync.Engine == "" || config.Sync.preservePermissions() == true
+	oneWay := config.Sync.Engine == syncEngineMirror && config.Sync.Delete == false
+
+	started := time.Now()
+	stats, err = verifyRoots(target, filesystems, excludes, comparePermissions, oneWay, options)
+	if err != nil {
+		return stats, functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to verify target -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	for _, problem := range stats.problems {
+		showText(fmt.Sprintf("%v %v %v", red.Sprintf("%-11v", problem.kind), problem.path, gray.Sprintf("(%v)", problem.details)), program.indentLevel)
+	}
+
+	showText(gray.Sprintf(fmt.Sprintf("%v file(s) compared with %v: %v hashed (%v), %v from the manifest, in %v", stats.files, options.algorithm, stats.hashed, formatByteSize(stats.bytes), stats.cached, time.Since(started).Round(time.Millisecond))), program.indentLevel)
+
+	return stats, functionResponse{exitCode: 0}
+}
+
+func targetsVerify(crate Crate, targets []Target, options verifyOptions, program Program) functionResponse {
+	connections := newSSHConnectionPool(crate, program)
+	defer connections.close()
+
+	problemsCount := 0
+	failedCount := 0
+
+	for _, target := range targets {
+		space()
+		showInfoSectionTitle(displayTargetTag("Verifying", target), program.indentLevel)
+
+		stats, response := verifyTarget(target, connections, options, incrementProgramIndentLevel(program, 1))
+		if response.exitCode != 0 {
+			handleFunctionResponse(response, false)
+			failedCount++
+			continue
+		}
+
+		if len(stats.problems) == 0 {
+			showSuccess("> Roots match", program.indentLevel+1)
+		} else {
+			showError(fmt.Sprintf("> Found %v difference(s)", len(stats.problems)), program.indentLevel+1)
+			problemsCount += len(stats.problems)
+		}
+	}
+
+	space()
+
+	if problemsCount > 0 || failedCount > 0 {
+		message := fmt.Sprintf("Found %v difference(s)", problemsCount)
+		if failedCount > 0 {
+			message += fmt.Sprintf(" (%v target(s) could not be verified)", failedCount)
+		}
+
+		return functionResponse{
+			exitCode:    1,
+			message:     message,
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	return functionResponse{
+		exitCode:    0,
+		message:     "All targets verified",
+		logLevel:    "success",
+		indentLevel: program.indentLevel,
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+//
+//// IMPORTS
+//
+
+import (
+	// Modules in GOROOT
+	"bytes"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+	// External modules
+)
+
+//
+//// WATCH
+//
+
+// 'targets watch' syncs each target when its primary directory changes (the same
+// directory captured by the snapshots). Directories are watched with inotify; the
+// events of a target are debounced, so that a burst of changes (e.g. an editor
+// saving a file) starts a single sync. Changes made while the target is being
+// synced (most of them by the sync itself) do not start another sync.
+
+const watchEvents = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// Time during which the changes are still ignored after a sync, as the last
+// events caused by the sync may be read after it finished
+const watchSyncGracePeriod = 500 * time.Millisecond
+
+// Time before trying again to sync when the crate is locked by another process
+const watchLockRetryDelay = 5 * time.Second
+
+type watchedDir struct {
+	target int
+	path   string
+}
+
+type targetWatcher struct {
+	fd       int
+	targets  []Target
+	dirs     []string
+	excludes [][]string
+	debounce time.Duration
+
+	// Only used by the goroutine reading the events (after the initial setup)
+	watches map[int32]watchedDir
+
+	mutex       sync.Mutex
+	deadlines   map[int]time.Time
+	syncing     int
+	quietUntil  map[int]time.Time
+	errors      []error
+	wake        chan struct{}
+	readerEnded chan struct{}
+}
+
+// Watches a directory and all its subdirectories (except the excluded ones)
+func (watcher *targetWatcher) addWatches(target int, dir string) error {
+	return filepath.Walk(dir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) == true && filePath != dir {
+				// Removed while walking
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() == false {
+			return nil
+		}
+
+		if filePath != dir {
+			relativePath, _ := filepath.Rel(watcher.dirs[target], filePath)
+			if isSyncPathExcluded(filepath.ToSlash(relativePath), true, watcher.excludes[target]) == true {
+				return filepath.SkipDir
+			}
+		}
+
+		wd, err := syscall.InotifyAddWatch(watcher.fd, filePath, watchEvents)
+		if err != nil {
+			return fmt.Errorf("failed to watch %v -> %v", filePath, err.Error())
+		}
+		watcher.watches[int32(wd)] = watchedDir{target: target, path: filePath}
+
+		return nil
+	})
+}
+
+// Schedules a sync of the target, unless it is being synced
+func (watcher *targetWatcher) touch(target int) {
+	watcher.mutex.Lock()
+	if watcher.syncing != target && time.Now().Before(watcher.quietUntil[target]) == false {
+		watcher.deadlines[target] = time.Now().Add(watcher.debounce)
+	}
+	watcher.mutex.Unlock()
+
+	select {
+	case watcher.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Reads the inotify events until the file descriptor is closed
+func (watcher *targetWatcher) readEvents() {
+	defer close(watcher.readerEnded)
+
+	buffer := make([]byte, 64*1024)
+	for {
+		count, err := syscall.Read(watcher.fd, buffer)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil || count <= 0 {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= count; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+			nameBytes := buffer[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(event.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			offset += syscall.SizeofInotifyEvent + int(event.Len)
+
+			// Events were lost: sync every target
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				for target := range watcher.targets {
+					watcher.touch(target)
+				}
+				continue
+			}
+
+			dir, exists := watcher.watches[event.Wd]
+			if exists == false {
+				continue
+			}
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(watcher.watches, event.Wd)
+				continue
+			}
+
+			// Temporary files of the sync engines
+			if strings.HasSuffix(name, syncTempFileSuffix) == true {
+				continue
+			}
+
+			filePath := dir.path
+			if name != "" {
+				filePath = filepath.Join(dir.path, name)
+			}
+
+			relativePath, _ := filepath.Rel(watcher.dirs[dir.target], filePath)
+			isDir := event.Mask&syscall.IN_ISDIR != 0
+			if name != "" && isSyncPathExcluded(filepath.ToSlash(relativePath), isDir, watcher.excludes[dir.target]) == true {
+				continue
+			}
+
+			// Watch new directories (and what was created in them before they were
+			// watched is found by the sync anyway)
+			if isDir == true && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				err := watcher.addWatches(dir.target, filePath)
+				if err != nil {
+					watcher.mutex.Lock()
+					watcher.errors = append(watcher.errors, err)
+					watcher.mutex.Unlock()
+				}
+			}
+
+			watcher.touch(dir.target)
+		}
+	}
+}
+
+// Returns the next target to sync (or -1) and how long to wait for it
+func (watcher *targetWatcher) next() (int, time.Duration) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	next := -1
+	var nextDeadline time.Time
+	for target, deadline := range watcher.deadlines {
+		if next == -1 || deadline.Before(nextDeadline) == true {
+			next = target
+			nextDeadline = deadline
+		}
+	}
+
+	if next == -1 {
+		return -1, time.Hour
+	}
+
+	return next, time.Until(nextDeadline)
+}
+
+// Syncs a single target with the normal sync pipeline (crate and target hooks,
+// journal, snapshots), unless the crate or the target is disabled
+func (watcher *targetWatcher) sync(crate Crate, target int, program Program) {
+	watcher.mutex.Lock()
+	delete(watcher.deadlines, target)
+	watcher.mutex.Unlock()
+
+	space()
+	showInfoSectionTitle(displayTargetTag(fmt.Sprintf("Changes detected (%v)", time.Now().Format("15:04:05")), watcher.targets[target]), program.indentLevel)
+
+	isCrateDisabled, response := isCrateDisabled(crate, program)
+	if response.exitCode != 0 {
+		handleFunctionResponse(response, false)
+		return
+	}
+	isTargetDisabled, response := isTargetDisabled(watcher.targets[target], program)
+	if response.exitCode != 0 {
+		handleFunctionResponse(response, false)
+		return
+	}
+
+	if isCrateDisabled == true || isTargetDisabled == true {
+		showAttention("> Skipping (disabled)", program.indentLevel+1)
+		return
+	}
+
+	// Try again later if another process is syncing the crate. The lock is held
+	// until the sync ends, so that no other process can take it in between.
+	lock, err := lockCrate(crate)
+	if err == errCrateLocked {
+		showAttention(fmt.Sprintf("> Crate is being synced by another process (PID %v). Trying again in %v", getCrateLockOwner(crate), watchLockRetryDelay), program.indentLevel+1)
+
+		watcher.mutex.Lock()
+		watcher.deadlines[target] = time.Now().Add(watchLockRetryDelay)
+		watcher.mutex.Unlock()
+		return
+	} else if err != nil {
+		showError(fmt.Sprintf("> Failed to lock crate -> %v", err.Error()), program.indentLevel+1)
+		return
+	}
+
+	watcher.mutex.Lock()
+	watcher.syncing = target
+	watcher.mutex.Unlock()
+
+	space()
+	response = syncCrateTargets(crate, []Target{watcher.targets[target]}, program)
+	unlockCrate(lock)
+	handleFunctionResponse(response, false)
+
+	watcher.mutex.Lock()
+	watcher.syncing = -1
+	watcher.quietUntil[target] = time.Now().Add(watchSyncGracePeriod)
+	watcher.mutex.Unlock()
+
+	space()
+	showText(gray.Sprintf("Watching for changes..."), program.indentLevel)
+}
+
+func targetsWatch(crate Crate, targets []Target, debounce time.Duration, program Program) functionResponse {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		return functionResponse{
+			exitCode:    1,
+			message:     fmt.Sprintf("Failed to initialize inotify -> " + err.Error()),
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	watcher := &targetWatcher{
+		fd:          fd,
+		debounce:    debounce,
+		watches:     make(map[int32]watchedDir),
+		deadlines:   make(map[int]time.Time),
+		syncing:     -1,
+		quietUntil:  make(map[int]time.Time),
+		wake:        make(chan struct{}, 1),
+		readerEnded: make(chan struct{}),
+	}
+
+	space()
+
+	for _, target := range targets {
+		showInfoSectionTitle(displayTargetTag("Watching", target), program.indentLevel)
+
+		config, response := readTargetConfig(target, program)
+		if response.exitCode != 0 {
+			response.indentLevel = program.indentLevel + 1
+			handleFunctionResponse(response, false)
+			continue
+		}
+
+		primaryDir, err := getTargetPrimaryDir(target, config)
+		if err != nil {
+			showAttention(fmt.Sprintf("> Skipping: %v", err.Error()), program.indentLevel+1)
+			continue
+		}
+
+		watcher.targets = append(watcher.targets, target)
+		watcher.dirs = append(watcher.dirs, primaryDir)
+		watcher.excludes = append(watcher.excludes, append(append([]string{}, config.Sync.Exclude...), config.Snapshot.Exclude...))
+
+		err = watcher.addWatches(len(watcher.targets)-1, primaryDir)
+		if err != nil {
+			syscall.Close(fd)
+
+			return functionResponse{
+				exitCode:    1,
+				message:     fmt.Sprintf("Failed to watch target -> " + err.Error()),
+				logLevel:    "error",
+				indentLevel: program.indentLevel + 1,
+			}
+		}
+
+		showText(gray.Sprintf(primaryDir), program.indentLevel+1)
+	}
+
+	if len(watcher.targets) == 0 {
+		syscall.Close(fd)
+
+		return functionResponse{
+			exitCode:    1,
+			message:     "No targets to watch",
+			logLevel:    "error",
+			indentLevel: program.indentLevel,
+		}
+	}
+
+	go watcher.readEvents()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	space()
+	showText(gray.Sprintf(fmt.Sprintf("Watching for changes (debounce: %v). Press Ctrl+C to stop.", debounce)), program.indentLevel)
+
+	for {
+		target, wait := watcher.next()
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-signals:
+			timer.Stop()
+			syscall.Close(fd)
+
+			space()
+			return functionResponse{
+				exitCode:    0,
+				message:     "Stopped watching",
+				logLevel:    "success",
+				indentLevel: program.indentLevel,
+			}
+		case <-watcher.readerEnded:
+			timer.Stop()
+
+			return functionResponse{
+				exitCode:    1,
+				message:     "Failed to read inotify events",
+				logLevel:    "error",
+				indentLevel: program.indentLevel,
+			}
+		case <-watcher.wake:
+			timer.Stop()
+		case <-timer.C:
+			if target != -1 {
+				watcher.sync(crate, target, program)
+			}
+		}
+
+		watcher.mutex.Lock()
+		errors := watcher.errors
+		watcher.errors = nil
+		watcher.mutex.Unlock()
+
+		for _, err := range errors {
+			showAttention(fmt.Sprintf("> %v", err.Error()), program.indentLevel)
+		}
+	}
+}